@@ -0,0 +1,143 @@
+// Package claimcheck implements the claim-check pattern for oversized message
+// payloads.
+//
+// Payloads over a threshold are stored in a Store and the message carries a
+// reference to it in the claim-check attribute instead. Subscribers fetch the
+// payload back from the store before handling the message.
+//
+// Stored payloads are not deleted once fetched: a message is delivered to
+// every subscription of its topic and may be redelivered, so no subscriber
+// knows when a payload is not needed anymore. The store must expire them
+// instead, e.g. with a lifecycle or retention policy on its bucket, which
+// outlives the retention of the messages.
+package claimcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/Flahmingo-Investments/ship"
+)
+
+// ReferenceKey is the attribute key which holds the reference of a stored
+// payload.
+const ReferenceKey = "claim-check"
+
+// referenceLength is the number of random bytes in a generated reference.
+const referenceLength = 16
+
+// ErrNotFound is returned by a Store if the payload does not exist.
+var ErrNotFound = errors.New("claimcheck: payload not found")
+
+// ErrInvalidReference is returned by CheckOut if the reference of a message
+// was not generated by CheckIn.
+var ErrInvalidReference = errors.New("claimcheck: invalid reference")
+
+// Store stores message payloads by reference.
+//
+// Implementations must be safe for concurrent use. They should expire the
+// stored payloads, see the package documentation.
+type Store interface {
+	// Put stores the payload under the reference.
+	Put(ctx context.Context, ref string, data []byte) error
+
+	// Get returns the payload stored under the reference or ErrNotFound.
+	// Other errors are considered temporary, the message is redelivered.
+	Get(ctx context.Context, ref string) ([]byte, error)
+
+	// Delete removes the payload stored under the reference. It is not called
+	// by CheckOut.
+	Delete(ctx context.Context, ref string) error
+}
+
+// newReference generates a random reference.
+func newReference() (string, error) {
+	b := make([]byte, referenceLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validReference reports whether ref is a reference generated by
+// newReference.
+func validReference(ref string) bool {
+	b, err := hex.DecodeString(ref)
+	return err == nil && len(b) == referenceLength
+}
+
+// CheckIn stores the payload of the message in the store, if it is over
+// threshold bytes long, and returns a message which carries the reference of
+// the stored payload instead of the payload.
+//
+// It returns a new message and does not modify the given one.
+func CheckIn(
+	ctx context.Context, store Store, threshold int, m *ship.RawMessage,
+) (*ship.RawMessage, error) {
+	if store == nil || len(m.Data) <= threshold {
+		return m, nil
+	}
+
+	ref, err := newReference()
+	if err != nil {
+		return nil, fmt.Errorf("claimcheck: unable to generate reference: %w", err)
+	}
+
+	if err := store.Put(ctx, ref, m.Data); err != nil {
+		return nil, fmt.Errorf("claimcheck: unable to store payload: %w", err)
+	}
+
+	out := *m
+	out.Data = nil
+	out.Attributes = make(map[string]string, len(m.Attributes)+1)
+	for k, v := range m.Attributes {
+		out.Attributes[k] = v
+	}
+	out.Attributes[ReferenceKey] = ref
+
+	return &out, nil
+}
+
+// CheckOut fetches the payload of the message from the store, if it carries a
+// reference.
+//
+// It returns a new message without the claim-check attribute and does not
+// modify the given one. The errors of the store other than ErrNotFound are
+// returned as a ship.TemporaryError, so the message is redelivered.
+func CheckOut(ctx context.Context, store Store, m *ship.RawMessage) (*ship.RawMessage, error) {
+	ref, ok := m.Attributes[ReferenceKey]
+	if !ok {
+		return m, nil
+	}
+
+	if !validReference(ref) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidReference, ref)
+	}
+
+	if store == nil {
+		return nil, fmt.Errorf("claimcheck: no store configured to fetch payload %s", ref)
+	}
+
+	data, err := store.Get(ctx, ref)
+	if err != nil {
+		err = fmt.Errorf("claimcheck: unable to fetch payload %s: %w", ref, err)
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, ship.NewTemporaryError(err)
+	}
+
+	out := *m
+	out.Data = data
+	out.Attributes = make(map[string]string, len(m.Attributes))
+	for k, v := range m.Attributes {
+		if k != ReferenceKey {
+			out.Attributes[k] = v
+		}
+	}
+
+	return &out, nil
+}
@@ -0,0 +1,158 @@
+package claimcheck
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+// unavailableStore is a Store which always fails.
+type unavailableStore struct{}
+
+func (unavailableStore) Put(context.Context, string, []byte) error {
+	return errors.New("store unavailable")
+}
+
+func (unavailableStore) Get(context.Context, string) ([]byte, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func (unavailableStore) Delete(context.Context, string) error {
+	return errors.New("store unavailable")
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	_, err = store.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = store.Put(ctx, "../escape", []byte("data"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid reference")
+
+	assert.NoError(t, store.Put(ctx, "some-ref", []byte("data")))
+
+	data, err := store.Get(ctx, "some-ref")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	assert.NoError(t, store.Delete(ctx, "some-ref"))
+	assert.NoError(t, store.Delete(ctx, "some-ref"))
+
+	_, err = store.Get(ctx, "some-ref")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCheckIn(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		threshold    int
+		message      *ship.RawMessage
+		checkResults func(t *testing.T, in, out *ship.RawMessage, err error)
+	}{
+		{
+			name:      "should not check in message within threshold",
+			threshold: 4,
+			message:   &ship.RawMessage{Data: []byte("data")},
+			checkResults: func(t *testing.T, in, out *ship.RawMessage, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, in, out)
+			},
+		},
+		{
+			name:      "should check in and check out message over threshold",
+			threshold: 3,
+			message: &ship.RawMessage{
+				Data:       []byte("data"),
+				Attributes: map[string]string{"key": "value"},
+			},
+			checkResults: func(t *testing.T, in, out *ship.RawMessage, err error) {
+				assert.NoError(t, err)
+				assert.Empty(t, out.Data)
+				assert.NotEmpty(t, out.Attributes[ReferenceKey])
+				assert.NotContains(t, in.Attributes, ReferenceKey)
+
+				checkedOut, err := CheckOut(ctx, store, out)
+				assert.NoError(t, err)
+				assert.Equal(t, in, checkedOut)
+
+				_, err = CheckOut(ctx, nil, out)
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "no store configured")
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := CheckIn(ctx, store, tc.threshold, tc.message)
+			tc.checkResults(t, tc.message, out, err)
+		})
+	}
+}
+
+func TestCheckOut(t *testing.T) {
+	ctx := context.Background()
+
+	fileStore, err := NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	validRef := strings.Repeat("ab", referenceLength)
+
+	testCases := []struct {
+		name      string
+		store     Store
+		ref       string
+		err       error
+		temporary bool
+	}{
+		{
+			name:  "should reject a malformed reference",
+			store: fileStore,
+			ref:   "../escape",
+			err:   ErrInvalidReference,
+		},
+		{
+			name:  "should return a permanent error for a missing payload",
+			store: fileStore,
+			ref:   validRef,
+			err:   ErrNotFound,
+		},
+		{
+			name:      "should return a temporary error when the store fails",
+			store:     unavailableStore{},
+			ref:       validRef,
+			temporary: true,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := CheckOut(ctx, tc.store, &ship.RawMessage{
+				Attributes: map[string]string{ReferenceKey: tc.ref},
+			})
+			assert.Error(t, err)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			}
+			assert.Equal(t, tc.temporary, ship.IsTemporary(err))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package claimcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Compile time check.
+var _ Store = (*FileStore)(nil)
+
+// FileStore is a Store which keeps payloads as files in a local directory.
+type FileStore struct {
+	dir string
+}
+
+// dirPerm is the permission of the store directory.
+const dirPerm = 0o750
+
+// filePerm is the permission of the stored payload files.
+const filePerm = 0o640
+
+// NewFileStore creates a FileStore in given directory. The directory is
+// created if it does not exists.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("claimcheck: unable to create directory %s: %w", dir, err)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file path of the reference.
+func (s *FileStore) path(ref string) (string, error) {
+	if ref == "" || ref != filepath.Base(ref) || ref == "." || ref == ".." {
+		return "", fmt.Errorf("claimcheck: invalid reference %q", ref)
+	}
+	return filepath.Join(s.dir, ref), nil
+}
+
+// Put stores the payload under the reference.
+func (s *FileStore) Put(_ context.Context, ref string, data []byte) error {
+	path, err := s.path(ref)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a reader never sees a partial payload.
+	tmp, err := os.CreateTemp(s.dir, ref+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(tmp, data); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get returns the payload stored under the reference or ErrNotFound.
+func (s *FileStore) Get(_ context.Context, ref string) ([]byte, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+// Delete removes the payload stored under the reference.
+func (s *FileStore) Delete(_ context.Context, ref string) error {
+	path, err := s.path(ref)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// writeFile writes the data to the file and closes it.
+func writeFile(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Chmod(filePerm); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
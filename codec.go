@@ -0,0 +1,146 @@
+package ship
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types of the built-in codecs.
+const (
+	// ContentTypeJSON is the content type of JSONCodec.
+	ContentTypeJSON = "application/json"
+
+	// ContentTypeProtoJSON is the content type of ProtoJSONCodec.
+	ContentTypeProtoJSON = "application/x-protobuf+json"
+
+	// ContentTypeProto is the content type of ProtoCodec.
+	ContentTypeProto = "application/x-protobuf"
+)
+
+// Codec encodes and decodes event data.
+type Codec interface {
+	// ContentType returns the content type of the encoded data.
+	// For example: application/json.
+	ContentType() string
+
+	// Marshal encodes the event into bytes.
+	Marshal(e Event) ([]byte, error)
+
+	// Unmarshal decodes the data into the event. The event must be a pointer.
+	Unmarshal(data []byte, e Event) error
+}
+
+// Compile time checks.
+var (
+	_ Codec = JSONCodec{}
+	_ Codec = ProtoJSONCodec{}
+	_ Codec = ProtoCodec{}
+)
+
+// JSONCodec is a codec which uses encoding/json.
+type JSONCodec struct{}
+
+// ContentType returns the content type of the encoded data.
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+// Marshal encodes the event into JSON.
+func (JSONCodec) Marshal(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Unmarshal decodes the JSON data into the event.
+func (JSONCodec) Unmarshal(data []byte, e Event) error {
+	return json.Unmarshal(data, e)
+}
+
+// protoJSONMarshaler is the protojson marshaler used by ProtoJSONCodec.
+var protoJSONMarshaler = protojson.MarshalOptions{
+	UseProtoNames:   true,
+	EmitUnpopulated: true,
+	AllowPartial:    true,
+}
+
+// protoJSONUnmarshaler is the protojson unmarshaler used by ProtoJSONCodec.
+var protoJSONUnmarshaler = protojson.UnmarshalOptions{
+	AllowPartial:   true,
+	DiscardUnknown: true,
+}
+
+// ProtoJSONCodec is a codec which uses protojson. Events must be protobuf
+// messages.
+type ProtoJSONCodec struct{}
+
+// ContentType returns the content type of the encoded data.
+func (ProtoJSONCodec) ContentType() string {
+	return ContentTypeProtoJSON
+}
+
+// Marshal encodes the event into JSON using protojson.
+func (ProtoJSONCodec) Marshal(e Event) ([]byte, error) {
+	m, err := toProto(e)
+	if err != nil {
+		return nil, err
+	}
+	return protoJSONMarshaler.Marshal(m)
+}
+
+// Unmarshal decodes the JSON data into the event using protojson.
+func (ProtoJSONCodec) Unmarshal(data []byte, e Event) error {
+	m, err := toProto(e)
+	if err != nil {
+		return err
+	}
+	return protoJSONUnmarshaler.Unmarshal(data, m)
+}
+
+// ProtoCodec is a codec which uses protobuf binary encoding. Events must be
+// protobuf messages.
+type ProtoCodec struct{}
+
+// ContentType returns the content type of the encoded data.
+func (ProtoCodec) ContentType() string {
+	return ContentTypeProto
+}
+
+// Marshal encodes the event into protobuf binary format.
+func (ProtoCodec) Marshal(e Event) ([]byte, error) {
+	m, err := toProto(e)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal decodes the protobuf binary data into the event.
+func (ProtoCodec) Unmarshal(data []byte, e Event) error {
+	m, err := toProto(e)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// toProto asserts that the event is a protobuf message.
+func toProto(e Event) (proto.Message, error) {
+	m, ok := e.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("ship: event %T is not a protobuf message", e)
+	}
+	return m, nil
+}
+
+// DefaultCodecs returns the built-in codecs keyed by their content type.
+func DefaultCodecs() map[string]Codec {
+	codecs := []Codec{JSONCodec{}, ProtoJSONCodec{}, ProtoCodec{}}
+
+	m := make(map[string]Codec, len(codecs))
+	for _, c := range codecs {
+		m[c.ContentType()] = c
+	}
+	return m
+}
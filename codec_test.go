@@ -0,0 +1,131 @@
+package ship
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testJSONEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testJSONEvent) EventName() string { return "TestJSONEvent" }
+
+type testProtoEvent struct {
+	*wrapperspb.StringValue
+}
+
+func (*testProtoEvent) EventName() string { return "TestProtoEvent" }
+
+func TestCodecs(t *testing.T) {
+	testCases := []struct {
+		name        string
+		codec       Codec
+		contentType string
+		event       Event
+		newEvent    func() Event
+	}{
+		{
+			name:        "json codec",
+			codec:       JSONCodec{},
+			contentType: ContentTypeJSON,
+			event:       &testJSONEvent{Name: "ship"},
+			newEvent:    func() Event { return &testJSONEvent{} },
+		},
+		{
+			name:        "protojson codec",
+			codec:       ProtoJSONCodec{},
+			contentType: ContentTypeProtoJSON,
+			event:       &testProtoEvent{wrapperspb.String("ship")},
+			newEvent:    func() Event { return &testProtoEvent{&wrapperspb.StringValue{}} },
+		},
+		{
+			name:        "proto codec",
+			codec:       ProtoCodec{},
+			contentType: ContentTypeProto,
+			event:       &testProtoEvent{wrapperspb.String("ship")},
+			newEvent:    func() Event { return &testProtoEvent{&wrapperspb.StringValue{}} },
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.contentType, tc.codec.ContentType())
+
+			data, err := tc.codec.Marshal(tc.event)
+			assert.NoError(t, err)
+
+			e := tc.newEvent()
+			err = tc.codec.Unmarshal(data, e)
+			assert.NoError(t, err)
+			assert.Contains(t, DefaultCodecs(), tc.contentType)
+
+			if pe, ok := e.(*testProtoEvent); ok {
+				assert.Equal(t, "ship", pe.GetValue())
+				return
+			}
+			assert.Equal(t, tc.event, e)
+		})
+	}
+}
+
+func TestCodecs_NotProto(t *testing.T) {
+	_, err := ProtoCodec{}.Marshal(&testJSONEvent{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a protobuf message")
+
+	err = ProtoJSONCodec{}.Unmarshal([]byte("{}"), &testJSONEvent{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a protobuf message")
+}
+
+func TestMarshalMessage(t *testing.T) {
+	r := NewRegistry()
+	assert.NoError(t, r.Register(&testJSONEvent{}))
+
+	at := time.Date(2022, 1, 31, 14, 15, 17, 181841000, time.UTC)
+	m := &Message{
+		ID:            "some-id",
+		Metadata:      Metadata{"key": "value"},
+		Type:          "TestJSONEvent",
+		AggregateID:   "some-aggregate-id",
+		AggregateType: "user",
+		Data:          &testJSONEvent{Name: "ship"},
+		At:            at,
+		Version:       42,
+	}
+
+	raw, err := MarshalMessage(JSONCodec{}, m)
+	assert.NoError(t, err)
+	assert.True(t, IsEnvelope(raw))
+	assert.Equal(t, ContentTypeJSON, raw.Attributes[ContentTypeKey])
+	assert.Equal(t, "1", raw.Attributes[SchemaVersionKey])
+	assert.JSONEq(t, `{"name":"ship"}`, string(raw.Data))
+
+	decoded, err := UnmarshalMessage(r, DefaultCodecs(), raw)
+	assert.NoError(t, err)
+	assert.Equal(t, m, decoded)
+
+	// Other producers set a content type as well.
+	assert.False(t, IsEnvelope(&RawMessage{
+		Attributes: map[string]string{ContentTypeKey: ContentTypeJSON},
+	}))
+
+	raw.Attributes[ContentTypeKey] = "text/plain"
+	_, err = UnmarshalMessage(r, DefaultCodecs(), raw)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `no codec for content type "text/plain"`)
+	assert.Equal(t, DecodeReasonContentType, DecodeReason(err))
+
+	raw.Attributes[ContentTypeKey] = ContentTypeJSON
+	raw.Attributes[TypeKey] = "NotRegistered"
+	_, err = UnmarshalMessage(r, DefaultCodecs(), raw)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "event NotRegistered is not registered")
+	assert.Equal(t, DecodeReasonUnregistered, DecodeReason(err))
+}
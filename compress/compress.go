@@ -0,0 +1,239 @@
+// Package compress contains compressors for ship message payloads.
+//
+// A compressed payload is signalled with the content-encoding attribute, so
+// the subscribers can decompress it transparently.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// EncodingKey is the attribute key which holds the content encoding of a
+// compressed payload.
+const EncodingKey = "content-encoding"
+
+// Encodings of the built-in compressors.
+const (
+	// EncodingGzip is the encoding of the gzip compressor.
+	EncodingGzip = "gzip"
+
+	// EncodingZstd is the encoding of the zstd compressor.
+	EncodingZstd = "zstd"
+
+	// EncodingSnappy is the encoding of the snappy compressor.
+	EncodingSnappy = "snappy"
+)
+
+// Compressor compresses and decompresses message payloads.
+type Compressor interface {
+	// Encoding returns the content encoding of the compressed data.
+	// For example: gzip.
+	Encoding() string
+
+	// Compress compresses the data.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress decompresses the data.
+	Decompress(data []byte) ([]byte, error)
+}
+
+// gzipCompressor is a Compressor which uses gzip.
+type gzipCompressor struct {
+	level int
+}
+
+// NewGzip returns a gzip compressor with given compression level.
+// See compress/gzip for the levels.
+func NewGzip(level int) Compressor {
+	return &gzipCompressor{level: level}
+}
+
+// Encoding returns the content encoding of the compressed data.
+func (c *gzipCompressor) Encoding() string {
+	return EncodingGzip
+}
+
+// Compress compresses the data.
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses the data.
+func (c *gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// zstdCompressor is a Compressor which uses zstd.
+type zstdCompressor struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+}
+
+// NewZstd returns a zstd compressor.
+func NewZstd() Compressor {
+	return &zstdCompressor{}
+}
+
+// init initialises the encoder and decoder, they are safe for concurrent use.
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		c.enc, c.err = zstd.NewWriter(nil)
+		if c.err != nil {
+			return
+		}
+		c.dec, c.err = zstd.NewReader(nil)
+	})
+	return c.err
+}
+
+// Encoding returns the content encoding of the compressed data.
+func (c *zstdCompressor) Encoding() string {
+	return EncodingZstd
+}
+
+// Compress compresses the data.
+func (c *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.enc.EncodeAll(data, nil), nil
+}
+
+// Decompress decompresses the data.
+func (c *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.dec.DecodeAll(data, nil)
+}
+
+// snappyCompressor is a Compressor which uses snappy block format.
+type snappyCompressor struct{}
+
+// NewSnappy returns a snappy compressor.
+func NewSnappy() Compressor {
+	return snappyCompressor{}
+}
+
+// Encoding returns the content encoding of the compressed data.
+func (snappyCompressor) Encoding() string {
+	return EncodingSnappy
+}
+
+// Compress compresses the data.
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+// Decompress decompresses the data.
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+// Defaults returns the built-in compressors keyed by their encoding.
+func Defaults() map[string]Compressor {
+	compressors := []Compressor{NewGzip(gzip.DefaultCompression), NewZstd(), NewSnappy()}
+
+	m := make(map[string]Compressor, len(compressors))
+	for _, c := range compressors {
+		m[c.Encoding()] = c
+	}
+	return m
+}
+
+// Compress compresses the payload of the message, if it is at least threshold
+// bytes long, and marks it with the content-encoding attribute.
+//
+// It returns a new message and does not modify the given one.
+func Compress(c Compressor, threshold int, m *ship.RawMessage) (*ship.RawMessage, error) {
+	if c == nil || len(m.Data) < threshold {
+		return m, nil
+	}
+
+	if _, ok := m.Attributes[EncodingKey]; ok {
+		return nil, errors.New("compress: message is already encoded")
+	}
+
+	data, err := c.Compress(m.Data)
+	if err != nil {
+		return nil, fmt.Errorf("compress: unable to compress with %s: %w", c.Encoding(), err)
+	}
+
+	out := *m
+	out.Data = data
+	out.Attributes = make(map[string]string, len(m.Attributes)+1)
+	for k, v := range m.Attributes {
+		out.Attributes[k] = v
+	}
+	out.Attributes[EncodingKey] = c.Encoding()
+
+	return &out, nil
+}
+
+// Decompress decompresses the payload of the message, if it is marked with
+// the content-encoding attribute. The compressor is picked from compressors by
+// the encoding.
+//
+// It returns a new message without the content-encoding attribute and does
+// not modify the given one.
+func Decompress(
+	compressors map[string]Compressor, m *ship.RawMessage,
+) (*ship.RawMessage, error) {
+	encoding, ok := m.Attributes[EncodingKey]
+	if !ok {
+		return m, nil
+	}
+
+	c, ok := compressors[encoding]
+	if !ok {
+		return nil, fmt.Errorf("compress: no compressor for encoding %q", encoding)
+	}
+
+	data, err := c.Decompress(m.Data)
+	if err != nil {
+		return nil, fmt.Errorf("compress: unable to decompress with %s: %w", encoding, err)
+	}
+
+	out := *m
+	out.Data = data
+	out.Attributes = make(map[string]string, len(m.Attributes))
+	for k, v := range m.Attributes {
+		if k != EncodingKey {
+			out.Attributes[k] = v
+		}
+	}
+
+	return &out, nil
+}
@@ -0,0 +1,112 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressors(t *testing.T) {
+	data := bytes.Repeat([]byte("ship it "), 128)
+
+	for encoding, c := range Defaults() {
+		c := c
+
+		t.Run(encoding, func(t *testing.T) {
+			assert.Equal(t, encoding, c.Encoding())
+
+			compressed, err := c.Compress(data)
+			assert.NoError(t, err)
+			assert.Less(t, len(compressed), len(data))
+
+			decompressed, err := c.Decompress(compressed)
+			assert.NoError(t, err)
+			assert.Equal(t, data, decompressed)
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("ship it "), 128)
+
+	testCases := []struct {
+		name         string
+		compressor   Compressor
+		threshold    int
+		message      *ship.RawMessage
+		checkResults func(t *testing.T, in, out *ship.RawMessage, err error)
+	}{
+		{
+			name:       "should not compress message below threshold",
+			compressor: NewGzip(gzip.BestSpeed),
+			threshold:  len(data) + 1,
+			message:    &ship.RawMessage{Data: data},
+			checkResults: func(t *testing.T, in, out *ship.RawMessage, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, in, out)
+			},
+		},
+		{
+			name:       "should return error: message is already encoded",
+			compressor: NewSnappy(),
+			message: &ship.RawMessage{
+				Data:       data,
+				Attributes: map[string]string{EncodingKey: EncodingGzip},
+			},
+			checkResults: func(t *testing.T, in, out *ship.RawMessage, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "message is already encoded")
+			},
+		},
+		{
+			name:       "should compress and decompress message",
+			compressor: NewZstd(),
+			threshold:  len(data),
+			message: &ship.RawMessage{
+				Data:       data,
+				Attributes: map[string]string{"key": "value"},
+			},
+			checkResults: func(t *testing.T, in, out *ship.RawMessage, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, EncodingZstd, out.Attributes[EncodingKey])
+				assert.NotContains(t, in.Attributes, EncodingKey)
+
+				decompressed, err := Decompress(Defaults(), out)
+				assert.NoError(t, err)
+				assert.Equal(t, in, decompressed)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Compress(tc.compressor, tc.threshold, tc.message)
+			tc.checkResults(t, tc.message, out, err)
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	m := &ship.RawMessage{Data: []byte("plain")}
+	out, err := Decompress(Defaults(), m)
+	assert.NoError(t, err)
+	assert.Equal(t, m, out)
+
+	_, err = Decompress(Defaults(), &ship.RawMessage{
+		Data:       []byte("data"),
+		Attributes: map[string]string{EncodingKey: "br"},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `no compressor for encoding "br"`)
+
+	_, err = Decompress(Defaults(), &ship.RawMessage{
+		Data:       []byte("not gzip"),
+		Attributes: map[string]string{EncodingKey: EncodingGzip},
+	})
+	assert.Error(t, err)
+}
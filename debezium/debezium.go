@@ -0,0 +1,499 @@
+// Package debezium decodes the outbox events captured by Debezium into ship
+// messages.
+//
+// Both the full change event envelope and the flattened rows produced by the
+// ExtractNewRecordState transformation are supported, with or without their
+// schema. The source of the captured row is added to the message metadata.
+package debezium
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/pkg/errors"
+)
+
+// ErrDeleted is wrapped by the DecodeError returned for the deletions and
+// tombstones of captured rows, which are not events.
+var ErrDeleted = errors.New("captured row was deleted")
+
+// Metadata keys of the source of a captured row, set when the change event
+// carries them.
+const (
+	MetadataOp         = "debezium_op"
+	MetadataDatabase   = "debezium_db"
+	MetadataSchema     = "debezium_schema"
+	MetadataTable      = "debezium_table"
+	MetadataLSN        = "debezium_lsn"
+	MetadataTxID       = "debezium_tx_id"
+	MetadataSourceTime = "debezium_ts_ms"
+)
+
+// Operations of a change event.
+const (
+	OpCreate   = "c"
+	OpRead     = "r"
+	OpUpdate   = "u"
+	OpDelete   = "d"
+	OpTruncate = "t"
+)
+
+// sourceFields maps the fields of the source block of a change event to the
+// metadata keys.
+var sourceFields = map[string]string{
+	"db":     MetadataDatabase,
+	"schema": MetadataSchema,
+	"table":  MetadataTable,
+	"lsn":    MetadataLSN,
+	"txId":   MetadataTxID,
+	"ts_ms":  MetadataSourceTime,
+}
+
+// flattenedFields maps the fields added to a flattened row by the
+// ExtractNewRecordState transformation to the metadata keys.
+var flattenedFields = map[string]string{
+	"__op":           MetadataOp,
+	"__db":           MetadataDatabase,
+	"__schema":       MetadataSchema,
+	"__table":        MetadataTable,
+	"__lsn":          MetadataLSN,
+	"__txId":         MetadataTxID,
+	"__source_ts_ms": MetadataSourceTime,
+}
+
+// Columns maps the fields of a ship.Message to the columns of the outbox
+// table. The field of an empty column is left unset.
+type Columns struct {
+	ID            string
+	Type          string
+	AggregateID   string
+	AggregateType string
+	At            string
+	Version       string
+	Data          string
+	Metadata      string
+}
+
+// DefaultColumns are the columns of the outbox table by default.
+var DefaultColumns = Columns{
+	ID:            "id",
+	Type:          "type",
+	AggregateID:   "aggregate_id",
+	AggregateType: "aggregate_type",
+	At:            "at",
+	Version:       "version",
+	Data:          "data",
+	Metadata:      "metadata",
+}
+
+// Validate checks the columns of the event type and data are mapped.
+func (c Columns) Validate() error {
+	if c.Type == "" {
+		return errors.New("type column cannot be empty")
+	}
+	if c.Data == "" {
+		return errors.New("data column cannot be empty")
+	}
+	return nil
+}
+
+type payload struct {
+	ID            string
+	Type          string
+	Metadata      map[string]string
+	AggregateID   string
+	AggregateType string
+	At            time.Time
+	Version       uint64
+	Data          json.RawMessage
+}
+
+// changeEvent is the full change event envelope.
+type changeEvent struct {
+	Op     string                     `json:"op"`
+	After  json.RawMessage            `json:"after"`
+	Source map[string]json.RawMessage `json:"source"`
+}
+
+// record is a captured row along with its source.
+type record struct {
+	row     json.RawMessage
+	source  ship.Metadata
+	deleted bool
+}
+
+// Decode decodes a Debezium outbox event with the DefaultColumns into a
+// ship.Message.
+// Returned errors are of type *ship.DecodeError.
+//
+// Deletions and tombstones are reported with ship.DecodeReasonDeleted, the
+// error wraps ErrDeleted.
+//
+// The event data is upcasted to the current schema version of the event
+// registered in r, from the version held by the ship.SchemaVersionKey of the
+// metadata column. Rows without it are considered to be of the default
+// version, 1, and are upcasted again if the event has a newer version.
+func Decode(r *ship.Registry, data []byte) (*ship.Message, error) {
+	return DecodeColumns(r, DefaultColumns, data)
+}
+
+// DecodeColumns decodes a Debezium outbox event with the columns c into a
+// ship.Message, as Decode does.
+func DecodeColumns(r *ship.Registry, c Columns, data []byte) (*ship.Message, error) {
+	rec, err := parseRecord(data)
+	if err != nil {
+		return nil, ship.NewDecodeError(
+			ship.DecodeReasonMalformed, errors.Wrap(err, "unable to unmarshal debezium message"),
+		)
+	}
+
+	if rec.deleted {
+		return nil, ship.NewDecodeError(ship.DecodeReasonDeleted, ErrDeleted)
+	}
+
+	p, err := parsePayload(c, rec.row)
+	if err != nil {
+		return nil, ship.NewDecodeError(
+			ship.DecodeReasonMalformed, errors.Wrap(err, "unable to unmarshal debezium message"),
+		)
+	}
+
+	if p.Type == "" {
+		return nil, ship.NewDecodeError(
+			ship.DecodeReasonEmptyType,
+			errors.Errorf("empty event type in message %s", p.ID),
+		)
+	}
+
+	// Returned event is a pointer.
+	event, err := r.Get(p.Type)
+	if err != nil {
+		return nil, ship.NewDecodeError(
+			ship.DecodeReasonUnregistered,
+			errors.Wrap(err, "event is not registered: replay the event for reprocessing"),
+		)
+	}
+
+	eventData, err := upcast(r, p.Type, p.Metadata, p.Data)
+	if err != nil {
+		return nil, ship.NewDecodeError(
+			ship.DecodeReasonUpcast,
+			errors.Wrap(err, "unable to upcast event data: replay the event for reprocessing"),
+		)
+	}
+
+	if err := json.Unmarshal(eventData, event); err != nil {
+		// Check whether the error is due to invalid type error. This could
+		// happen if a field type does not match with event field.
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, ship.NewDecodeError(
+				ship.DecodeReasonInvalidData,
+				errors.Wrap(
+					err,
+					"[BUG]: invalid field type in event data: replay the event for reprocessing",
+				),
+			)
+		}
+
+		return nil, ship.NewDecodeError(
+			ship.DecodeReasonInvalidData, errors.Wrap(err, "unable to unmarshal event data"),
+		)
+	}
+
+	// The data is upcasted to the current version, the stamped version does
+	// not describe it anymore.
+	metadata := mergeMetadata(p.Metadata, rec.source)
+	delete(metadata, ship.SchemaVersionKey)
+
+	return &ship.Message{
+		ID:            p.ID,
+		Metadata:      metadata,
+		Type:          p.Type,
+		AggregateID:   p.AggregateID,
+		AggregateType: p.AggregateType,
+		Data:          event,
+		At:            p.At,
+		Version:       p.Version,
+	}, nil
+}
+
+// parsePayload reads the outbox event from the columns of a row.
+func parsePayload(c Columns, data []byte) (*payload, error) {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(data, &row); err != nil {
+		return nil, err
+	}
+
+	p := &payload{}
+	columns := []struct {
+		name string
+		dst  interface{}
+	}{
+		{c.ID, &p.ID},
+		{c.Type, &p.Type},
+		{c.AggregateID, &p.AggregateID},
+		{c.AggregateType, &p.AggregateType},
+		{c.At, &p.At},
+		{c.Version, &p.Version},
+		{c.Data, &p.Data},
+		{c.Metadata, &p.Metadata},
+	}
+	for _, col := range columns {
+		v, ok := row[col.name]
+		if col.name == "" || !ok || isNull(v) {
+			continue
+		}
+
+		if err := unmarshalColumn(v, col.dst); err != nil {
+			return nil, errors.Wrapf(err, "invalid column %s", col.name)
+		}
+	}
+
+	return p, nil
+}
+
+// unmarshalColumn unmarshals the value of a column. The text of a number is
+// accepted for a string, as identifiers may be numbers.
+//
+// The data and metadata columns hold JSON documents, see jsonColumn.
+func unmarshalColumn(v json.RawMessage, dst interface{}) error {
+	switch dst := dst.(type) {
+	case *json.RawMessage:
+		*dst = jsonColumn(v)
+		return nil
+	case *map[string]string:
+		return unmarshalMetadata(v, dst)
+	}
+
+	err := json.Unmarshal(v, dst)
+	if s, ok := dst.(*string); ok && err != nil {
+		var n json.Number
+		if json.Unmarshal(v, &n) == nil {
+			*s = n.String()
+			return nil
+		}
+	}
+	return err
+}
+
+// jsonColumn returns the JSON document held by a column. Depending on the
+// converter, it is a string, a nested value, base64 encoded bytes or an Avro
+// union of those.
+//
+// A string which is neither JSON nor base64 encoded JSON is returned as is,
+// it fails once unmarshaled.
+func jsonColumn(v json.RawMessage) json.RawMessage {
+	if inner, ok := avroUnion(v); ok {
+		return jsonColumn(inner)
+	}
+
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		// A nested value.
+		return v
+	}
+
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && json.Valid(b) {
+		return b
+	}
+
+	return json.RawMessage(s)
+}
+
+// avroUnion returns the value of a nullable string, bytes or map column
+// decoded from Avro, which is wrapped in an object keyed by its type.
+func avroUnion(v json.RawMessage) (json.RawMessage, bool) {
+	var union map[string]json.RawMessage
+	if json.Unmarshal(v, &union) != nil || len(union) != 1 {
+		return nil, false
+	}
+
+	for branch, inner := range union {
+		inner = bytes.TrimSpace(inner)
+		switch {
+		case (branch == "string" || branch == "bytes") && len(inner) > 0 && inner[0] == '"':
+			return inner, true
+		case branch == "map" && len(inner) > 0 && inner[0] == '{':
+			return inner, true
+		}
+	}
+
+	return nil, false
+}
+
+// unmarshalMetadata unmarshals the metadata column into a map. The values
+// which are not strings are kept as their JSON text.
+func unmarshalMetadata(v json.RawMessage, dst *map[string]string) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(jsonColumn(v), &values); err != nil {
+		return err
+	}
+
+	metadata := make(map[string]string, len(values))
+	for k, value := range values {
+		if s, ok := metadataValue(value); ok {
+			metadata[k] = s
+		}
+	}
+	*dst = metadata
+
+	return nil
+}
+
+// parseRecord extracts the captured row and its source from a change event,
+// either a full envelope or a flattened row.
+func parseRecord(data []byte) (*record, error) {
+	data = bytes.TrimSpace(data)
+	if isNull(data) {
+		// A tombstone.
+		return &record{deleted: true}, nil
+	}
+
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	// The JSON converter wraps the value with its schema, unless schemas are
+	// disabled.
+	value := data
+	if p, ok := root["payload"]; ok && isSchemaWrapper(root) {
+		if isNull(p) {
+			return &record{deleted: true}, nil
+		}
+		value = p
+		root = nil
+		if err := json.Unmarshal(value, &root); err != nil {
+			return nil, err
+		}
+	}
+
+	if isChangeEvent(root) {
+		return parseChangeEvent(value)
+	}
+
+	return parseFlattened(value, root), nil
+}
+
+// isSchemaWrapper reports whether the fields are the ones of a value wrapped
+// with its schema, rather than a row.
+func isSchemaWrapper(root map[string]json.RawMessage) bool {
+	for k := range root {
+		if k != "schema" && k != "payload" {
+			return false
+		}
+	}
+	return true
+}
+
+// isChangeEvent reports whether the fields are the ones of a full change
+// event envelope, rather than a flattened row.
+func isChangeEvent(root map[string]json.RawMessage) bool {
+	_, hasOp := root["op"]
+	_, hasAfter := root["after"]
+	_, hasBefore := root["before"]
+	return hasOp && (hasAfter || hasBefore)
+}
+
+// parseChangeEvent extracts the row created, read or updated by a full change
+// event envelope.
+func parseChangeEvent(data []byte) (*record, error) {
+	var ev changeEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return nil, err
+	}
+
+	rec := &record{row: ev.After, source: ship.Metadata{MetadataOp: ev.Op}}
+	for field, key := range sourceFields {
+		if v, ok := metadataValue(ev.Source[field]); ok {
+			rec.source[key] = v
+		}
+	}
+
+	switch ev.Op {
+	case OpDelete, OpTruncate:
+		rec.deleted = true
+	default:
+		rec.deleted = isNull(ev.After)
+	}
+
+	return rec, nil
+}
+
+// parseFlattened extracts the source of a row flattened by the
+// ExtractNewRecordState transformation, from the fields it added.
+func parseFlattened(data []byte, root map[string]json.RawMessage) *record {
+	rec := &record{row: data, source: ship.Metadata{}}
+	for field, key := range flattenedFields {
+		if v, ok := metadataValue(root[field]); ok {
+			rec.source[key] = v
+		}
+	}
+
+	// Deletions are rewritten with a __deleted field, which is a string
+	// unless the transformation is configured otherwise.
+	deleted, _ := metadataValue(root["__deleted"])
+	rec.deleted = deleted == "true" || rec.source[MetadataOp] == OpDelete
+
+	return rec
+}
+
+// metadataValue converts a JSON string, number or boolean to a metadata
+// value. It reports false for null or missing values.
+func metadataValue(v json.RawMessage) (string, bool) {
+	if len(v) == 0 || isNull(v) {
+		return "", false
+	}
+
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s, true
+	}
+
+	return strings.TrimSpace(string(v)), true
+}
+
+// isNull reports whether data is empty or a JSON null.
+func isNull(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) == 0 || string(data) == "null"
+}
+
+// mergeMetadata returns the metadata of an outbox event with the source of its
+// row, or nil if both are empty.
+func mergeMetadata(metadata map[string]string, source ship.Metadata) ship.Metadata {
+	if len(metadata) == 0 && len(source) == 0 {
+		return nil
+	}
+
+	merged := make(ship.Metadata, len(metadata)+len(source))
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	for k, v := range source {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// upcast brings the event data to the current schema version of the
+// registered event.
+func upcast(
+	r *ship.Registry, eventType string, metadata ship.Metadata, data []byte,
+) ([]byte, error) {
+	version, err := ship.ParseSchemaVersion(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Upcast(eventType, version, data)
+}
@@ -0,0 +1,407 @@
+package debezium
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+func TestDecode(t *testing.T) {
+	valid, err := os.ReadFile("testdata/valid_data.fixture")
+	assert.NoError(t, err)
+
+	invalid, err := os.ReadFile("testdata/invalid_data.fixture")
+	assert.NoError(t, err)
+
+	full, err := os.ReadFile("testdata/full_envelope.fixture")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		reason string
+		check  func(t *testing.T, m *ship.Message)
+	}{
+		{
+			name: "should decode an outbox event",
+			data: valid,
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Equal(t, "e79e906a-5022-473f-9a67-ff0993851be9", m.ID)
+				assert.Equal(t, "UserCreated", m.Type)
+				assert.Equal(t, uint64(92697), m.Version)
+				assert.Equal(
+					t, time.Date(2022, 1, 31, 14, 15, 17, 181841000, time.UTC), m.At.UTC(),
+				)
+				assert.Equal(t, "someone@flahmingo.com", m.Data.(*userCreated).Email)
+				assert.Equal(t, ship.Metadata{
+					MetadataTable: "events",
+					MetadataLSN:   "218487408",
+				}, m.Metadata)
+			},
+		},
+		{
+			name: "should decode an outbox event from a full envelope",
+			data: full,
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Equal(t, "e79e906a-5022-473f-9a67-ff0993851be9", m.ID)
+				assert.Equal(t, "UserCreated", m.Type)
+				assert.Equal(t, uint64(92697), m.Version)
+				assert.Equal(t, "someone@flahmingo.com", m.Data.(*userCreated).Email)
+				assert.Equal(t, ship.Metadata{
+					"correlation_id":   "some-correlation-id",
+					MetadataOp:         OpCreate,
+					MetadataDatabase:   "flahmingo",
+					MetadataSchema:     "public",
+					MetadataTable:      "events",
+					MetadataLSN:        "218487408",
+					MetadataTxID:       "767",
+					MetadataSourceTime: "1643638517181",
+				}, m.Metadata)
+			},
+		},
+		{
+			name: "should decode an outbox event from a full envelope with its schema",
+			data: []byte(`{
+				"schema": {"type": "struct"},
+				"payload": {
+					"before": null,
+					"after": {"type": "UserCreated", "data": "{}"},
+					"source": {"table": "events", "lsn": null},
+					"op": "r"
+				}
+			}`),
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Equal(t, "UserCreated", m.Type)
+				assert.Equal(t, ship.Metadata{
+					MetadataOp:    OpRead,
+					MetadataTable: "events",
+				}, m.Metadata)
+			},
+		},
+		{
+			name: "should decode an outbox event without schema",
+			data: []byte(`{
+				"id": "some-id",
+				"type": "UserCreated",
+				"data": "{}",
+				"__op": "c",
+				"__txId": 767,
+				"__deleted": false
+			}`),
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Equal(t, "some-id", m.ID)
+				assert.Equal(t, ship.Metadata{
+					MetadataOp:   OpCreate,
+					MetadataTxID: "767",
+				}, m.Metadata)
+			},
+		},
+		{
+			name: "should decode an outbox event without metadata",
+			data: []byte(`{"payload": {"type": "UserCreated", "data": "{}"}}`),
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Nil(t, m.Metadata)
+			},
+		},
+		{
+			name:   "should return error: tombstone",
+			data:   nil,
+			reason: ship.DecodeReasonDeleted,
+		},
+		{
+			name:   "should return error: tombstone with schema",
+			data:   []byte(`{"schema": null, "payload": null}`),
+			reason: ship.DecodeReasonDeleted,
+		},
+		{
+			name: "should return error: deleted row",
+			data: []byte(`{
+				"before": {"type": "UserCreated"},
+				"after": null,
+				"source": {"table": "events"},
+				"op": "d"
+			}`),
+			reason: ship.DecodeReasonDeleted,
+		},
+		{
+			name:   "should return error: truncated table",
+			data:   []byte(`{"before": null, "after": null, "op": "t"}`),
+			reason: ship.DecodeReasonDeleted,
+		},
+		{
+			name:   "should return error: rewritten deleted row",
+			data:   []byte(`{"payload": {"type": "UserCreated", "__deleted": "true"}}`),
+			reason: ship.DecodeReasonDeleted,
+		},
+		{
+			name:   "should return error: deleted row flattened with its operation",
+			data:   []byte(`{"type": "UserCreated", "__op": "d"}`),
+			reason: ship.DecodeReasonDeleted,
+		},
+		{
+			name:   "should return error: malformed message",
+			data:   invalid,
+			reason: ship.DecodeReasonMalformed,
+		},
+		{
+			name:   "should return error: empty event type",
+			data:   []byte(`{"payload": {"id": "some-id"}}`),
+			reason: ship.DecodeReasonEmptyType,
+		},
+		{
+			name:   "should return error: event is not registered",
+			data:   []byte(`{"payload": {"id": "some-id", "type": "Unknown"}}`),
+			reason: ship.DecodeReasonUnregistered,
+		},
+		{
+			name: "should return error: invalid schema version",
+			data: []byte(
+				`{"payload": {"type": "UserCreated", "metadata": {"schema_version": "x"}}}`,
+			),
+			reason: ship.DecodeReasonUpcast,
+		},
+		{
+			name:   "should return error: invalid field type",
+			data:   []byte(`{"payload": {"type": "UserCreated", "data": "{\"id\": 1}"}}`),
+			reason: ship.DecodeReasonInvalidData,
+		},
+		{
+			name:   "should return error: invalid event data",
+			data:   []byte(`{"payload": {"type": "UserCreated", "data": "{"}}`),
+			reason: ship.DecodeReasonInvalidData,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			r := ship.NewRegistry()
+			assert.NoError(t, r.Register(&userCreated{}))
+
+			m, err := Decode(r, tc.data)
+			if tc.reason != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tc.reason, ship.DecodeReason(err))
+				if tc.reason == ship.DecodeReasonDeleted {
+					assert.True(t, errors.Is(err, ErrDeleted))
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			tc.check(t, m)
+		})
+	}
+}
+
+func TestDecodeColumns(t *testing.T) {
+	valid, err := os.ReadFile("testdata/valid_data.fixture")
+	assert.NoError(t, err)
+
+	columns := DefaultColumns
+	columns.AggregateID = "entity_id"
+	columns.AggregateType = "entity_type"
+
+	testCases := []struct {
+		name    string
+		columns Columns
+		data    []byte
+		reason  string
+		check   func(t *testing.T, m *ship.Message)
+	}{
+		{
+			name:    "should decode the mapped columns",
+			columns: columns,
+			data:    valid,
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Equal(t, "e79e906a-5022-473f-9a67-ff0993851be9", m.ID)
+				assert.Equal(t, "39fe69b7-62aa-4685-99ed-d14331754a57", m.AggregateID)
+				assert.Equal(t, "user", m.AggregateType)
+				assert.Equal(t, "someone@flahmingo.com", m.Data.(*userCreated).Email)
+			},
+		},
+		{
+			name:    "should leave the fields of the default columns empty",
+			columns: DefaultColumns,
+			data:    valid,
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Empty(t, m.AggregateID)
+				assert.Empty(t, m.AggregateType)
+			},
+		},
+		{
+			name:    "should leave the fields of the unmapped columns empty",
+			columns: Columns{Type: "type", Data: "data"},
+			data:    valid,
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Empty(t, m.ID)
+				assert.True(t, m.At.IsZero())
+				assert.Zero(t, m.Version)
+				assert.Equal(t, "someone@flahmingo.com", m.Data.(*userCreated).Email)
+			},
+		},
+		{
+			name:    "should decode numeric identifiers",
+			columns: DefaultColumns,
+			data:    []byte(`{"id": 42, "type": "UserCreated", "aggregate_id": 7, "data": "{}"}`),
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Equal(t, "42", m.ID)
+				assert.Equal(t, "7", m.AggregateID)
+			},
+		},
+		{
+			name:    "should strip the schema version from the metadata",
+			columns: DefaultColumns,
+			data: []byte(`{
+				"type": "UserCreated",
+				"data": "{}",
+				"metadata": {"schema_version": "1", "trace_id": "some-trace"}
+			}`),
+			check: func(t *testing.T, m *ship.Message) {
+				assert.Equal(t, ship.Metadata{"trace_id": "some-trace"}, m.Metadata)
+			},
+		},
+		{
+			name:    "should return error: invalid column",
+			columns: DefaultColumns,
+			data:    []byte(`{"type": "UserCreated", "version": "latest", "data": "{}"}`),
+			reason:  ship.DecodeReasonMalformed,
+		},
+		{
+			name:    "should return error: unmapped type column",
+			columns: Columns{Type: "event_type", Data: "data"},
+			data:    valid,
+			reason:  ship.DecodeReasonEmptyType,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			r := ship.NewRegistry()
+			assert.NoError(t, r.Register(&userCreated{}))
+
+			m, err := DecodeColumns(r, tc.columns, tc.data)
+			if tc.reason != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tc.reason, ship.DecodeReason(err))
+				return
+			}
+
+			assert.NoError(t, err)
+			tc.check(t, m)
+		})
+	}
+}
+
+func TestColumns_Validate(t *testing.T) {
+	assert.NoError(t, DefaultColumns.Validate())
+	assert.EqualError(t, Columns{Data: "data"}.Validate(), "type column cannot be empty")
+	assert.EqualError(t, Columns{Type: "type"}.Validate(), "data column cannot be empty")
+}
+
+func TestDecode_JSONColumns(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     string
+		reason   string
+		metadata ship.Metadata
+	}{
+		{
+			name: "should decode string encoded JSON",
+			data: `{
+				"type": "UserCreated",
+				"data": "{\"email\": \"someone@flahmingo.com\"}",
+				"metadata": "{\"trace_id\": \"some-trace\"}"
+			}`,
+		},
+		{
+			name: "should decode nested JSON",
+			data: `{
+				"type": "UserCreated",
+				"data": {"email": "someone@flahmingo.com"},
+				"metadata": {"trace_id": "some-trace"}
+			}`,
+		},
+		{
+			name: "should decode base64 encoded JSON",
+			data: `{
+				"type": "UserCreated",
+				"data": "eyJpZCI6ICJzb21lLWlkIiwgImVtYWlsIjogInNvbWVvbmVAZmxhaG1pbmdvLmNvbSJ9",
+				"metadata": "eyJ0cmFjZV9pZCI6ICJzb21lLXRyYWNlIn0="
+			}`,
+		},
+		{
+			name: "should decode Avro unions",
+			data: `{
+				"type": "UserCreated",
+				"data": {"string": "{\"email\": \"someone@flahmingo.com\"}"},
+				"metadata": {"map": {"trace_id": "some-trace"}}
+			}`,
+		},
+		{
+			name: "should decode Avro bytes",
+			data: `{
+				"type": "UserCreated",
+				"data": {"bytes": "eyJpZCI6ICJzb21lLWlkIiwgImVtYWlsIjogInNvbWVvbmVAZmxhaG1pbmdvLmNvbSJ9"},
+				"metadata": {"string": "{\"trace_id\": \"some-trace\"}"}
+			}`,
+		},
+		{
+			name: "should decode the metadata values which are not strings",
+			data: `{
+				"type": "UserCreated",
+				"data": {"email": "someone@flahmingo.com"},
+				"metadata": {"trace_id": "some-trace", "attempt": 2, "parent": null}
+			}`,
+			metadata: ship.Metadata{"trace_id": "some-trace", "attempt": "2"},
+		},
+		{
+			name:   "should return error: invalid metadata",
+			data:   `{"type": "UserCreated", "data": {}, "metadata": ["some-trace"]}`,
+			reason: ship.DecodeReasonMalformed,
+		},
+		{
+			name:   "should return error: invalid data",
+			data:   `{"type": "UserCreated", "data": "not json"}`,
+			reason: ship.DecodeReasonInvalidData,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			r := ship.NewRegistry()
+			assert.NoError(t, r.Register(&userCreated{}))
+
+			m, err := Decode(r, []byte(tc.data))
+			if tc.reason != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tc.reason, ship.DecodeReason(err))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "someone@flahmingo.com", m.Data.(*userCreated).Email)
+			if tc.metadata != nil {
+				assert.Equal(t, tc.metadata, m.Metadata)
+				return
+			}
+			assert.Equal(t, ship.Metadata{"trace_id": "some-trace"}, m.Metadata)
+		})
+	}
+}
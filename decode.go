@@ -0,0 +1,110 @@
+package ship
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reasons of a DecodeError.
+const (
+	// DecodeReasonUnwrap is used when a transformation of the payload, e.g.
+	// decompression or decryption, could not be reversed.
+	DecodeReasonUnwrap = "unwrap"
+
+	// DecodeReasonMalformed is used when the message is not in the expected
+	// format.
+	DecodeReasonMalformed = "malformed"
+
+	// DecodeReasonContentType is used when there is no codec for the content
+	// type of the message.
+	DecodeReasonContentType = "content_type"
+
+	// DecodeReasonEmptyType is used when the message has an empty event type.
+	DecodeReasonEmptyType = "empty_type"
+
+	// DecodeReasonUnregistered is used when the event is not registered.
+	DecodeReasonUnregistered = "unregistered"
+
+	// DecodeReasonUpcast is used when the payload could not be upcasted.
+	DecodeReasonUpcast = "upcast"
+
+	// DecodeReasonInvalidData is used when the payload could not be decoded
+	// into the event.
+	DecodeReasonInvalidData = "invalid_data"
+
+	// DecodeReasonDeleted is used when the message is the deletion or the
+	// tombstone of a captured row, which is not an event.
+	DecodeReasonDeleted = "deleted"
+
+	// DecodeReasonUnavailable is used when a dependency of the decoding, e.g.
+	// a claim-check store or a key provider, is temporarily unavailable. The
+	// message is redelivered instead of being acknowledged.
+	DecodeReasonUnavailable = "unavailable"
+
+	// DecodeReasonUnknown is used for errors which are not a DecodeError.
+	DecodeReasonUnknown = "unknown"
+)
+
+// DecodeError is returned when a received message could not be decoded into
+// a Message.
+type DecodeError struct {
+	// Reason is a short, low cardinality reason of the failure, suitable for
+	// metric labels. For example: unregistered.
+	Reason string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// NewDecodeError returns a DecodeError with given reason.
+func NewDecodeError(reason string, err error) *DecodeError {
+	return &DecodeError{Reason: reason, Err: err}
+}
+
+// Error returns the error message.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("ship: unable to decode message (%s): %v", e.Reason, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeReason returns the reason of a DecodeError in the error chain or
+// DecodeReasonUnknown.
+func DecodeReason(err error) string {
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		return decodeErr.Reason
+	}
+	return DecodeReasonUnknown
+}
+
+// TemporaryError wraps an error which may not happen again, e.g. a network
+// failure. A message which could not be decoded because of a temporary error
+// is redelivered instead of being acknowledged.
+type TemporaryError struct {
+	Err error
+}
+
+// NewTemporaryError returns a TemporaryError wrapping err.
+func NewTemporaryError(err error) *TemporaryError {
+	return &TemporaryError{Err: err}
+}
+
+// Error returns the error message.
+func (e *TemporaryError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *TemporaryError) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporary reports whether a TemporaryError is in the error chain.
+func IsTemporary(err error) bool {
+	var tempErr *TemporaryError
+	return errors.As(err, &tempErr)
+}
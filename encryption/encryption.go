@@ -0,0 +1,263 @@
+// Package encryption implements envelope encryption of ship message payloads.
+//
+// Every payload is encrypted with a fresh AES-GCM data key. The data key is
+// wrapped by a key encryption key of a KeyProvider and travels with the
+// message in attributes, along with the id of the key encryption key.
+//
+// The ciphertext is bound to the key id and to the attributes describing the
+// payload, see AADVersionKey, so they cannot be altered without failing the
+// decryption.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/pkg/errors"
+)
+
+// Attribute keys of an encrypted message.
+const (
+	// KeyIDKey holds the id of the key encryption key.
+	KeyIDKey = "encryption-key-id"
+
+	// DataKeyKey holds the base64 encoded wrapped data key.
+	DataKeyKey = "encryption-data-key"
+
+	// AADVersionKey holds the version of the additional authenticated data of
+	// the payload. Payloads without it only authenticate the key id, they are
+	// encrypted by older versions.
+	AADVersionKey = "encryption-aad"
+)
+
+// aadVersion authenticates the key id along with the content type, content
+// encoding, schema version and ship_* attributes of the message.
+const aadVersion = "2"
+
+// dataKeyLength is the length of the generated data keys, AES-256.
+const dataKeyLength = 32
+
+// ErrKeyNotFound is returned by a KeyProvider if the key does not exist.
+var ErrKeyNotFound = errors.New("encryption: key not found")
+
+// ErrInvalidCiphertext is returned when a ciphertext cannot be decrypted,
+// because it or its additional data were altered or the key is wrong.
+var ErrInvalidCiphertext = errors.New("encryption: invalid ciphertext")
+
+// KeyProvider wraps and unwraps data keys with key encryption keys.
+//
+// Implementations must be safe for concurrent use. A KeyProvider can be backed
+// by a local keyring or by a remote KMS.
+type KeyProvider interface {
+	// WrapKey encrypts the data key with the key encryption key identified by
+	// keyID.
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+
+	// UnwrapKey decrypts the wrapped data key with the key encryption key
+	// identified by keyID.
+	//
+	// It returns an error wrapping ErrKeyNotFound if the key does not exist and
+	// ErrInvalidCiphertext if the wrapped key cannot be decrypted. Other errors,
+	// e.g. an unavailable or throttling KMS, are considered temporary: the
+	// message is redelivered.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Seal encrypts the plaintext with AES-GCM using the key. The random nonce is
+// prepended to the returned ciphertext.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a ciphertext created by Seal. It returns an error wrapping
+// ErrInvalidCiphertext if the ciphertext or the additional data were altered.
+func Open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.Wrap(ErrInvalidCiphertext, "ciphertext is too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCiphertext, err.Error())
+	}
+	return plaintext, nil
+}
+
+// newGCM returns an AES-GCM cipher for the key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewDataKey generates a random data key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, dataKeyLength)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return key, nil
+}
+
+// Encrypt encrypts the payload of the message with a fresh data key, which is
+// wrapped by the key encryption key identified by keyID.
+//
+// It returns a new message and does not modify the given one.
+func Encrypt(
+	ctx context.Context, kp KeyProvider, keyID string, m *ship.RawMessage,
+) (*ship.RawMessage, error) {
+	if _, ok := m.Attributes[KeyIDKey]; ok {
+		return nil, errors.New("encryption: message is already encrypted")
+	}
+
+	dataKey, err := NewDataKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "encryption: unable to generate data key")
+	}
+
+	wrapped, err := kp.WrapKey(ctx, keyID, dataKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encryption: unable to wrap data key with %s", keyID)
+	}
+
+	aad, err := additionalData(keyID, aadVersion, m.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := Seal(dataKey, m.Data, aad)
+	if err != nil {
+		return nil, errors.Wrap(err, "encryption: unable to encrypt payload")
+	}
+
+	out := *m
+	out.Data = data
+	out.Attributes = make(map[string]string, len(m.Attributes)+3)
+	for k, v := range m.Attributes {
+		out.Attributes[k] = v
+	}
+	out.Attributes[KeyIDKey] = keyID
+	out.Attributes[DataKeyKey] = base64.StdEncoding.EncodeToString(wrapped)
+	out.Attributes[AADVersionKey] = aadVersion
+
+	return &out, nil
+}
+
+// Decrypt decrypts the payload of the message, if it is encrypted by Encrypt.
+//
+// It returns a new message without the encryption attributes and does not
+// modify the given one. Errors of the key provider other than ErrKeyNotFound
+// and ErrInvalidCiphertext are returned wrapped in a ship.TemporaryError.
+func Decrypt(ctx context.Context, kp KeyProvider, m *ship.RawMessage) (*ship.RawMessage, error) {
+	keyID, ok := m.Attributes[KeyIDKey]
+	if !ok {
+		return m, nil
+	}
+
+	if kp == nil {
+		return nil, errors.Errorf("encryption: no key provider configured to decrypt with %s", keyID)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(m.Attributes[DataKeyKey])
+	if err != nil {
+		return nil, errors.Wrap(err, "encryption: invalid data key")
+	}
+
+	dataKey, err := kp.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		err = errors.Wrapf(err, "encryption: unable to unwrap data key with %s", keyID)
+		if !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrInvalidCiphertext) {
+			return nil, ship.NewTemporaryError(err)
+		}
+		return nil, err
+	}
+
+	aad, err := additionalData(keyID, m.Attributes[AADVersionKey], m.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := Open(dataKey, m.Data, aad)
+	if err != nil {
+		return nil, errors.Wrap(err, "encryption: unable to decrypt payload")
+	}
+
+	out := *m
+	out.Data = data
+	out.Attributes = make(map[string]string, len(m.Attributes))
+	for k, v := range m.Attributes {
+		if k != KeyIDKey && k != DataKeyKey && k != AADVersionKey {
+			out.Attributes[k] = v
+		}
+	}
+
+	return &out, nil
+}
+
+// additionalData returns the additional data authenticated along with a
+// payload.
+//
+// Version 2 covers the attributes describing how the payload is decoded. The
+// claim-check reference is added once the payload is encrypted: a payload
+// swapped in the store fails the authentication anyway. An unknown version
+// fails the decryption, so the attributes cannot be stripped to downgrade it.
+func additionalData(keyID, version string, attributes map[string]string) ([]byte, error) {
+	switch version {
+	case "":
+		return []byte(keyID), nil
+	case aadVersion:
+	default:
+		return nil, errors.Errorf("encryption: unknown additional data version %q", version)
+	}
+
+	authenticated := make(map[string]string)
+	for k, v := range attributes {
+		if isAuthenticated(k) {
+			authenticated[k] = v
+		}
+	}
+
+	// Keys of a map are sorted by json.Marshal, its output is canonical.
+	aad, err := json.Marshal(struct {
+		KeyID      string            `json:"keyId"`
+		Attributes map[string]string `json:"attributes"`
+	}{keyID, authenticated})
+	return aad, errors.Wrap(err, "encryption: unable to encode additional data")
+}
+
+// isAuthenticated reports whether the attribute is covered by the additional
+// data of the payload.
+func isAuthenticated(key string) bool {
+	switch key {
+	case ship.ContentTypeKey, ship.SchemaVersionKey, compress.EncodingKey:
+		return true
+	}
+	return strings.HasPrefix(key, "ship_")
+}
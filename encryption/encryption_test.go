@@ -0,0 +1,276 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyring(t *testing.T) {
+	ctx := context.Background()
+	keyring := NewKeyring()
+
+	err := keyring.Add("short", []byte("too short"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid key short")
+
+	assert.NoError(t, keyring.Generate("some-key"))
+
+	err = keyring.Generate("some-key")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "key some-key already exists")
+
+	dataKey, err := NewDataKey()
+	assert.NoError(t, err)
+
+	wrapped, err := keyring.WrapKey(ctx, "some-key", dataKey)
+	assert.NoError(t, err)
+	assert.NotEqual(t, dataKey, wrapped)
+
+	unwrapped, err := keyring.UnwrapKey(ctx, "some-key", wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	keyring.Remove("some-key")
+
+	_, err = keyring.UnwrapKey(ctx, "some-key", wrapped)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestEncrypt(t *testing.T) {
+	ctx := context.Background()
+
+	keyring := NewKeyring()
+	assert.NoError(t, keyring.Generate("some-key"))
+
+	testCases := []struct {
+		name         string
+		keyID        string
+		message      *ship.RawMessage
+		checkResults func(t *testing.T, in, out *ship.RawMessage, err error)
+	}{
+		{
+			name:    "should return error: key not found",
+			keyID:   "missing-key",
+			message: &ship.RawMessage{Data: []byte("secret")},
+			checkResults: func(t *testing.T, in, out *ship.RawMessage, err error) {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+			},
+		},
+		{
+			name:  "should return error: message is already encrypted",
+			keyID: "some-key",
+			message: &ship.RawMessage{
+				Data:       []byte("secret"),
+				Attributes: map[string]string{KeyIDKey: "some-key"},
+			},
+			checkResults: func(t *testing.T, in, out *ship.RawMessage, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "message is already encrypted")
+			},
+		},
+		{
+			name:  "should encrypt and decrypt message",
+			keyID: "some-key",
+			message: &ship.RawMessage{
+				Data:       []byte("secret"),
+				Attributes: map[string]string{"key": "value"},
+			},
+			checkResults: func(t *testing.T, in, out *ship.RawMessage, err error) {
+				assert.NoError(t, err)
+				assert.NotContains(t, string(out.Data), "secret")
+				assert.Equal(t, "some-key", out.Attributes[KeyIDKey])
+				assert.NotEmpty(t, out.Attributes[DataKeyKey])
+				assert.NotContains(t, in.Attributes, KeyIDKey)
+
+				decrypted, err := Decrypt(ctx, keyring, out)
+				assert.NoError(t, err)
+				assert.Equal(t, in, decrypted)
+
+				_, err = Decrypt(ctx, nil, out)
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "no key provider configured")
+
+				out.Data[len(out.Data)-1] ^= 0xff
+				_, err = Decrypt(ctx, keyring, out)
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "unable to decrypt payload")
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Encrypt(ctx, keyring, tc.keyID, tc.message)
+			tc.checkResults(t, tc.message, out, err)
+		})
+	}
+}
+
+// unavailableProvider is a KeyProvider whose KMS cannot be reached.
+type unavailableProvider struct {
+	*Keyring
+}
+
+func (unavailableProvider) UnwrapKey(context.Context, string, []byte) ([]byte, error) {
+	return nil, errors.New("kms is unavailable")
+}
+
+func TestDecrypt(t *testing.T) {
+	ctx := context.Background()
+
+	keyring := NewKeyring()
+	assert.NoError(t, keyring.Generate("some-key"))
+
+	plain := &ship.RawMessage{
+		Data: []byte("secret"),
+		Attributes: map[string]string{
+			ship.TypeKey:         "SomethingCreated",
+			compress.EncodingKey: "gzip",
+			"key":                "value",
+		},
+	}
+
+	encrypted, err := Encrypt(ctx, keyring, "some-key", plain)
+	assert.NoError(t, err)
+
+	// legacy encrypts a message as the versions without the
+	// AADVersionKey attribute.
+	legacy := func() *ship.RawMessage {
+		dataKey, err := NewDataKey()
+		assert.NoError(t, err)
+		wrapped, err := keyring.WrapKey(ctx, "some-key", dataKey)
+		assert.NoError(t, err)
+		data, err := Seal(dataKey, plain.Data, []byte("some-key"))
+		assert.NoError(t, err)
+
+		return &ship.RawMessage{
+			Data: data,
+			Attributes: map[string]string{
+				ship.TypeKey: "SomethingCreated",
+				KeyIDKey:     "some-key",
+				DataKeyKey:   base64.StdEncoding.EncodeToString(wrapped),
+			},
+		}
+	}
+
+	// with returns a copy of the encrypted message with an attribute changed,
+	// an empty value removes it.
+	with := func(key, value string) *ship.RawMessage {
+		m := *encrypted
+		m.Attributes = make(map[string]string, len(encrypted.Attributes))
+		for k, v := range encrypted.Attributes {
+			m.Attributes[k] = v
+		}
+		if value == "" {
+			delete(m.Attributes, key)
+		} else {
+			m.Attributes[key] = value
+		}
+		return &m
+	}
+
+	testCases := []struct {
+		name         string
+		kp           KeyProvider
+		message      *ship.RawMessage
+		checkResults func(t *testing.T, out *ship.RawMessage, err error)
+	}{
+		{
+			name:    "should decrypt message",
+			kp:      keyring,
+			message: encrypted,
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, plain, out)
+			},
+		},
+		{
+			name:    "should decrypt message encrypted without authenticated attributes",
+			kp:      keyring,
+			message: legacy(),
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, plain.Data, out.Data)
+				assert.Equal(t, map[string]string{ship.TypeKey: "SomethingCreated"}, out.Attributes)
+			},
+		},
+		{
+			name:    "should return error: type is altered",
+			kp:      keyring,
+			message: with(ship.TypeKey, "SomethingDeleted"),
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.ErrorIs(t, err, ErrInvalidCiphertext)
+				assert.False(t, ship.IsTemporary(err))
+			},
+		},
+		{
+			name:    "should return error: content encoding is removed",
+			kp:      keyring,
+			message: with(compress.EncodingKey, ""),
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.ErrorIs(t, err, ErrInvalidCiphertext)
+			},
+		},
+		{
+			name:    "should return error: additional data version is removed",
+			kp:      keyring,
+			message: with(AADVersionKey, ""),
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.ErrorIs(t, err, ErrInvalidCiphertext)
+			},
+		},
+		{
+			name:    "should return error: unknown additional data version",
+			kp:      keyring,
+			message: with(AADVersionKey, "99"),
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "unknown additional data version")
+			},
+		},
+		{
+			name:    "should decrypt message: unauthenticated attribute is added",
+			kp:      keyring,
+			message: with("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"),
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, plain.Data, out.Data)
+			},
+		},
+		{
+			name:    "should return temporary error: key provider is unavailable",
+			kp:      unavailableProvider{keyring},
+			message: encrypted,
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.Error(t, err)
+				assert.True(t, ship.IsTemporary(err))
+			},
+		},
+		{
+			name:    "should return error: key not found",
+			kp:      NewKeyring(),
+			message: encrypted,
+			checkResults: func(t *testing.T, out *ship.RawMessage, err error) {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+				assert.False(t, ship.IsTemporary(err))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Decrypt(ctx, tc.kp, tc.message)
+			tc.checkResults(t, out, err)
+		})
+	}
+}
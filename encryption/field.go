@@ -0,0 +1,232 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// fieldPrefix marks an encrypted field value.
+//
+// An encrypted value has the following format.
+//
+//	ship:pii:<base64 wrapped data key>:<base64 ciphertext>
+const fieldPrefix = "ship:pii:"
+
+// fieldSeparator separates the wrapped data key and ciphertext of an
+// encrypted field value.
+const fieldSeparator = ":"
+
+// PIIEvent is implemented by the events generated from messages which have
+// fields marked with the (ship.pii) option.
+type PIIEvent interface {
+	// EncryptPII encrypts the fields marked as PII with the key of the subject.
+	//
+	// The key of the subject must exist, an error wrapping ErrKeyNotFound is
+	// returned otherwise, see NewFieldSealer.
+	EncryptPII(ctx context.Context, kp KeyProvider, subject string) error
+
+	// DecryptPII decrypts the fields marked as PII with the key of the subject.
+	//
+	// If the key of the subject does not exist anymore, the fields are cleared
+	// and an error wrapping ErrKeyNotFound is returned. Rest of the event is
+	// still usable.
+	DecryptPII(ctx context.Context, kp KeyProvider, subject string) error
+
+	// RedactPII clears the fields marked as PII.
+	RedactPII()
+}
+
+// IsEncryptedField reports whether the field value is encrypted by a
+// FieldSealer.
+func IsEncryptedField(v string) bool {
+	return strings.HasPrefix(v, fieldPrefix)
+}
+
+// FieldSealer encrypts field values of an event with a data key wrapped by
+// the key of a subject.
+//
+// The first error is recorded and returned by Err, rest of the calls become
+// no-op. It is used by the generated EncryptPII methods.
+type FieldSealer struct {
+	subject    string
+	dataKey    []byte
+	wrappedKey string
+	err        error
+}
+
+// NewFieldSealer returns a FieldSealer which encrypts with a fresh data key
+// wrapped by the key of the subject.
+//
+// The key of the subject is not created on demand, so a deleted key is never
+// recreated: the application provisions it when the subject is created, e.g.
+// with Keyring.Generate or in its KMS. Sealing with a key which does not exist
+// fails with an error wrapping ErrKeyNotFound.
+func NewFieldSealer(ctx context.Context, kp KeyProvider, subject string) *FieldSealer {
+	s := &FieldSealer{subject: subject}
+
+	if kp == nil {
+		s.err = errors.New("encryption: key provider cannot be nil")
+		return s
+	}
+
+	dataKey, err := NewDataKey()
+	if err != nil {
+		s.err = errors.Wrap(err, "encryption: unable to generate data key")
+		return s
+	}
+
+	wrapped, err := kp.WrapKey(ctx, subject, dataKey)
+	if err != nil {
+		s.err = errors.Wrapf(err, "encryption: unable to wrap data key with %s", subject)
+		return s
+	}
+
+	s.dataKey = dataKey
+	s.wrappedKey = base64.RawURLEncoding.EncodeToString(wrapped)
+
+	return s
+}
+
+// SealString encrypts the value. Empty and already encrypted values are
+// returned as is.
+func (s *FieldSealer) SealString(v string) string {
+	if s.err != nil || v == "" || IsEncryptedField(v) {
+		return v
+	}
+
+	ciphertext, err := Seal(s.dataKey, []byte(v), []byte(s.subject))
+	if err != nil {
+		s.err = errors.Wrap(err, "encryption: unable to encrypt field")
+		return v
+	}
+
+	return fieldPrefix + s.wrappedKey + fieldSeparator +
+		base64.RawURLEncoding.EncodeToString(ciphertext)
+}
+
+// SealBytes encrypts the value. Empty and already encrypted values are
+// returned as is.
+func (s *FieldSealer) SealBytes(v []byte) []byte {
+	if len(v) == 0 || IsEncryptedField(string(v)) {
+		return v
+	}
+	return []byte(s.SealString(string(v)))
+}
+
+// Err returns the first error occurred while sealing.
+func (s *FieldSealer) Err() error {
+	return s.err
+}
+
+// FieldOpener decrypts field values encrypted by a FieldSealer with the key
+// of a subject.
+//
+// The first error is recorded and returned by Err, the value failed to
+// decrypt is cleared. It is used by the generated DecryptPII methods.
+type FieldOpener struct {
+	ctx     context.Context
+	kp      KeyProvider
+	subject string
+
+	// dataKeys caches unwrapped data keys by their wrapped form.
+	dataKeys map[string][]byte
+	err      error
+}
+
+// NewFieldOpener returns a FieldOpener which decrypts with the key of the
+// subject.
+func NewFieldOpener(ctx context.Context, kp KeyProvider, subject string) *FieldOpener {
+	return &FieldOpener{
+		ctx:      ctx,
+		kp:       kp,
+		subject:  subject,
+		dataKeys: make(map[string][]byte),
+	}
+}
+
+// OpenString decrypts the value. Values which are not encrypted are returned
+// as is.
+func (o *FieldOpener) OpenString(v string) string {
+	if !IsEncryptedField(v) {
+		return v
+	}
+
+	plaintext, err := o.open(v)
+	if err != nil {
+		if o.err == nil {
+			o.err = err
+		}
+		return ""
+	}
+
+	return string(plaintext)
+}
+
+// OpenBytes decrypts the value. Values which are not encrypted are returned
+// as is.
+func (o *FieldOpener) OpenBytes(v []byte) []byte {
+	if !IsEncryptedField(string(v)) {
+		return v
+	}
+
+	plaintext, err := o.open(string(v))
+	if err != nil {
+		if o.err == nil {
+			o.err = err
+		}
+		return nil
+	}
+
+	return plaintext
+}
+
+// open decrypts an encrypted field value.
+func (o *FieldOpener) open(v string) ([]byte, error) {
+	if o.kp == nil {
+		return nil, errors.New("encryption: key provider cannot be nil")
+	}
+
+	v = strings.TrimPrefix(v, fieldPrefix)
+
+	i := strings.Index(v, fieldSeparator)
+	if i < 0 {
+		return nil, errors.New("encryption: invalid encrypted field")
+	}
+	wrappedKey, sealed := v[:i], v[i+len(fieldSeparator):]
+
+	dataKey, ok := o.dataKeys[wrappedKey]
+	if !ok {
+		wrapped, err := base64.RawURLEncoding.DecodeString(wrappedKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "encryption: invalid data key")
+		}
+
+		dataKey, err = o.kp.UnwrapKey(o.ctx, o.subject, wrapped)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err, "encryption: unable to unwrap data key with %s", o.subject,
+			)
+		}
+		o.dataKeys[wrappedKey] = dataKey
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "encryption: invalid encrypted field")
+	}
+
+	plaintext, err := Open(dataKey, ciphertext, []byte(o.subject))
+	if err != nil {
+		return nil, errors.Wrap(err, "encryption: unable to decrypt field")
+	}
+
+	return plaintext, nil
+}
+
+// Err returns the first error occurred while opening.
+func (o *FieldOpener) Err() error {
+	return o.err
+}
@@ -0,0 +1,48 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldSealer(t *testing.T) {
+	ctx := context.Background()
+
+	keyring := NewKeyring()
+	assert.NoError(t, keyring.Generate("some-subject"))
+
+	s := NewFieldSealer(ctx, keyring, "some-subject")
+	name := s.SealString("Jane")
+	email := s.SealBytes([]byte("jane@example.com"))
+	empty := s.SealString("")
+	assert.NoError(t, s.Err())
+
+	assert.True(t, IsEncryptedField(name))
+	assert.True(t, IsEncryptedField(string(email)))
+	assert.Empty(t, empty)
+	assert.Equal(t, name, s.SealString(name))
+
+	o := NewFieldOpener(ctx, keyring, "some-subject")
+	assert.Equal(t, "Jane", o.OpenString(name))
+	assert.Equal(t, []byte("jane@example.com"), o.OpenBytes(email))
+	assert.Equal(t, "plaintext", o.OpenString("plaintext"))
+	assert.NoError(t, o.Err())
+
+	o = NewFieldOpener(ctx, keyring, "other-subject")
+	assert.Empty(t, o.OpenString(name))
+	assert.Error(t, o.Err())
+
+	// Deleting the key of the subject shreds the fields.
+	keyring.Remove("some-subject")
+
+	o = NewFieldOpener(ctx, keyring, "some-subject")
+	assert.Empty(t, o.OpenString(name))
+	assert.Nil(t, o.OpenBytes(email))
+	assert.ErrorIs(t, o.Err(), ErrKeyNotFound)
+
+	s = NewFieldSealer(ctx, keyring, "some-subject")
+	assert.Equal(t, "Jane", s.SealString("Jane"))
+	assert.ErrorIs(t, s.Err(), ErrKeyNotFound)
+}
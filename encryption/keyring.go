@@ -0,0 +1,96 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Compile time check.
+var _ KeyProvider = (*Keyring)(nil)
+
+// Keyring is a local KeyProvider which holds key encryption keys in memory.
+//
+// All methods are thread-safe.
+type Keyring struct {
+	keys map[string][]byte
+	mu   sync.RWMutex
+}
+
+// NewKeyring creates an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string][]byte),
+	}
+}
+
+// Add adds a key encryption key to the keyring. The key must be 16, 24 or 32
+// bytes long to select AES-128, AES-192 or AES-256.
+func (k *Keyring) Add(keyID string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return errors.Wrapf(err, "encryption: invalid key %s", keyID)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[keyID]; ok {
+		return errors.Errorf("encryption: key %s already exists", keyID)
+	}
+
+	k.keys[keyID] = append([]byte(nil), key...)
+
+	return nil
+}
+
+// Generate generates a random AES-256 key encryption key and adds it to the
+// keyring.
+func (k *Keyring) Generate(keyID string) error {
+	key, err := NewDataKey()
+	if err != nil {
+		return err
+	}
+	return k.Add(keyID, key)
+}
+
+// Remove removes the key encryption key from the keyring. Data keys wrapped by
+// it cannot be unwrapped anymore.
+func (k *Keyring) Remove(keyID string) {
+	k.mu.Lock()
+	delete(k.keys, keyID)
+	k.mu.Unlock()
+}
+
+// key returns the key encryption key identified by keyID.
+func (k *Keyring) key(keyID string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.Wrap(ErrKeyNotFound, keyID)
+	}
+	return key, nil
+}
+
+// WrapKey encrypts the data key with the key encryption key identified by
+// keyID.
+func (k *Keyring) WrapKey(_ context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	key, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return Seal(key, dataKey, []byte(keyID))
+}
+
+// UnwrapKey decrypts the wrapped data key with the key encryption key
+// identified by keyID.
+func (k *Keyring) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return Open(key, wrapped, []byte(keyID))
+}
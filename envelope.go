@@ -0,0 +1,175 @@
+package ship
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Attribute keys used to carry the message fields alongside the encoded event
+// data.
+const (
+	// ContentTypeKey holds the content type of the encoded event data.
+	ContentTypeKey = "content-type"
+
+	// IDKey holds the Message.ID.
+	IDKey = "ship_id"
+
+	// TypeKey holds the Message.Type.
+	TypeKey = "ship_type"
+
+	// AggregateIDKey holds the Message.AggregateID.
+	AggregateIDKey = "ship_aggregate_id"
+
+	// AggregateTypeKey holds the Message.AggregateType.
+	AggregateTypeKey = "ship_aggregate_type"
+
+	// AtKey holds the Message.At in RFC3339 format.
+	AtKey = "ship_at"
+
+	// VersionKey holds the Message.Version.
+	VersionKey = "ship_version"
+)
+
+// envelopeKeys are the attribute keys owned by the envelope.
+var envelopeKeys = []string{
+	ContentTypeKey, IDKey, TypeKey, AggregateIDKey, AggregateTypeKey, AtKey, VersionKey,
+}
+
+// MarshalMessage encodes the message data with the codec and returns a raw
+// message carrying the message fields and metadata as attributes.
+//
+// The schema version of the event is stamped in the attributes, so the
+// subscribers can upcast the payload.
+func MarshalMessage(c Codec, m *Message) (*RawMessage, error) {
+	data, err := c.Marshal(m.Data)
+	if err != nil {
+		return nil, fmt.Errorf("ship: unable to marshal event %s: %w", m.Type, err)
+	}
+
+	attrs := make(map[string]string, len(m.Metadata)+len(envelopeKeys)+1)
+	for k, v := range m.Metadata {
+		attrs[k] = v
+	}
+
+	eventType := m.Type
+	if eventType == "" && m.Data != nil {
+		eventType = m.Data.EventName()
+	}
+
+	attrs[ContentTypeKey] = c.ContentType()
+	attrs[IDKey] = m.ID
+	attrs[TypeKey] = eventType
+	attrs[AggregateIDKey] = m.AggregateID
+	attrs[AggregateTypeKey] = m.AggregateType
+	attrs[VersionKey] = strconv.FormatUint(m.Version, 10)
+	attrs[SchemaVersionKey] = strconv.FormatUint(getSchemaVersion(m.Data), 10)
+	if !m.At.IsZero() {
+		attrs[AtKey] = m.At.Format(time.RFC3339Nano)
+	}
+
+	return &RawMessage{
+		Data:       data,
+		Attributes: attrs,
+	}, nil
+}
+
+// IsEnvelope reports whether the raw message was encoded by MarshalMessage,
+// which always sets the event type attribute. The content type is not
+// checked, as other producers, e.g. Kafka Connect, set it as well.
+func IsEnvelope(m *RawMessage) bool {
+	_, ok := m.Attributes[TypeKey]
+	return ok
+}
+
+// UnmarshalMessage decodes a raw message encoded by MarshalMessage.
+// Returned errors are of type *DecodeError.
+//
+// The codec is picked from codecs by the content type attribute, the event is
+// created from the registry and its payload is upcasted to the current schema
+// version before decoding. The envelope attributes and the schema version are
+// not part of the returned metadata.
+func UnmarshalMessage(
+	r *Registry, codecs map[string]Codec, m *RawMessage,
+) (*Message, error) {
+	contentType := m.Attributes[ContentTypeKey]
+	c, ok := codecs[contentType]
+	if !ok {
+		return nil, NewDecodeError(
+			DecodeReasonContentType,
+			fmt.Errorf("ship: no codec for content type %q", contentType),
+		)
+	}
+
+	eventType := m.Attributes[TypeKey]
+	if eventType == "" {
+		return nil, NewDecodeError(
+			DecodeReasonEmptyType,
+			fmt.Errorf("ship: message %s has an empty event type", m.ID),
+		)
+	}
+
+	event, err := r.Get(eventType)
+	if err != nil {
+		return nil, NewDecodeError(DecodeReasonUnregistered, err)
+	}
+
+	metadata := make(Metadata, len(m.Attributes))
+	for k, v := range m.Attributes {
+		metadata[k] = v
+	}
+	for _, k := range envelopeKeys {
+		delete(metadata, k)
+	}
+
+	schemaVersion, err := ParseSchemaVersion(metadata)
+	if err != nil {
+		return nil, NewDecodeError(DecodeReasonUpcast, err)
+	}
+	// The payload is upcasted to the current version, the stamped version
+	// does not describe it anymore.
+	delete(metadata, SchemaVersionKey)
+
+	data, err := r.Upcast(eventType, schemaVersion, m.Data)
+	if err != nil {
+		return nil, NewDecodeError(DecodeReasonUpcast, err)
+	}
+
+	if err := c.Unmarshal(data, event); err != nil {
+		return nil, NewDecodeError(
+			DecodeReasonInvalidData,
+			fmt.Errorf("ship: unable to unmarshal event %s: %w", eventType, err),
+		)
+	}
+
+	msg := &Message{
+		ID:            m.Attributes[IDKey],
+		Metadata:      metadata,
+		Type:          eventType,
+		AggregateID:   m.Attributes[AggregateIDKey],
+		AggregateType: m.Attributes[AggregateTypeKey],
+		Data:          event,
+	}
+
+	if v := m.Attributes[VersionKey]; v != "" {
+		msg.Version, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, NewDecodeError(
+				DecodeReasonMalformed,
+				fmt.Errorf("ship: invalid message version %q: %w", v, err),
+			)
+		}
+	}
+
+	if at := m.Attributes[AtKey]; at != "" {
+		msg.At, err = time.Parse(time.RFC3339Nano, at)
+		if err != nil {
+			return nil, NewDecodeError(
+				DecodeReasonMalformed,
+				fmt.Errorf("ship: invalid message time %q: %w", at, err),
+			)
+		}
+	}
+
+	return msg, nil
+}
@@ -3,6 +3,8 @@ package ship
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 	"sync"
 )
 
@@ -12,13 +14,62 @@ type Event interface {
 	EventName() string
 }
 
-var (
-	// eventStore is global to hold event registration data.
-	eventStore = make(map[string]reflect.Type)
+// VersionedEvent is an event which carries a schema version.
+//
+// Events which does not implement this interface are considered to be on
+// version 1.
+type VersionedEvent interface {
+	Event
 
-	// eventStoreMu is a mutex for locking the event store.
-	eventStoreMu = sync.RWMutex{}
-)
+	// SchemaVersion returns the current schema version of the event.
+	SchemaVersion() uint64
+}
+
+// Upcaster transforms the payload of an event from one schema version to the
+// next one. It receives the payload as it was encoded (e.g. JSON or proto) and
+// returns it in the shape of the next version.
+type Upcaster func(data []byte) ([]byte, error)
+
+// SchemaVersionKey is the metadata key which holds the schema version of an
+// event payload.
+const SchemaVersionKey = "schema_version"
+
+// defaultSchemaVersion is the version of an event that does not define one.
+const defaultSchemaVersion = 1
+
+// eventEntry holds the registration data of an event.
+type eventEntry struct {
+	typ     reflect.Type
+	version uint64
+}
+
+// Registry holds event registration data. It is used to create concrete event
+// data structs by their name and to upcast old payloads.
+//
+// All methods are thread-safe.
+type Registry struct {
+	// events holds registered events keyed by their name.
+	events map[string]eventEntry
+
+	// upcasters holds upcasters of an event keyed by the version they
+	// upcast from.
+	upcasters map[string]map[uint64]Upcaster
+
+	// mu is a mutex for locking the registry.
+	mu sync.RWMutex
+}
+
+// NewRegistry creates an empty event registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		events:    make(map[string]eventEntry),
+		upcasters: make(map[string]map[uint64]Upcaster),
+	}
+}
+
+// DefaultRegistry is the registry used by the package level functions, e.g.
+// RegisterEvent, GetEvent, etc.
+var DefaultRegistry = NewRegistry()
 
 // getType returns the reflected type of given value.
 func getType(v interface{}) reflect.Type {
@@ -29,42 +80,267 @@ func getType(v interface{}) reflect.Type {
 	return t
 }
 
+// getSchemaVersion returns the schema version of given event.
+func getSchemaVersion(e Event) uint64 {
+	if ve, ok := e.(VersionedEvent); ok && ve.SchemaVersion() > 0 {
+		return ve.SchemaVersion()
+	}
+	return defaultSchemaVersion
+}
+
+// Register registers an event with its current schema version, see
+// VersionedEvent. It returns an error if the event is already registered.
+func (r *Registry) Register(e Event) error {
+	name := e.EventName()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.events[name]; ok {
+		return fmt.Errorf("ship: event %s is already registered", name)
+	}
+	r.events[name] = eventEntry{
+		typ:     getType(e),
+		version: getSchemaVersion(e),
+	}
+
+	return nil
+}
+
+// Get returns a new instance of event matching it's name or an error if the
+// event is not registered.
+func (r *Registry) Get(name string) (Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if entry, ok := r.events[name]; ok {
+		return reflect.New(entry.typ).Interface().(Event), nil
+	}
+
+	return nil, fmt.Errorf("ship: event %s is not registered", name)
+}
+
+// Unregister removes the event and its upcasters from the registry. It returns
+// an error if the event is not registered.
+func (r *Registry) Unregister(e Event) error {
+	name := e.EventName()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.events[name]; !ok {
+		return fmt.Errorf("ship: event %s is not registered", name)
+	}
+
+	delete(r.events, name)
+	delete(r.upcasters, name)
+
+	return nil
+}
+
+// List returns the sorted names of registered events.
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.events))
+	for name := range r.events {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+
+	sort.Strings(names)
+
+	return names
+}
+
+// SchemaVersion returns the current schema version of a registered event or an
+// error if the event is not registered.
+func (r *Registry) SchemaVersion(name string) (uint64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if entry, ok := r.events[name]; ok {
+		return entry.version, nil
+	}
+
+	return 0, fmt.Errorf("ship: event %s is not registered", name)
+}
+
+// RegisterUpcaster registers an upcaster for the named event, which transforms
+// a payload from version `from` to version `from+1`. It returns an error if an
+// upcaster is already registered for the version.
+func (r *Registry) RegisterUpcaster(name string, from uint64, up Upcaster) error {
+	if up == nil {
+		return fmt.Errorf("ship: upcaster for event %s is nil", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	upcasters, ok := r.upcasters[name]
+	if !ok {
+		upcasters = make(map[uint64]Upcaster)
+		r.upcasters[name] = upcasters
+	}
+
+	if _, ok := upcasters[from]; ok {
+		return fmt.Errorf(
+			"ship: upcaster for event %s from version %d is already registered", name, from,
+		)
+	}
+	upcasters[from] = up
+
+	return nil
+}
+
+// UnregisterUpcaster removes the upcaster of the named event for version
+// `from`. It returns an error if the upcaster is not registered.
+func (r *Registry) UnregisterUpcaster(name string, from uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.upcasters[name][from]; !ok {
+		return fmt.Errorf(
+			"ship: upcaster for event %s from version %d is not registered", name, from,
+		)
+	}
+
+	delete(r.upcasters[name], from)
+
+	return nil
+}
+
+// Upcast transforms the payload of the named event from given version to the
+// current version of the registered event by running the chain of registered
+// upcasters.
+//
+// A version of 0 is treated as the default version, 1.
+func (r *Registry) Upcast(name string, version uint64, data []byte) ([]byte, error) {
+	if version == 0 {
+		version = defaultSchemaVersion
+	}
+
+	chain, err := r.upcastChain(name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// The upcasters run without the lock, so a slow upcaster does not block
+	// the registry.
+	for i, up := range chain {
+		data, err = up(data)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"ship: unable to upcast event %s from version %d: %w",
+				name, version+uint64(i), err,
+			)
+		}
+	}
+
+	return data, nil
+}
+
+// upcastChain returns a copy of the chain of upcasters of the named event from
+// given version to the current version of the registered event.
+func (r *Registry) upcastChain(name string, version uint64) ([]Upcaster, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.events[name]
+	if !ok {
+		return nil, fmt.Errorf("ship: event %s is not registered", name)
+	}
+
+	if version > entry.version {
+		return nil, fmt.Errorf(
+			"ship: event %s version %d is newer than registered version %d",
+			name, version, entry.version,
+		)
+	}
+
+	chain := make([]Upcaster, 0, entry.version-version)
+	for v := version; v < entry.version; v++ {
+		up, ok := r.upcasters[name][v]
+		if !ok {
+			return nil, fmt.Errorf(
+				"ship: no upcaster registered for event %s from version %d", name, v,
+			)
+		}
+		chain = append(chain, up)
+	}
+
+	return chain, nil
+}
+
 // RegisterEvent registers an event to be global available for serialization, and
 // other dependents. It used to create concrete event data structs when loading
 // from event store.
+//
+// The event is registered with its current schema version, see VersionedEvent.
 func RegisterEvent(e Event) {
-	name := e.EventName()
-
-	eventStoreMu.Lock()
-	defer eventStoreMu.Unlock()
-
-	if _, ok := eventStore[name]; ok {
-		panic(fmt.Sprintf("ship: event %s is already registered", name))
+	if err := DefaultRegistry.Register(e); err != nil {
+		panic(err.Error())
 	}
-	eventStore[name] = getType(e)
 }
 
 // GetEvent returns a new instance of event matching it's name or an error if
 // the event is not registered.
 func GetEvent(name string) (Event, error) {
-	eventStoreMu.RLock()
-	defer eventStoreMu.RUnlock()
-
-	if eventType, ok := eventStore[name]; ok {
-		return reflect.New(eventType).Interface().(Event), nil
-	}
+	return DefaultRegistry.Get(name)
+}
 
-	return nil, fmt.Errorf("ship: event %s is not registered", name)
+// GetSchemaVersion returns the current schema version of a registered event or
+// an error if the event is not registered.
+func GetSchemaVersion(name string) (uint64, error) {
+	return DefaultRegistry.SchemaVersion(name)
 }
 
 // UnregisterEvent removes the event from registered events list.
 // This is mainly useful in mainenance situations where the event data
 // needs to be switched in a migrations or test.
 func UnregisterEvent(event Event) {
-	name := event.EventName()
-	if _, ok := eventStore[name]; !ok {
-		panic(fmt.Sprintf("ship: event %s is not registered", name))
+	if err := DefaultRegistry.Unregister(event); err != nil {
+		panic(err.Error())
+	}
+}
+
+// RegisterUpcaster registers an upcaster for the named event, which transforms
+// a payload from version `from` to version `from+1`.
+//
+// Upcasters are chained by Upcast to bring an old payload to the current
+// version of the event.
+func RegisterUpcaster(name string, from uint64, up Upcaster) {
+	if err := DefaultRegistry.RegisterUpcaster(name, from, up); err != nil {
+		panic(err.Error())
+	}
+}
+
+// UnregisterUpcaster removes the upcaster of the named event for version
+// `from`.
+func UnregisterUpcaster(name string, from uint64) {
+	if err := DefaultRegistry.UnregisterUpcaster(name, from); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Upcast transforms the payload of the named event from given version to the
+// current version of the registered event by running the chain of registered
+// upcasters.
+func Upcast(name string, version uint64, data []byte) ([]byte, error) {
+	return DefaultRegistry.Upcast(name, version, data)
+}
+
+// ParseSchemaVersion parses the schema version stored in metadata.
+// It returns the default version if metadata does not carry one.
+func ParseSchemaVersion(m Metadata) (uint64, error) {
+	v, ok := m[SchemaVersionKey]
+	if !ok || v == "" {
+		return defaultSchemaVersion, nil
+	}
+
+	version, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ship: invalid schema version %q: %w", v, err)
 	}
 
-	delete(eventStore, name)
+	return version, nil
 }
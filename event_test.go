@@ -0,0 +1,206 @@
+package ship
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEventV1 struct{}
+
+func (*testEventV1) EventName() string { return "TestEventV1" }
+
+type testEventV3 struct{}
+
+func (*testEventV3) EventName() string { return "TestEventV3" }
+
+func (*testEventV3) SchemaVersion() uint64 { return 3 }
+
+func TestUpcast(t *testing.T) {
+	r := NewRegistry()
+	assert.NoError(t, r.Register(&testEventV1{}))
+	assert.NoError(t, r.Register(&testEventV3{}))
+
+	appendVersion := func(v string) Upcaster {
+		return func(data []byte) ([]byte, error) {
+			return append(data, v...), nil
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		eventName    string
+		version      uint64
+		configure    func(t *testing.T, r *Registry)
+		checkResults func(t *testing.T, data []byte, err error)
+	}{
+		{
+			name:      "should return error: event is not registered",
+			eventName: "NotRegistered",
+			version:   1,
+			configure: func(t *testing.T, r *Registry) {},
+			checkResults: func(t *testing.T, data []byte, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "event NotRegistered is not registered")
+			},
+		},
+		{
+			name:      "should return data as is for current version",
+			eventName: "TestEventV1",
+			version:   0,
+			configure: func(t *testing.T, r *Registry) {},
+			checkResults: func(t *testing.T, data []byte, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "v1", string(data))
+			},
+		},
+		{
+			name:      "should return error: version is newer than registered",
+			eventName: "TestEventV1",
+			version:   2,
+			configure: func(t *testing.T, r *Registry) {},
+			checkResults: func(t *testing.T, data []byte, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "is newer than registered version 1")
+			},
+		},
+		{
+			name:      "should return error: no upcaster registered",
+			eventName: "TestEventV3",
+			version:   1,
+			configure: func(t *testing.T, r *Registry) {
+				assert.NoError(t, r.RegisterUpcaster("TestEventV3", 2, appendVersion("->v3")))
+				t.Cleanup(func() { assert.NoError(t, r.UnregisterUpcaster("TestEventV3", 2)) })
+			},
+			checkResults: func(t *testing.T, data []byte, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "no upcaster registered for event TestEventV3 from version 1")
+			},
+		},
+		{
+			name:      "should return error: upcaster failed",
+			eventName: "TestEventV3",
+			version:   2,
+			configure: func(t *testing.T, r *Registry) {
+				assert.NoError(t, r.RegisterUpcaster("TestEventV3", 2, func(data []byte) ([]byte, error) {
+					return nil, errors.New("some error")
+				}))
+				t.Cleanup(func() { assert.NoError(t, r.UnregisterUpcaster("TestEventV3", 2)) })
+			},
+			checkResults: func(t *testing.T, data []byte, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "some error")
+			},
+		},
+		{
+			name:      "should run the upcaster chain",
+			eventName: "TestEventV3",
+			version:   1,
+			configure: func(t *testing.T, r *Registry) {
+				assert.NoError(t, r.RegisterUpcaster("TestEventV3", 1, appendVersion("->v2")))
+				assert.NoError(t, r.RegisterUpcaster("TestEventV3", 2, appendVersion("->v3")))
+				t.Cleanup(func() {
+					assert.NoError(t, r.UnregisterUpcaster("TestEventV3", 1))
+					assert.NoError(t, r.UnregisterUpcaster("TestEventV3", 2))
+				})
+			},
+			checkResults: func(t *testing.T, data []byte, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "v1->v2->v3", string(data))
+			},
+		},
+		{
+			name:      "should run the upcasters without holding the registry lock",
+			eventName: "TestEventV3",
+			version:   2,
+			configure: func(t *testing.T, r *Registry) {
+				assert.NoError(t, r.RegisterUpcaster("TestEventV3", 2, func(data []byte) ([]byte, error) {
+					// Changing the registry waits for the lock.
+					done := make(chan error, 1)
+					go func() { done <- r.RegisterUpcaster("SomeOtherEvent", 1, appendVersion("")) }()
+
+					select {
+					case err := <-done:
+						return append(data, "->v3"...), err
+					case <-time.After(time.Second):
+						return nil, errors.New("registry is locked")
+					}
+				}))
+				t.Cleanup(func() {
+					assert.NoError(t, r.UnregisterUpcaster("TestEventV3", 2))
+					assert.NoError(t, r.UnregisterUpcaster("SomeOtherEvent", 1))
+				})
+			},
+			checkResults: func(t *testing.T, data []byte, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "v1->v3", string(data))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			tc.configure(t, r)
+
+			data, err := r.Upcast(tc.eventName, tc.version, []byte("v1"))
+			tc.checkResults(t, data, err)
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	assert.NoError(t, r.Register(&testEventV3{}))
+	assert.NoError(t, r.Register(&testEventV1{}))
+
+	err := r.Register(&testEventV1{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "event TestEventV1 is already registered")
+
+	assert.Equal(t, []string{"TestEventV1", "TestEventV3"}, r.List())
+
+	e, err := r.Get("TestEventV3")
+	assert.NoError(t, err)
+	assert.IsType(t, &testEventV3{}, e)
+
+	version, err := r.SchemaVersion("TestEventV3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), version)
+
+	up := func(data []byte) ([]byte, error) { return data, nil }
+	assert.NoError(t, r.RegisterUpcaster("TestEventV3", 2, up))
+
+	assert.NoError(t, r.Unregister(&testEventV3{}))
+
+	_, err = r.Get("TestEventV3")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "event TestEventV3 is not registered")
+
+	// The upcasters of the event are removed as well.
+	assert.NoError(t, r.RegisterUpcaster("TestEventV3", 2, up))
+
+	err = r.Unregister(&testEventV3{})
+	assert.Error(t, err)
+
+	// Other registries should not be affected.
+	_, err = DefaultRegistry.Get("TestEventV1")
+	assert.Error(t, err)
+}
+
+func TestParseSchemaVersion(t *testing.T) {
+	version, err := ParseSchemaVersion(Metadata{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), version)
+
+	version, err = ParseSchemaVersion(Metadata{SchemaVersionKey: "4"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), version)
+
+	_, err = ParseSchemaVersion(Metadata{SchemaVersionKey: "four"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package ship
+
+import "time"
+
+// SubscriptionState is the state of a subscription.
+type SubscriptionState string
+
+// States of a subscription.
+const (
+	// SubscriptionRunning is used when the subscription is receiving messages.
+	SubscriptionRunning SubscriptionState = "running"
+
+	// SubscriptionStopped is used when the subscription was stopped by the
+	// client.
+	SubscriptionStopped SubscriptionState = "stopped"
+
+	// SubscriptionPanicked is used when the subscription was removed from
+	// listening after its handler panicked.
+	SubscriptionPanicked SubscriptionState = "panicked"
+
+	// SubscriptionErrored is used when the subscription could not receive
+	// messages anymore.
+	SubscriptionErrored SubscriptionState = "errored"
+)
+
+// SubscriptionHealth describes the health of a subscription.
+type SubscriptionHealth struct {
+	// Subscription is the name of the subscription.
+	Subscription string `json:"subscription"`
+
+	// Handler is the name of the message handler.
+	Handler string `json:"handler"`
+
+	// State of the subscription.
+	State SubscriptionState `json:"state"`
+
+	// Error is the reason of a panicked or errored state.
+	Error string `json:"error,omitempty"`
+
+	// StartedAt is the time at which the subscription was started.
+	StartedAt time.Time `json:"started_at"`
+
+	// LastMessageAt is the time at which the last message was received, it is
+	// zero if no message was received yet.
+	LastMessageAt time.Time `json:"last_message_at,omitempty"`
+}
+
+// Health describes the health of a Subscriber.
+type Health struct {
+	// Stopped is true when the Subscriber was stopped.
+	Stopped bool `json:"stopped"`
+
+	// Subscriptions contains the health of every subscription, in the order
+	// they were started.
+	Subscriptions []SubscriptionHealth `json:"subscriptions"`
+}
+
+// Live reports whether the Subscriber is alive, i.e. none of its
+// subscriptions panicked or errored. A Subscriber which is not alive needs to
+// be restarted.
+func (h Health) Live() bool {
+	for _, s := range h.Subscriptions {
+		if s.State == SubscriptionPanicked || s.State == SubscriptionErrored {
+			return false
+		}
+	}
+	return true
+}
+
+// Ready reports whether the Subscriber is ready to process messages, i.e. it
+// is not stopped and all of its subscriptions are running.
+func (h Health) Ready() bool {
+	if h.Stopped {
+		return false
+	}
+
+	for _, s := range h.Subscriptions {
+		if s.State != SubscriptionRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthChecker reports the health of a Subscriber.
+type HealthChecker interface {
+	// Health returns the current health.
+	Health() Health
+}
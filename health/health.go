@@ -0,0 +1,53 @@
+// Package health exposes the health of a ship.Subscriber over HTTP, for
+// example as Kubernetes liveness and readiness probes.
+//
+// Example:
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/livez", health.Liveness(client))
+//	mux.Handle("/readyz", health.Readiness(client))
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Flahmingo-Investments/ship"
+)
+
+// Liveness returns a handler responding with status 200 when the subscriber
+// is alive and 503 otherwise, see ship.Health.Live.
+//
+// The body of the response is the JSON encoded ship.Health.
+func Liveness(c ship.HealthChecker) http.Handler {
+	return handler(c, ship.Health.Live)
+}
+
+// Readiness returns a handler responding with status 200 when the subscriber
+// is ready and 503 otherwise, see ship.Health.Ready.
+//
+// The body of the response is the JSON encoded ship.Health.
+func Readiness(c ship.HealthChecker) http.Handler {
+	return handler(c, ship.Health.Ready)
+}
+
+// handler returns a handler reporting the health of c based on check.
+func handler(c ship.HealthChecker, check func(ship.Health) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := c.Health()
+
+		status := http.StatusOK
+		if !check(h) {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(h)
+	})
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+type checker ship.Health
+
+func (c checker) Health() ship.Health { return ship.Health(c) }
+
+func TestHandlers(t *testing.T) {
+	panicked := checker{Subscriptions: []ship.SubscriptionHealth{
+		{Subscription: "some-subscription", State: ship.SubscriptionPanicked, Error: "boom"},
+	}}
+	stopped := checker{Stopped: true}
+
+	testCases := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		status  int
+	}{
+		{
+			name:    "should be live",
+			handler: Liveness(checker{}),
+			method:  http.MethodGet,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "should not be live when a subscription panicked",
+			handler: Liveness(panicked),
+			method:  http.MethodGet,
+			status:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "should be live when stopped",
+			handler: Liveness(stopped),
+			method:  http.MethodGet,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "should be ready",
+			handler: Readiness(checker{}),
+			method:  http.MethodGet,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "should not be ready when stopped",
+			handler: Readiness(stopped),
+			method:  http.MethodHead,
+			status:  http.StatusServiceUnavailable,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rec, httptest.NewRequest(tc.method, "/", nil))
+
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			if tc.method == http.MethodHead {
+				assert.Empty(t, rec.Body.Bytes())
+				return
+			}
+
+			var h ship.Health
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &h))
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	Liveness(panicked).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var h ship.Health
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &h))
+	assert.Equal(t, ship.Health(panicked), h)
+}
@@ -0,0 +1,73 @@
+package ship
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	testCases := []struct {
+		name   string
+		health Health
+		live   bool
+		ready  bool
+	}{
+		{
+			name:   "should be live and ready without subscriptions",
+			health: Health{},
+			live:   true,
+			ready:  true,
+		},
+		{
+			name:   "should not be ready when stopped",
+			health: Health{Stopped: true},
+			live:   true,
+			ready:  false,
+		},
+		{
+			name: "should be live and ready when all subscriptions are running",
+			health: Health{Subscriptions: []SubscriptionHealth{
+				{State: SubscriptionRunning},
+				{State: SubscriptionRunning},
+			}},
+			live:  true,
+			ready: true,
+		},
+		{
+			name: "should not be ready when a subscription is stopped",
+			health: Health{Subscriptions: []SubscriptionHealth{
+				{State: SubscriptionRunning},
+				{State: SubscriptionStopped},
+			}},
+			live:  true,
+			ready: false,
+		},
+		{
+			name: "should not be live when a subscription panicked",
+			health: Health{Subscriptions: []SubscriptionHealth{
+				{State: SubscriptionRunning},
+				{State: SubscriptionPanicked},
+			}},
+			live:  false,
+			ready: false,
+		},
+		{
+			name: "should not be live when a subscription errored",
+			health: Health{Subscriptions: []SubscriptionHealth{
+				{State: SubscriptionErrored},
+			}},
+			live:  false,
+			ready: false,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.live, tc.health.Live())
+			assert.Equal(t, tc.ready, tc.health.Ready())
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"go.uber.org/zap"
+)
+
+// SubscriptionHealth tracks the health of a running subscription.
+type SubscriptionHealth struct {
+	mu     sync.Mutex
+	health ship.SubscriptionHealth
+}
+
+// SetLastMessageAt records the time at which a message was received.
+func (s *SubscriptionHealth) SetLastMessageAt(t time.Time) {
+	s.mu.Lock()
+	s.health.LastMessageAt = t
+	s.mu.Unlock()
+}
+
+// Panicked marks the subscription as panicked.
+func (s *SubscriptionHealth) Panicked(reason string) {
+	s.mu.Lock()
+	s.health.State = ship.SubscriptionPanicked
+	s.health.Error = reason
+	s.mu.Unlock()
+}
+
+// Recovered logs a panic recovered while the subscription handled a message
+// and marks the subscription as panicked. The action tells what happens to
+// the message, the fields identify it.
+func (s *SubscriptionHealth) Recovered(logger *zap.Logger, r interface{}, action string, fields ...zap.Field) {
+	h := s.Get()
+	logger.Error(
+		"[BUG]: recovered from a panic in subscription. "+action,
+		append([]zap.Field{
+			zap.String("subscription", h.Subscription),
+			zap.String("handlerName", h.Handler),
+			zap.String("panic", fmt.Sprint(r)),
+		}, fields...)...,
+	)
+	s.Panicked(fmt.Sprint(r))
+}
+
+// Stopped marks the subscription as errored, if err is not nil, or stopped.
+//
+// A panicked subscription keeps its state.
+func (s *SubscriptionHealth) Stopped(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case err != nil:
+		s.health.State = ship.SubscriptionErrored
+		s.health.Error = err.Error()
+	case s.health.State == ship.SubscriptionRunning:
+		s.health.State = ship.SubscriptionStopped
+	}
+}
+
+// Get returns a copy of the health.
+func (s *SubscriptionHealth) Get() ship.SubscriptionHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health
+}
+
+// Health tracks the health of the subscriptions of a client.
+//
+// The zero value is ready to use.
+type Health struct {
+	mu            sync.RWMutex
+	subscriptions []*SubscriptionHealth
+}
+
+// Track starts tracking the health of a running subscription.
+func (h *Health) Track(subscription, handler string) *SubscriptionHealth {
+	s := &SubscriptionHealth{
+		health: ship.SubscriptionHealth{
+			Subscription: subscription,
+			Handler:      handler,
+			State:        ship.SubscriptionRunning,
+			StartedAt:    time.Now(),
+		},
+	}
+
+	h.mu.Lock()
+	h.subscriptions = append(h.subscriptions, s)
+	h.mu.Unlock()
+
+	return s
+}
+
+// StopAll marks every running subscription as stopped.
+func (h *Health) StopAll() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, s := range h.subscriptions {
+		s.Stopped(nil)
+	}
+}
+
+// Get returns the health of the client and of every subscription.
+func (h *Health) Get(stopped bool) ship.Health {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	health := ship.Health{
+		Stopped:       stopped,
+		Subscriptions: make([]ship.SubscriptionHealth, 0, len(h.subscriptions)),
+	}
+	for _, s := range h.subscriptions {
+		health.Subscriptions = append(health.Subscriptions, s.Get())
+	}
+
+	return health
+}
@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSubscriptionHealth_Recovered(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+
+	var h Health
+	s := h.Track("some-subscription", "someHandler")
+	s.Recovered(zap.New(core), "some panic", "Nacking the message.", zap.String("messageId", "1"))
+
+	health := s.Get()
+	assert.Equal(t, ship.SubscriptionPanicked, health.State)
+	assert.Equal(t, "some panic", health.Error)
+
+	entries := logs.TakeAll()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "[BUG]: recovered from a panic in subscription. Nacking the message.", entries[0].Message)
+		assert.Equal(t, map[string]interface{}{
+			"subscription": "some-subscription",
+			"handlerName":  "someHandler",
+			"panic":        "some panic",
+			"messageId":    "1",
+		}, entries[0].ContextMap())
+	}
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/debezium"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Settings points to the fields of a client changed by the options shared by
+// the transports.
+type Settings struct {
+	// Name is the name of the client logger, e.g. kafka.
+	Name string
+
+	Pipeline *pipeline.Pipeline
+	Tracing  *[]tracing.Option
+	Metrics  *metrics.Recorder
+	Logger   **zap.Logger
+}
+
+// Option is an option setter shared by the transports, a transport wraps it
+// in its own option type.
+type Option func(s Settings) error
+
+// WithLogger attaches a zap logger, named after the client.
+func WithLogger(logger *zap.Logger) Option {
+	return func(s Settings) error {
+		*s.Logger = logger.Named(s.Name)
+		return nil
+	}
+}
+
+// WithRegistry uses the provided event registry instead of the
+// ship.DefaultRegistry.
+func WithRegistry(registry *ship.Registry) Option {
+	return func(s Settings) error {
+		return s.Pipeline.SetRegistry(registry)
+	}
+}
+
+// WithDebeziumColumns changes the columns of the Debezium outbox table, see
+// pipeline.Pipeline.SetDebeziumColumns.
+func WithDebeziumColumns(columns debezium.Columns) Option {
+	return func(s Settings) error {
+		return s.Pipeline.SetDebeziumColumns(columns)
+	}
+}
+
+// WithCodec changes the codec of the published messages, see
+// pipeline.Pipeline.SetCodec.
+func WithCodec(codec ship.Codec) Option {
+	return func(s Settings) error {
+		return s.Pipeline.SetCodec(codec)
+	}
+}
+
+// WithCompression compresses the published messages, see
+// pipeline.Pipeline.SetCompression.
+func WithCompression(c compress.Compressor, threshold int) Option {
+	return func(s Settings) error {
+		return s.Pipeline.SetCompression(c, threshold)
+	}
+}
+
+// WithClaimCheck checks the oversized published messages in to the store,
+// see pipeline.Pipeline.SetClaimCheck.
+func WithClaimCheck(store claimcheck.Store, threshold int) Option {
+	return func(s Settings) error {
+		return s.Pipeline.SetClaimCheck(store, threshold)
+	}
+}
+
+// WithEncryption encrypts the published messages, see
+// pipeline.Pipeline.SetEncryption.
+func WithEncryption(kp encryption.KeyProvider, keyID string) Option {
+	return func(s Settings) error {
+		return s.Pipeline.SetEncryption(kp, keyID)
+	}
+}
+
+// WithTracerProvider changes the tracer provider, see
+// tracing.WithTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s Settings) error {
+		*s.Tracing = append(*s.Tracing, tracing.WithTracerProvider(tp))
+		return nil
+	}
+}
+
+// WithPropagator changes the trace context propagator, see
+// tracing.WithPropagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(s Settings) error {
+		*s.Tracing = append(*s.Tracing, tracing.WithPropagator(propagator))
+		return nil
+	}
+}
+
+// WithMetrics reports the metrics of the client to the recorder, see
+// metrics.Recorder.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return func(s Settings) error {
+		if recorder == nil {
+			return errors.New("metrics recorder cannot be nil")
+		}
+		*s.Metrics = recorder
+		return nil
+	}
+}
@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type testSettings struct {
+	pipeline *pipeline.Pipeline
+	tracing  []tracing.Option
+	metrics  metrics.Recorder
+	logger   *zap.Logger
+}
+
+func (s *testSettings) settings() Settings {
+	return Settings{
+		Name:     "test",
+		Pipeline: s.pipeline,
+		Tracing:  &s.tracing,
+		Metrics:  &s.metrics,
+		Logger:   &s.logger,
+	}
+}
+
+func TestOptions(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	recorder := newTestRecorder()
+
+	testCases := []struct {
+		name   string
+		opt    Option
+		errMsg string
+		check  func(*testing.T, *testSettings)
+	}{
+		{
+			name:   "should return error: registry cannot be nil",
+			opt:    WithRegistry(nil),
+			errMsg: "registry cannot be nil",
+		},
+		{
+			name:   "should return error: codec cannot be nil",
+			opt:    WithCodec(nil),
+			errMsg: "codec cannot be nil",
+		},
+		{
+			name:   "should return error: compressor cannot be nil",
+			opt:    WithCompression(nil, 0),
+			errMsg: "compressor cannot be nil",
+		},
+		{
+			name:   "should return error: claim-check store cannot be nil",
+			opt:    WithClaimCheck(nil, 0),
+			errMsg: "claim-check store cannot be nil",
+		},
+		{
+			name:   "should return error: key provider cannot be nil",
+			opt:    WithEncryption(nil, "some-key"),
+			errMsg: "key provider cannot be nil",
+		},
+		{
+			name:   "should return error: metrics recorder cannot be nil",
+			opt:    WithMetrics(nil),
+			errMsg: "metrics recorder cannot be nil",
+		},
+		{
+			name: "should set the metrics recorder",
+			opt:  WithMetrics(recorder),
+			check: func(t *testing.T, s *testSettings) {
+				assert.Equal(t, recorder, s.metrics)
+			},
+		},
+		{
+			name: "should name the logger after the client",
+			opt:  WithLogger(zap.New(core)),
+			check: func(t *testing.T, s *testSettings) {
+				s.logger.Info("some message")
+				entries := logs.TakeAll()
+				if assert.Len(t, entries, 1) {
+					assert.Equal(t, "test", entries[0].LoggerName)
+				}
+			},
+		},
+		{
+			name: "should add the tracer provider",
+			opt:  WithTracerProvider(trace.NewNoopTracerProvider()),
+			check: func(t *testing.T, s *testSettings) {
+				assert.Len(t, s.tracing, 1)
+			},
+		},
+		{
+			name: "should add the propagator",
+			opt:  WithPropagator(propagation.TraceContext{}),
+			check: func(t *testing.T, s *testSettings) {
+				assert.Len(t, s.tracing, 1)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			s := &testSettings{
+				pipeline: pipeline.New(),
+				metrics:  metrics.Nop{},
+				logger:   zap.NewNop(),
+			}
+
+			err := tc.opt(s.settings())
+			if tc.errMsg != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errMsg)
+				return
+			}
+
+			assert.NoError(t, err)
+			tc.check(t, s)
+		})
+	}
+}
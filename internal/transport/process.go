@@ -0,0 +1,202 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"go.uber.org/zap"
+)
+
+// ProcessFunc processes a received message. It returns nil when the message
+// must be acknowledged, the error to redeliver it otherwise.
+type ProcessFunc func(ctx context.Context, subID, hName string, raw *ship.RawMessage) error
+
+// Processor decodes the received messages and passes them to the handlers,
+// it decides whether they are acknowledged.
+type Processor struct {
+	Pipeline *pipeline.Pipeline
+	Tracer   *tracing.Tracer
+	Metrics  metrics.Recorder
+	Logger   *zap.Logger
+
+	// IDKey is the log field of the message ids, e.g. kafkaMessageId.
+	IDKey string
+}
+
+// Handler returns a ProcessFunc decoding the messages and passing them to h.
+func (p *Processor) Handler(h ship.MessageHandler) ProcessFunc {
+	return func(ctx context.Context, subID, hName string, raw *ship.RawMessage) error {
+		return p.Process(ctx, subID, hName, h, raw)
+	}
+}
+
+// RawHandler returns a ProcessFunc unwrapping the messages and passing them to
+// h.
+func (p *Processor) RawHandler(h ship.RawMessageHandler) ProcessFunc {
+	return func(ctx context.Context, subID, hName string, raw *ship.RawMessage) error {
+		return p.ProcessRaw(ctx, subID, hName, h, raw)
+	}
+}
+
+// ProcessRaw unwraps a received message and passes it to the handler.
+//
+// It returns the error of the handler: a message which could not be unwrapped
+// is acknowledged, so we don't process it again, unless the failure is
+// temporary, see ship.TemporaryError.
+func (p *Processor) ProcessRaw(
+	ctx context.Context,
+	subID, hName string,
+	h ship.RawMessageHandler,
+	raw *ship.RawMessage,
+) error {
+	ctx, span := p.Tracer.StartReceive(ctx, subID, raw.ID, raw.Attributes)
+	defer span.End()
+
+	decodeCtx, decodeSpan := p.Tracer.StartDecode(ctx)
+	unwrapped, err := p.Pipeline.Unwrap(decodeCtx, raw)
+	tracing.End(decodeSpan, err)
+	if err != nil {
+		return p.decodeFailed(subID, hName, raw.ID, err)
+	}
+
+	p.Logger.Debug(
+		"sending message to the handler",
+		zap.String(p.IDKey, raw.ID),
+		zap.String("handlerName", hName),
+	)
+	handleCtx, handleSpan := p.Tracer.StartHandle(ctx, hName, "")
+	start := time.Now()
+	hErr := h.HandleRawMessage(handleCtx, unwrapped)
+	p.Metrics.Handled(subID, hName, time.Since(start))
+	tracing.End(handleSpan, hErr)
+
+	if hErr != nil {
+		p.Logger.Error(
+			"handler could not process message",
+			zap.Error(hErr),
+			zap.String(p.IDKey, raw.ID),
+			zap.String("handlerName", hName),
+		)
+		return hErr
+	}
+
+	p.Logger.Debug(
+		"acknowledging raw message",
+		zap.String("handlerName", hName),
+		zap.String(p.IDKey, raw.ID),
+	)
+	return nil
+}
+
+// Process decodes a received message and passes it to the handler.
+//
+// It returns the error of the handler: a message which could not be decoded
+// is acknowledged, so we don't process it again, unless the failure is
+// temporary, see ship.TemporaryError.
+func (p *Processor) Process(
+	ctx context.Context,
+	subID, hName string,
+	h ship.MessageHandler,
+	raw *ship.RawMessage,
+) error {
+	ctx, span := p.Tracer.StartReceive(ctx, subID, raw.ID, raw.Attributes)
+	defer span.End()
+
+	m, err := p.Decode(ctx, subID, hName, raw)
+	if m == nil {
+		return err
+	}
+
+	p.Logger.Debug(
+		"sending message to the handler",
+		zap.String("eventType", m.Type),
+		zap.String("handlerName", hName),
+	)
+	handleCtx, handleSpan := p.Tracer.StartHandle(ctx, hName, m.Type)
+	start := time.Now()
+	hErr := h.HandleMessage(handleCtx, m)
+	p.Metrics.Handled(subID, hName, time.Since(start))
+	tracing.End(handleSpan, hErr)
+
+	if hErr != nil {
+		p.Logger.Error(
+			"handler could not process message",
+			zap.Error(hErr),
+			zap.String(p.IDKey, raw.ID),
+			zap.String("handlerName", hName),
+		)
+		return hErr
+	}
+
+	p.Logger.Debug(
+		"acknowledging message",
+		zap.String("eventType", m.Type),
+		zap.String("handlerName", hName),
+		zap.String(p.IDKey, raw.ID),
+		zap.String("messageId", m.ID),
+	)
+	return nil
+}
+
+// Decode decodes a received message, ctx should carry its receive span.
+//
+// It returns a nil message when the message must not be passed to the
+// handler, along with a nil error when it must be acknowledged anyway.
+func (p *Processor) Decode(
+	ctx context.Context, subID, hName string, raw *ship.RawMessage,
+) (*ship.Message, error) {
+	p.Logger.Debug("decoding received message", zap.String(p.IDKey, raw.ID))
+
+	stripped := *raw
+	stripped.Attributes = p.Tracer.Strip(raw.Attributes)
+
+	decodeCtx, decodeSpan := p.Tracer.StartDecode(ctx)
+	m, err := p.Pipeline.Decode(decodeCtx, &stripped)
+	tracing.End(decodeSpan, err)
+	if err != nil {
+		return nil, p.decodeFailed(subID, hName, raw.ID, err)
+	}
+
+	return m, nil
+}
+
+// decodeFailed records a message which could not be decoded.
+//
+// It returns the error when the failure is temporary, so the message is
+// redelivered, and nil otherwise, so the message is acknowledged and we don't
+// process it again. Deletions of outbox rows are not events, they are
+// acknowledged without being recorded as failures.
+func (p *Processor) decodeFailed(subID, hName, id string, err error) error {
+	if ship.DecodeReason(err) == ship.DecodeReasonDeleted {
+		p.Logger.Debug(
+			"received message is a deletion: acking it",
+			zap.String(p.IDKey, id),
+			zap.String("handlerName", hName),
+		)
+		return nil
+	}
+
+	p.Metrics.DecodeFailed(subID, hName, ship.DecodeReason(err))
+
+	if ship.IsTemporary(err) {
+		p.Logger.Warn(
+			"unable to decode received message: nacking it, so it is retried",
+			zap.Error(err),
+			zap.String(p.IDKey, id),
+			zap.String("handlerName", hName),
+		)
+		return err
+	}
+
+	p.Logger.Error(
+		"unable to decode received message: acking it, so we don't process it again",
+		zap.Error(err),
+		zap.String(p.IDKey, id),
+		zap.String("handlerName", hName),
+	)
+	return nil
+}
@@ -0,0 +1,212 @@
+package transport
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type userCreated struct {
+	ID string `json:"id"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+// testRecorder is a metrics.Recorder counting the recorded metrics.
+type testRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{counts: make(map[string]int)}
+}
+
+func (r *testRecorder) inc(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+func (r *testRecorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+func (r *testRecorder) PublishSucceeded(topic string, _ time.Duration) {
+	r.inc("published:" + topic)
+}
+
+func (r *testRecorder) PublishFailed(topic string, _ time.Duration) {
+	r.inc("publish_failed:" + topic)
+}
+
+func (r *testRecorder) Handled(sub, handler string, _ time.Duration) {
+	r.inc("handled:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Acked(sub, handler string) {
+	r.inc("acked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Nacked(sub, handler string) {
+	r.inc("nacked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DeadLettered(sub, handler string) {
+	r.inc("dead_lettered:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DecodeFailed(sub, handler, reason string) {
+	r.inc("decode_failed:" + sub + ":" + handler + ":" + reason)
+}
+
+// unavailableStore is a claimcheck.Store which always fails.
+type unavailableStore struct{}
+
+func (unavailableStore) Put(context.Context, string, []byte) error {
+	return errors.New("store unavailable")
+}
+
+func (unavailableStore) Get(context.Context, string) ([]byte, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func (unavailableStore) Delete(context.Context, string) error {
+	return errors.New("store unavailable")
+}
+
+// newTestProcessor returns a Processor decoding UserCreated events.
+func newTestProcessor(t *testing.T, recorder *testRecorder) *Processor {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&userCreated{}))
+
+	p := pipeline.New()
+	assert.NoError(t, p.SetRegistry(registry))
+
+	return &Processor{
+		Pipeline: p,
+		Tracer:   tracing.New("test"),
+		Metrics:  recorder,
+		Logger:   zap.NewNop(),
+		IDKey:    "testMessageId",
+	}
+}
+
+func TestProcessor_Process(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	contentType := ship.JSONCodec{}.ContentType()
+
+	testCases := []struct {
+		name       string
+		data       []byte
+		attributes map[string]string
+		handlerErr error
+		err        error
+		temporary  bool
+		handled    int
+		decodeFail string
+	}{
+		{
+			name:       "should acknowledge a processed message",
+			attributes: map[string]string{ship.ContentTypeKey: contentType, ship.TypeKey: "UserCreated"},
+			data:       []byte(`{"id":"some-id"}`),
+			handled:    1,
+		},
+		{
+			name:       "should return the error of the handler",
+			attributes: map[string]string{ship.ContentTypeKey: contentType, ship.TypeKey: "UserCreated"},
+			data:       []byte(`{"id":"some-id"}`),
+			handlerErr: errHandler,
+			err:        errHandler,
+			handled:    1,
+		},
+		{
+			name:       "should acknowledge a message which could not be decoded",
+			attributes: map[string]string{ship.ContentTypeKey: contentType, ship.TypeKey: "UserDeleted"},
+			data:       []byte(`{"id":"some-id"}`),
+			decodeFail: ship.DecodeReasonUnregistered,
+		},
+		{
+			name: "should redeliver a message which could not be decoded for a temporary reason",
+			attributes: map[string]string{
+				ship.ContentTypeKey:     contentType,
+				ship.TypeKey:            "UserCreated",
+				claimcheck.ReferenceKey: strings.Repeat("ab", 16),
+			},
+			temporary:  true,
+			decodeFail: ship.DecodeReasonUnavailable,
+		},
+		{
+			name: "should acknowledge a deleted outbox row without recording a failure",
+			data: []byte(`{"payload":{"id":"some-id","__deleted":"true"}}`),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder()
+			p := newTestProcessor(t, recorder)
+			assert.NoError(t, p.Pipeline.SetClaimCheck(unavailableStore{}, 0))
+
+			handler := ship.MessageHandlerFunc(func(context.Context, *ship.Message) error {
+				return tc.handlerErr
+			})
+			process := p.Handler(handler)
+
+			err := process(context.Background(), "some-sub", "handler", &ship.RawMessage{
+				ID:         "some-id",
+				Attributes: tc.attributes,
+				Data:       tc.data,
+			})
+			if tc.temporary {
+				assert.True(t, ship.IsTemporary(err))
+			} else {
+				assert.Equal(t, tc.err, err)
+			}
+			assert.Equal(t, tc.handled, recorder.count("handled:some-sub:handler"))
+			if tc.decodeFail != "" {
+				assert.Equal(t, 1, recorder.count("decode_failed:some-sub:handler:"+tc.decodeFail))
+			}
+			assert.Zero(t, recorder.count("decode_failed:some-sub:handler:"+ship.DecodeReasonDeleted))
+		})
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	var l Lifecycle
+
+	assert.NoError(t, l.Acquire())
+	assert.False(t, l.Stopped())
+
+	assert.NoError(t, l.Stop())
+	assert.True(t, l.Stopped())
+	assert.ErrorIs(t, l.Acquire(), ErrStopped)
+	assert.ErrorIs(t, l.Stop(), ErrStopped)
+
+	done := make(chan struct{})
+	go func() {
+		l.Wait()
+		close(done)
+	}()
+
+	l.Release()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return once the subscription was released")
+	}
+}
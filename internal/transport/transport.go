@@ -0,0 +1,93 @@
+// Package transport holds the parts shared by the pubsub transports: the
+// options of a client, its lifecycle, the health of its subscriptions and the
+// processing of the received messages.
+//
+// A transport only implements the glue with its broker, e.g. receiving,
+// acknowledging and redelivering messages.
+package transport
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrStopped is returned when subscribing to or stopping a stopped pubsub.
+var ErrStopped = errors.New("pubsub is stopped")
+
+// HandlerName returns the type name of a handler.
+func HandlerName(h interface{}) string {
+	t := reflect.TypeOf(h)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// ReportError sends a subscription error to the error channel.
+//
+// The error is dropped when the channel is full, so a subscription goroutine
+// never blocks Stop.
+func ReportError(errCh chan<- error, err error) {
+	select {
+	case errCh <- err:
+	default:
+	}
+}
+
+// Lifecycle tracks the running subscriptions of a client until it is stopped.
+//
+// The zero value is ready to use.
+type Lifecycle struct {
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// Acquire counts a new subscription, unless the client is stopped.
+//
+// It must be called before the subscription goroutine is started, so Wait
+// waits for it. The goroutine calls Release when it returns.
+func (l *Lifecycle) Acquire() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopped {
+		return ErrStopped
+	}
+
+	l.wg.Add(1)
+	return nil
+}
+
+// Release releases a subscription counted by Acquire.
+func (l *Lifecycle) Release() {
+	l.wg.Done()
+}
+
+// Stop marks the client as stopped, new subscriptions are rejected
+// afterwards. It returns ErrStopped if the client is already stopped.
+func (l *Lifecycle) Stop() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopped {
+		return ErrStopped
+	}
+
+	l.stopped = true
+	return nil
+}
+
+// Stopped reports whether Stop was called.
+func (l *Lifecycle) Stopped() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopped
+}
+
+// Wait waits for the acquired subscriptions to be released.
+func (l *Lifecycle) Wait() {
+	l.wg.Wait()
+}
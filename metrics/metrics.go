@@ -0,0 +1,65 @@
+// Package metrics defines the hook used by the ship transports to report
+// publishing and consumption metrics.
+//
+// A transport reports to a Recorder, which does nothing by default. See the
+// prometheus sub-package for a Prometheus backed Recorder.
+package metrics
+
+import "time"
+
+// Recorder records the metrics of publishing and consuming messages.
+//
+// Implementations must be safe for concurrent use.
+type Recorder interface {
+	// PublishSucceeded records a message published to topic in d.
+	PublishSucceeded(topic string, d time.Duration)
+
+	// PublishFailed records a message which could not be published to topic
+	// after d.
+	PublishFailed(topic string, d time.Duration)
+
+	// Handled records a message processed by handler in d, whatever the
+	// outcome.
+	Handled(subscription, handler string, d time.Duration)
+
+	// Acked records an acknowledged message.
+	Acked(subscription, handler string)
+
+	// Nacked records a negatively acknowledged message, which is redelivered.
+	Nacked(subscription, handler string)
+
+	// DeadLettered records a negatively acknowledged message which reached
+	// the maximum number of delivery attempts and is not redelivered anymore.
+	DeadLettered(subscription, handler string)
+
+	// DecodeFailed records a message which could not be decoded, reason is one
+	// of the ship.DecodeReason* constants.
+	DecodeFailed(subscription, handler, reason string)
+}
+
+// Nop is a Recorder which does nothing.
+type Nop struct{}
+
+// Compile time check.
+var _ Recorder = Nop{}
+
+// PublishSucceeded does nothing.
+func (Nop) PublishSucceeded(string, time.Duration) {}
+
+// PublishFailed does nothing.
+func (Nop) PublishFailed(string, time.Duration) {}
+
+// Handled does nothing.
+func (Nop) Handled(string, string, time.Duration) {}
+
+// Acked does nothing.
+func (Nop) Acked(string, string) {}
+
+// Nacked does nothing.
+func (Nop) Nacked(string, string) {}
+
+// DeadLettered does nothing.
+func (Nop) DeadLettered(string, string) {}
+
+// DecodeFailed does nothing.
+func (Nop) DecodeFailed(string, string, string) {}
@@ -0,0 +1,166 @@
+// Package prometheus implements a metrics.Recorder backed by Prometheus
+// collectors.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultNamespace is the namespace of the metrics when none is provided.
+const DefaultNamespace = "ship"
+
+// Label names of the metrics.
+const (
+	TopicLabel        = "topic"
+	SubscriptionLabel = "subscription"
+	HandlerLabel      = "handler"
+	ReasonLabel       = "reason"
+)
+
+// Recorder records ship metrics in Prometheus collectors.
+//
+// The zero value is not usable, use NewRecorder.
+type Recorder struct {
+	published       *prometheus.CounterVec
+	publishFailed   *prometheus.CounterVec
+	publishDuration *prometheus.HistogramVec
+	handled         *prometheus.CounterVec
+	acked           *prometheus.CounterVec
+	nacked          *prometheus.CounterVec
+	deadLettered    *prometheus.CounterVec
+	decodeFailed    *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+}
+
+// Compile time check.
+var _ metrics.Recorder = (*Recorder)(nil)
+
+// NewRecorder creates the ship collectors in namespace and registers them
+// with reg. An empty namespace defaults to DefaultNamespace.
+//
+// Example:
+//
+//	recorder, err := prometheus.NewRecorder(prom.DefaultRegisterer, "")
+//	if err != nil {
+//		// do something with error
+//		return
+//	}
+//
+//	client, err := gcp.NewClient("projectID", gcp.WithMetrics(recorder))
+func NewRecorder(reg prometheus.Registerer, namespace string) (*Recorder, error) {
+	if reg == nil {
+		return nil, errors.New("registerer cannot be nil")
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	subLabels := []string{SubscriptionLabel, HandlerLabel}
+
+	r := &Recorder{
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_published_total",
+			Help:      "Total number of messages published.",
+		}, []string{TopicLabel}),
+		publishFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_publish_failed_total",
+			Help:      "Total number of messages which could not be published.",
+		}, []string{TopicLabel}),
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "publish_duration_seconds",
+			Help:      "Time taken to publish a message.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{TopicLabel}),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_handled_total",
+			Help:      "Total number of messages processed by a handler.",
+		}, subLabels),
+		acked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_acked_total",
+			Help:      "Total number of acknowledged messages.",
+		}, subLabels),
+		nacked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_nacked_total",
+			Help:      "Total number of negatively acknowledged messages.",
+		}, subLabels),
+		deadLettered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_dead_lettered_total",
+			Help:      "Total number of messages which reached the maximum delivery attempts.",
+		}, subLabels),
+		decodeFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_decode_failed_total",
+			Help:      "Total number of messages which could not be decoded.",
+		}, append(subLabels, ReasonLabel)),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Time taken by a handler to process a message.",
+			Buckets:   prometheus.DefBuckets,
+		}, subLabels),
+	}
+
+	collectors := []prometheus.Collector{
+		r.published, r.publishFailed, r.publishDuration,
+		r.handled, r.acked, r.nacked, r.deadLettered, r.decodeFailed,
+		r.handlerDuration,
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, errors.Wrap(err, "unable to register collector")
+		}
+	}
+
+	return r, nil
+}
+
+// PublishSucceeded increments the published counter and observes the publish
+// duration.
+func (r *Recorder) PublishSucceeded(topic string, d time.Duration) {
+	r.published.WithLabelValues(topic).Inc()
+	r.publishDuration.WithLabelValues(topic).Observe(d.Seconds())
+}
+
+// PublishFailed increments the publish failures counter and observes the
+// publish duration.
+func (r *Recorder) PublishFailed(topic string, d time.Duration) {
+	r.publishFailed.WithLabelValues(topic).Inc()
+	r.publishDuration.WithLabelValues(topic).Observe(d.Seconds())
+}
+
+// Handled increments the handled counter and observes the handler duration.
+func (r *Recorder) Handled(subscription, handler string, d time.Duration) {
+	r.handled.WithLabelValues(subscription, handler).Inc()
+	r.handlerDuration.WithLabelValues(subscription, handler).Observe(d.Seconds())
+}
+
+// Acked increments the acknowledged counter.
+func (r *Recorder) Acked(subscription, handler string) {
+	r.acked.WithLabelValues(subscription, handler).Inc()
+}
+
+// Nacked increments the negatively acknowledged counter.
+func (r *Recorder) Nacked(subscription, handler string) {
+	r.nacked.WithLabelValues(subscription, handler).Inc()
+}
+
+// DeadLettered increments the dead-lettered counter.
+func (r *Recorder) DeadLettered(subscription, handler string) {
+	r.deadLettered.WithLabelValues(subscription, handler).Inc()
+}
+
+// DecodeFailed increments the decode failures counter of reason.
+func (r *Recorder) DecodeFailed(subscription, handler, reason string) {
+	r.decodeFailed.WithLabelValues(subscription, handler, reason).Inc()
+}
@@ -0,0 +1,59 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRecorder(t *testing.T) {
+	_, err := NewRecorder(nil, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "registerer cannot be nil")
+
+	reg := prometheus.NewRegistry()
+	_, err = NewRecorder(reg, "")
+	assert.NoError(t, err)
+
+	_, err = NewRecorder(reg, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to register collector")
+
+	_, err = NewRecorder(reg, "other")
+	assert.NoError(t, err)
+}
+
+func TestRecorder(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r, err := NewRecorder(reg, "")
+	assert.NoError(t, err)
+
+	r.PublishSucceeded("topic", time.Millisecond)
+	r.PublishSucceeded("topic", time.Millisecond)
+	r.PublishFailed("topic", time.Second)
+	r.Handled("sub", "handler", time.Millisecond)
+	r.Acked("sub", "handler")
+	r.Nacked("sub", "handler")
+	r.DeadLettered("sub", "handler")
+	r.DecodeFailed("sub", "handler", "unregistered")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.published.WithLabelValues("topic")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.publishFailed.WithLabelValues("topic")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.handled.WithLabelValues("sub", "handler")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.acked.WithLabelValues("sub", "handler")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.nacked.WithLabelValues("sub", "handler")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.deadLettered.WithLabelValues("sub", "handler")))
+	assert.Equal(
+		t, 1.0,
+		testutil.ToFloat64(r.decodeFailed.WithLabelValues("sub", "handler", "unregistered")),
+	)
+
+	count, err := testutil.GatherAndCount(
+		reg, "ship_publish_duration_seconds", "ship_handler_duration_seconds",
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
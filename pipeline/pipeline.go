@@ -0,0 +1,215 @@
+// Package pipeline converts messages between their ship and wire
+// representations, so every transport encodes and decodes them the same way.
+//
+// On publish, a message is marshaled with a codec into a ship envelope, see
+// ship.MarshalMessage, then its payload is compressed, encrypted and checked
+// in to a claim-check store. On receive, the transformations are reversed and
+// the payload is decoded as a ship envelope or as a Debezium outbox event.
+package pipeline
+
+import (
+	"context"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/debezium"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/pkg/errors"
+)
+
+// Pipeline encodes published messages and decodes received ones.
+//
+// It is not safe to configure a Pipeline concurrently with its use.
+type Pipeline struct {
+	registry *ship.Registry
+	codec    ship.Codec
+	codecs   map[string]ship.Codec
+	columns  debezium.Columns
+
+	compressor        compress.Compressor
+	compressThreshold int
+	compressors       map[string]compress.Compressor
+
+	claimStore     claimcheck.Store
+	claimThreshold int
+
+	keyProvider encryption.KeyProvider
+	keyID       string
+}
+
+// New returns a Pipeline using ship.DefaultRegistry, ship.JSONCodec and
+// debezium.DefaultColumns, without compression, encryption or claim-check.
+func New() *Pipeline {
+	return &Pipeline{
+		registry:    ship.DefaultRegistry,
+		codec:       ship.JSONCodec{},
+		codecs:      ship.DefaultCodecs(),
+		columns:     debezium.DefaultColumns,
+		compressors: compress.Defaults(),
+	}
+}
+
+// SetRegistry uses the provided event registry to decode received messages.
+func (p *Pipeline) SetRegistry(registry *ship.Registry) error {
+	if registry == nil {
+		return errors.New("registry cannot be nil")
+	}
+	p.registry = registry
+	return nil
+}
+
+// SetCodec changes the codec used to encode the published messages.
+// Default codec is ship.JSONCodec.
+//
+// The codec is also used to decode the received messages with its content
+// type, along with the built-in codecs.
+func (p *Pipeline) SetCodec(codec ship.Codec) error {
+	if codec == nil {
+		return errors.New("codec cannot be nil")
+	}
+	p.codec = codec
+	p.codecs[codec.ContentType()] = codec
+	return nil
+}
+
+// SetDebeziumColumns changes the columns of the outbox table the Debezium
+// outbox events are decoded from. Default columns are
+// debezium.DefaultColumns.
+func (p *Pipeline) SetDebeziumColumns(columns debezium.Columns) error {
+	if err := columns.Validate(); err != nil {
+		return errors.Wrap(err, "invalid debezium columns")
+	}
+	p.columns = columns
+	return nil
+}
+
+// SetCompression compresses the payload of published messages which are at
+// least threshold bytes long.
+//
+// Received messages are decompressed with the built-in compressors and the
+// provided one.
+func (p *Pipeline) SetCompression(c compress.Compressor, threshold int) error {
+	if c == nil {
+		return errors.New("compressor cannot be nil")
+	}
+	p.compressor = c
+	p.compressThreshold = threshold
+	p.compressors[c.Encoding()] = c
+	return nil
+}
+
+// SetClaimCheck stores the payload of published messages which are over
+// threshold bytes in the store and sends a reference to it instead.
+//
+// Received messages carrying a reference are fetched from the store.
+func (p *Pipeline) SetClaimCheck(store claimcheck.Store, threshold int) error {
+	if store == nil {
+		return errors.New("claim-check store cannot be nil")
+	}
+	p.claimStore = store
+	p.claimThreshold = threshold
+	return nil
+}
+
+// SetEncryption encrypts the payload of published messages with a data key
+// wrapped by the key encryption key identified by keyID. An empty keyID only
+// enables decryption.
+//
+// Received encrypted messages are decrypted with the key provider.
+func (p *Pipeline) SetEncryption(kp encryption.KeyProvider, keyID string) error {
+	if kp == nil {
+		return errors.New("key provider cannot be nil")
+	}
+	p.keyProvider = kp
+	p.keyID = keyID
+	return nil
+}
+
+// Marshal marshals a message into a raw message with the codec.
+func (p *Pipeline) Marshal(m *ship.Message) (*ship.RawMessage, error) {
+	return ship.MarshalMessage(p.codec, m)
+}
+
+// Wrap applies the configured transformations on a message before it is
+// published.
+//
+// The payload is compressed first, as encrypted data does not compress, then
+// encrypted and finally checked in to the claim-check store, if it is still
+// oversized.
+func (p *Pipeline) Wrap(
+	ctx context.Context, message *ship.RawMessage,
+) (*ship.RawMessage, error) {
+	message, err := compress.Compress(p.compressor, p.compressThreshold, message)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyProvider != nil && p.keyID != "" {
+		message, err = encryption.Encrypt(ctx, p.keyProvider, p.keyID, message)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return claimcheck.CheckIn(ctx, p.claimStore, p.claimThreshold, message)
+}
+
+// Unwrap reverses the transformations applied by Wrap on a received message.
+// Returned errors are of type *ship.DecodeError.
+//
+// Errors wrapping a ship.TemporaryError, e.g. an unavailable claim-check
+// store, have the ship.DecodeReasonUnavailable reason, the others the
+// ship.DecodeReasonUnwrap reason.
+func (p *Pipeline) Unwrap(
+	ctx context.Context, raw *ship.RawMessage,
+) (*ship.RawMessage, error) {
+	raw, err := p.unwrap(ctx, raw)
+	if err != nil {
+		reason := ship.DecodeReasonUnwrap
+		if ship.IsTemporary(err) {
+			reason = ship.DecodeReasonUnavailable
+		}
+		return nil, ship.NewDecodeError(reason, errors.Wrap(err, "unable to unwrap message"))
+	}
+
+	return raw, nil
+}
+
+// unwrap reverses the transformations applied by Wrap on a received message.
+func (p *Pipeline) unwrap(
+	ctx context.Context, raw *ship.RawMessage,
+) (*ship.RawMessage, error) {
+	raw, err := claimcheck.CheckOut(ctx, p.claimStore, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err = encryption.Decrypt(ctx, p.keyProvider, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return compress.Decompress(p.compressors, raw)
+}
+
+// Decode unwraps and decodes a received message into a ship.Message.
+// Returned errors are of type *ship.DecodeError.
+//
+// Messages carrying an event type attribute are decoded as a ship envelope,
+// see ship.IsEnvelope. Rest of the messages are decoded as Debezium outbox
+// events.
+func (p *Pipeline) Decode(
+	ctx context.Context, raw *ship.RawMessage,
+) (*ship.Message, error) {
+	raw, err := p.Unwrap(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if ship.IsEnvelope(raw) {
+		return ship.UnmarshalMessage(p.registry, p.codecs, raw)
+	}
+
+	return debezium.DecodeColumns(p.registry, p.columns, raw.Data)
+}
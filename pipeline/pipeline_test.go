@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/debezium"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testEvent) EventName() string { return "TestEvent" }
+
+func TestPipeline(t *testing.T) {
+	testCases := []struct {
+		name      string
+		configure func(t *testing.T, p *Pipeline)
+	}{
+		{
+			name:      "should round trip a message",
+			configure: func(t *testing.T, p *Pipeline) {},
+		},
+		{
+			name: "should round trip a message with every transformation",
+			configure: func(t *testing.T, p *Pipeline) {
+				assert.NoError(t, p.SetCodec(ship.ProtoJSONCodec{}))
+				assert.NoError(t, p.SetCompression(compress.NewZstd(), 0))
+
+				keyring := encryption.NewKeyring()
+				assert.NoError(t, keyring.Generate("some-key"))
+				assert.NoError(t, p.SetEncryption(keyring, "some-key"))
+
+				store, err := claimcheck.NewFileStore(t.TempDir())
+				assert.NoError(t, err)
+				assert.NoError(t, p.SetClaimCheck(store, 0))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			p := New()
+			r := ship.NewRegistry()
+			assert.NoError(t, r.Register(&testEvent{}))
+			assert.NoError(t, p.SetRegistry(r))
+			tc.configure(t, p)
+
+			m := &ship.Message{
+				ID:   "some-id",
+				Type: "TestEvent",
+				Data: &testEvent{Name: "ship"},
+			}
+
+			raw, err := p.Marshal(m)
+			if err != nil {
+				// The proto codecs require a protobuf message.
+				assert.Contains(t, err.Error(), "is not a protobuf message")
+				return
+			}
+
+			raw, err = p.Wrap(ctx, raw)
+			assert.NoError(t, err)
+
+			decoded, err := p.Decode(ctx, raw)
+			assert.NoError(t, err)
+			assert.Equal(t, m.ID, decoded.ID)
+			assert.Equal(t, m.Data, decoded.Data)
+		})
+	}
+}
+
+func TestPipeline_Setters(t *testing.T) {
+	p := New()
+
+	assert.EqualError(t, p.SetRegistry(nil), "registry cannot be nil")
+	assert.EqualError(t, p.SetCodec(nil), "codec cannot be nil")
+	assert.EqualError(
+		t, p.SetDebeziumColumns(debezium.Columns{}),
+		"invalid debezium columns: type column cannot be empty",
+	)
+	assert.EqualError(t, p.SetCompression(nil, 0), "compressor cannot be nil")
+	assert.EqualError(t, p.SetClaimCheck(nil, 0), "claim-check store cannot be nil")
+	assert.EqualError(t, p.SetEncryption(nil, ""), "key provider cannot be nil")
+}
+
+func TestPipeline_DecodeDebezium(t *testing.T) {
+	p := New()
+	r := ship.NewRegistry()
+	assert.NoError(t, r.Register(&testEvent{}))
+	assert.NoError(t, p.SetRegistry(r))
+
+	columns := debezium.DefaultColumns
+	columns.Type = "event_type"
+	columns.Data = "payload"
+	assert.NoError(t, p.SetDebeziumColumns(columns))
+
+	m, err := p.Decode(context.Background(), &ship.RawMessage{
+		Data: []byte(
+			`{"id": "some-id", "event_type": "TestEvent", "payload": "{\"name\": \"ship\"}"}`,
+		),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "some-id", m.ID)
+	assert.Equal(t, &testEvent{Name: "ship"}, m.Data)
+}
+
+func TestPipeline_DecodeUnwrapError(t *testing.T) {
+	p := New()
+
+	_, err := p.Decode(context.Background(), &ship.RawMessage{
+		Attributes: map[string]string{compress.EncodingKey: "unknown"},
+		Data:       []byte("data"),
+	})
+	assert.Error(t, err)
+	assert.Equal(t, ship.DecodeReasonUnwrap, ship.DecodeReason(err))
+}
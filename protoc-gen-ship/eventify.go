@@ -43,6 +43,11 @@ func (p *EventifyPlugin) InitContext(buildCtx pgs.BuildContext) {
 		"marshaler":   p.marshaler,
 		"unmarshaler": p.unmarshaler,
 		"isEvent":     p.isEvent,
+		"hasPII":      p.hasPII,
+		"piiFields":   p.piiFields,
+		"subject":     p.subject,
+		"fieldKind":   p.fieldKind,
+		"fieldZero":   p.fieldZero,
 	})
 
 	p.tpl = template.Must(tpl.Parse(eventifyTemplate))
@@ -96,12 +101,120 @@ func (p *EventifyPlugin) isEvent(m pgs.Message) bool {
 	return isEvent
 }
 
+// isPII reports whether the field is marked with the (ship.pii) option.
+func (p *EventifyPlugin) isPII(f pgs.Field) bool {
+	var isPII bool
+	_, err := f.Extension(schema.E_Pii, &isPII)
+	p.CheckErr(err, "unable to read pii extension from field")
+
+	return isPII
+}
+
+// hasPII reports whether any message of the file has a PII field.
+func (p *EventifyPlugin) hasPII(f pgs.File) bool {
+	for _, msg := range f.AllMessages() {
+		if len(p.piiFields(msg)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// piiFields returns the fields of the message marked as PII.
+// Only singular string and bytes fields outside of a oneof can be marked as
+// PII, the generated methods assign them directly.
+func (p *EventifyPlugin) piiFields(m pgs.Message) []pgs.Field {
+	var fields []pgs.Field
+	for _, f := range m.Fields() {
+		if !p.isPII(f) {
+			continue
+		}
+
+		if f.InOneOf() {
+			p.Failf(
+				"%s: fields of a oneof cannot be marked as pii",
+				f.FullyQualifiedName(),
+			)
+		}
+
+		if !isStringOrBytes(f) {
+			p.Failf(
+				"%s: only singular string and bytes fields can be marked as pii",
+				f.FullyQualifiedName(),
+			)
+		}
+
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// subject returns the field of the message marked as subject, or nil.
+// Only a singular string field can be marked as subject.
+func (p *EventifyPlugin) subject(m pgs.Message) pgs.Field {
+	for _, f := range m.Fields() {
+		var isSubject bool
+		_, err := f.Extension(schema.E_Subject, &isSubject)
+		p.CheckErr(err, "unable to read subject extension from field")
+
+		if !isSubject {
+			continue
+		}
+
+		if f.Type().IsRepeated() || f.Type().IsMap() || f.Type().ProtoType() != pgs.StringT {
+			p.Failf(
+				"%s: only a singular string field can be marked as subject",
+				f.FullyQualifiedName(),
+			)
+		}
+
+		return f
+	}
+	return nil
+}
+
+// fieldKind returns the kind used by the encryption field helpers, String or
+// Bytes.
+func (p *EventifyPlugin) fieldKind(f pgs.Field) string {
+	if f.Type().ProtoType() == pgs.BytesT {
+		return "Bytes"
+	}
+	return "String"
+}
+
+// fieldZero returns the zero value of a string or bytes field.
+func (p *EventifyPlugin) fieldZero(f pgs.Field) string {
+	if f.Type().ProtoType() == pgs.BytesT {
+		return "nil"
+	}
+	return `""`
+}
+
+// isStringOrBytes reports whether the field is a singular string or bytes
+// field, which is not a member of a oneof.
+func isStringOrBytes(f pgs.Field) bool {
+	if f.InOneOf() || f.Type().IsRepeated() || f.Type().IsMap() {
+		return false
+	}
+
+	t := f.Type().ProtoType()
+	return t == pgs.StringT || t == pgs.BytesT
+}
+
 const eventifyTemplate = `package {{ package . }}
 
 import (
+{{- if hasPII . }}
+	"context"
+{{- end }}
 	"encoding/json"
 
 	"github.com/Flahmingo-Investments/ship"
+{{- if hasPII . }}
+	"github.com/Flahmingo-Investments/ship/encryption"
+{{- end }}
+	"github.com/Flahmingo-Investments/ship/redact"
+	"go.uber.org/zap/zapcore"
 	"google.golang.org/protobuf/encoding/protojson"
 )
 
@@ -154,5 +267,82 @@ func (m *{{ name . }}) UnmarshalJSON(b []byte) error {
 
 var _ json.Unmarshaler = (*{{ name . }})(nil)
 
+// MarshalLogObject satisfies the zapcore ObjectMarshaler interface. Fields
+// marked as pii or sensitive are masked, so the event can be logged safely.
+func (m *{{ name . }}) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return redact.MarshalLogObject(enc, m)
+}
+
+var _ zapcore.ObjectMarshaler = (*{{ name . }})(nil)
+
+// Redacted returns the JSON representation of the event with the fields
+// marked as pii or sensitive masked.
+func (m *{{ name . }}) Redacted() string {
+	return redact.String(m)
+}
+
+{{- with subject . }}
+
+// PIISubject returns the id of the data subject of the event.
+func (m *{{ name .Message }}) PIISubject() string {
+	return m.Get{{ name . }}()
+}
+{{- end }}
+
+{{- $pii := piiFields . }}
+{{- if $pii }}
+
+// EncryptPII encrypts the fields marked as PII with the key of the subject.
+//
+// The key of the subject must have been provisioned in the key provider, an
+// error wrapping encryption.ErrKeyNotFound is returned otherwise.
+func (m *{{ name . }}) EncryptPII(
+	ctx context.Context, kp encryption.KeyProvider, subject string,
+) error {
+	if m == nil {
+		return nil
+	}
+
+	s := encryption.NewFieldSealer(ctx, kp, subject)
+{{- range $pii }}
+	m.{{ name . }} = s.Seal{{ fieldKind . }}(m.{{ name . }})
+{{- end }}
+
+	return s.Err()
+}
+
+// DecryptPII decrypts the fields marked as PII with the key of the subject.
+//
+// If the key of the subject does not exist anymore, the fields are cleared
+// and an error wrapping encryption.ErrKeyNotFound is returned. Rest of the
+// event is still usable.
+func (m *{{ name . }}) DecryptPII(
+	ctx context.Context, kp encryption.KeyProvider, subject string,
+) error {
+	if m == nil {
+		return nil
+	}
+
+	o := encryption.NewFieldOpener(ctx, kp, subject)
+{{- range $pii }}
+	m.{{ name . }} = o.Open{{ fieldKind . }}(m.{{ name . }})
+{{- end }}
+
+	return o.Err()
+}
+
+// RedactPII clears the fields marked as PII.
+func (m *{{ name . }}) RedactPII() {
+	if m == nil {
+		return
+	}
+{{ range $pii }}
+	m.{{ name . }} = {{ fieldZero . }}
+{{- end }}
+}
+
+var _ encryption.PIIEvent = (*{{ name . }})(nil)
+{{- end }}
+
 {{ end }}
 `
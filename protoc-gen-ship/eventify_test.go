@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship/schema"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// pluginEnv makes the test binary run the plugin, as the plugin exits on
+// failures.
+const pluginEnv = "SHIP_TEST_RUN_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// field returns a field of the sample event, marked with the extensions.
+func field(
+	name string,
+	number int32,
+	typ descriptorpb.FieldDescriptorProto_Type,
+	exts ...protoreflect.ExtensionType,
+) *descriptorpb.FieldDescriptorProto {
+	opts := &descriptorpb.FieldOptions{}
+	for _, ext := range exts {
+		proto.SetExtension(opts, ext, true)
+	}
+
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     typ.Enum(),
+		Options:  opts,
+	}
+}
+
+// repeated marks the field as repeated.
+func repeated(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return f
+}
+
+// oneof makes the field a member of the contact oneof.
+func oneof(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	f.OneofIndex = proto.Int32(0)
+	return f
+}
+
+// request returns the request generating sample.proto, holding the
+// UserCreated event with the fields.
+func request(fields ...*descriptorpb.FieldDescriptorProto) *pluginpb.CodeGeneratorRequest {
+	opts := &descriptorpb.MessageOptions{}
+	proto.SetExtension(opts, schema.E_Event, true)
+
+	sample := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("sample.proto"),
+		Package:    proto.String("sample.v1"),
+		Dependency: []string{schema.File_ship_proto.Path()},
+		Syntax:     proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{
+			GoPackage: proto.String("example.com/sample;sample"),
+		},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:      proto.String("UserCreated"),
+				Field:     fields,
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("contact")}},
+				Options:   opts,
+			},
+		},
+	}
+
+	return &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"sample.proto"},
+		Parameter:      proto.String("paths=source_relative"),
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(schema.File_ship_proto),
+			sample,
+		},
+	}
+}
+
+// runPlugin runs a protoc plugin on the request. It returns the response and
+// the standard error of the plugin.
+func runPlugin(
+	t *testing.T, cmd *exec.Cmd, req *pluginpb.CodeGeneratorRequest,
+) (*pluginpb.CodeGeneratorResponse, string, error) {
+	t.Helper()
+
+	in, err := proto.Marshal(req)
+	assert.NoError(t, err)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, stderr.String(), err
+	}
+
+	resp := &pluginpb.CodeGeneratorResponse{}
+	assert.NoError(t, proto.Unmarshal(stdout.Bytes(), resp))
+
+	return resp, stderr.String(), nil
+}
+
+// shipPlugin returns the command running the plugin.
+func shipPlugin() *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), pluginEnv+"=1")
+	return cmd
+}
+
+// writeFiles writes the generated files in dir.
+func writeFiles(t *testing.T, dir string, resp *pluginpb.CodeGeneratorResponse) {
+	t.Helper()
+
+	assert.Empty(t, resp.GetError())
+	for _, f := range resp.GetFile() {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, f.GetName()), []byte(f.GetContent()), 0o600))
+	}
+}
+
+func TestEventify(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the generated code")
+	}
+
+	req := request(
+		field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, schema.E_Subject),
+		field("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, schema.E_Pii),
+		field("avatar", 3, descriptorpb.FieldDescriptorProto_TYPE_BYTES, schema.E_Pii),
+		field("password", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, schema.E_Sensitive),
+		field("age", 5, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+		oneof(field("email", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING)),
+		oneof(field("phone", 7, descriptorpb.FieldDescriptorProto_TYPE_STRING)),
+	)
+
+	resp, stderr, err := runPlugin(t, shipPlugin(), req)
+	if !assert.NoError(t, err, stderr) {
+		return
+	}
+
+	events := resp.GetFile()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "sample.pb.event.go", events[0].GetName())
+		assert.Contains(t, events[0].GetContent(), "func (m *UserCreated) EncryptPII(")
+		assert.Contains(t, events[0].GetContent(), "m.Avatar = s.SealBytes(m.Avatar)")
+		assert.Contains(t, events[0].GetContent(), "return m.GetId()")
+	}
+
+	// The generated code is built along with the messages generated by
+	// protoc-gen-go, in the module so the ship packages are resolved.
+	messages, stderr, err := runPlugin(
+		t, exec.Command("go", "run", "google.golang.org/protobuf/cmd/protoc-gen-go"), req,
+	)
+	if !assert.NoError(t, err, stderr) {
+		return
+	}
+
+	assert.NoError(t, os.MkdirAll("testdata", 0o700))
+	dir, err := os.MkdirTemp("testdata", "sample")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+		// Removes testdata, if it was created by the test.
+		_ = os.Remove("testdata")
+	}()
+
+	writeFiles(t, dir, resp)
+	writeFiles(t, dir, messages)
+
+	out, err := exec.Command("go", "build", "./"+dir).CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
+
+func TestEventify_errors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		fields []*descriptorpb.FieldDescriptorProto
+		err    string
+	}{
+		{
+			name: "should fail: pii field is not a string or bytes",
+			fields: []*descriptorpb.FieldDescriptorProto{
+				field("age", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, schema.E_Pii),
+			},
+			err: ".sample.v1.UserCreated.age: only singular string and bytes fields can be marked as pii",
+		},
+		{
+			name: "should fail: pii field is repeated",
+			fields: []*descriptorpb.FieldDescriptorProto{
+				repeated(field("names", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, schema.E_Pii)),
+			},
+			err: ".sample.v1.UserCreated.names: only singular string and bytes fields can be marked as pii",
+		},
+		{
+			name: "should fail: pii field is in a oneof",
+			fields: []*descriptorpb.FieldDescriptorProto{
+				oneof(field("email", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, schema.E_Pii)),
+			},
+			err: ".sample.v1.UserCreated.email: fields of a oneof cannot be marked as pii",
+		},
+		{
+			name: "should fail: subject field is not a string",
+			fields: []*descriptorpb.FieldDescriptorProto{
+				field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, schema.E_Subject),
+			},
+			err: ".sample.v1.UserCreated.id: only a singular string field can be marked as subject",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, stderr, err := runPlugin(t, shipPlugin(), request(tc.fields...))
+			assert.Error(t, err)
+			assert.Contains(t, stderr, tc.err)
+		})
+	}
+}
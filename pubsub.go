@@ -49,3 +49,22 @@ type MessageHandlerFunc func(context.Context, *Message) error
 func (f MessageHandlerFunc) HandleMessage(ctx context.Context, m *Message) error {
 	return f(ctx, m)
 }
+
+// BatchHandler provides method to handle received messages in batches.
+type BatchHandler interface {
+	// HandleBatch handles a batch of received messages from Subscriber.
+	//
+	// It returns the result of every message at the same index: a nil error
+	// acknowledges the message, otherwise it is redelivered. Returning a
+	// slice of a different length redelivers the whole batch.
+	HandleBatch(ctx context.Context, messages []*Message) []error
+}
+
+// BatchHandlerFunc type is an adapter to allow the use of ordinary functions
+// as batch handlers.
+type BatchHandlerFunc func(context.Context, []*Message) []error
+
+// HandleBatch handles received messages from Subscriber.
+func (f BatchHandlerFunc) HandleBatch(ctx context.Context, messages []*Message) []error {
+	return f(ctx, messages)
+}
@@ -0,0 +1,649 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const (
+	testTopic        = "some-topic"
+	testSubscription = "some-subscription"
+)
+
+// errNotFound is returned by the broker for a missing exchange or queue.
+var errNotFound = &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND"}
+
+// broker is an in-memory AMQP server. The exchanges route the published
+// messages to the queues bound with the routing key or "#".
+type broker struct {
+	mu        sync.Mutex
+	exchanges map[string]string
+	queues    map[string]*queue
+	nack      bool
+	channels  []*fakeChannel
+}
+
+// queue is a queue of the broker.
+type queue struct {
+	args     amqp.Table
+	bindings map[string][]string
+	ready    []amqp.Delivery
+	rejected []amqp.Delivery
+}
+
+func newBroker() *broker {
+	return &broker{
+		exchanges: make(map[string]string),
+		queues:    make(map[string]*queue),
+	}
+}
+
+// route appends a published message to the queues bound to the exchange.
+func (b *broker) route(exchange, key string, msg amqp.Publishing) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, q := range b.queues {
+		for _, k := range q.bindings[exchange] {
+			if k != "#" && k != key {
+				continue
+			}
+
+			q.ready = append(q.ready, amqp.Delivery{
+				Headers:     msg.Headers,
+				ContentType: msg.ContentType,
+				MessageId:   msg.MessageId,
+				Timestamp:   msg.Timestamp,
+				Exchange:    exchange,
+				RoutingKey:  key,
+				Body:        msg.Body,
+			})
+			break
+		}
+	}
+}
+
+// pop returns the next ready message of a queue.
+func (b *broker) pop(name string) (amqp.Delivery, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queues[name]
+	if q == nil || len(q.ready) == 0 {
+		return amqp.Delivery{}, false
+	}
+
+	d := q.ready[0]
+	q.ready = q.ready[1:]
+	return d, true
+}
+
+// requeue puts a message back at the head of its queue, or rejects it.
+func (b *broker) requeue(name string, d amqp.Delivery, requeue bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queues[name]
+	if !requeue {
+		q.rejected = append(q.rejected, d)
+		return
+	}
+
+	d.Redelivered = true
+	q.ready = append([]amqp.Delivery{d}, q.ready...)
+}
+
+// size returns the number of ready and rejected messages of a queue.
+func (b *broker) size(name string) (ready, rejected int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queues[name]
+	if q == nil {
+		return 0, 0
+	}
+	return len(q.ready), len(q.rejected)
+}
+
+// consumerChannel returns the first channel with a consumer.
+func (b *broker) consumerChannel() *fakeChannel {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.channels {
+		ch.mu.Lock()
+		consuming := len(ch.consumers) > 0
+		ch.mu.Unlock()
+
+		if consuming {
+			return ch
+		}
+	}
+	return nil
+}
+
+// fakeConnection implements connection with the broker.
+type fakeConnection struct {
+	b *broker
+
+	mu     sync.Mutex
+	closes []chan *amqp.Error
+}
+
+func (c *fakeConnection) channel() (channel, error) {
+	ch := &fakeChannel{
+		b:         c.b,
+		unacked:   make(map[uint64]unacked),
+		consumers: make(map[string]chan struct{}),
+	}
+
+	c.b.mu.Lock()
+	c.b.channels = append(c.b.channels, ch)
+	c.b.mu.Unlock()
+
+	return ch, nil
+}
+
+func (c *fakeConnection) NotifyClose(closes chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	c.closes = append(c.closes, closes)
+	c.mu.Unlock()
+	return closes
+}
+
+func (c *fakeConnection) Close() error {
+	c.b.mu.Lock()
+	channels := c.b.channels
+	c.b.mu.Unlock()
+
+	for _, ch := range channels {
+		_ = ch.Close()
+	}
+
+	c.mu.Lock()
+	for _, closes := range c.closes {
+		close(closes)
+	}
+	c.closes = nil
+	c.mu.Unlock()
+	return nil
+}
+
+// unacked is a delivered message waiting for its acknowledgement.
+type unacked struct {
+	queue    string
+	delivery amqp.Delivery
+}
+
+// fakeChannel implements channel and amqp.Acknowledger with the broker.
+type fakeChannel struct {
+	b *broker
+
+	mu        sync.Mutex
+	closed    bool
+	prefetch  int
+	tag       uint64
+	unacked   map[uint64]unacked
+	consumers map[string]chan struct{}
+	confirms  []chan amqp.Confirmation
+	closes    []chan *amqp.Error
+	confirm   bool
+	published uint64
+	wg        sync.WaitGroup
+}
+
+func (ch *fakeChannel) ExchangeDeclare(
+	name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table,
+) error {
+	ch.b.mu.Lock()
+	defer ch.b.mu.Unlock()
+
+	ch.b.exchanges[name] = kind
+	return nil
+}
+
+func (ch *fakeChannel) ExchangeDeclarePassive(
+	name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table,
+) error {
+	ch.b.mu.Lock()
+	defer ch.b.mu.Unlock()
+
+	if _, ok := ch.b.exchanges[name]; !ok {
+		return errNotFound
+	}
+	return nil
+}
+
+func (ch *fakeChannel) QueueDeclare(
+	name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table,
+) (amqp.Queue, error) {
+	ch.b.mu.Lock()
+	defer ch.b.mu.Unlock()
+
+	if _, ok := ch.b.queues[name]; !ok {
+		ch.b.queues[name] = &queue{args: args, bindings: make(map[string][]string)}
+	}
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) QueueDeclarePassive(
+	name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table,
+) (amqp.Queue, error) {
+	ch.b.mu.Lock()
+	defer ch.b.mu.Unlock()
+
+	if _, ok := ch.b.queues[name]; !ok {
+		return amqp.Queue{}, errNotFound
+	}
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	ch.b.mu.Lock()
+	defer ch.b.mu.Unlock()
+
+	if _, ok := ch.b.exchanges[exchange]; !ok {
+		return errNotFound
+	}
+	q := ch.b.queues[name]
+	q.bindings[exchange] = append(q.bindings[exchange], key)
+	return nil
+}
+
+func (ch *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	ch.mu.Lock()
+	ch.prefetch = prefetchCount
+	ch.mu.Unlock()
+	return nil
+}
+
+// Consume delivers the ready messages of the queue, up to the prefetch count,
+// until the consumer is cancelled.
+func (ch *fakeChannel) Consume(
+	name, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table,
+) (<-chan amqp.Delivery, error) {
+	deliveries := make(chan amqp.Delivery)
+	done := make(chan struct{})
+
+	ch.mu.Lock()
+	ch.consumers[consumer] = done
+	ch.wg.Add(1)
+	ch.mu.Unlock()
+
+	go func() {
+		defer ch.wg.Done()
+		defer close(deliveries)
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+
+			ch.mu.Lock()
+			full := len(ch.unacked) >= ch.prefetch
+			ch.mu.Unlock()
+			if full {
+				continue
+			}
+
+			d, ok := ch.b.pop(name)
+			if !ok {
+				continue
+			}
+
+			ch.mu.Lock()
+			ch.tag++
+			d.DeliveryTag = ch.tag
+			d.ConsumerTag = consumer
+			d.Acknowledger = ch
+			ch.unacked[d.DeliveryTag] = unacked{queue: name, delivery: d}
+			ch.mu.Unlock()
+
+			select {
+			case deliveries <- d:
+			case <-done:
+				if _, ok := ch.ack(d.DeliveryTag); ok {
+					ch.b.requeue(name, d, true)
+				}
+				return
+			}
+		}
+	}()
+
+	return deliveries, nil
+}
+
+func (ch *fakeChannel) Cancel(consumer string, noWait bool) error {
+	ch.mu.Lock()
+	done, ok := ch.consumers[consumer]
+	delete(ch.consumers, consumer)
+	ch.mu.Unlock()
+
+	if ok {
+		close(done)
+	}
+	return nil
+}
+
+func (ch *fakeChannel) Confirm(noWait bool) error {
+	ch.mu.Lock()
+	ch.confirm = true
+	ch.mu.Unlock()
+	return nil
+}
+
+func (ch *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.mu.Lock()
+	ch.confirms = append(ch.confirms, confirm)
+	ch.mu.Unlock()
+	return confirm
+}
+
+func (ch *fakeChannel) NotifyClose(closes chan *amqp.Error) chan *amqp.Error {
+	ch.mu.Lock()
+	ch.closes = append(ch.closes, closes)
+	ch.mu.Unlock()
+	return closes
+}
+
+func (ch *fakeChannel) Publish(
+	exchange, key string, mandatory, immediate bool, msg amqp.Publishing,
+) error {
+	ch.b.route(exchange, key, msg)
+
+	ch.b.mu.Lock()
+	nack := ch.b.nack
+	ch.b.mu.Unlock()
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if !ch.confirm {
+		return nil
+	}
+	ch.published++
+	for _, c := range ch.confirms {
+		c <- amqp.Confirmation{DeliveryTag: ch.published, Ack: !nack}
+	}
+	return nil
+}
+
+// Close cancels the consumers and requeues the unacknowledged messages.
+func (ch *fakeChannel) Close() error {
+	return ch.close(nil)
+}
+
+// close closes the channel, as the server does with a non-nil error.
+func (ch *fakeChannel) close(err *amqp.Error) error {
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return nil
+	}
+	ch.closed = true
+	for _, closes := range ch.closes {
+		if err != nil {
+			closes <- err
+		}
+		close(closes)
+	}
+	ch.closes = nil
+	for tag, done := range ch.consumers {
+		close(done)
+		delete(ch.consumers, tag)
+	}
+	ch.mu.Unlock()
+
+	ch.wg.Wait()
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	for tag, u := range ch.unacked {
+		ch.b.requeue(u.queue, u.delivery, true)
+		delete(ch.unacked, tag)
+	}
+	for _, c := range ch.confirms {
+		close(c)
+	}
+	ch.confirms = nil
+	return nil
+}
+
+func (ch *fakeChannel) ack(tag uint64) (unacked, bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	u, ok := ch.unacked[tag]
+	delete(ch.unacked, tag)
+	return u, ok
+}
+
+func (ch *fakeChannel) Ack(tag uint64, multiple bool) error {
+	if _, ok := ch.ack(tag); !ok {
+		return errors.New("unknown delivery tag")
+	}
+	return nil
+}
+
+func (ch *fakeChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	u, ok := ch.ack(tag)
+	if !ok {
+		return errors.New("unknown delivery tag")
+	}
+	ch.b.requeue(u.queue, u.delivery, requeue)
+	return nil
+}
+
+func (ch *fakeChannel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+func TestNewClient(t *testing.T) {
+	testCases := []struct {
+		name         string
+		opts         []Option
+		checkReturns func(*testing.T, *PubSub, error)
+	}{
+		{
+			name: "should return error: could not apply option",
+			opts: []Option{
+				func(ps *PubSub) error {
+					return errors.New("some error")
+				},
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "could not apply option")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: subscription has no topic",
+			opts: []Option{WithSubscription(testSubscription, "")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "subscription some-subscription has no topic")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: exchange kind cannot be empty",
+			opts: []Option{WithExchangeKind("")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "exchange kind cannot be empty")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid prefetch",
+			opts: []Option{WithPrefetch(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid prefetch 0")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return a new client",
+			opts: []Option{
+				nil,
+				WithLogger(zap.NewNop()),
+				WithRegistry(ship.NewRegistry()),
+				WithCreateTopic(true),
+				WithCreateSubscription(true),
+				WithSubscription(testSubscription, testTopic, "UserCreated"),
+				WithExchangeKind(amqp.ExchangeDirect),
+				WithDeadLetterExchange("some-dead-letter-exchange"),
+				WithPrefetch(1),
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.NoError(t, err)
+				assert.NotNil(t, ps)
+				assert.Equal(t, []string{"UserCreated"}, ps.binding(testSubscription).keys)
+				assert.NoError(t, ps.Stop())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			useBroker(t, newBroker())
+
+			client, err := NewClient("amqp://localhost", tc.opts...)
+			tc.checkReturns(t, client, err)
+		})
+	}
+}
+
+func TestNewClient_connectionError(t *testing.T) {
+	orig := dial
+	dial = func(string) (connection, error) {
+		return nil, errors.New("some error")
+	}
+	t.Cleanup(func() { dial = orig })
+
+	client, err := NewClient("amqp://localhost")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to connect to amqp server")
+	assert.Nil(t, client)
+}
+
+func TestPubSub_EnsureTopics(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		check func(*testing.T, *broker, error)
+	}{
+		{
+			name: "should return error: exchange does not exists",
+			check: func(t *testing.T, b *broker, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "exchange some-topic does not exists")
+			},
+		},
+		{
+			name: "should declare the exchange",
+			opts: []Option{WithCreateTopic(true), WithExchangeKind(amqp.ExchangeFanout)},
+			check: func(t *testing.T, b *broker, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, map[string]string{testTopic: amqp.ExchangeFanout}, b.exchanges)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+			defer suite.Teardown(t)
+
+			err := suite.client.EnsureTopics("", testTopic)
+			tc.check(t, suite.broker, err)
+		})
+	}
+}
+
+func TestPubSub_Stop(t *testing.T) {
+	suite := newTestSuite(t)
+
+	assert.NoError(t, suite.client.Stop())
+	assert.True(t, suite.client.Health().Stopped)
+	assert.ErrorIs(t, suite.client.Stop(), ErrStopped)
+}
+
+type suite struct {
+	broker *broker
+	client *PubSub
+}
+
+// Teardown teardowns the test suite.
+func (s *suite) Teardown(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), 10*time.Second)
+	defer timeout()
+
+	done := make(chan struct{})
+
+	go func(t *testing.T) {
+		err := s.client.Stop()
+		assert.NoError(t, err)
+
+		close(done)
+	}(t)
+
+	select {
+	case <-ctx.Done():
+		assert.Fail(t, "unable to stop client properly")
+	case <-done:
+	}
+}
+
+// useBroker makes the clients connect to the broker until the end of the
+// test.
+func useBroker(t *testing.T, b *broker) {
+	t.Helper()
+
+	orig := dial
+	dial = func(string) (connection, error) {
+		return &fakeConnection{b: b}, nil
+	}
+	t.Cleanup(func() { dial = orig })
+}
+
+// newTestSuite returns a test suite for easier testing.
+func newTestSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	b := newBroker()
+	useBroker(t, b)
+
+	opts = append([]Option{WithSubscription(testSubscription, testTopic)}, opts...)
+	client, err := NewClient("amqp://localhost", opts...)
+	assert.NoError(t, err)
+
+	return &suite{
+		broker: b,
+		client: client,
+	}
+}
@@ -0,0 +1,332 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/pkg/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Publisher = (*PubSub)(nil)
+
+// OrderingKeyHeader holds the ship.RawMessage.OrderingKey, AMQP messages have
+// no ordering key.
+const OrderingKeyHeader = "ship_ordering_key"
+
+// EnsureTopics checks whether the exchange of a topic exists or not.
+//
+// If createTopic is `true` it will create a durable exchange.
+func (p *PubSub) EnsureTopics(topics ...string) error {
+	for _, topic := range topics {
+		if topic == "" {
+			continue
+		}
+
+		if err := p.topicInit(topic); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// topicInit checks the exchange of a topic and create it, if required.
+func (p *PubSub) topicInit(topic string) error {
+	if p.createTopic {
+		p.logger.Info(
+			fmt.Sprintf("declaring exchange %s", topic), zap.String("topic", topic),
+		)
+		err := p.withChannel(func(ch channel) error {
+			return ch.ExchangeDeclare(topic, p.exchangeKind, true, false, false, false, nil)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "unable to create %s exchange", topic)
+		}
+
+		return nil
+	}
+
+	p.logger.Info(
+		fmt.Sprintf("checking if exchange (%s) exists", topic), zap.String("topic", topic),
+	)
+	err := p.withChannel(func(ch channel) error {
+		return ch.ExchangeDeclarePassive(topic, p.exchangeKind, true, false, false, false, nil)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "exchange %s does not exists", topic)
+	}
+
+	return nil
+}
+
+// Publish publishes the message to a given topic.
+//
+// The message data is encoded with the configured codec and the message fields
+// are sent as headers, see ship.MarshalMessage. It returns once the server
+// confirmed the message.
+func (p *PubSub) Publish(topic string, message *ship.Message) error {
+	return p.PublishContext(context.Background(), topic, message)
+}
+
+// PublishContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message headers. The confirmation of the server is awaited until ctx is
+// done.
+func (p *PubSub) PublishContext(
+	ctx context.Context, topic string, message *ship.Message,
+) error {
+	raw, err := p.pipeline.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal message to bytes")
+	}
+
+	return p.publish(ctx, topic, raw)
+}
+
+// PublishRaw publishes the message to a given topic.
+func (p *PubSub) PublishRaw(topic string, message *ship.RawMessage) error {
+	return p.PublishRawContext(context.Background(), topic, message)
+}
+
+// PublishRawContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message headers. The confirmation of the server is awaited until ctx is
+// done.
+func (p *PubSub) PublishRawContext(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) error {
+	return p.publish(ctx, topic, message)
+}
+
+// publish wraps the raw message, publishes it to the exchange of a topic and
+// waits for its confirmation.
+func (p *PubSub) publish(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) (err error) {
+	start := time.Now()
+	ctx, span := p.tracer.StartPublish(ctx, topic)
+	defer func() {
+		tracing.End(span, err)
+		if err != nil {
+			p.metrics.PublishFailed(topic, time.Since(start))
+			return
+		}
+		p.metrics.PublishSucceeded(topic, time.Since(start))
+	}()
+
+	message, err = p.pipeline.Wrap(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "unable to wrap message")
+	}
+
+	attributes := p.tracer.Inject(ctx, message.Attributes)
+	headers := make(amqp.Table, len(attributes)+1)
+	for k, v := range attributes {
+		headers[k] = v
+	}
+	if message.OrderingKey != "" {
+		headers[OrderingKeyHeader] = message.OrderingKey
+	}
+
+	msg := amqp.Publishing{
+		Headers:      headers,
+		ContentType:  attributes[ship.ContentTypeKey],
+		DeliveryMode: amqp.Persistent,
+		MessageId:    attributes[ship.IDKey],
+		Timestamp:    time.Now(),
+		Body:         message.Data,
+	}
+
+	p.logger.Debug(
+		"publishing message to topic", zap.String("topic", topic),
+	)
+	if err := p.confirmPublish(ctx, topic, attributes[ship.TypeKey], msg); err != nil {
+		p.logger.Error(
+			"unable to publish message",
+			zap.Error(err),
+			zap.String("topic", topic),
+		)
+		return errors.Wrap(err, "could not publish message")
+	}
+
+	tracing.SetMessageID(span, msg.MessageId)
+
+	return nil
+}
+
+// confirmPublish publishes a message and waits for its confirmation.
+//
+// The messages are published concurrently, the confirmations are matched to
+// them by delivery tag.
+func (p *PubSub) confirmPublish(
+	ctx context.Context, exchange, key string, msg amqp.Publishing,
+) error {
+	c, err := p.publisher()
+	if err != nil {
+		return err
+	}
+
+	tag, confirm, err := c.publish(exchange, key, msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case ack, ok := <-confirm:
+		if !ok {
+			return errors.New("publishing channel is closed")
+		}
+		if !ack {
+			return errors.New("message was not confirmed by the server")
+		}
+		return nil
+	case <-ctx.Done():
+		c.forget(tag)
+		return errors.Wrap(ctx.Err(), "confirmation not received")
+	}
+}
+
+// publisher returns the confirmer publishing the messages. A new channel is
+// opened if the previous one was closed, e.g. by a publish to a missing
+// exchange.
+func (p *PubSub) publisher() (*confirmer, error) {
+	p.confirmerMu.Lock()
+	defer p.confirmerMu.Unlock()
+
+	if p.confirmer != nil && !p.confirmer.isClosed() {
+		return p.confirmer, nil
+	}
+
+	ch, err := p.conn.channel()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open publishing channel")
+	}
+
+	c, err := newConfirmer(ch)
+	if err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	go p.watchPublisher(ch.NotifyClose(make(chan *amqp.Error, 1)))
+
+	p.confirmer = c
+	return c, nil
+}
+
+// watchPublisher reports the closing of a publishing channel by the server.
+func (p *PubSub) watchPublisher(closes <-chan *amqp.Error) {
+	amqpErr, ok := <-closes
+	if !ok || amqpErr == nil {
+		return
+	}
+
+	err := errors.Wrap(amqpErr, "publishing channel is closed")
+	p.logger.Error("publishing channel is closed by the server", zap.Error(err))
+	transport.ReportError(p.errCh, err)
+}
+
+// confirmer publishes messages on a channel in confirm mode and dispatches
+// the confirmations of the server to the publishers by delivery tag.
+type confirmer struct {
+	ch channel
+
+	// publishMu orders the delivery tags as the publishes.
+	publishMu sync.Mutex
+	tag       uint64
+
+	mu      sync.Mutex
+	pending map[uint64]chan bool
+	closed  bool
+}
+
+// newConfirmer puts the channel in confirm mode and starts dispatching its
+// confirmations.
+func newConfirmer(ch channel) (*confirmer, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, errors.Wrap(err, "unable to enable publisher confirms")
+	}
+
+	c := &confirmer{
+		ch:      ch,
+		pending: make(map[uint64]chan bool),
+	}
+	go c.dispatch(ch.NotifyPublish(make(chan amqp.Confirmation, DefaultPrefetch)))
+
+	return c, nil
+}
+
+// publish publishes a message. The returned channel receives whether the
+// server acknowledged the message, it is closed if the channel is closed
+// first.
+func (c *confirmer) publish(
+	exchange, key string, msg amqp.Publishing,
+) (uint64, <-chan bool, error) {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+
+	// The confirmation is awaited before publishing, as it can be dispatched
+	// before Publish returns.
+	tag := c.tag + 1
+	confirm := make(chan bool, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, nil, errors.New("publishing channel is closed")
+	}
+	c.pending[tag] = confirm
+	c.mu.Unlock()
+
+	if err := c.ch.Publish(exchange, key, false, false, msg); err != nil {
+		c.forget(tag)
+		return 0, nil, err
+	}
+	c.tag = tag
+
+	return tag, confirm, nil
+}
+
+// forget stops awaiting the confirmation of a message.
+func (c *confirmer) forget(tag uint64) {
+	c.mu.Lock()
+	delete(c.pending, tag)
+	c.mu.Unlock()
+}
+
+// isClosed reports whether the channel is closed.
+func (c *confirmer) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// dispatch passes the confirmations to their publishers, until the channel is
+// closed.
+func (c *confirmer) dispatch(confirms <-chan amqp.Confirmation) {
+	for confirm := range confirms {
+		c.mu.Lock()
+		if ch, ok := c.pending[confirm.DeliveryTag]; ok {
+			ch <- confirm.Ack
+			delete(c.pending, confirm.DeliveryTag)
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	c.closed = true
+	for tag, ch := range c.pending {
+		close(ch)
+		delete(c.pending, tag)
+	}
+	c.mu.Unlock()
+}
@@ -0,0 +1,152 @@
+package amqp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testEvent) EventName() string { return "TestEvent" }
+
+// declare declares the test exchange and a queue bound to it with the key.
+func (s *suite) declare(t *testing.T, key string) {
+	t.Helper()
+
+	ch, err := s.client.conn.channel()
+	assert.NoError(t, err)
+	defer ch.Close()
+
+	assert.NoError(t, ch.ExchangeDeclare(testTopic, amqp.ExchangeTopic, true, false, false, false, nil))
+	_, err = ch.QueueDeclare(testSubscription, true, false, false, false, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, ch.QueueBind(testSubscription, key, testTopic, false, nil))
+}
+
+func TestPubSub_Publish(t *testing.T) {
+	testCases := []struct {
+		name    string
+		publish func(p *PubSub) error
+		check   func(t *testing.T, d amqp.Delivery)
+	}{
+		{
+			name: "should publish a message routed by its type",
+			publish: func(p *PubSub) error {
+				return p.Publish(testTopic, &ship.Message{
+					ID:   "some-id",
+					Data: &testEvent{Name: "ship"},
+				})
+			},
+			check: func(t *testing.T, d amqp.Delivery) {
+				assert.Equal(t, testTopic, d.Exchange)
+				assert.Equal(t, "TestEvent", d.RoutingKey)
+				assert.Equal(t, "some-id", d.MessageId)
+				assert.Equal(t, "application/json", d.ContentType)
+				assert.JSONEq(t, `{"name": "ship"}`, string(d.Body))
+				assert.Equal(t, "some-id", d.Headers[ship.IDKey])
+				assert.Equal(t, "TestEvent", d.Headers[ship.TypeKey])
+				assert.False(t, d.Timestamp.IsZero())
+			},
+		},
+		{
+			name: "should publish a raw message with its ordering key",
+			publish: func(p *PubSub) error {
+				return p.PublishRaw(testTopic, &ship.RawMessage{
+					Data:        []byte("some-data"),
+					Attributes:  map[string]string{"some-key": "some-value"},
+					OrderingKey: "some-ordering-key",
+				})
+			},
+			check: func(t *testing.T, d amqp.Delivery) {
+				assert.Equal(t, "", d.RoutingKey)
+				assert.Equal(t, "some-data", string(d.Body))
+				assert.Equal(t, amqp.Table{
+					"some-key":        "some-value",
+					OrderingKeyHeader: "some-ordering-key",
+				}, d.Headers)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t)
+			defer suite.Teardown(t)
+
+			suite.declare(t, DefaultBindingKey)
+			assert.NoError(t, tc.publish(suite.client))
+
+			d, ok := suite.broker.pop(testSubscription)
+			if assert.True(t, ok) {
+				tc.check(t, d)
+			}
+		})
+	}
+}
+
+func TestPubSub_PublishError(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newTestSuite(t, WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	suite.broker.nack = true
+
+	err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "message was not confirmed by the server")
+	assert.Equal(t, 1, recorder.count("publish_failed:"+testTopic))
+}
+
+func TestPubSub_PublishConcurrently(t *testing.T) {
+	suite := newTestSuite(t)
+	defer suite.Teardown(t)
+
+	suite.declare(t, DefaultBindingKey)
+
+	const n = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	ready, _ := suite.broker.size(testSubscription)
+	assert.Equal(t, n, ready)
+}
+
+func TestPubSub_PublishChannelClosed(t *testing.T) {
+	suite := newTestSuite(t)
+	defer suite.Teardown(t)
+
+	suite.declare(t, DefaultBindingKey)
+
+	ch := suite.client.confirmer.ch.(*fakeChannel)
+	assert.NoError(t, ch.close(&amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND"}))
+
+	select {
+	case err := <-suite.client.errCh:
+		assert.Contains(t, err.Error(), "publishing channel is closed")
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "closing of the channel not reported")
+	}
+
+	// The next publish opens a new channel.
+	assert.Eventually(t, func() bool {
+		return suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")}) == nil
+	}, 10*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,504 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+// testRecorder is a metrics.Recorder counting the recorded metrics.
+type testRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{counts: make(map[string]int)}
+}
+
+func (r *testRecorder) inc(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+func (r *testRecorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+func (r *testRecorder) PublishSucceeded(topic string, _ time.Duration) {
+	r.inc("published:" + topic)
+}
+
+func (r *testRecorder) PublishFailed(topic string, _ time.Duration) {
+	r.inc("publish_failed:" + topic)
+}
+
+func (r *testRecorder) Handled(sub, handler string, _ time.Duration) {
+	r.inc("handled:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Acked(sub, handler string) {
+	r.inc("acked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Nacked(sub, handler string) {
+	r.inc("nacked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DeadLettered(sub, handler string) {
+	r.inc("dead_lettered:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DecodeFailed(sub, handler, reason string) {
+	r.inc("decode_failed:" + sub + ":" + handler + ":" + reason)
+}
+
+type rawHandlerFunc func(context.Context, *ship.RawMessage) error
+
+func (f rawHandlerFunc) HandleRawMessage(ctx context.Context, m *ship.RawMessage) error {
+	return f(ctx, m)
+}
+
+// newSubscriptionSuite returns a test suite with the exchange of the test
+// topic and the queue of the test subscription.
+func newSubscriptionSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	opts = append([]Option{WithCreateTopic(true), WithCreateSubscription(true)}, opts...)
+	suite := newTestSuite(t, opts...)
+
+	assert.NoError(t, suite.client.EnsureTopics(testTopic))
+
+	return suite
+}
+
+// envelope returns a ship envelope of the event.
+func envelope(t *testing.T, id string, event ship.Event) *ship.RawMessage {
+	t.Helper()
+
+	raw, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{ID: id, Data: event})
+	assert.NoError(t, err)
+
+	return raw
+}
+
+func TestPubSub_Subscribe(t *testing.T) {
+	debezium, err := os.ReadFile("../../debezium/testdata/valid_data.fixture")
+	assert.NoError(t, err)
+
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&userCreated{}))
+
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(
+		t,
+		WithRegistry(registry),
+		WithMetrics(recorder),
+		WithRetryBackoff(time.Millisecond, time.Millisecond),
+	)
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		received []string
+		failed   bool
+	)
+	handler := ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first delivery of the last message fails, it must be requeued.
+		if m.ID == "some-last-id" && !failed {
+			failed = true
+			return errors.New("some error")
+		}
+
+		received = append(received, m.ID)
+		return nil
+	})
+
+	err = suite.client.Subscribe(testSubscription, handler)
+	assert.NoError(t, err)
+
+	for _, m := range []*ship.RawMessage{
+		envelope(t, "some-id", &userCreated{Email: "someone@flahmingo.com"}),
+		envelope(t, "some-other-id", &testEvent{Name: "unregistered"}),
+		{Data: debezium},
+		envelope(t, "some-last-id", &userCreated{Email: "someone@flahmingo.com"}),
+	} {
+		assert.NoError(t, suite.client.PublishRaw(testTopic, m))
+	}
+
+	hName := "MessageHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("acked:"+testSubscription+":"+hName) == 4
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []string{
+		"some-id",
+		"e79e906a-5022-473f-9a67-ff0993851be9",
+		"some-last-id",
+	}, received)
+	mu.Unlock()
+
+	assert.Equal(t, 1, recorder.count("nacked:"+testSubscription+":"+hName))
+	assert.Equal(
+		t, 1,
+		recorder.count("decode_failed:"+testSubscription+":"+hName+":"+ship.DecodeReasonUnregistered),
+	)
+}
+
+func TestPubSub_SubscribeRaw(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	received := make(chan *ship.RawMessage, 1)
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{
+		Data:        []byte("some-data"),
+		Attributes:  map[string]string{ship.IDKey: "some-id", "some-key": "some-value"},
+		OrderingKey: "some-ordering-key",
+	})
+	assert.NoError(t, err)
+
+	select {
+	case m := <-received:
+		assert.Equal(t, "some-id", m.ID)
+		assert.Equal(t, []byte("some-data"), m.Data)
+		assert.Equal(t, "some-ordering-key", m.OrderingKey)
+		assert.Equal(t, map[string]string{
+			ship.IDKey: "some-id",
+			"some-key": "some-value",
+		}, m.Attributes)
+		assert.WithinDuration(t, time.Now(), m.PublishTime, time.Minute)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+}
+
+func TestPubSub_SubscribeNoRequeue(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(
+		t, WithMetrics(recorder), WithDeadLetterExchange("some-dead-letter-exchange"),
+	)
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			return fmt.Errorf("invalid payment: %w", ErrNoRequeue)
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+
+	hName := "rawHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("dead_lettered:"+testSubscription+":"+hName) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+
+	ready, rejected := suite.broker.size(testSubscription)
+	assert.Equal(t, 0, ready)
+	assert.Equal(t, 1, rejected)
+	assert.Equal(t, 1, recorder.count("nacked:"+testSubscription+":"+hName))
+	assert.Equal(
+		t, "some-dead-letter-exchange",
+		suite.broker.queues[testSubscription].args["x-dead-letter-exchange"],
+	)
+}
+
+func TestPubSub_SubscribeMaxAttempts(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(
+		t,
+		WithMetrics(recorder),
+		WithDeadLetterExchange("some-dead-letter-exchange"),
+		WithRetryBackoff(backoff, time.Second),
+		WithMaxAttempts(3),
+	)
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		attempts []time.Time
+	)
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			mu.Lock()
+			attempts = append(attempts, time.Now())
+			mu.Unlock()
+			return errors.New("some error")
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{
+		Data:       []byte("some-data"),
+		Attributes: map[string]string{ship.IDKey: "some-id"},
+	})
+	assert.NoError(t, err)
+
+	hName := "rawHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("dead_lettered:"+testSubscription+":"+hName) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+
+	ready, rejected := suite.broker.size(testSubscription)
+	assert.Equal(t, 0, ready)
+	assert.Equal(t, 1, rejected)
+	assert.Equal(t, 3, recorder.count("nacked:"+testSubscription+":"+hName))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The delay before a requeue doubles after every attempt.
+	if assert.Len(t, attempts, 3) {
+		assert.GreaterOrEqual(t, int64(attempts[1].Sub(attempts[0])), int64(backoff))
+		assert.GreaterOrEqual(t, int64(attempts[2].Sub(attempts[1])), int64(2*backoff))
+	}
+}
+
+func TestPubSub_SubscribeRequeueDelay(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(
+		t, WithMetrics(recorder), WithRetryBackoff(time.Minute, time.Minute),
+	)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			if string(m.Data) == "some-data" {
+				return errors.New("some error")
+			}
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	for _, data := range []string{"some-data", "some-other-data"} {
+		err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte(data)})
+		assert.NoError(t, err)
+	}
+
+	// The next message is processed while the failed one waits to be
+	// requeued.
+	hName := "rawHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("acked:"+testSubscription+":"+hName) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, recorder.count("nacked:"+testSubscription+":"+hName))
+
+	// The failed message is requeued by the server on Stop.
+	suite.Teardown(t)
+
+	ready, rejected := suite.broker.size(testSubscription)
+	assert.Equal(t, 1, ready)
+	assert.Equal(t, 0, rejected)
+}
+
+func TestConsumer_attempt(t *testing.T) {
+	c := &consumer{
+		p:        &PubSub{maxBackoff: time.Second},
+		attempts: make(map[string]*attempts),
+	}
+
+	delivery := func(id string, headers amqp.Table) *amqp.Delivery {
+		return &amqp.Delivery{MessageId: id, Headers: headers}
+	}
+
+	// The server counts the deliveries of quorum queues.
+	assert.Equal(t, 3, c.attempt(delivery("some-id", amqp.Table{deliveryCountHeader: int64(2)})))
+	assert.Empty(t, c.attempts)
+
+	// Messages without id are not counted.
+	assert.Equal(t, 1, c.attempt(delivery("", nil)))
+	assert.Equal(t, 1, c.attempt(delivery("", nil)))
+	assert.Empty(t, c.attempts)
+
+	assert.Equal(t, 1, c.attempt(delivery("some-id", nil)))
+	assert.Equal(t, 2, c.attempt(delivery("some-id", nil)))
+
+	// The expired attempts are forgotten.
+	c.attempts["some-id"].expires = time.Now().Add(-time.Second)
+	c.pruned = time.Now().Add(-attemptsExpiry)
+
+	assert.Equal(t, 1, c.attempt(delivery("some-other-id", nil)))
+	assert.NotContains(t, c.attempts, "some-id")
+	assert.Equal(t, 1, c.attempt(delivery("some-id", nil)))
+}
+
+func TestPubSub_SubscribeChannelClosed(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	ch := suite.broker.consumerChannel()
+	if !assert.NotNil(t, ch) {
+		return
+	}
+	assert.NoError(t, ch.close(&amqp.Error{Code: amqp.InternalError, Reason: "some reason"}))
+
+	assert.Eventually(t, func() bool {
+		h := suite.client.Health()
+		return len(h.Subscriptions) == 1 &&
+			h.Subscriptions[0].State == ship.SubscriptionErrored
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Contains(t, suite.client.Health().Subscriptions[0].Error, "some reason")
+}
+
+func TestPubSub_SubscribeBindingKeys(t *testing.T) {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&userCreated{}))
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	suite := newSubscriptionSuite(
+		t,
+		WithRegistry(registry),
+		WithSubscription(testSubscription, testTopic, "UserCreated"),
+	)
+	defer suite.Teardown(t)
+
+	received := make(chan *ship.Message, 2)
+	err := suite.client.Subscribe(
+		testSubscription,
+		ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	assert.NoError(t, suite.client.Publish(testTopic, &ship.Message{
+		ID: "some-id", Data: &testEvent{Name: "ship"},
+	}))
+	assert.NoError(t, suite.client.Publish(testTopic, &ship.Message{
+		ID: "some-other-id", Data: &userCreated{Email: "someone@flahmingo.com"},
+	}))
+
+	select {
+	case m := <-received:
+		assert.Equal(t, "some-other-id", m.ID)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+	assert.Empty(t, received)
+}
+
+func TestPubSub_SubscribePanic(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			panic("some panic")
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		h := suite.client.Health()
+		return len(h.Subscriptions) == 1 &&
+			h.Subscriptions[0].State == ship.SubscriptionPanicked
+	}, 10*time.Second, 10*time.Millisecond)
+
+	h := suite.client.Health().Subscriptions[0]
+	assert.Equal(t, testSubscription, h.Subscription)
+	assert.Equal(t, "some panic", h.Error)
+
+	// The message is requeued, so it is not lost.
+	assert.Eventually(t, func() bool {
+		ready, _ := suite.broker.size(testSubscription)
+		return ready == 1
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_SubscribeErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *suite)
+		err   string
+	}{
+		{
+			name: "should return error: subscription does not exists",
+			err:  "subscription some-subscription does not exists",
+		},
+		{
+			name: "should return error: exchange does not exists",
+			opts: []Option{WithCreateSubscription(true)},
+			err:  "unable to create some-subscription subscription",
+		},
+		{
+			name: "should return error: pubsub is stopped",
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.Stop())
+			},
+			err: ErrStopped.Error(),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+
+			if tc.setup != nil {
+				tc.setup(t, suite)
+			}
+			defer func() { _ = suite.client.Stop() }()
+
+			err := suite.client.Subscribe(
+				testSubscription,
+				ship.MessageHandlerFunc(func(context.Context, *ship.Message) error {
+					return nil
+				}),
+			)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
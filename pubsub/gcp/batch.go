@@ -0,0 +1,354 @@
+package gcp
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Default batch settings.
+const (
+	DefaultBatchSize = 100
+	DefaultBatchWait = time.Second
+)
+
+// BatchOption is an option setter used to configure a batch subscription.
+type BatchOption func(*batchConfig)
+
+// batchConfig is the configuration of a batch subscription.
+type batchConfig struct {
+	size int
+	wait time.Duration
+}
+
+// WithBatchSize changes the maximum number of messages in a batch.
+// Default size is DefaultBatchSize.
+func WithBatchSize(size int) BatchOption {
+	return func(c *batchConfig) {
+		if size > 0 {
+			c.size = size
+		}
+	}
+}
+
+// WithBatchWait changes the maximum time to wait for a batch to fill up,
+// starting from its first message. Default wait is DefaultBatchWait.
+func WithBatchWait(wait time.Duration) BatchOption {
+	return func(c *batchConfig) {
+		if wait > 0 {
+			c.wait = wait
+		}
+	}
+}
+
+// batchItem is a received message waiting for its batch to be processed.
+type batchItem struct {
+	msg  *pubsub.Message
+	done chan struct{}
+}
+
+// SubscribeBatch subscribes a batch handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// Messages are decoded as in Subscribe and passed to the handler once the
+// batch is full or its wait time elapsed. Messages which could not be decoded
+// are left out of the batch and acknowledged, or nacked when the failure is
+// temporary.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+//
+// Example:
+//
+//	pubsub.SubscribeBatch(
+//		"some-subscription-name", handler,
+//		gcp.WithBatchSize(500), gcp.WithBatchWait(5*time.Second),
+//	)
+func (p *PubSub) SubscribeBatch(
+	subscription string, handler ship.BatchHandler, opts ...BatchOption,
+) error {
+	cfg := batchConfig{size: DefaultBatchSize, wait: DefaultBatchWait}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+
+	sub, err := p.subInit(subscription)
+	if err != nil {
+		p.lifecycle.Release()
+		return errors.WithStack(err)
+	}
+
+	// Every message of a batch is outstanding until the batch is processed.
+	sub.ReceiveSettings.MaxOutstandingMessages = cfg.size
+
+	health := p.health.Track(sub.ID(), transport.HandlerName(handler))
+
+	p.logger.Info(
+		"starting batch listener for subscription",
+		zap.String("subscription", subscription),
+		zap.Int("batchSize", cfg.size),
+	)
+	go p.handleBatch(handler, sub, health, cfg)
+
+	return nil
+}
+
+// handleBatch takes a batch handler and a subscription.
+func (p *PubSub) handleBatch(
+	h ship.BatchHandler,
+	sub *pubsub.Subscription,
+	health *transport.SubscriptionHealth,
+	cfg batchConfig,
+) {
+	defer p.lifecycle.Release()
+
+	hName := health.Get().Handler
+
+	p.logger.Debug(
+		"subscription started",
+		zap.String("subscription", sub.String()),
+		zap.String("handlerName", hName),
+	)
+
+	subID := sub.ID()
+	maxAttempts := p.maxDeliveryAttempts(sub)
+
+	// Creating a context with cancel so, we can cancel the subscription in case
+	// of panic.
+	ctx, cancel := context.WithCancel(p.receiveCtx)
+
+	items := make(chan batchItem)
+	batched := make(chan struct{})
+	go func() {
+		defer close(batched)
+
+		b := &batcher{
+			p:           p,
+			h:           h,
+			subID:       subID,
+			hName:       hName,
+			maxAttempts: maxAttempts,
+			health:      health,
+			cancel:      cancel,
+		}
+		b.run(ctx, items, cfg)
+	}()
+
+	// The callbacks wait for their batch to be processed, so the messages are
+	// acknowledged before Receive returns.
+	err := sub.Receive(ctx, func(_ context.Context, msg *pubsub.Message) {
+		health.SetLastMessageAt(time.Now())
+		defer p.inFlight.add(msg, subID, hName)()
+
+		item := batchItem{msg: msg, done: make(chan struct{})}
+		items <- item
+		<-item.done
+	})
+	close(items)
+	<-batched
+
+	health.Stopped(err)
+	if err != nil {
+		p.logger.Error("unable to receive messages from subscription", zap.Error(err))
+		transport.ReportError(p.errCh, err)
+		return
+	}
+}
+
+// batcher groups received messages into batches and passes them to a batch
+// handler.
+type batcher struct {
+	p           *PubSub
+	h           ship.BatchHandler
+	subID       string
+	hName       string
+	maxAttempts int
+	health      *transport.SubscriptionHealth
+	cancel      context.CancelFunc
+}
+
+// run collects the items into batches until items is closed.
+//
+// A batch is processed once it is full or its wait time elapsed. Once ctx is
+// done, the pending messages are processed right away.
+func (b *batcher) run(ctx context.Context, items <-chan batchItem, cfg batchConfig) {
+	batch := make([]batchItem, 0, cfg.size)
+
+	var (
+		timer   *time.Timer
+		timeout <-chan time.Time
+		done    = ctx.Done()
+	)
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		b.process(batch)
+		batch = make([]batchItem, 0, cfg.size)
+	}
+
+	for {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch = append(batch, item)
+			if timer == nil {
+				timer = time.NewTimer(cfg.wait)
+				timeout = timer.C
+			}
+
+			if len(batch) >= cfg.size || ctx.Err() != nil {
+				flush()
+			}
+		case <-timeout:
+			flush()
+		case <-done:
+			// Stop waiting for the batches to fill up.
+			done = nil
+			flush()
+		}
+	}
+}
+
+// process decodes a batch, passes it to the handler and acknowledges every
+// message based on its result.
+func (b *batcher) process(batch []batchItem) {
+	p := b.p
+
+	defer func() {
+		for _, item := range batch {
+			close(item.done)
+		}
+	}()
+
+	pending := make([]*pubsub.Message, 0, len(batch))
+	messages := make([]*ship.Message, 0, len(batch))
+	links := make([]trace.Link, 0, len(batch))
+
+	// next is the first message of the batch which is neither acknowledged
+	// nor pending yet.
+	next := 0
+
+	// We don't want an unexpected error in consumer to take down whole application.
+	// We'll try to recover from the panic and remove the subscription from listening.
+	defer func() {
+		if r := recover(); r != nil {
+			undecided := batch[next:]
+			b.health.Recovered(
+				p.logger, r,
+				"Nacking the received batch and removing the subscription from listening.",
+				zap.Int("batchSize", len(pending)+len(undecided)),
+			)
+			for _, msg := range pending {
+				p.nack(msg, b.subID, b.hName, b.maxAttempts)
+			}
+			// The messages left when the panic occurred while decoding.
+			for _, item := range undecided {
+				p.nack(item.msg, b.subID, b.hName, b.maxAttempts)
+			}
+
+			// Cancel the context will remove stop the subscription from receiving messages.
+			b.cancel()
+		}
+	}()
+
+	for ; next < len(batch); next++ {
+		item := batch[next]
+
+		m, link, err := b.decode(item.msg)
+		if m == nil {
+			if err != nil {
+				p.nack(item.msg, b.subID, b.hName, b.maxAttempts)
+				continue
+			}
+
+			p.ack(item.msg, b.subID, b.hName)
+			continue
+		}
+
+		pending = append(pending, item.msg)
+		messages = append(messages, m)
+		links = append(links, link)
+	}
+
+	if len(messages) == 0 {
+		return
+	}
+
+	p.logger.Debug(
+		"sending batch to the handler",
+		zap.Int("batchSize", len(messages)),
+		zap.String("handlerName", b.hName),
+	)
+
+	// The handlers are given their own context, so they can finish
+	// processing when the subscription is stopped.
+	// The handle span is linked to the receive span of every message, as they
+	// belong to different traces.
+	ctx, span := p.tracer.StartHandle(p.handlerCtx, b.hName, "", links...)
+	start := time.Now()
+	errs := b.h.HandleBatch(ctx, messages)
+	d := time.Since(start)
+
+	var batchErr error
+	if len(errs) != len(messages) {
+		batchErr = errors.Errorf(
+			"handler returned %d results for a batch of %d messages", len(errs), len(messages),
+		)
+		p.logger.Error("[BUG]: nacking the whole batch", zap.Error(batchErr))
+	}
+	tracing.End(span, batchErr)
+
+	for i, msg := range pending {
+		p.metrics.Handled(b.subID, b.hName, d)
+
+		if batchErr != nil || errs[i] != nil {
+			if batchErr == nil {
+				p.logger.Error(
+					"handler could not process message",
+					zap.Error(errs[i]),
+					zap.String(logIDKey, msg.ID),
+				)
+			}
+			p.nack(msg, b.subID, b.hName, b.maxAttempts)
+			continue
+		}
+
+		p.ack(msg, b.subID, b.hName)
+	}
+}
+
+// decode decodes a received message of the batch, it returns a nil message
+// when the message must not be passed to the handler, see
+// transport.Processor.Decode. The returned link refers to the receive span of
+// the message.
+func (b *batcher) decode(msg *pubsub.Message) (*ship.Message, trace.Link, error) {
+	p := b.p
+
+	ctx, span := p.tracer.StartReceive(p.handlerCtx, b.subID, msg.ID, msg.Attributes)
+	defer span.End()
+
+	m, err := p.processor.Decode(ctx, b.subID, b.hName, rawMessage(msg))
+	return m, trace.Link{SpanContext: span.SpanContext()}, err
+}
@@ -0,0 +1,273 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// panicEvent is an event whose decoding panics.
+type panicEvent struct{}
+
+func (*panicEvent) EventName() string { return "PanicEvent" }
+
+func (*panicEvent) UnmarshalJSON([]byte) error { panic("some panic") }
+
+// batchMessage returns a received message of the event.
+func batchMessage(t *testing.T, id string, event ship.Event) *pubsub.Message {
+	t.Helper()
+
+	raw, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{ID: id, Data: event})
+	assert.NoError(t, err)
+
+	return &pubsub.Message{ID: id, Data: raw.Data, Attributes: raw.Attributes}
+}
+
+// newTestBatcher returns a batcher of the client passing the batches to h.
+func newTestBatcher(client *PubSub, h ship.BatchHandler) *batcher {
+	return &batcher{
+		p:      client,
+		h:      h,
+		subID:  "some-subscription",
+		hName:  "handler",
+		health: client.health.Track("some-subscription", "handler"),
+		cancel: func() {},
+	}
+}
+
+// batchItems returns the items of the messages.
+func batchItems(messages ...*pubsub.Message) []batchItem {
+	items := make([]batchItem, len(messages))
+	for i, msg := range messages {
+		items[i] = batchItem{msg: msg, done: make(chan struct{})}
+	}
+	return items
+}
+
+func TestPubSub_SubscribeBatch(t *testing.T) {
+	testCases := []struct {
+		name string
+		// results returns the results of a batch, calls is the number of
+		// batches handled before.
+		results func(calls int, messages []*ship.Message) []error
+		acked   int
+		// checkNacked checks the number of nacked messages.
+		checkNacked func(t *testing.T, nacked int)
+	}{
+		{
+			name: "should ack and nack every message of a batch",
+			results: func(calls int, messages []*ship.Message) []error {
+				errs := make([]error, len(messages))
+				for i, m := range messages {
+					if m.Data.(*testEvent).Name == "fail" && calls == 0 {
+						errs[i] = errors.New("some error")
+					}
+				}
+				return errs
+			},
+			// 4 decoded messages, the failed one redelivered and the one which
+			// could not be decoded.
+			acked: 5,
+			checkNacked: func(t *testing.T, nacked int) {
+				assert.Equal(t, 1, nacked)
+			},
+		},
+		{
+			name: "should nack the whole batch on invalid results",
+			results: func(calls int, messages []*ship.Message) []error {
+				if calls == 0 {
+					return nil
+				}
+				return make([]error, len(messages))
+			},
+			acked: 5,
+			checkNacked: func(t *testing.T, nacked int) {
+				// The decoded messages of the first batch.
+				assert.GreaterOrEqual(t, nacked, 1)
+				assert.LessOrEqual(t, nacked, 3)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			registry := ship.NewRegistry()
+			assert.NoError(t, registry.Register(&testEvent{}))
+
+			recorder := newTestRecorder()
+			suite := newTestSuite(t, WithRegistry(registry), WithMetrics(recorder))
+			defer suite.Teardown(t)
+
+			ctx := context.Background()
+			topic, err := suite.client.client.CreateTopic(ctx, "some-topic")
+			assert.NoError(t, err)
+
+			_, err = suite.client.client.CreateSubscription(
+				ctx, "some-subscription", pubsub.SubscriptionConfig{Topic: topic},
+			)
+			assert.NoError(t, err)
+
+			var (
+				mu      sync.Mutex
+				calls   int
+				maxSize int
+			)
+			handler := ship.BatchHandlerFunc(
+				func(ctx context.Context, messages []*ship.Message) []error {
+					mu.Lock()
+					defer mu.Unlock()
+
+					if len(messages) > maxSize {
+						maxSize = len(messages)
+					}
+					errs := tc.results(calls, messages)
+					calls++
+					return errs
+				},
+			)
+
+			err = suite.client.SubscribeBatch(
+				"some-subscription", handler,
+				WithBatchSize(3), WithBatchWait(100*time.Millisecond),
+			)
+			assert.NoError(t, err)
+
+			for _, name := range []string{"a", "b", "fail", "c"} {
+				err = suite.client.Publish("some-topic", &ship.Message{
+					ID:   name,
+					Type: "TestEvent",
+					Data: &testEvent{Name: name},
+				})
+				assert.NoError(t, err)
+			}
+			err = suite.client.Publish("some-topic", &ship.Message{
+				ID:   "unknown",
+				Type: "UnknownEvent",
+				Data: &testEvent{Name: "unknown"},
+			})
+			assert.NoError(t, err)
+
+			assert.Eventually(t, func() bool {
+				return recorder.count("acked:some-subscription:BatchHandlerFunc") == tc.acked
+			}, 10*time.Second, 10*time.Millisecond)
+
+			tc.checkNacked(t, recorder.count("nacked:some-subscription:BatchHandlerFunc"))
+			assert.Equal(
+				t, 1,
+				recorder.count(
+					"decode_failed:some-subscription:BatchHandlerFunc:"+ship.DecodeReasonUnregistered,
+				),
+			)
+
+			mu.Lock()
+			assert.LessOrEqual(t, maxSize, 3)
+			mu.Unlock()
+		})
+	}
+}
+
+func TestBatcher_processPanic(t *testing.T) {
+	testCases := []struct {
+		name    string
+		event   ship.Event
+		handler ship.BatchHandler
+	}{
+		{
+			name:  "should nack the batch: decoding panicked",
+			event: &panicEvent{},
+			handler: ship.BatchHandlerFunc(func(context.Context, []*ship.Message) []error {
+				return nil
+			}),
+		},
+		{
+			name:  "should nack the batch: handler panicked",
+			event: &testEvent{Name: "b"},
+			handler: ship.BatchHandlerFunc(func(context.Context, []*ship.Message) []error {
+				panic("some panic")
+			}),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			registry := ship.NewRegistry()
+			assert.NoError(t, registry.Register(&testEvent{}))
+			assert.NoError(t, registry.Register(&panicEvent{}))
+
+			recorder := newTestRecorder()
+			suite := newTestSuite(t, WithRegistry(registry), WithMetrics(recorder))
+			defer suite.Teardown(t)
+
+			b := newTestBatcher(suite.client, tc.handler)
+			batch := batchItems(
+				batchMessage(t, "a", &testEvent{Name: "a"}),
+				batchMessage(t, "b", tc.event),
+				batchMessage(t, "c", &testEvent{Name: "c"}),
+			)
+			b.process(batch)
+
+			// Every message is nacked, including the ones left when decoding
+			// panicked.
+			assert.Equal(t, 3, recorder.count("nacked:some-subscription:handler"))
+			assert.Equal(t, 0, recorder.count("acked:some-subscription:handler"))
+			assert.Equal(t, ship.SubscriptionPanicked, b.health.Get().State)
+			for _, item := range batch {
+				<-item.done
+			}
+		})
+	}
+}
+
+func TestBatcher_processTracing(t *testing.T) {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	suite := newTestSuite(t, WithRegistry(registry), WithTracerProvider(tp))
+	defer suite.Teardown(t)
+
+	b := newTestBatcher(
+		suite.client,
+		ship.BatchHandlerFunc(func(_ context.Context, messages []*ship.Message) []error {
+			return make([]error, len(messages))
+		}),
+	)
+	b.process(batchItems(
+		batchMessage(t, "a", &testEvent{Name: "a"}),
+		batchMessage(t, "b", &testEvent{Name: "b"}),
+	))
+
+	var (
+		received []trace.SpanContext
+		links    []trace.SpanContext
+	)
+	for _, span := range spans.Ended() {
+		switch span.Name() {
+		case "some-subscription receive":
+			received = append(received, span.SpanContext())
+		case "handle":
+			for _, link := range span.Links() {
+				links = append(links, link.SpanContext)
+			}
+		}
+	}
+
+	// The handle span is linked to the receive span of every message.
+	assert.Len(t, received, 2)
+	assert.Equal(t, received, links)
+}
@@ -7,15 +7,42 @@ import (
 	"sync"
 
 	"cloud.google.com/go/pubsub"
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/debezium"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc"
 )
 
+// Compile time check.
+var _ ship.HealthChecker = (*PubSub)(nil)
+
 // Option is an option setter used to configure creation.
 type Option func(*PubSub) error
 
+// shared wraps an option shared by the transports.
+func shared(opt transport.Option) Option {
+	return func(p *PubSub) error {
+		return opt(transport.Settings{
+			Name:     "gcp-pubsub",
+			Pipeline: p.pipeline,
+			Tracing:  &p.tracingOpts,
+			Metrics:  &p.metrics,
+			Logger:   &p.logger,
+		})
+	}
+}
+
 // WithCreateTopic toggle topic creation if it does not exists.
 func WithCreateTopic(create bool) Option {
 	return func(p *PubSub) error {
@@ -42,10 +69,7 @@ func WithEndpoint(endpoint string) Option {
 
 // WithLogger attaches a zap logger.
 func WithLogger(logger *zap.Logger) Option {
-	return func(p *PubSub) error {
-		p.logger = logger.Named("gcp-pubsub")
-		return nil
-	}
+	return shared(transport.WithLogger(logger))
 }
 
 // WithGRPCConn uses the provided connection instead of the default one.
@@ -57,6 +81,60 @@ func WithGRPCConn(conn *grpc.ClientConn) Option {
 	}
 }
 
+// WithRegistry uses the provided event registry instead of the
+// ship.DefaultRegistry.
+func WithRegistry(registry *ship.Registry) Option {
+	return shared(transport.WithRegistry(registry))
+}
+
+// WithDebeziumColumns changes the columns of the Debezium outbox table, see
+// pipeline.Pipeline.SetDebeziumColumns.
+func WithDebeziumColumns(columns debezium.Columns) Option {
+	return shared(transport.WithDebeziumColumns(columns))
+}
+
+// WithCodec changes the codec of the published messages, see
+// pipeline.Pipeline.SetCodec.
+func WithCodec(codec ship.Codec) Option {
+	return shared(transport.WithCodec(codec))
+}
+
+// WithCompression compresses the published messages, see
+// pipeline.Pipeline.SetCompression.
+func WithCompression(c compress.Compressor, threshold int) Option {
+	return shared(transport.WithCompression(c, threshold))
+}
+
+// WithClaimCheck checks the oversized published messages in to the store,
+// see pipeline.Pipeline.SetClaimCheck.
+func WithClaimCheck(store claimcheck.Store, threshold int) Option {
+	return shared(transport.WithClaimCheck(store, threshold))
+}
+
+// WithEncryption encrypts the published messages, see
+// pipeline.Pipeline.SetEncryption.
+func WithEncryption(kp encryption.KeyProvider, keyID string) Option {
+	return shared(transport.WithEncryption(kp, keyID))
+}
+
+// WithTracerProvider changes the tracer provider, see
+// tracing.WithTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return shared(transport.WithTracerProvider(tp))
+}
+
+// WithPropagator changes the trace context propagator, see
+// tracing.WithPropagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return shared(transport.WithPropagator(propagator))
+}
+
+// WithMetrics reports the metrics of the client to the recorder, see
+// metrics.Recorder. Default recorder is metrics.Nop.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return shared(transport.WithMetrics(recorder))
+}
+
 // PubSub is a wrapper over GCP PubSub.
 type PubSub struct {
 	projectID   string
@@ -69,13 +147,39 @@ type PubSub struct {
 	createTopic bool
 	createSub   bool
 	logger      *zap.Logger
-	wg          sync.WaitGroup
 	errCh       chan error
 	conn        *grpc.ClientConn
+	pipeline    *pipeline.Pipeline
+	processor   *transport.Processor
+
+	tracer      *tracing.Tracer
+	tracingOpts []tracing.Option
+
+	metrics metrics.Recorder
+
+	health transport.Health
+
+	// receiveCtx is cancelled to stop receiving messages.
+	receiveCtx    context.Context
+	receiveCancel context.CancelFunc
+	// handlerCtx is passed to the handlers, it outlives receiveCtx so the
+	// in-flight handlers can finish.
+	handlerCtx    context.Context
+	handlerCancel context.CancelFunc
+	inFlight      inFlight
+
+	// lifecycle rejects new subscriptions once Stop or Shutdown is called.
+	lifecycle transport.Lifecycle
 }
 
 const errorBufferLimit = 10
 
+// tracingSystem is the messaging system reported in spans.
+const tracingSystem = "gcp_pubsub"
+
+// logIDKey is the log field of the message ids.
+const logIDKey = "pubsubMessageId"
+
 // NewClient creates an instance of GCP PubSub.
 // All methods are thread-safe until mentioned specifically.
 func NewClient(
@@ -86,6 +190,8 @@ func NewClient(
 		logger:    zap.NewNop(),
 		topics:    make(map[string]*pubsub.Topic),
 		errCh:     make(chan error, errorBufferLimit),
+		pipeline:  pipeline.New(),
+		metrics:   metrics.Nop{},
 	}
 
 	// Apply configuration options.
@@ -98,10 +204,21 @@ func NewClient(
 		}
 	}
 
+	p.tracer = tracing.New(tracingSystem, p.tracingOpts...)
+	p.processor = &transport.Processor{
+		Pipeline: p.pipeline,
+		Tracer:   p.tracer,
+		Metrics:  p.metrics,
+		Logger:   p.logger,
+		IDKey:    logIDKey,
+	}
+
 	// Create a cancelable context.
 	ctx, cancel := context.WithCancel(context.Background())
 	p.ctx = ctx
 	p.cancelFn = cancel
+	p.receiveCtx, p.receiveCancel = context.WithCancel(ctx)
+	p.handlerCtx, p.handlerCancel = context.WithCancel(ctx)
 
 	pubsubOpts := []option.ClientOption{}
 	if p.endpoint != "" {
@@ -127,21 +244,21 @@ func NewClient(
 }
 
 // Stop stops the pubsub gracefully.
+//
+// The context passed to the in-flight handlers is cancelled right away, then
+// it waits for them to return and flushes the pending published messages. Use
+// Shutdown to let the in-flight handlers finish.
+//
+// Stop is not idempotent: it returns ErrStopped if the pubsub is already
+// stopped, by Stop or Shutdown.
 func (p *PubSub) Stop() error {
-	p.topicsMu.Lock()
-	for _, topic := range p.topics {
-		if topic != nil {
-			p.logger.Debug("closing topic", zap.String("topic", topic.String()))
-			topic.Stop()
-		}
-	}
-	p.topicsMu.Unlock()
+	p.logger.Debug("cancelling handlers context")
+	p.handlerCancel()
 
-	p.logger.Debug("cancelling context")
-	p.cancelFn()
-
-	p.logger.Info("waiting for subscription to finish")
-	p.wg.Wait()
+	return p.Shutdown(context.Background())
+}
 
-	return p.client.Close()
+// Health returns the health of the client and of every subscription.
+func (p *PubSub) Health() ship.Health {
+	return p.health.Get(p.lifecycle.Stopped())
 }
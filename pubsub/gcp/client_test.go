@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"cloud.google.com/go/pubsub/pstest"
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/debezium"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
@@ -36,6 +38,34 @@ func TestNewClient(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "should return error: registry cannot be nil",
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "registry cannot be nil")
+
+				assert.Nil(t, ps)
+			},
+			configureOpts: func(t *testing.T, s *pstest.Server) []Option {
+				return []Option{
+					WithRegistry(nil),
+				}
+			},
+		},
+		{
+			name: "should return error: invalid debezium columns",
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "type column cannot be empty")
+
+				assert.Nil(t, ps)
+			},
+			configureOpts: func(t *testing.T, s *pstest.Server) []Option {
+				return []Option{
+					WithDebeziumColumns(debezium.Columns{Data: "payload"}),
+				}
+			},
+		},
 		{
 			name: "should return a new client",
 			checkReturns: func(t *testing.T, ps *PubSub, err error) {
@@ -53,6 +83,7 @@ func TestNewClient(t *testing.T) {
 					WithCreateTopic(true),
 					nil,
 					WithLogger(zap.NewNop()),
+					WithRegistry(ship.NewRegistry()),
 				}
 			},
 		},
@@ -159,14 +190,14 @@ func (s *suite) Teardown(t *testing.T) {
 }
 
 // newTestSuite returns a test suite for easier testing.
-func newTestSuite(t *testing.T) *suite {
+func newTestSuite(t *testing.T, opts ...Option) *suite {
 	t.Helper()
 
 	server := pstest.NewServer()
 	conn, err := grpc.Dial(server.Addr, grpc.WithInsecure())
 	assert.NoError(t, err)
 
-	client, err := NewClient("some-id", WithGRPCConn(conn))
+	client, err := NewClient("some-id", append([]Option{WithGRPCConn(conn)}, opts...)...)
 	assert.NoError(t, err)
 
 	return &suite{
@@ -0,0 +1,419 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// DefaultWorkers is the default number of workers of an ordered subscription.
+const DefaultWorkers = 10
+
+// OrderedOption is an option setter used to configure an ordered
+// subscription.
+type OrderedOption func(*orderedConfig)
+
+// orderedConfig is the configuration of an ordered subscription.
+type orderedConfig struct {
+	workers int
+}
+
+// WithWorkers changes the maximum number of messages processed in parallel.
+// Default is DefaultWorkers.
+func WithWorkers(workers int) OrderedOption {
+	return func(c *orderedConfig) {
+		if workers > 0 {
+			c.workers = workers
+		}
+	}
+}
+
+// SubscribeOrdered subscribes a handler to a given subscription, processing
+// the messages of a key sequentially and different keys in parallel.
+// It stops receiving message in case of, panics.
+//
+// The subscription must have message ordering enabled: Pub/Sub then delivers
+// the messages of an ordering key one at a time, in order. Messages published
+// by Publish are ordered by their aggregate id. Messages without an ordering
+// key are processed sequentially per aggregate id, in the order they are
+// received, and in parallel when they have no aggregate id either.
+//
+// When the handler fails to process a message, its key is paused: the
+// following messages of the key are nacked without being processed, until the
+// failed message is redelivered and processed successfully, or dead-lettered.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+//
+// Example:
+//
+//	pubsub.SubscribeOrdered("some-subscription-name", handler, gcp.WithWorkers(20))
+func (p *PubSub) SubscribeOrdered(
+	subscription string, handler ship.MessageHandler, opts ...OrderedOption,
+) error {
+	cfg := orderedConfig{workers: DefaultWorkers}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+
+	sub, err := p.subInit(subscription)
+	if err != nil {
+		p.lifecycle.Release()
+		return errors.WithStack(err)
+	}
+
+	if err := p.checkOrdering(sub); err != nil {
+		p.lifecycle.Release()
+		return err
+	}
+
+	health := p.health.Track(sub.ID(), transport.HandlerName(handler))
+
+	p.logger.Info(
+		"starting ordered listener for subscription",
+		zap.String("subscription", subscription),
+		zap.Int("workers", cfg.workers),
+	)
+	go p.handleOrdered(handler, sub, health, cfg)
+
+	return nil
+}
+
+// checkOrdering returns an error if the subscription does not have message
+// ordering enabled.
+//
+// As the Pub/Sub client does, ordering is assumed to be enabled if the config
+// of the subscription cannot be read.
+func (p *PubSub) checkOrdering(sub *pubsub.Subscription) error {
+	cfg, err := sub.Config(p.ctx)
+	if err != nil {
+		p.logger.Warn(
+			"unable to get subscription config: assuming message ordering is enabled",
+			zap.Error(err),
+			zap.String("subscription", sub.String()),
+		)
+		return nil
+	}
+
+	if !cfg.EnableMessageOrdering {
+		return errors.Errorf("subscription %s does not have message ordering enabled", sub.ID())
+	}
+
+	return nil
+}
+
+// handleOrdered takes a message handler and a subscription.
+func (p *PubSub) handleOrdered(
+	h ship.MessageHandler,
+	sub *pubsub.Subscription,
+	health *transport.SubscriptionHealth,
+	cfg orderedConfig,
+) {
+	defer p.lifecycle.Release()
+
+	hName := health.Get().Handler
+
+	p.logger.Debug(
+		"subscription started",
+		zap.String("subscription", sub.String()),
+		zap.String("handlerName", hName),
+	)
+
+	// Creating a context with cancel so, we can cancel the subscription in case
+	// of panic.
+	ctx, cancel := context.WithCancel(p.receiveCtx)
+
+	o := &orderedSubscription{
+		p:           p,
+		h:           h,
+		subID:       sub.ID(),
+		hName:       hName,
+		maxAttempts: p.maxDeliveryAttempts(sub),
+		health:      health,
+		cancel:      cancel,
+		paused:      make(map[string]string),
+	}
+
+	pool := newKeyedPool(cfg.workers)
+	defer pool.close()
+
+	// The callbacks wait for their message to be processed by the pool, so the
+	// messages are acknowledged before Receive returns. Pub/Sub does not call
+	// the callback of a message before the one of the previous message of its
+	// ordering key returns: they are queued in the pool in order.
+	err := sub.Receive(ctx, func(_ context.Context, msg *pubsub.Message) {
+		health.SetLastMessageAt(time.Now())
+		defer p.inFlight.add(msg, o.subID, hName)()
+
+		o.receive(pool, msg)
+	})
+	health.Stopped(err)
+	if err != nil {
+		p.logger.Error("unable to receive messages from subscription", zap.Error(err))
+		transport.ReportError(p.errCh, err)
+		return
+	}
+}
+
+// orderedSubscription processes the messages of a subscription in order of
+// their key.
+type orderedSubscription struct {
+	p           *PubSub
+	h           ship.MessageHandler
+	subID       string
+	hName       string
+	maxAttempts int
+	health      *transport.SubscriptionHealth
+	cancel      context.CancelFunc
+
+	// paused maps a paused key to the id of its failed message.
+	paused   map[string]string
+	pausedMu sync.Mutex
+}
+
+// receive decodes a received message, processes it in the pool and
+// acknowledges it.
+func (o *orderedSubscription) receive(pool *keyedPool, msg *pubsub.Message) {
+	p := o.p
+
+	// The handlers are given their own context, so they can finish
+	// processing when the subscription is stopped.
+	ctx, span := p.tracer.StartReceive(p.handlerCtx, o.subID, msg.ID, msg.Attributes)
+	defer span.End()
+
+	m, err := p.processor.Decode(ctx, o.subID, o.hName, rawMessage(msg))
+	if m == nil {
+		if err != nil {
+			p.nack(msg, o.subID, o.hName, o.maxAttempts)
+			return
+		}
+
+		p.ack(msg, o.subID, o.hName)
+		return
+	}
+
+	// Messages published without an ordering key, e.g. by another publisher,
+	// are still kept in order of their aggregate.
+	key := msg.OrderingKey
+	if key == "" {
+		key = m.AggregateID
+	}
+
+	var ack bool
+	pool.run(key, func() {
+		ack = o.process(ctx, key, msg, m)
+	})
+
+	if !ack {
+		p.nack(msg, o.subID, o.hName, o.maxAttempts)
+		return
+	}
+
+	p.ack(msg, o.subID, o.hName)
+}
+
+// process passes a message to the handler, unless its key is paused by
+// another message. It reports whether the message must be acknowledged.
+func (o *orderedSubscription) process(
+	ctx context.Context, key string, msg *pubsub.Message, m *ship.Message,
+) (ack bool) {
+	p := o.p
+
+	o.pausedMu.Lock()
+	failedID, paused := o.paused[key]
+	o.pausedMu.Unlock()
+
+	if paused && failedID != msg.ID {
+		p.logger.Debug(
+			"key is paused by a failed message: nacking message",
+			zap.String("key", key),
+			zap.String("failedPubsubMessageId", failedID),
+			zap.String(logIDKey, msg.ID),
+		)
+		return false
+	}
+
+	// We don't want an unexpected error in consumer to take down whole application.
+	// We'll try to recover from the panic and remove the subscription from listening.
+	defer func() {
+		if r := recover(); r != nil {
+			o.health.Recovered(
+				p.logger, r,
+				"Nacking the received message and removing the subscription from listening.",
+				zap.String(logIDKey, msg.ID),
+			)
+
+			// Cancel the context will remove stop the subscription from receiving messages.
+			o.cancel()
+			ack = false
+		}
+	}()
+
+	p.logger.Debug(
+		"sending message to the handler",
+		zap.String("eventType", m.Type),
+		zap.String("handlerName", o.hName),
+		zap.String("key", key),
+	)
+	handleCtx, handleSpan := p.tracer.StartHandle(ctx, o.hName, m.Type)
+	start := time.Now()
+	hErr := o.h.HandleMessage(handleCtx, m)
+	p.metrics.Handled(o.subID, o.hName, time.Since(start))
+	tracing.End(handleSpan, hErr)
+
+	if hErr != nil {
+		p.logger.Error("handler could not process message", zap.Error(hErr))
+
+		// A dead-lettered message is not redelivered, it must not pause its key
+		// forever.
+		if !o.isLastAttempt(msg) {
+			o.pause(key, msg.ID)
+		} else {
+			o.resume(key)
+		}
+		return false
+	}
+
+	if paused {
+		o.resume(key)
+	}
+
+	return true
+}
+
+// pause pauses a key until the message is processed successfully.
+//
+// Messages without a key are not paused.
+func (o *orderedSubscription) pause(key, id string) {
+	if key == "" {
+		return
+	}
+
+	o.pausedMu.Lock()
+	o.paused[key] = id
+	o.pausedMu.Unlock()
+}
+
+// resume resumes a paused key.
+func (o *orderedSubscription) resume(key string) {
+	o.pausedMu.Lock()
+	delete(o.paused, key)
+	o.pausedMu.Unlock()
+}
+
+// isLastAttempt reports whether the message is dead-lettered when nacked.
+func (o *orderedSubscription) isLastAttempt(msg *pubsub.Message) bool {
+	return o.maxAttempts > 0 && msg.DeliveryAttempt != nil &&
+		*msg.DeliveryAttempt >= o.maxAttempts
+}
+
+// keyedPool runs functions on a bounded number of workers, sequentially for a
+// given key and in parallel for different keys.
+//
+// Functions with an empty key are not ordered.
+type keyedPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[string][]*keyedTask
+	ready  []string
+	closed bool
+	wg     sync.WaitGroup
+
+	// unordered is used to give a unique key to the functions without a key.
+	unordered uint64
+}
+
+// keyedTask is a function waiting to be run.
+type keyedTask struct {
+	fn   func()
+	done chan struct{}
+}
+
+// newKeyedPool returns a pool running workers goroutines.
+func newKeyedPool(workers int) *keyedPool {
+	pool := &keyedPool{queues: make(map[string][]*keyedTask)}
+	pool.cond = sync.NewCond(&pool.mu)
+
+	pool.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+
+	return pool
+}
+
+// run runs fn after the previous functions of key and waits for it.
+func (k *keyedPool) run(key string, fn func()) {
+	task := &keyedTask{fn: fn, done: make(chan struct{})}
+
+	k.mu.Lock()
+	if key == "" {
+		k.unordered++
+		key = fmt.Sprintf("\x00%d", k.unordered)
+	}
+
+	k.queues[key] = append(k.queues[key], task)
+	if len(k.queues[key]) == 1 {
+		k.ready = append(k.ready, key)
+		k.cond.Signal()
+	}
+	k.mu.Unlock()
+
+	<-task.done
+}
+
+// work runs the first function of the ready keys, until the pool is closed.
+func (k *keyedPool) work() {
+	defer k.wg.Done()
+
+	for {
+		k.mu.Lock()
+		for len(k.ready) == 0 && !k.closed {
+			k.cond.Wait()
+		}
+		if len(k.ready) == 0 {
+			k.mu.Unlock()
+			return
+		}
+
+		key := k.ready[0]
+		k.ready = k.ready[1:]
+		task := k.queues[key][0]
+		k.mu.Unlock()
+
+		task.fn()
+		close(task.done)
+
+		k.mu.Lock()
+		k.queues[key] = k.queues[key][1:]
+		if len(k.queues[key]) == 0 {
+			delete(k.queues, key)
+		} else {
+			k.ready = append(k.ready, key)
+			k.cond.Signal()
+		}
+		k.mu.Unlock()
+	}
+}
+
+// close stops the workers once the queued functions are run.
+func (k *keyedPool) close() {
+	k.mu.Lock()
+	k.closed = true
+	k.cond.Broadcast()
+	k.mu.Unlock()
+
+	k.wg.Wait()
+}
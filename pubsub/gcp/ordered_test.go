@@ -0,0 +1,359 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedPool(t *testing.T) {
+	const workers = 2
+
+	pool := newKeyedPool(workers)
+	defer pool.close()
+
+	var (
+		mu       sync.Mutex
+		running  int
+		maxRun   int
+		perKey   = make(map[string]int)
+		order    = make(map[string][]int)
+		gate     = make(chan struct{})
+		finished sync.WaitGroup
+	)
+
+	task := func(key string, i int) func() {
+		return func() {
+			mu.Lock()
+			running++
+			perKey[key]++
+			if running > maxRun {
+				maxRun = running
+			}
+			assert.Equal(t, 1, perKey[key], "key %s is processed concurrently", key)
+			mu.Unlock()
+
+			if key == "a" && i == 0 {
+				<-gate
+			}
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running--
+			perKey[key]--
+			order[key] = append(order[key], i)
+			mu.Unlock()
+		}
+	}
+
+	queued := func(key string) int {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.queues[key])
+	}
+
+	// Block the first function of key a, then queue the next ones in order.
+	for i := 0; i < 5; i++ {
+		finished.Add(1)
+		go func(i int) {
+			defer finished.Done()
+			pool.run("a", task("a", i))
+		}(i)
+
+		n := i + 1
+		assert.Eventually(t, func() bool { return queued("a") == n }, time.Second, time.Millisecond)
+	}
+
+	for _, key := range []string{"b", "c", "", ""} {
+		for i := 0; i < 5; i++ {
+			finished.Add(1)
+			go func(key string, i int) {
+				defer finished.Done()
+				pool.run(key, task(key, i))
+			}(key, i)
+		}
+	}
+
+	// Other keys are processed while key a is blocked.
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order["b"]) == 5 && len(order["c"]) == 5
+	}, 5*time.Second, time.Millisecond)
+
+	close(gate)
+	finished.Wait()
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order["a"])
+	assert.Len(t, order[""], 10)
+	assert.LessOrEqual(t, maxRun, workers)
+}
+
+func TestPubSub_SubscribeOrdered(t *testing.T) {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	suite := newTestSuite(t, WithRegistry(registry))
+	defer suite.Teardown(t)
+
+	ctx := context.Background()
+	topic, err := suite.client.client.CreateTopic(ctx, "some-topic")
+	assert.NoError(t, err)
+
+	_, err = suite.client.client.CreateSubscription(
+		ctx, "unordered-subscription", pubsub.SubscriptionConfig{Topic: topic},
+	)
+	assert.NoError(t, err)
+
+	_, err = suite.client.client.CreateSubscription(
+		ctx, "some-subscription", pubsub.SubscriptionConfig{
+			Topic:                 topic,
+			EnableMessageOrdering: true,
+		},
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.SubscribeOrdered(
+		"unordered-subscription", ship.MessageHandlerFunc(func(context.Context, *ship.Message) error {
+			return nil
+		}),
+	)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not have message ordering enabled")
+
+	var (
+		mu        sync.Mutex
+		running   = make(map[string]int)
+		succeeded = make(map[string]int)
+	)
+
+	err = suite.client.SubscribeOrdered(
+		"some-subscription",
+		ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+			mu.Lock()
+			running[m.AggregateID]++
+			assert.Equal(t, 1, running[m.AggregateID], "key is processed concurrently")
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			running[m.AggregateID]--
+			succeeded[m.AggregateID]++
+			return nil
+		}),
+		WithWorkers(2),
+	)
+	assert.NoError(t, err)
+
+	for _, id := range []string{"a1", "a2", "b1", "a3", "b2"} {
+		err = suite.client.Publish("some-topic", &ship.Message{
+			ID:          id,
+			Type:        "TestEvent",
+			AggregateID: id[:1],
+			Data:        &testEvent{Name: id},
+		})
+		assert.NoError(t, err)
+	}
+
+	// The fake server does not deliver the messages of an ordering key in
+	// order, the order is checked by TestOrderedSubscription_receive.
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return succeeded["a"] == 3 && succeeded["b"] == 2
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestOrderedSubscription_receive(t *testing.T) {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	suite := newTestSuite(t, WithRegistry(registry))
+	defer suite.Teardown(t)
+
+	var (
+		mu        sync.Mutex
+		failed    bool
+		handled   []string
+		succeeded = make(map[string][]string)
+	)
+
+	o := &orderedSubscription{
+		p: suite.client,
+		h: ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, m.ID)
+
+			if m.ID == "a1" && !failed {
+				failed = true
+				return errors.New("some error")
+			}
+
+			succeeded[m.AggregateID] = append(succeeded[m.AggregateID], m.ID)
+			return nil
+		}),
+		subID:  "some-subscription",
+		hName:  "handler",
+		health: suite.client.health.Track("some-subscription", "handler"),
+		cancel: func() {},
+		paused: make(map[string]string),
+	}
+
+	pool := newKeyedPool(2)
+	defer pool.close()
+
+	message := func(id string) *pubsub.Message {
+		raw, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{
+			ID:          id,
+			Type:        "TestEvent",
+			AggregateID: id[:1],
+			Data:        &testEvent{Name: id},
+		})
+		assert.NoError(t, err)
+
+		return &pubsub.Message{
+			ID:          id,
+			Data:        raw.Data,
+			Attributes:  raw.Attributes,
+			OrderingKey: id[:1],
+		}
+	}
+
+	// Pub/Sub delivers the messages of a key one at a time, a nacked message
+	// is redelivered along with the following messages of its key.
+	deliveries := map[string][]string{
+		"a": {"a1", "a2", "a3", "a1", "a2", "a3"},
+		"b": {"b1", "b2", "b3"},
+	}
+
+	var wg sync.WaitGroup
+	for _, ids := range deliveries {
+		wg.Add(1)
+		go func(ids []string) {
+			defer wg.Done()
+			for _, id := range ids {
+				o.receive(pool, message(id))
+			}
+		}(ids)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The key is paused until the failed message is processed.
+	assert.Equal(t, []string{"a1", "a2", "a3"}, succeeded["a"])
+	assert.Equal(t, []string{"b1", "b2", "b3"}, succeeded["b"])
+	assert.Equal(t, 2, strings.Count(strings.Join(handled, ","), "a1"))
+	assert.Equal(t, 1, strings.Count(strings.Join(handled, ","), "a2"))
+}
+
+func TestOrderedSubscription_receiveWithoutOrderingKey(t *testing.T) {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	suite := newTestSuite(t, WithRegistry(registry))
+	defer suite.Teardown(t)
+
+	var (
+		mu      sync.Mutex
+		running = make(map[string]int)
+		handled = make(map[string][]string)
+		gate    = make(chan struct{})
+	)
+
+	o := &orderedSubscription{
+		p: suite.client,
+		h: ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+			mu.Lock()
+			running[m.AggregateID]++
+			assert.Equal(t, 1, running[m.AggregateID], "aggregate is processed concurrently")
+			mu.Unlock()
+
+			if m.ID == "a1" {
+				<-gate
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			running[m.AggregateID]--
+			handled[m.AggregateID] = append(handled[m.AggregateID], m.ID)
+			return nil
+		}),
+		subID:  "some-subscription",
+		hName:  "handler",
+		health: suite.client.health.Track("some-subscription", "handler"),
+		cancel: func() {},
+		paused: make(map[string]string),
+	}
+
+	pool := newKeyedPool(2)
+	defer pool.close()
+
+	message := func(id string) *pubsub.Message {
+		raw, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{
+			ID:          id,
+			Type:        "TestEvent",
+			AggregateID: id[:1],
+			Data:        &testEvent{Name: id},
+		})
+		assert.NoError(t, err)
+
+		return &pubsub.Message{ID: id, Data: raw.Data, Attributes: raw.Attributes}
+	}
+
+	queued := func(key string) int {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.queues[key])
+	}
+
+	var wg sync.WaitGroup
+	receive := func(id string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.receive(pool, message(id))
+		}()
+	}
+
+	// Block the first message of aggregate a, then receive the next ones in
+	// order.
+	for i, id := range []string{"a1", "a2", "a3"} {
+		receive(id)
+
+		n := i + 1
+		assert.Eventually(t, func() bool { return queued("a") == n }, time.Second, time.Millisecond)
+	}
+
+	// Aggregate b is processed while aggregate a is blocked.
+	for i, id := range []string{"b1", "b2", "b3"} {
+		receive(id)
+
+		n := i + 1
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(handled["b"]) == n
+		}, time.Second, time.Millisecond)
+	}
+
+	close(gate)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, []string{"a1", "a2", "a3"}, handled["a"])
+	assert.Equal(t, []string{"b1", "b2", "b3"}, handled["b"])
+}
@@ -1,11 +1,13 @@
 package gcp
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/tracing"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
@@ -77,62 +79,59 @@ func (p *PubSub) cacheTopic(name string, t *pubsub.Topic) {
 }
 
 // Publish publishes the message to a given topic.
+//
+// The message data is encoded with the configured codec and the message fields
+// are sent as attributes, see ship.MarshalMessage. The messages of an
+// aggregate are published in order, with its id as ordering key.
 func (p *PubSub) Publish(topic string, message *ship.Message) error {
-	p.topicsMu.RLock()
-	t, ok := p.topics[topic]
-	p.topicsMu.RUnlock()
-
-	// if topic is not cached then cache it.
-	//
-	// Why?
-	//
-	// Excerpt from pubsub.Topic documentation.
-	// Avoid creating many Topic instances if you use them to publish.
-	if !ok {
-		p.logger.Debug(
-			fmt.Sprintf("topic (%s) is not cached, caching it", topic),
-			zap.String("topic", topic),
-		)
-		t = p.client.Topic(topic)
-		t.EnableMessageOrdering = true
-
-		p.cacheTopic(topic, t)
-	}
-
-	p.logger.Debug(
-		"publishing message to topic", zap.String("topic", topic),
-	)
+	return p.PublishContext(p.ctx, topic, message)
+}
 
-	data, err := json.Marshal(message.Data)
+// PublishContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message attributes.
+func (p *PubSub) PublishContext(
+	ctx context.Context, topic string, message *ship.Message,
+) error {
+	raw, err := p.pipeline.Marshal(message)
 	if err != nil {
 		return errors.Wrap(err, "unable to marshal message to bytes")
 	}
 
-	pbMsg := pubsub.Message{
-		Data:        data,
-		Attributes:  message.Metadata,
-		OrderingKey: "",
-	}
-	res := t.Publish(p.ctx, &pbMsg)
-
-	p.logger.Debug(
-		"checking if message was published successfully",
-		zap.String("topic", topic),
-	)
-	if id, err := res.Get(p.ctx); err != nil {
-		p.logger.Error(
-			"unable to publish message",
-			zap.String("topic", topic),
-			zap.String("messageId", id),
-		)
-		return errors.Wrap(err, "could not publish message")
-	}
-
-	return nil
+	return p.publish(ctx, topic, raw)
 }
 
 // PublishRaw publishes the message to a given topic.
 func (p *PubSub) PublishRaw(topic string, message *ship.RawMessage) error {
+	return p.PublishRawContext(p.ctx, topic, message)
+}
+
+// PublishRawContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message attributes.
+func (p *PubSub) PublishRawContext(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) error {
+	return p.publish(ctx, topic, message)
+}
+
+// publish wraps the raw message and publishes it to a given topic.
+func (p *PubSub) publish(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) (err error) {
+	start := time.Now()
+	ctx, span := p.tracer.StartPublish(ctx, topic)
+	defer func() {
+		tracing.End(span, err)
+		if err != nil {
+			p.metrics.PublishFailed(topic, time.Since(start))
+			return
+		}
+		p.metrics.PublishSucceeded(topic, time.Since(start))
+	}()
+
 	p.topicsMu.RLock()
 	t, ok := p.topics[topic]
 	p.topicsMu.RUnlock()
@@ -154,30 +153,53 @@ func (p *PubSub) PublishRaw(topic string, message *ship.RawMessage) error {
 		p.cacheTopic(topic, t)
 	}
 
+	message, err = p.pipeline.Wrap(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "unable to wrap message")
+	}
+
 	p.logger.Debug(
 		"publishing message to topic", zap.String("topic", topic),
 	)
 
 	pbMsg := pubsub.Message{
 		Data:        message.Data,
-		Attributes:  message.Attributes,
-		OrderingKey: message.OrderingKey,
+		Attributes:  p.tracer.Inject(ctx, message.Attributes),
+		OrderingKey: orderingKey(message),
 	}
 
-	res := t.Publish(p.ctx, &pbMsg)
+	res := t.Publish(ctx, &pbMsg)
 
 	p.logger.Debug(
 		"checking if message was published successfully",
 		zap.String("topic", topic),
 	)
-	if id, err := res.Get(p.ctx); err != nil {
+	id, err := res.Get(ctx)
+	if err != nil {
 		p.logger.Error(
 			"unable to publish message",
 			zap.String("topic", topic),
 			zap.String("messageId", id),
 		)
+		// A failed publish pauses the ordering key of the topic, the next
+		// messages of the key are published once it is resumed.
+		if pbMsg.OrderingKey != "" {
+			t.ResumePublish(pbMsg.OrderingKey)
+		}
 		return errors.Wrap(err, "could not publish message")
 	}
 
+	tracing.SetMessageID(span, id)
+
 	return nil
 }
+
+// orderingKey returns the ordering key of a message: its own or, if it has
+// none, its aggregate id.
+func orderingKey(message *ship.RawMessage) string {
+	if message.OrderingKey != "" {
+		return message.OrderingKey
+	}
+
+	return message.Attributes[ship.AggregateIDKey]
+}
@@ -0,0 +1,187 @@
+package gcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testEvent) EventName() string { return "TestEvent" }
+
+// newFileStore returns a claim-check store in a temporary directory.
+func newFileStore(t *testing.T) claimcheck.Store {
+	t.Helper()
+
+	store, err := claimcheck.NewFileStore(t.TempDir())
+	assert.NoError(t, err)
+
+	return store
+}
+
+// newKeyring returns a keyring with a generated key.
+func newKeyring(t *testing.T, keyID string) encryption.KeyProvider {
+	t.Helper()
+
+	keyring := encryption.NewKeyring()
+	assert.NoError(t, keyring.Generate(keyID))
+
+	return keyring
+}
+
+func TestPubSub_Publish(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option
+	}{
+		{
+			name: "should publish and receive a message with default codec",
+		},
+		{
+			name: "should publish and receive a message with custom codec",
+			opts: []Option{WithCodec(ship.JSONCodec{})},
+		},
+		{
+			name: "should publish and receive a compressed message",
+			opts: []Option{WithCompression(compress.NewSnappy(), 0)},
+		},
+		{
+			name: "should publish and receive a claim-checked message",
+			opts: []Option{WithClaimCheck(newFileStore(t), 0)},
+		},
+		{
+			name: "should publish and receive an encrypted message",
+			opts: []Option{WithEncryption(newKeyring(t, "some-key"), "some-key")},
+		},
+		{
+			name: "should publish and receive a compressed, encrypted and claim-checked message",
+			opts: []Option{
+				WithCompression(compress.NewZstd(), 0),
+				WithEncryption(newKeyring(t, "some-key"), "some-key"),
+				WithClaimCheck(newFileStore(t), 0),
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			registry := ship.NewRegistry()
+			assert.NoError(t, registry.Register(&testEvent{}))
+
+			suite := newTestSuite(t, append(tc.opts, WithRegistry(registry))...)
+			defer suite.Teardown(t)
+
+			ctx := context.Background()
+			topic, err := suite.client.client.CreateTopic(ctx, "some-topic")
+			assert.NoError(t, err)
+
+			_, err = suite.client.client.CreateSubscription(
+				ctx, "some-subscription", pubsub.SubscriptionConfig{Topic: topic},
+			)
+			assert.NoError(t, err)
+
+			received := make(chan *ship.Message, 1)
+			err = suite.client.Subscribe(
+				"some-subscription",
+				ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+					received <- m
+					return nil
+				}),
+			)
+			assert.NoError(t, err)
+
+			at := time.Now().UTC()
+			err = suite.client.Publish("some-topic", &ship.Message{
+				ID:            "some-id",
+				Metadata:      ship.Metadata{"key": "value"},
+				Type:          "TestEvent",
+				AggregateID:   "some-aggregate-id",
+				AggregateType: "test",
+				Data:          &testEvent{Name: "ship"},
+				At:            at,
+				Version:       1,
+			})
+			assert.NoError(t, err)
+
+			select {
+			case m := <-received:
+				assert.Equal(t, "some-id", m.ID)
+				assert.Equal(t, "TestEvent", m.Type)
+				assert.Equal(t, "some-aggregate-id", m.AggregateID)
+				assert.Equal(t, "test", m.AggregateType)
+				assert.Equal(t, "value", m.Metadata["key"])
+				assert.Equal(t, &testEvent{Name: "ship"}, m.Data)
+				assert.True(t, at.Equal(m.At))
+				assert.Equal(t, uint64(1), m.Version)
+
+				published := suite.internalPubSub.Messages()
+				assert.Len(t, published, 1)
+				assert.Equal(t, "some-aggregate-id", published[0].OrderingKey)
+			case <-time.After(10 * time.Second):
+				assert.Fail(t, "message was not received")
+			}
+		})
+	}
+}
+
+func TestPubSub_PublishTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	suite := newTestSuite(t, WithRegistry(registry), WithTracerProvider(tp))
+	defer suite.Teardown(t)
+
+	ctx := context.Background()
+	topic, err := suite.client.client.CreateTopic(ctx, "some-topic")
+	assert.NoError(t, err)
+
+	_, err = suite.client.client.CreateSubscription(
+		ctx, "some-subscription", pubsub.SubscriptionConfig{Topic: topic},
+	)
+	assert.NoError(t, err)
+
+	received := make(chan trace.SpanContext, 1)
+	err = suite.client.Subscribe(
+		"some-subscription",
+		ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+			assert.NotContains(t, m.Metadata, "traceparent")
+			received <- trace.SpanContextFromContext(ctx)
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, span := tp.Tracer("test").Start(ctx, "parent")
+	err = suite.client.PublishContext(ctx, "some-topic", &ship.Message{
+		ID:   "some-id",
+		Type: "TestEvent",
+		Data: &testEvent{Name: "ship"},
+	})
+	assert.NoError(t, err)
+	span.End()
+
+	select {
+	case sc := <-received:
+		assert.Equal(t, span.SpanContext().TraceID(), sc.TraceID())
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message was not received")
+	}
+}
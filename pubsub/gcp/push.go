@@ -0,0 +1,160 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"go.uber.org/zap"
+)
+
+// maxPushRequestSize is the maximum size of a push request body. Pub/Sub
+// messages are at most 10MB, which grows by a third once base64 encoded.
+const maxPushRequestSize = 16 << 20
+
+// pushRequest is the body of a push subscription request.
+//
+// See: https://cloud.google.com/pubsub/docs/push#receive_push
+type pushRequest struct {
+	Message struct {
+		Attributes  map[string]string `json:"attributes"`
+		Data        []byte            `json:"data"`
+		MessageID   string            `json:"messageId"`
+		PublishTime time.Time         `json:"publishTime"`
+		OrderingKey string            `json:"orderingKey"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// PushHandler returns an http.Handler receiving the messages of a push
+// subscription and passing them to the handler.
+//
+// The messages are decoded as in Subscribe. The handler responds with:
+//   - 204 when the message is processed or could not be decoded, which acks it.
+//   - 500 when the handler returns an error or panics, or the message could not
+//     be decoded for a temporary reason, which nacks it.
+//   - 400 when the request is not a push request.
+//   - 503 once the pubsub is stopped.
+//
+// The requests being processed are waited for and reported by Shutdown as the
+// messages received by Subscribe.
+//
+// Example:
+//
+//	http.Handle("/pubsub/push", pubsub.PushHandler(handler))
+func (p *PubSub) PushHandler(handler ship.MessageHandler) http.Handler {
+	return p.pushHandler(
+		transport.HandlerName(handler),
+		p.processor.Handler(handler),
+	)
+}
+
+// PushHandlerRaw returns an http.Handler receiving the messages of a push
+// subscription and passing them to the raw handler.
+//
+// The messages are unwrapped as in SubscribeRaw, see PushHandler for the
+// response status codes.
+func (p *PubSub) PushHandlerRaw(handler ship.RawMessageHandler) http.Handler {
+	return p.pushHandler(
+		transport.HandlerName(handler),
+		p.processor.RawHandler(handler),
+	)
+}
+
+// pushHandler returns an http.Handler decoding push requests and passing the
+// messages to process.
+func (p *PubSub) pushHandler(
+	hName string, process transport.ProcessFunc,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The request is counted as a subscription, so Shutdown waits for it.
+		if err := p.lifecycle.Acquire(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer p.lifecycle.Release()
+
+		var req pushRequest
+		body := http.MaxBytesReader(w, r.Body, maxPushRequestSize)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			p.logger.Error("unable to decode push request", zap.Error(err))
+			http.Error(w, "invalid push request", http.StatusBadRequest)
+			return
+		}
+
+		subID := subscriptionID(req.Subscription)
+
+		// We don't want an unexpected error in consumer to take down whole
+		// application. Unlike Subscribe, there is no subscription to remove from
+		// listening, the message is nacked.
+		defer func() {
+			if rec := recover(); rec != nil {
+				p.logger.Error(
+					"[BUG]: recovered from a panic in push handler. Nacking the received message.",
+					zap.String("subscription", subID),
+					zap.String("handlerName", hName),
+					zap.String(logIDKey, req.Message.MessageID),
+					zap.String("panic", fmt.Sprint(rec)),
+				)
+				p.metrics.Nacked(subID, hName)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+
+		defer p.inFlight.track(req.Message.MessageID, subID, hName)()
+
+		// The handler is cancelled when the request is or when Shutdown
+		// cancels the in-flight handlers.
+		ctx, cancel := p.pushContext(r.Context())
+		defer cancel()
+
+		err := process(ctx, subID, hName, &ship.RawMessage{
+			ID:          req.Message.MessageID,
+			Attributes:  req.Message.Attributes,
+			Data:        req.Message.Data,
+			PublishTime: req.Message.PublishTime,
+			OrderingKey: req.Message.OrderingKey,
+		})
+		if err != nil {
+			p.metrics.Nacked(subID, hName)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		p.metrics.Acked(subID, hName)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// pushContext returns a context cancelled when ctx or the context of the
+// handlers is.
+func (p *PubSub) pushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-p.handlerCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// subscriptionID returns the id of a fully qualified subscription name, e.g.
+// projects/my-project/subscriptions/my-subscription.
+func subscriptionID(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -0,0 +1,311 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+// newPushRequest returns a push request body of a raw message.
+func newPushRequest(t *testing.T, raw *ship.RawMessage) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"attributes":  raw.Attributes,
+			"data":        raw.Data,
+			"messageId":   "some-message-id",
+			"publishTime": "2022-01-31T14:15:17.181841Z",
+		},
+		"subscription": "projects/some-id/subscriptions/some-subscription",
+	})
+	assert.NoError(t, err)
+
+	return body
+}
+
+func TestPubSub_PushHandler(t *testing.T) {
+	envelope, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{
+		ID:   "some-id",
+		Type: "TestEvent",
+		Data: &testEvent{Name: "ship"},
+	})
+	assert.NoError(t, err)
+
+	unregistered, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{
+		ID:   "some-id",
+		Type: "UnknownEvent",
+		Data: &testEvent{Name: "ship"},
+	})
+	assert.NoError(t, err)
+
+	debezium, err := os.ReadFile("testdata/valid_data.fixture")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		method  string
+		body    func(t *testing.T) []byte
+		handle  func(ctx context.Context, m *ship.Message) error
+		stop    bool
+		status  int
+		handled int
+	}{
+		{
+			name:   "should ack a ship envelope",
+			method: http.MethodPost,
+			body: func(t *testing.T) []byte {
+				return newPushRequest(t, envelope)
+			},
+			handle: func(ctx context.Context, m *ship.Message) error {
+				assert.Equal(t, &testEvent{Name: "ship"}, m.Data)
+				return nil
+			},
+			status:  http.StatusNoContent,
+			handled: 1,
+		},
+		{
+			name:   "should ack a debezium message",
+			method: http.MethodPost,
+			body: func(t *testing.T) []byte {
+				return newPushRequest(t, &ship.RawMessage{Data: debezium})
+			},
+			handle: func(ctx context.Context, m *ship.Message) error {
+				assert.Equal(t, "UserCreated", m.Type)
+				assert.Equal(t, "someone@flahmingo.com", m.Data.(*userCreated).Email)
+				return nil
+			},
+			status:  http.StatusNoContent,
+			handled: 1,
+		},
+		{
+			name:   "should ack a message which could not be decoded",
+			method: http.MethodPost,
+			body: func(t *testing.T) []byte {
+				return newPushRequest(t, unregistered)
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "should nack when the handler returns an error",
+			method: http.MethodPost,
+			body: func(t *testing.T) []byte {
+				return newPushRequest(t, envelope)
+			},
+			handle: func(ctx context.Context, m *ship.Message) error {
+				return errors.New("some error")
+			},
+			status:  http.StatusInternalServerError,
+			handled: 1,
+		},
+		{
+			name:   "should nack when the handler panics",
+			method: http.MethodPost,
+			body: func(t *testing.T) []byte {
+				return newPushRequest(t, envelope)
+			},
+			handle: func(ctx context.Context, m *ship.Message) error {
+				panic("this function would panic")
+			},
+			status:  http.StatusInternalServerError,
+			handled: 1,
+		},
+		{
+			name:   "should reject an invalid push request",
+			method: http.MethodPost,
+			body: func(t *testing.T) []byte {
+				return []byte("not json")
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should reject other methods",
+			method: http.MethodGet,
+			body: func(t *testing.T) []byte {
+				return nil
+			},
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "should reject messages once stopped",
+			method: http.MethodPost,
+			body: func(t *testing.T) []byte {
+				return newPushRequest(t, envelope)
+			},
+			stop:   true,
+			status: http.StatusServiceUnavailable,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			registry := ship.NewRegistry()
+			assert.NoError(t, registry.Register(&testEvent{}))
+			assert.NoError(t, registry.Register(&userCreated{}))
+
+			suite := newTestSuite(t, WithRegistry(registry))
+			if tc.stop {
+				suite.Teardown(t)
+			} else {
+				defer suite.Teardown(t)
+			}
+
+			handled := 0
+			handler := suite.client.PushHandler(
+				ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+					handled++
+					return tc.handle(ctx, m)
+				}),
+			)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "/", bytes.NewReader(tc.body(t)))
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.handled, handled)
+		})
+	}
+}
+
+func TestPubSub_PushHandlerRaw(t *testing.T) {
+	suite := newTestSuite(t)
+	defer suite.Teardown(t)
+
+	received := make(chan *ship.RawMessage, 1)
+	handler := suite.client.PushHandlerRaw(rawHandlerFunc(
+		func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		},
+	))
+
+	body := newPushRequest(t, &ship.RawMessage{
+		Attributes: map[string]string{"key": "value"},
+		Data:       []byte("some data"),
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	m := <-received
+	assert.Equal(t, "some-message-id", m.ID)
+	assert.Equal(t, []byte("some data"), m.Data)
+	assert.Equal(t, map[string]string{"key": "value"}, m.Attributes)
+	assert.False(t, m.PublishTime.IsZero())
+}
+
+func TestPubSub_PushHandlerShutdown(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handle  func(ctx context.Context) error
+		timeout time.Duration
+		status  int
+		check   func(t *testing.T, err error)
+	}{
+		{
+			name: "should wait for the in-flight request",
+			handle: func(ctx context.Context) error {
+				time.Sleep(200 * time.Millisecond)
+				return ctx.Err()
+			},
+			timeout: 10 * time.Second,
+			status:  http.StatusNoContent,
+			check: func(t *testing.T, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name: "should report the abandoned in-flight request",
+			handle: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			timeout: 200 * time.Millisecond,
+			status:  http.StatusInternalServerError,
+			check: func(t *testing.T, err error) {
+				var shutdownErr *ShutdownError
+				assert.True(t, errors.As(err, &shutdownErr))
+				assert.Equal(t, []AbandonedMessage{{
+					Subscription: "some-subscription",
+					Handler:      "rawHandlerFunc",
+					MessageID:    "some-message-id",
+				}}, shutdownErr.Messages)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t)
+			defer suite.internalPubSub.Close()
+
+			started := make(chan struct{})
+			returned := make(chan struct{})
+			handler := suite.client.PushHandlerRaw(rawHandlerFunc(
+				func(ctx context.Context, m *ship.RawMessage) error {
+					defer close(returned)
+					close(started)
+					return tc.handle(ctx)
+				},
+			))
+
+			body := newPushRequest(t, &ship.RawMessage{Data: []byte("some data")})
+
+			rec := httptest.NewRecorder()
+			served := make(chan struct{})
+			go func() {
+				defer close(served)
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+			}()
+
+			select {
+			case <-started:
+			case <-time.After(10 * time.Second):
+				assert.FailNow(t, "request was not handled")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+
+			tc.check(t, suite.client.Shutdown(ctx))
+
+			// The handler returned before Shutdown did.
+			select {
+			case <-returned:
+			default:
+				assert.Fail(t, "request is still in flight")
+			}
+			<-served
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+type rawHandlerFunc func(context.Context, *ship.RawMessage) error
+
+func (f rawHandlerFunc) HandleRawMessage(ctx context.Context, m *ship.RawMessage) error {
+	return f(ctx, m)
+}
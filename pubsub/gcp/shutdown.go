@@ -0,0 +1,239 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"go.uber.org/zap"
+)
+
+// AbandonedMessage describes a received message whose handler did not finish
+// before the shutdown deadline.
+type AbandonedMessage struct {
+	// Subscription is the name of the subscription.
+	Subscription string
+
+	// Handler is the name of the message handler.
+	Handler string
+
+	// MessageID is the pubsub message id.
+	MessageID string
+}
+
+// ShutdownError is returned by Shutdown when the deadline is exceeded before
+// the client is drained.
+type ShutdownError struct {
+	// Messages are the in-flight messages whose handler was cancelled. They are
+	// redelivered, unless the handler acknowledged them on cancellation.
+	Messages []AbandonedMessage
+
+	// Topics are the topics whose pending messages were not flushed.
+	Topics []string
+
+	// Err is the error of the shutdown context.
+	Err error
+}
+
+// Error returns the error message.
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf(
+		"shutdown abandoned %d in-flight messages and %d topics: %v",
+		len(e.Messages), len(e.Topics), e.Err,
+	)
+}
+
+// Unwrap returns the error of the shutdown context.
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// cancelGracePeriod bounds the time Shutdown waits for the in-flight handlers
+// to return once they are cancelled, after its deadline.
+const cancelGracePeriod = time.Second
+
+// inFlight tracks the messages being processed by handlers.
+type inFlight struct {
+	mu       sync.Mutex
+	messages map[*AbandonedMessage]struct{}
+}
+
+// add tracks a received message until the returned function is called.
+func (f *inFlight) add(msg *pubsub.Message, subscription, handler string) func() {
+	return f.track(msg.ID, subscription, handler)
+}
+
+// track tracks a message by its id until the returned function is called.
+func (f *inFlight) track(id, subscription, handler string) func() {
+	m := &AbandonedMessage{
+		Subscription: subscription,
+		Handler:      handler,
+		MessageID:    id,
+	}
+
+	f.mu.Lock()
+	if f.messages == nil {
+		f.messages = make(map[*AbandonedMessage]struct{})
+	}
+	f.messages[m] = struct{}{}
+	f.mu.Unlock()
+
+	return func() {
+		f.mu.Lock()
+		delete(f.messages, m)
+		f.mu.Unlock()
+	}
+}
+
+// list returns the messages being processed.
+func (f *inFlight) list() []AbandonedMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := make([]AbandonedMessage, 0, len(f.messages))
+	for m := range f.messages {
+		messages = append(messages, *m)
+	}
+	return messages
+}
+
+// Shutdown stops the pubsub gracefully within the deadline of ctx.
+//
+// It stops receiving new messages, waits for the in-flight handlers to finish
+// and flushes the pending published messages. The context passed to the
+// handlers is cancelled when ctx is done.
+//
+// If ctx is done before the client is drained, the handlers are given
+// cancelGracePeriod to return, the client is closed anyway and a
+// *ShutdownError reporting what was abandoned is returned. It returns
+// ErrStopped if the pubsub is already stopped.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//
+//	if err := pubsub.Shutdown(ctx); err != nil {
+//		// do something with error
+//	}
+func (p *PubSub) Shutdown(ctx context.Context) error {
+	if err := p.lifecycle.Stop(); err != nil {
+		return err
+	}
+
+	shutdownErr := &ShutdownError{}
+
+	p.logger.Debug("cancelling subscriptions context")
+	p.receiveCancel()
+
+	p.logger.Info("waiting for in-flight messages to be processed")
+	drained := make(chan struct{})
+	go func() {
+		p.lifecycle.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		p.handlerCancel()
+	case <-ctx.Done():
+		shutdownErr.Messages = p.inFlight.list()
+		p.logger.Warn(
+			"shutdown deadline exceeded: cancelling in-flight handlers",
+			zap.Int("inFlight", len(shutdownErr.Messages)),
+		)
+		p.handlerCancel()
+
+		// The cancelled handlers are given a moment to return, so their
+		// messages are not acked or nacked on a closed client.
+		select {
+		case <-drained:
+		case <-time.After(cancelGracePeriod):
+			p.logger.Warn(
+				"in-flight handlers did not return after cancellation: closing the client",
+				zap.Int("inFlight", len(p.inFlight.list())),
+			)
+		}
+	}
+
+	shutdownErr.Topics = p.stopTopics(ctx)
+
+	p.logger.Debug("cancelling context")
+	p.cancelFn()
+
+	if err := p.client.Close(); err != nil {
+		return err
+	}
+
+	if len(shutdownErr.Messages) > 0 || len(shutdownErr.Topics) > 0 {
+		shutdownErr.Err = ctx.Err()
+		return shutdownErr
+	}
+
+	return nil
+}
+
+// stopTopics flushes the pending messages of every topic and stops them.
+//
+// It returns the topics which were not flushed when ctx is done.
+func (p *PubSub) stopTopics(ctx context.Context) []string {
+	p.topicsMu.Lock()
+	defer p.topicsMu.Unlock()
+
+	var (
+		mu        sync.Mutex
+		abandoned = make(map[string]struct{}, len(p.topics))
+		wg        sync.WaitGroup
+	)
+
+	for name, topic := range p.topics {
+		if topic == nil {
+			continue
+		}
+
+		abandoned[name] = struct{}{}
+		wg.Add(1)
+
+		go func(name string, topic *pubsub.Topic) {
+			defer wg.Done()
+
+			p.logger.Debug("closing topic", zap.String("topic", topic.String()))
+			topic.Stop()
+
+			mu.Lock()
+			delete(abandoned, name)
+			mu.Unlock()
+		}(name, topic)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	topics := make([]string, 0, len(abandoned))
+	for name := range abandoned {
+		topics = append(topics, name)
+	}
+	sort.Strings(topics)
+
+	p.logger.Warn(
+		"shutdown deadline exceeded: abandoning pending published messages",
+		zap.Strings("topics", topics),
+	)
+
+	return topics
+}
@@ -0,0 +1,109 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubSub_Shutdown(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handle  func(ctx context.Context) error
+		timeout time.Duration
+		check   func(t *testing.T, err error, handlerErr error)
+	}{
+		{
+			name: "should let the in-flight handler finish",
+			handle: func(ctx context.Context) error {
+				time.Sleep(200 * time.Millisecond)
+				return ctx.Err()
+			},
+			timeout: 10 * time.Second,
+			check: func(t *testing.T, err error, handlerErr error) {
+				assert.NoError(t, err)
+				assert.NoError(t, handlerErr)
+			},
+		},
+		{
+			name: "should report the abandoned in-flight handler",
+			handle: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			timeout: 200 * time.Millisecond,
+			check: func(t *testing.T, err error, handlerErr error) {
+				assert.ErrorIs(t, handlerErr, context.Canceled)
+
+				var shutdownErr *ShutdownError
+				assert.True(t, errors.As(err, &shutdownErr))
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				assert.Len(t, shutdownErr.Messages, 1)
+				assert.Equal(t, "some-subscription", shutdownErr.Messages[0].Subscription)
+				assert.NotEmpty(t, shutdownErr.Messages[0].MessageID)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			registry := ship.NewRegistry()
+			assert.NoError(t, registry.Register(&testEvent{}))
+
+			suite := newTestSuite(t, WithRegistry(registry))
+			defer suite.internalPubSub.Close()
+
+			ctx := context.Background()
+			topic, err := suite.client.client.CreateTopic(ctx, "some-topic")
+			assert.NoError(t, err)
+
+			_, err = suite.client.client.CreateSubscription(
+				ctx, "some-subscription", pubsub.SubscriptionConfig{Topic: topic},
+			)
+			assert.NoError(t, err)
+
+			started := make(chan struct{}, 1)
+			handlerErr := make(chan error, 1)
+			err = suite.client.Subscribe(
+				"some-subscription",
+				ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+					started <- struct{}{}
+					err := tc.handle(ctx)
+					handlerErr <- err
+					return err
+				}),
+			)
+			assert.NoError(t, err)
+
+			err = suite.client.Publish("some-topic", &ship.Message{
+				ID:   "some-id",
+				Type: "TestEvent",
+				Data: &testEvent{Name: "ship"},
+			})
+			assert.NoError(t, err)
+
+			select {
+			case <-started:
+			case <-time.After(10 * time.Second):
+				assert.FailNow(t, "message was not received")
+			}
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, tc.timeout)
+			defer cancel()
+
+			err = suite.client.Shutdown(shutdownCtx)
+
+			// The handler returned before the client was closed.
+			assert.Len(t, handlerErr, 1)
+			tc.check(t, err, <-handlerErr)
+			assert.True(t, suite.client.Health().Stopped)
+		})
+	}
+}
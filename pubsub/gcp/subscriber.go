@@ -1,15 +1,12 @@
 package gcp
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"reflect"
-	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
@@ -17,12 +14,8 @@ import (
 // Compile time check.
 var _ ship.Subscriber = (*PubSub)(nil)
 
-// _bufferPool is a pool of bytes.Buffers.
-var _bufferPool = sync.Pool{
-	New: func() interface{} {
-		return &bytes.Buffer{}
-	},
-}
+// ErrStopped is returned when subscribing to or stopping a stopped pubsub.
+var ErrStopped = transport.ErrStopped
 
 // subInit check for existence of subscription name and returns it.
 // If subscription does not exists, it will throw error.
@@ -47,7 +40,7 @@ func (p *PubSub) subInit(subName string) (*pubsub.Subscription, error) {
 // Subscribe subscribes a handler to a given subscription.
 // It stops receiving message in case of, panics.
 //
-// It is a non-blocking call.
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
 // NOTE: to stop the subscriptions. Call Stop method.
 //
 // Example:
@@ -64,15 +57,22 @@ func (p *PubSub) subInit(subName string) (*pubsub.Subscription, error) {
 func (p *PubSub) Subscribe(
 	subscription string, handler ship.MessageHandler,
 ) error {
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+
 	sub, err := p.subInit(subscription)
 	if err != nil {
+		p.lifecycle.Release()
 		return errors.WithStack(err)
 	}
 
+	health := p.health.Track(sub.ID(), transport.HandlerName(handler))
+
 	p.logger.Info(
 		"starting listener for subscription", zap.String("subscription", subscription),
 	)
-	go p.handle(handler, sub)
+	go p.receive(sub, health, p.processor.Handler(handler))
 
 	return nil
 }
@@ -80,7 +80,7 @@ func (p *PubSub) Subscribe(
 // SubscribeRaw subscribes a handler to a given subscription.
 // It stops receiving message in case of, panics.
 //
-// It is a non-blocking call.
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
 // NOTE: to stop the subscriptions. Call Stop method.
 //
 // Example:
@@ -97,28 +97,36 @@ func (p *PubSub) Subscribe(
 func (p *PubSub) SubscribeRaw(
 	subscription string, handler ship.RawMessageHandler,
 ) error {
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+
 	sub, err := p.subInit(subscription)
 	if err != nil {
+		p.lifecycle.Release()
 		return errors.WithStack(err)
 	}
 
+	health := p.health.Track(sub.ID(), transport.HandlerName(handler))
+
 	p.logger.Info(
 		"starting listener for subscription", zap.String("subscription", subscription),
 	)
-	go p.handleRaw(handler, sub)
+	go p.receive(sub, health, p.processor.RawHandler(handler))
 
 	return nil
 }
 
-func (p *PubSub) handleRaw(h ship.RawMessageHandler, sub *pubsub.Subscription) {
-	p.wg.Add(1)
-	defer p.wg.Done()
+// receive receives the messages of a subscription and processes them, until
+// the pubsub is stopped or the handler panics.
+func (p *PubSub) receive(
+	sub *pubsub.Subscription,
+	health *transport.SubscriptionHealth,
+	process transport.ProcessFunc,
+) {
+	defer p.lifecycle.Release()
 
-	t := reflect.TypeOf(h)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
-	hName := t.Name()
+	hName := health.Get().Handler
 
 	p.logger.Debug(
 		"subscription started",
@@ -126,11 +134,14 @@ func (p *PubSub) handleRaw(h ship.RawMessageHandler, sub *pubsub.Subscription) {
 		zap.String("handlerName", hName),
 	)
 
+	subID := sub.ID()
+	maxAttempts := p.maxDeliveryAttempts(sub)
+
 	// Creating a context with cancel so, we can cancel the subscription in case
 	// of panic.
-	ctx, cancel := context.WithCancel(p.ctx)
+	ctx, cancel := context.WithCancel(p.receiveCtx)
 
-	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+	err := sub.Receive(ctx, func(_ context.Context, msg *pubsub.Message) {
 		// We don't want an unexpected error in consumer to take down whole application.
 		// We'll try to recover from the panic and remove the subscription from listening.
 		//
@@ -138,244 +149,94 @@ func (p *PubSub) handleRaw(h ship.RawMessageHandler, sub *pubsub.Subscription) {
 		// orchestrated environment.
 		defer func() {
 			if r := recover(); r != nil {
-				p.logger.Error(
-					"[BUG]: recovered from a panic in subscription."+" "+
-						"Nacking the received message and removing the subscription from listening.",
-					zap.String("subscription", sub.String()),
-					zap.String("handlerName", hName),
-					zap.String("pubsubMessageId", msg.ID),
+				health.Recovered(
+					p.logger, r,
+					"Nacking the received message and removing the subscription from listening.",
+					zap.String(logIDKey, msg.ID),
 				)
-				msg.Nack()
+				p.nack(msg, subID, hName, maxAttempts)
 
 				p.logger.Debug(
 					"cancelling panicked subscription context",
 					zap.String("subscription", sub.String()),
 					zap.String("handlerName", hName),
-					zap.String("pubsubMessageId", msg.ID),
+					zap.String(logIDKey, msg.ID),
 				)
 				// Cancel the context will remove stop the subscription from receiving messages.
 				cancel()
 			}
 		}()
 
-		p.logger.Debug(
-			"sending message to the handler",
-			zap.String("messageId", msg.ID),
-			zap.String("handlerName", hName),
-		)
-		hErr := h.HandleRawMessage(ctx, &ship.RawMessage{
-			ID:          msg.ID,
-			Attributes:  msg.Attributes,
-			Data:        msg.Data,
-			PublishTime: msg.PublishTime,
-			OrderingKey: msg.OrderingKey,
-		})
-
-		if hErr != nil {
-			p.logger.Error("handler could not process message", zap.Error(hErr))
-			msg.Nack()
+		health.SetLastMessageAt(time.Now())
+		defer p.inFlight.add(msg, subID, hName)()
+
+		// The handlers are given their own context, so they can finish
+		// processing when the subscription is stopped.
+		if err := process(p.handlerCtx, subID, hName, rawMessage(msg)); err != nil {
+			p.nack(msg, subID, hName, maxAttempts)
 			return
 		}
 
-		p.logger.Debug(
-			"acknowledging raw message",
-			zap.String("handlerName", hName),
-			zap.String("pubsubMessageId", msg.ID),
-		)
-		msg.Ack()
+		p.ack(msg, subID, hName)
 	})
+	health.Stopped(err)
 	if err != nil {
 		p.logger.Error("unable to receive messages from subscription", zap.Error(err))
-		p.errCh <- err
+		transport.ReportError(p.errCh, err)
 		return
 	}
 }
 
-type debeziumMessage struct {
-	Payload payload `json:"payload,omitempty"`
-}
-
-type payload struct {
-	ID            string            `json:"id"`
-	Type          string            `json:"type"`
-	Metadata      map[string]string `json:"metadata"`
-	AggregateID   string            `json:"aggregate_id"`
-	AggregateType string            `json:"aggregate_type"`
-	At            time.Time         `json:"at"`
-	Version       uint64            `json:"version"`
-	Data          string            `json:"data"`
-	Table         string            `json:"__table"`
-	LSN           uint64            `json:"__lsn"`
-	Deleted       string            `json:"__deleted"`
-}
-
-// handle takes a message handler and a subscription.
-//
-//nolint:funlen
-func (p *PubSub) handle(h ship.MessageHandler, sub *pubsub.Subscription) {
-	p.wg.Add(1)
-	defer p.wg.Done()
-
-	t := reflect.TypeOf(h)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
+// rawMessage converts a received message to a raw message.
+func rawMessage(msg *pubsub.Message) *ship.RawMessage {
+	return &ship.RawMessage{
+		ID:          msg.ID,
+		Attributes:  msg.Attributes,
+		Data:        msg.Data,
+		PublishTime: msg.PublishTime,
+		OrderingKey: msg.OrderingKey,
 	}
-	hName := t.Name()
-
-	p.logger.Debug(
-		"subscription started",
-		zap.String("subscription", sub.String()),
-		zap.String("handlerName", hName),
-	)
-
-	// Creating a context with cancel so, we can cancel the subscription in case
-	// of panic.
-	ctx, cancel := context.WithCancel(p.ctx)
-
-	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		// We don't want an unexpected error in consumer to take down whole application.
-		// We'll try to recover from the panic and remove the subscription from listening.
-		//
-		// This protects an application from going in a continuous crash loop in a
-		// orchestrated environment.
-		defer func() {
-			if r := recover(); r != nil {
-				p.logger.Error(
-					"[BUG]: recovered from a panic in subscription."+" "+
-						"Nacking the received message and removing the subscription from listening.",
-					zap.String("subscription", sub.String()),
-					zap.String("handlerName", hName),
-					zap.String("pubsubMessageId", msg.ID),
-				)
-				msg.Nack()
-
-				p.logger.Debug(
-					"cancelling panicked subscription context",
-					zap.String("subscription", sub.String()),
-					zap.String("handlerName", hName),
-					zap.String("pubsubMessageId", msg.ID),
-				)
-				// Cancel the context will remove stop the subscription from receiving messages.
-				cancel()
-			}
-		}()
-
-		p.logger.Debug("unmarshaling received message", zap.String("pubsubMessageId", msg.ID))
-		dbzm := debeziumMessage{}
-		err := json.Unmarshal(msg.Data, &dbzm)
-		if err != nil {
-			p.logger.Error(
-				"unable to unmarshal received message: acking it, so we don't process it again",
-				zap.Error(err),
-				zap.String("handlerName", hName),
-			)
-
-			msg.Ack()
-			return
-		}
-
-		if dbzm.Payload.Type == "" {
-			p.logger.Error(
-				"empty event type: acking it so, we don't process it again.",
-				zap.String("pubsubMessageId", msg.ID),
-				zap.String("messageId", dbzm.Payload.ID),
-				zap.String("handlerName", hName),
-			)
-
-			msg.Ack()
-			return
-		}
-
-		p.logger.Debug("getting event from registry", zap.String("eventType", dbzm.Payload.Type))
-		// Returned event is a pointer.
-		event, err := ship.GetEvent(dbzm.Payload.Type)
-		if err != nil {
-			p.logger.Warn(
-				"event is not registered: replay the event for reprocessing, acking it for now.",
-				zap.Error(err),
-				zap.String("eventType", dbzm.Payload.Type),
-				zap.String("handlerName", hName),
-			)
-
-			msg.Ack()
-			return
-		}
-
-		p.logger.Debug("unmarshaling payload data", zap.String("eventType", dbzm.Payload.Type))
-		mErr := json.Unmarshal([]byte(dbzm.Payload.Data), event)
-		if mErr != nil {
-			// Check whether the error is due to invalid type error. This could
-			// happen if a field type does not match with event field.
-			if _, ok := mErr.(*json.UnmarshalTypeError); ok {
-				p.logger.Error(
-					"[BUG]: invalid field type in event data:"+
-						" replay the event for reprocessing, acking it for now.",
-					zap.Error(mErr),
-					zap.String("eventType", dbzm.Payload.Type),
-					zap.String("handlerName", hName),
-				)
-
-				msg.Ack()
-				return
-			}
-
-			p.logger.Error(
-				"unable to unmarshal event data: acking it",
-				zap.Error(mErr),
-				zap.String("eventType", dbzm.Payload.Type),
-				zap.String("handlerName", hName),
-			)
-
-			// Acknowleging invalid data.
-			msg.Ack()
-			return
-		}
+}
 
-		p.logger.Debug(
-			"sending message to the handler",
-			zap.String("eventType", dbzm.Payload.Type),
-			zap.String("handlerName", hName),
+// maxDeliveryAttempts returns the maximum delivery attempts of the dead letter
+// policy of a subscription, 0 means the subscription has no dead letter
+// policy.
+func (p *PubSub) maxDeliveryAttempts(sub *pubsub.Subscription) int {
+	cfg, err := sub.Config(p.ctx)
+	if err != nil {
+		p.logger.Warn(
+			"unable to get subscription config: dead-lettered messages are not reported",
+			zap.Error(err),
+			zap.String("subscription", sub.String()),
 		)
-		hErr := h.HandleMessage(ctx, &ship.Message{
-			ID:            dbzm.Payload.ID,
-			Metadata:      dbzm.Payload.Metadata,
-			Type:          dbzm.Payload.Type,
-			AggregateID:   dbzm.Payload.AggregateID,
-			AggregateType: dbzm.Payload.AggregateType,
-			Data:          event,
-			At:            dbzm.Payload.At,
-			Version:       dbzm.Payload.Version,
-		})
-
-		if hErr != nil {
-			p.logger.Error("handler could not process message", zap.Error(hErr))
-			msg.Nack()
-			return
-		}
-
-		// Get bytes buffer from pool and reset it to empty out any garbage.
-		buff := _bufferPool.Get().(*bytes.Buffer)
-		buff.Reset()
+		return 0
+	}
 
-		buff.WriteString("acknowledging msg with event type: ")
-		buff.WriteString(dbzm.Payload.Type)
+	if cfg.DeadLetterPolicy == nil {
+		return 0
+	}
 
-		ackMsg := buff.String()
+	return cfg.DeadLetterPolicy.MaxDeliveryAttempts
+}
 
-		_bufferPool.Put(buff)
+// ack acknowledges a message and records it.
+func (p *PubSub) ack(msg *pubsub.Message, subID, hName string) {
+	msg.Ack()
+	p.metrics.Acked(subID, hName)
+}
 
-		p.logger.Debug(
-			ackMsg,
-			zap.String("eventType", dbzm.Payload.Type),
-			zap.String("handlerName", hName),
-			zap.String("pubsubMessageId", msg.ID),
-			zap.String("messageId", dbzm.Payload.ID),
-		)
-		msg.Ack()
-	})
-	if err != nil {
-		p.logger.Error("unable to receive messages from subscription", zap.Error(err))
-		p.errCh <- err
-		return
+// nack negatively acknowledges a message and records it.
+//
+// The message is also recorded as dead-lettered, if it was the last delivery
+// attempt allowed by the dead letter policy of the subscription.
+func (p *PubSub) nack(
+	msg *pubsub.Message, subID, hName string, maxAttempts int,
+) {
+	msg.Nack()
+	p.metrics.Nacked(subID, hName)
+
+	if maxAttempts > 0 && msg.DeliveryAttempt != nil &&
+		*msg.DeliveryAttempt >= maxAttempts {
+		p.metrics.DeadLettered(subID, hName)
 	}
 }
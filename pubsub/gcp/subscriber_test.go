@@ -3,7 +3,9 @@ package gcp
 import (
 	"context"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/Flahmingo-Investments/ship"
@@ -215,3 +217,305 @@ func TestPubSub_SubscribeHandler(t *testing.T) {
 		})
 	}
 }
+
+// testRecorder is a metrics.Recorder counting the recorded metrics.
+type testRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{counts: make(map[string]int)}
+}
+
+func (r *testRecorder) inc(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+func (r *testRecorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+func (r *testRecorder) PublishSucceeded(topic string, _ time.Duration) {
+	r.inc("published:" + topic)
+}
+
+func (r *testRecorder) PublishFailed(topic string, _ time.Duration) {
+	r.inc("publish_failed:" + topic)
+}
+
+func (r *testRecorder) Handled(sub, handler string, _ time.Duration) {
+	r.inc("handled:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Acked(sub, handler string) {
+	r.inc("acked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Nacked(sub, handler string) {
+	r.inc("nacked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DeadLettered(sub, handler string) {
+	r.inc("dead_lettered:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DecodeFailed(sub, handler, reason string) {
+	r.inc("decode_failed:" + sub + ":" + handler + ":" + reason)
+}
+
+type metricsHandler struct{}
+
+type rawHandler struct{}
+
+func (rawHandler) HandleRawMessage(context.Context, *ship.RawMessage) error {
+	return nil
+}
+
+func (metricsHandler) HandleMessage(context.Context, *ship.Message) error {
+	return nil
+}
+
+func TestPubSub_Metrics(t *testing.T) {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	recorder := newTestRecorder()
+	suite := newTestSuite(t, WithRegistry(registry), WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	ctx := context.Background()
+	topic, err := suite.client.client.CreateTopic(ctx, "some-topic")
+	assert.NoError(t, err)
+
+	_, err = suite.client.client.CreateSubscription(
+		ctx, "some-subscription", pubsub.SubscriptionConfig{Topic: topic},
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.Subscribe("some-subscription", metricsHandler{})
+	assert.NoError(t, err)
+
+	err = suite.client.Publish("some-topic", &ship.Message{
+		ID:   "some-id",
+		Type: "TestEvent",
+		Data: &testEvent{Name: "ship"},
+	})
+	assert.NoError(t, err)
+
+	err = suite.client.Publish("some-topic", &ship.Message{
+		ID:   "some-other-id",
+		Type: "UnknownEvent",
+		Data: &testEvent{Name: "ship"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, recorder.count("published:some-topic"))
+	assert.Eventually(t, func() bool {
+		return recorder.count("handled:some-subscription:metricsHandler") == 1 &&
+			recorder.count("acked:some-subscription:metricsHandler") == 2 &&
+			recorder.count(
+				"decode_failed:some-subscription:metricsHandler:"+ship.DecodeReasonUnregistered,
+			) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_nack(t *testing.T) {
+	attempt := func(n int) *int { return &n }
+
+	testCases := []struct {
+		name         string
+		attempt      *int
+		maxAttempts  int
+		deadLettered int
+	}{
+		{
+			name:         "should not report dead-lettered without dead letter policy",
+			attempt:      nil,
+			maxAttempts:  0,
+			deadLettered: 0,
+		},
+		{
+			name:         "should not report dead-lettered before the last attempt",
+			attempt:      attempt(4),
+			maxAttempts:  5,
+			deadLettered: 0,
+		},
+		{
+			name:         "should report dead-lettered on the last attempt",
+			attempt:      attempt(5),
+			maxAttempts:  5,
+			deadLettered: 1,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder()
+			p := &PubSub{metrics: recorder}
+
+			p.nack(&pubsub.Message{DeliveryAttempt: tc.attempt}, "sub", "handler", tc.maxAttempts)
+
+			assert.Equal(t, 1, recorder.count("nacked:sub:handler"))
+			assert.Equal(t, tc.deadLettered, recorder.count("dead_lettered:sub:handler"))
+		})
+	}
+}
+
+func TestPubSub_Health(t *testing.T) {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	suite := newTestSuite(t, WithRegistry(registry))
+
+	ctx := context.Background()
+	topic, err := suite.client.client.CreateTopic(ctx, "some-topic")
+	assert.NoError(t, err)
+
+	for _, name := range []string{"some-subscription", "panicking-subscription"} {
+		_, err = suite.client.client.CreateSubscription(
+			ctx, name, pubsub.SubscriptionConfig{Topic: topic},
+		)
+		assert.NoError(t, err)
+	}
+
+	err = suite.client.Subscribe("some-subscription", metricsHandler{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(suite.client.Health().Subscriptions) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.True(t, suite.client.Health().Live())
+	assert.True(t, suite.client.Health().Ready())
+
+	err = suite.client.Subscribe(
+		"panicking-subscription",
+		ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+			panic("boom")
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.Publish("some-topic", &ship.Message{
+		ID:   "some-id",
+		Type: "TestEvent",
+		Data: &testEvent{Name: "ship"},
+	})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return !suite.client.Health().Live()
+	}, 10*time.Second, 10*time.Millisecond)
+
+	h := suite.client.Health()
+	assert.False(t, h.Ready())
+	assert.Len(t, h.Subscriptions, 2)
+	for _, s := range h.Subscriptions {
+		switch s.Subscription {
+		case "some-subscription":
+			assert.Equal(t, ship.SubscriptionRunning, s.State)
+		case "panicking-subscription":
+			assert.Equal(t, ship.SubscriptionPanicked, s.State)
+			assert.Equal(t, "boom", s.Error)
+			assert.False(t, s.LastMessageAt.IsZero())
+		}
+	}
+
+	suite.Teardown(t)
+
+	h = suite.client.Health()
+	assert.True(t, h.Stopped)
+	for _, s := range h.Subscriptions {
+		if s.Subscription == "some-subscription" {
+			assert.Equal(t, ship.SubscriptionStopped, s.State)
+		}
+	}
+}
+
+func TestPubSub_SubscribeAfterStop(t *testing.T) {
+	suite := newTestSuite(t)
+
+	topic, err := suite.client.client.CreateTopic(context.Background(), "some-topic")
+	assert.NoError(t, err)
+
+	_, err = suite.client.client.CreateSubscription(
+		context.Background(), "some-subscription", pubsub.SubscriptionConfig{Topic: topic},
+	)
+	assert.NoError(t, err)
+
+	suite.Teardown(t)
+
+	err = suite.client.Subscribe("some-subscription", metricsHandler{})
+	assert.ErrorIs(t, err, ErrStopped)
+
+	err = suite.client.SubscribeRaw("some-subscription", rawHandler{})
+	assert.ErrorIs(t, err, ErrStopped)
+
+	assert.ErrorIs(t, suite.client.Stop(), ErrStopped)
+	assert.ErrorIs(t, suite.client.Shutdown(context.Background()), ErrStopped)
+	assert.Empty(t, suite.client.Health().Subscriptions)
+}
+
+func TestPubSub_SubscribeConcurrentStop(t *testing.T) {
+	const subscriptions = 20
+
+	suite := newTestSuite(t)
+	defer suite.internalPubSub.Close()
+
+	ctx := context.Background()
+	topic, err := suite.client.client.CreateTopic(ctx, "some-topic")
+	assert.NoError(t, err)
+
+	_, err = suite.client.client.CreateSubscription(
+		ctx, "some-subscription", pubsub.SubscriptionConfig{Topic: topic},
+	)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, subscriptions)
+
+	for i := 0; i < subscriptions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- suite.client.Subscribe("some-subscription", metricsHandler{})
+		}()
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- suite.client.Stop()
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		assert.FailNow(t, "unable to stop client properly")
+	}
+
+	accepted := 0
+	for err := range errs {
+		if err == nil {
+			accepted++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrStopped)
+	}
+
+	// Every accepted subscription was waited for by Stop.
+	h := suite.client.Health()
+	assert.Len(t, h.Subscriptions, accepted)
+	for _, s := range h.Subscriptions {
+		assert.Equal(t, ship.SubscriptionStopped, s.State)
+	}
+}
@@ -0,0 +1,333 @@
+// Package kafka contains an implementation of ship.Publisher and
+// ship.Subscriber interface over Kafka.
+//
+// A subscription is a Kafka consumer group, consuming the topic of the same
+// name unless it is mapped to other topics with WithSubscription.
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/debezium"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Default retry backoff of the messages which failed to be processed.
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// Compile time check.
+var _ ship.HealthChecker = (*PubSub)(nil)
+
+// Option is an option setter used to configure creation.
+type Option func(*PubSub) error
+
+// shared wraps an option shared by the transports.
+func shared(opt transport.Option) Option {
+	return func(p *PubSub) error {
+		return opt(transport.Settings{
+			Name:     "kafka",
+			Pipeline: p.pipeline,
+			Tracing:  &p.tracingOpts,
+			Metrics:  &p.metrics,
+			Logger:   &p.logger,
+		})
+	}
+}
+
+// WithConfig uses the provided sarama configuration instead of the default
+// one.
+//
+// The producer always returns its successes and the offsets are always
+// committed automatically: the offsets of the acknowledged messages are
+// committed every Consumer.Offsets.AutoCommit.Interval and when a session
+// ends. These settings are changed on a copy, the provided configuration is
+// left untouched.
+func WithConfig(config *sarama.Config) Option {
+	return func(p *PubSub) error {
+		if config == nil {
+			return errors.New("config cannot be nil")
+		}
+		c := *config
+		p.config = &c
+		return nil
+	}
+}
+
+// WithSubscription maps a subscription to the topics consumed by its
+// consumer group. By default, a subscription consumes the topic of the same
+// name.
+func WithSubscription(subscription string, topics ...string) Option {
+	return func(p *PubSub) error {
+		if len(topics) == 0 {
+			return errors.Errorf("subscription %s has no topic", subscription)
+		}
+		p.subscriptions[subscription] = topics
+		return nil
+	}
+}
+
+// WithRetryBackoff changes the backoff between the attempts to process a
+// message. The backoff doubles after every failed attempt, from min up to
+// max.
+func WithRetryBackoff(min, max time.Duration) Option {
+	return func(p *PubSub) error {
+		if min <= 0 || max < min {
+			return errors.Errorf("invalid retry backoff [%s, %s]", min, max)
+		}
+		p.minBackoff = min
+		p.maxBackoff = max
+		return nil
+	}
+}
+
+// WithMaxAttempts limits the number of attempts to process a message. A
+// message which failed to be processed n times is published to the dead
+// letter topic, see WithDeadLetterTopic, and its offset is committed. Default
+// is unlimited: the message blocks its partition until it is processed.
+func WithMaxAttempts(n int) Option {
+	return func(p *PubSub) error {
+		if n <= 0 {
+			return errors.Errorf("invalid max attempts %d", n)
+		}
+		p.maxAttempts = n
+		return nil
+	}
+}
+
+// WithDeadLetterTopic sets the topic receiving the messages which reached the
+// max attempts, with the Dead* headers describing their origin. Without dead
+// letter topic, such messages are skipped.
+func WithDeadLetterTopic(topic string) Option {
+	return func(p *PubSub) error {
+		if topic == "" {
+			return errors.New("dead letter topic cannot be empty")
+		}
+		p.deadLetter = topic
+		return nil
+	}
+}
+
+// WithLogger attaches a zap logger.
+func WithLogger(logger *zap.Logger) Option {
+	return shared(transport.WithLogger(logger))
+}
+
+// WithRegistry uses the provided event registry instead of the
+// ship.DefaultRegistry.
+func WithRegistry(registry *ship.Registry) Option {
+	return shared(transport.WithRegistry(registry))
+}
+
+// WithDebeziumColumns changes the columns of the Debezium outbox table, see
+// pipeline.Pipeline.SetDebeziumColumns.
+func WithDebeziumColumns(columns debezium.Columns) Option {
+	return shared(transport.WithDebeziumColumns(columns))
+}
+
+// WithCodec changes the codec of the published messages, see
+// pipeline.Pipeline.SetCodec.
+func WithCodec(codec ship.Codec) Option {
+	return shared(transport.WithCodec(codec))
+}
+
+// WithCompression compresses the published messages, see
+// pipeline.Pipeline.SetCompression.
+func WithCompression(c compress.Compressor, threshold int) Option {
+	return shared(transport.WithCompression(c, threshold))
+}
+
+// WithClaimCheck checks the oversized published messages in to the store,
+// see pipeline.Pipeline.SetClaimCheck.
+func WithClaimCheck(store claimcheck.Store, threshold int) Option {
+	return shared(transport.WithClaimCheck(store, threshold))
+}
+
+// WithEncryption encrypts the published messages, see
+// pipeline.Pipeline.SetEncryption.
+func WithEncryption(kp encryption.KeyProvider, keyID string) Option {
+	return shared(transport.WithEncryption(kp, keyID))
+}
+
+// WithTracerProvider changes the tracer provider, see
+// tracing.WithTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return shared(transport.WithTracerProvider(tp))
+}
+
+// WithPropagator changes the trace context propagator, see
+// tracing.WithPropagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return shared(transport.WithPropagator(propagator))
+}
+
+// WithMetrics reports the metrics of the client to the recorder, see
+// metrics.Recorder. Default recorder is metrics.Nop.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return shared(transport.WithMetrics(recorder))
+}
+
+// PubSub is a wrapper over a Kafka client.
+type PubSub struct {
+	config        *sarama.Config
+	client        sarama.Client
+	producer      sarama.SyncProducer
+	subscriptions map[string][]string
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	maxAttempts   int
+	deadLetter    string
+	logger        *zap.Logger
+	errCh         chan error
+	pipeline      *pipeline.Pipeline
+	processor     *transport.Processor
+
+	tracer      *tracing.Tracer
+	tracingOpts []tracing.Option
+
+	metrics metrics.Recorder
+
+	health transport.Health
+
+	// receiveCtx is cancelled to stop consuming messages.
+	receiveCtx    context.Context
+	receiveCancel context.CancelFunc
+	// handlerCtx is passed to the handlers.
+	handlerCtx    context.Context
+	handlerCancel context.CancelFunc
+
+	// lifecycle rejects new subscriptions once Stop is called.
+	lifecycle transport.Lifecycle
+}
+
+const errorBufferLimit = 10
+
+// tracingSystem is the messaging system reported in spans.
+const tracingSystem = "kafka"
+
+// logIDKey is the log field of the message ids.
+const logIDKey = "kafkaMessageId"
+
+// NewConfig returns the default sarama configuration of the client.
+//
+// New consumer groups start from the oldest offset, so no message published
+// before the first subscription is lost.
+func NewConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.ClientID = "ship"
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	return config
+}
+
+// NewClient creates an instance of Kafka PubSub connected to the brokers.
+// All methods are thread-safe until mentioned specifically.
+func NewClient(brokers []string, options ...Option) (*PubSub, error) {
+	p := &PubSub{
+		config:        NewConfig(),
+		subscriptions: make(map[string][]string),
+		minBackoff:    DefaultMinBackoff,
+		maxBackoff:    DefaultMaxBackoff,
+		logger:        zap.NewNop(),
+		errCh:         make(chan error, errorBufferLimit),
+		pipeline:      pipeline.New(),
+		metrics:       metrics.Nop{},
+	}
+
+	// Apply configuration options.
+	for _, opt := range options {
+		if opt == nil {
+			continue
+		}
+		if err := opt(p); err != nil {
+			return nil, errors.Wrap(err, "could not apply option")
+		}
+	}
+
+	p.tracer = tracing.New(tracingSystem, p.tracingOpts...)
+	p.processor = &transport.Processor{
+		Pipeline: p.pipeline,
+		Tracer:   p.tracer,
+		Metrics:  p.metrics,
+		Logger:   p.logger,
+		IDKey:    logIDKey,
+	}
+
+	// Messages headers require Kafka 0.11.
+	if !p.config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, errors.Errorf(
+			"kafka version %s does not support message headers", p.config.Version,
+		)
+	}
+	p.config.Producer.Return.Successes = true
+	p.config.Consumer.Offsets.AutoCommit.Enable = true
+
+	p.receiveCtx, p.receiveCancel = context.WithCancel(context.Background())
+	p.handlerCtx, p.handlerCancel = context.WithCancel(context.Background())
+
+	p.logger.Info("creating a kafka client", zap.Strings("brokers", brokers))
+	client, err := sarama.NewClient(brokers, p.config)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create kafka client")
+	}
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, errors.Wrap(err, "unable to create kafka producer")
+	}
+
+	p.client = client
+	p.producer = producer
+
+	return p, nil
+}
+
+// Stop stops the pubsub gracefully.
+//
+// The context passed to the in-flight handlers is cancelled right away, then
+// it waits for them to return, leaves the consumer groups and closes the
+// producer. It returns ErrStopped if the pubsub is already stopped.
+func (p *PubSub) Stop() error {
+	if err := p.lifecycle.Stop(); err != nil {
+		return err
+	}
+
+	p.logger.Debug("cancelling handlers and receive contexts")
+	p.handlerCancel()
+	p.receiveCancel()
+
+	p.logger.Debug("waiting for the subscriptions to stop")
+	p.lifecycle.Wait()
+
+	producerErr := p.producer.Close()
+
+	if err := p.client.Close(); err != nil {
+		return errors.Wrap(err, "unable to close kafka client")
+	}
+
+	if producerErr != nil {
+		return errors.Wrap(producerErr, "unable to close kafka producer")
+	}
+
+	return nil
+}
+
+// Health returns the health of the client and of every subscription.
+func (p *PubSub) Health() ship.Health {
+	return p.health.Get(p.lifecycle.Stopped())
+}
@@ -0,0 +1,289 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const (
+	testTopic = "some-topic"
+	testGroup = "some-subscription"
+)
+
+func TestNewClient(t *testing.T) {
+	oldConfig := NewConfig()
+	oldConfig.Version = sarama.V0_10_2_0
+
+	config := NewConfig()
+	config.Producer.Return.Successes = false
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	testCases := []struct {
+		name         string
+		opts         []Option
+		checkReturns func(*testing.T, *PubSub, error)
+	}{
+		{
+			name: "should return error: could not apply option",
+			opts: []Option{
+				func(ps *PubSub) error {
+					return errors.New("some error")
+				},
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "could not apply option")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: config cannot be nil",
+			opts: []Option{WithConfig(nil)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "config cannot be nil")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: subscription has no topic",
+			opts: []Option{WithSubscription(testGroup)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "subscription some-subscription has no topic")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid retry backoff",
+			opts: []Option{WithRetryBackoff(time.Second, time.Millisecond)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid retry backoff")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid max attempts",
+			opts: []Option{WithMaxAttempts(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid max attempts 0")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: dead letter topic cannot be empty",
+			opts: []Option{WithDeadLetterTopic("")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "dead letter topic cannot be empty")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: kafka version does not support headers",
+			opts: []Option{WithConfig(oldConfig)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "does not support message headers")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should leave the provided config untouched",
+			opts: []Option{WithConfig(config)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.NoError(t, err)
+				assert.True(t, ps.config.Producer.Return.Successes)
+				assert.True(t, ps.config.Consumer.Offsets.AutoCommit.Enable)
+				assert.NoError(t, ps.Stop())
+
+				assert.False(t, config.Producer.Return.Successes)
+				assert.False(t, config.Consumer.Offsets.AutoCommit.Enable)
+			},
+		},
+		{
+			name: "should return a new client",
+			opts: []Option{
+				nil,
+				WithLogger(zap.NewNop()),
+				WithRegistry(ship.NewRegistry()),
+				WithSubscription(testGroup, testTopic),
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.NoError(t, err)
+				assert.NotNil(t, ps)
+				assert.NoError(t, ps.Stop())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			broker := newTestBroker(t, nil)
+			defer broker.Close()
+
+			client, err := NewClient([]string{broker.Addr()}, tc.opts...)
+			tc.checkReturns(t, client, err)
+		})
+	}
+}
+
+func TestPubSub_Stop(t *testing.T) {
+	suite := newTestSuite(t, nil)
+	defer suite.broker.Close()
+
+	assert.NoError(t, suite.client.Stop())
+	assert.True(t, suite.client.Health().Stopped)
+	assert.ErrorIs(t, suite.client.Stop(), ErrStopped)
+}
+
+type suite struct {
+	broker *sarama.MockBroker
+	client *PubSub
+}
+
+// Teardown teardowns the test suite.
+func (s *suite) Teardown(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), 10*time.Second)
+	defer timeout()
+
+	done := make(chan struct{})
+
+	go func(t *testing.T) {
+		err := s.client.Stop()
+		assert.NoError(t, err)
+
+		s.broker.Close()
+
+		close(done)
+	}(t)
+
+	select {
+	case <-ctx.Done():
+		assert.Fail(t, "unable to stop client properly")
+	case <-done:
+	}
+}
+
+// newTestSuite returns a test suite for easier testing, the broker serves the
+// fetch response to the testGroup subscription consuming testTopic.
+func newTestSuite(t *testing.T, fetch *sarama.FetchResponse, opts ...Option) *suite {
+	t.Helper()
+
+	broker := newTestBroker(t, fetch)
+
+	config := NewConfig()
+	config.Metadata.Retry.Backoff = 10 * time.Millisecond
+	config.Consumer.Retry.Backoff = 10 * time.Millisecond
+	config.Consumer.Group.Rebalance.Retry.Backoff = 10 * time.Millisecond
+	config.Consumer.Offsets.AutoCommit.Interval = 10 * time.Millisecond
+
+	client, err := NewClient(
+		[]string{broker.Addr()},
+		append([]Option{WithConfig(config), WithSubscription(testGroup, testTopic)}, opts...)...,
+	)
+	assert.NoError(t, err)
+
+	return &suite{
+		broker: broker,
+		client: client,
+	}
+}
+
+// newTestBroker returns a Kafka broker fake hosting testTopic with a single
+// partition, and coordinating the testGroup consumer group.
+//
+// The consumer group has a single member consuming the partition.
+func newTestBroker(t *testing.T, fetch *sarama.FetchResponse) *sarama.MockBroker {
+	t.Helper()
+
+	broker := sarama.NewMockBroker(t, 1)
+
+	if fetch == nil {
+		fetch = &sarama.FetchResponse{Version: 4}
+		fetch.AddError(testTopic, 0, sarama.ErrNoError)
+	}
+
+	var newest int64
+	if block := fetch.GetBlock(testTopic, 0); block != nil {
+		newest = block.HighWaterMarkOffset
+	}
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, testGroup, broker),
+		"JoinGroupRequest": sarama.NewMockJoinGroupResponse(t).
+			SetGenerationId(1).
+			SetGroupProtocol(sarama.RangeBalanceStrategyName).
+			SetLeaderId("some-leader").
+			SetMemberId("some-member"),
+		"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).
+			SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+				Topics: map[string][]int32{testTopic: {0}},
+			}),
+		"HeartbeatRequest":  sarama.NewMockHeartbeatResponse(t),
+		"LeaveGroupRequest": sarama.NewMockLeaveGroupResponse(t),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(testGroup, testTopic, 0, -1, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset(testTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(testTopic, 0, sarama.OffsetNewest, newest),
+		"FetchRequest":        sarama.NewMockWrapper(fetch),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	return broker
+}
+
+// newFetchResponse returns a fetch response serving the messages of testTopic,
+// from offset 0.
+func newFetchResponse(messages ...*ship.RawMessage) *sarama.FetchResponse {
+	fetch := &sarama.FetchResponse{Version: 4}
+	fetch.AddError(testTopic, 0, sarama.ErrNoError)
+
+	for i, m := range messages {
+		var key sarama.Encoder
+		if m.OrderingKey != "" {
+			key = sarama.StringEncoder(m.OrderingKey)
+		}
+		fetch.AddRecord(testTopic, 0, key, sarama.ByteEncoder(m.Data), int64(i))
+
+		batch := fetch.GetBlock(testTopic, 0).RecordsSet[0].RecordBatch
+		record := batch.Records[len(batch.Records)-1]
+		for k, v := range m.Attributes {
+			record.Headers = append(record.Headers, &sarama.RecordHeader{
+				Key:   []byte(k),
+				Value: []byte(v),
+			})
+		}
+	}
+
+	block := fetch.GetBlock(testTopic, 0)
+	block.HighWaterMarkOffset = int64(len(messages))
+	block.LastStableOffset = int64(len(messages))
+
+	return fetch
+}
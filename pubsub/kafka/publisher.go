@@ -0,0 +1,127 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Publisher = (*PubSub)(nil)
+
+// Publish publishes the message to a given topic.
+//
+// The message data is encoded with the configured codec and the message fields
+// are sent as headers, see ship.MarshalMessage. Messages of an aggregate are
+// published to the same partition, so they are received in order.
+func (p *PubSub) Publish(topic string, message *ship.Message) error {
+	return p.PublishContext(context.Background(), topic, message)
+}
+
+// PublishContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message headers.
+func (p *PubSub) PublishContext(
+	ctx context.Context, topic string, message *ship.Message,
+) error {
+	raw, err := p.pipeline.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal message to bytes")
+	}
+
+	return p.publish(ctx, topic, raw)
+}
+
+// PublishRaw publishes the message to a given topic.
+//
+// The message is partitioned by its ordering key.
+func (p *PubSub) PublishRaw(topic string, message *ship.RawMessage) error {
+	return p.PublishRawContext(context.Background(), topic, message)
+}
+
+// PublishRawContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message headers.
+func (p *PubSub) PublishRawContext(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) error {
+	return p.publish(ctx, topic, message)
+}
+
+// publish wraps the raw message and publishes it to a given topic.
+func (p *PubSub) publish(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) (err error) {
+	start := time.Now()
+	ctx, span := p.tracer.StartPublish(ctx, topic)
+	defer func() {
+		tracing.End(span, err)
+		if err != nil {
+			p.metrics.PublishFailed(topic, time.Since(start))
+			return
+		}
+		p.metrics.PublishSucceeded(topic, time.Since(start))
+	}()
+
+	key := partitionKey(message)
+
+	message, err = p.pipeline.Wrap(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "unable to wrap message")
+	}
+
+	attrs := p.tracer.Inject(ctx, message.Attributes)
+	headers := make([]sarama.RecordHeader, 0, len(attrs))
+	for k, v := range attrs {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(message.Data),
+		Headers: headers,
+	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+
+	p.logger.Debug(
+		"publishing message to topic", zap.String("topic", topic), zap.String("key", key),
+	)
+	partition, offset, err := p.producer.SendMessage(msg)
+	if err != nil {
+		p.logger.Error(
+			"unable to publish message",
+			zap.Error(err),
+			zap.String("topic", topic),
+		)
+		return errors.Wrap(err, "could not publish message")
+	}
+
+	tracing.SetMessageID(span, messageID(topic, partition, offset))
+
+	return nil
+}
+
+// partitionKey returns the key partitioning a message: its ordering key or,
+// if it has none, its aggregate id.
+func partitionKey(message *ship.RawMessage) string {
+	if message.OrderingKey != "" {
+		return message.OrderingKey
+	}
+
+	return message.Attributes[ship.AggregateIDKey]
+}
+
+// messageID returns the id of a Kafka message, which is unique per cluster.
+func messageID(topic string, partition int32, offset int64) string {
+	return fmt.Sprintf("%s/%d/%d", topic, partition, offset)
+}
@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testEvent) EventName() string { return "TestEvent" }
+
+// mockProducer replaces the producer of the client with a mock, so the
+// produced messages can be checked.
+func (s *suite) mockProducer(t *testing.T) *mocks.SyncProducer {
+	t.Helper()
+
+	assert.NoError(t, s.client.producer.Close())
+
+	producer := mocks.NewSyncProducer(t, nil)
+	s.client.producer = producer
+
+	return producer
+}
+
+// headers returns the headers of a produced message as a map.
+func headers(msg *sarama.ProducerMessage) map[string]string {
+	h := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		h[string(header.Key)] = string(header.Value)
+	}
+	return h
+}
+
+// encoded returns the bytes of an encoder.
+func encoded(t *testing.T, e sarama.Encoder) string {
+	t.Helper()
+
+	if e == nil {
+		return ""
+	}
+
+	b, err := e.Encode()
+	assert.NoError(t, err)
+
+	return string(b)
+}
+
+func TestPubSub_Publish(t *testing.T) {
+	testCases := []struct {
+		name    string
+		publish func(p *PubSub) error
+		check   func(t *testing.T, msg *sarama.ProducerMessage)
+	}{
+		{
+			name: "should publish a message partitioned by its aggregate id",
+			publish: func(p *PubSub) error {
+				return p.Publish(testTopic, &ship.Message{
+					ID:          "some-id",
+					AggregateID: "some-aggregate-id",
+					Data:        &testEvent{Name: "ship"},
+				})
+			},
+			check: func(t *testing.T, msg *sarama.ProducerMessage) {
+				assert.Equal(t, testTopic, msg.Topic)
+				assert.Equal(t, "some-aggregate-id", encoded(t, msg.Key))
+				assert.JSONEq(t, `{"name": "ship"}`, encoded(t, msg.Value))
+
+				h := headers(msg)
+				assert.Equal(t, "some-id", h[ship.IDKey])
+				assert.Equal(t, "TestEvent", h[ship.TypeKey])
+				assert.Equal(t, "application/json", h[ship.ContentTypeKey])
+			},
+		},
+		{
+			name: "should publish a raw message partitioned by its ordering key",
+			publish: func(p *PubSub) error {
+				return p.PublishRaw(testTopic, &ship.RawMessage{
+					Data:        []byte("some-data"),
+					Attributes:  map[string]string{"some-key": "some-value"},
+					OrderingKey: "some-ordering-key",
+				})
+			},
+			check: func(t *testing.T, msg *sarama.ProducerMessage) {
+				assert.Equal(t, "some-ordering-key", encoded(t, msg.Key))
+				assert.Equal(t, "some-data", encoded(t, msg.Value))
+				assert.Equal(t, "some-value", headers(msg)["some-key"])
+			},
+		},
+		{
+			name: "should publish a raw message without key",
+			publish: func(p *PubSub) error {
+				return p.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+			},
+			check: func(t *testing.T, msg *sarama.ProducerMessage) {
+				assert.Nil(t, msg.Key)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, nil)
+			defer suite.Teardown(t)
+
+			suite.mockProducer(t).ExpectSendMessageWithMessageCheckerFunctionAndSucceed(
+				func(msg *sarama.ProducerMessage) error {
+					tc.check(t, msg)
+					return nil
+				},
+			)
+
+			assert.NoError(t, tc.publish(suite.client))
+		})
+	}
+}
+
+func TestPubSub_PublishError(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newTestSuite(t, nil, WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	suite.mockProducer(t).ExpectSendMessageAndFail(errors.New("some error"))
+
+	err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not publish message")
+	assert.Equal(t, 1, recorder.count("publish_failed:"+testTopic))
+}
+
+func TestPubSub_PublishTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	suite := newTestSuite(t, nil, WithTracerProvider(tp))
+	defer suite.Teardown(t)
+
+	suite.mockProducer(t).ExpectSendMessageWithMessageCheckerFunctionAndSucceed(
+		func(msg *sarama.ProducerMessage) error {
+			assert.Contains(t, headers(msg), "traceparent")
+			return nil
+		},
+	)
+
+	err := suite.client.PublishRawContext(
+		context.Background(), testTopic, &ship.RawMessage{Data: []byte("some-data")},
+	)
+	assert.NoError(t, err)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, testTopic+" send", spans[0].Name())
+}
@@ -0,0 +1,427 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Subscriber = (*PubSub)(nil)
+
+// ErrStopped is returned when subscribing to or stopping a stopped pubsub.
+var ErrStopped = transport.ErrStopped
+
+// Headers of the messages published to the dead letter topic, in addition to
+// their own headers.
+const (
+	// DeadSubscriptionHeader holds the subscription which failed to process
+	// the message.
+	DeadSubscriptionHeader = "dead_letter_subscription"
+
+	// DeadTopicHeader, DeadPartitionHeader and DeadOffsetHeader hold the
+	// position of the message.
+	DeadTopicHeader     = "dead_letter_topic"
+	DeadPartitionHeader = "dead_letter_partition"
+	DeadOffsetHeader    = "dead_letter_offset"
+
+	// DeadErrorHeader holds the error of the last attempt.
+	DeadErrorHeader = "dead_letter_error"
+)
+
+// topics returns the topics consumed by a subscription.
+func (p *PubSub) topics(subscription string) []string {
+	if topics, ok := p.subscriptions[subscription]; ok {
+		return topics
+	}
+
+	return []string{subscription}
+}
+
+// subInit checks for existence of the topics of a subscription and creates its
+// consumer group.
+// If a topic does not exists, it will throw error.
+func (p *PubSub) subInit(subscription string) (sarama.ConsumerGroup, []string, error) {
+	topics := p.topics(subscription)
+
+	p.logger.Info(
+		"checking if subscription topics exist",
+		zap.String("name", subscription),
+		zap.Strings("topics", topics),
+	)
+	for _, topic := range topics {
+		if _, err := p.client.Partitions(topic); err != nil {
+			return nil, nil, errors.Wrapf(err, "topic %s does not exists", topic)
+		}
+	}
+
+	group, err := sarama.NewConsumerGroupFromClient(subscription, p.client)
+	if err != nil {
+		return nil, nil, errors.Wrapf(
+			err, "unable to create consumer group for subscription %s", subscription,
+		)
+	}
+
+	return group, topics, nil
+}
+
+// Subscribe subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The subscription joins the consumer group of the same name. A message which
+// the handler fails to process is retried with a backoff, blocking its
+// partition, until it is processed or it reached the max attempts, see
+// WithMaxAttempts. The offset of a message is marked once it is processed,
+// could not be decoded or was dead-lettered, and committed periodically.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+//
+// Example:
+//
+//	pubsub, err := kafka.NewClient([]string{"localhost:9092"})
+//	if err != nil {
+//		// do something with error
+//		return
+//	}
+//
+//	pubsub.Subscribe("some-subscription-name", handler)
+//	pubsub.Subscribe("some-subscription-name2", handler2)
+func (p *PubSub) Subscribe(
+	subscription string, handler ship.MessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.Handler(handler),
+	)
+}
+
+// SubscribeRaw subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The messages are retried, dead-lettered and committed as in Subscribe.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+func (p *PubSub) SubscribeRaw(
+	subscription string, handler ship.RawMessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.RawHandler(handler),
+	)
+}
+
+// subscribe starts consuming the topics of a subscription with process.
+func (p *PubSub) subscribe(subscription, hName string, process transport.ProcessFunc) error {
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+
+	group, topics, err := p.subInit(subscription)
+	if err != nil {
+		p.lifecycle.Release()
+		return errors.WithStack(err)
+	}
+
+	health := p.health.Track(subscription, hName)
+
+	p.logger.Info(
+		"starting listener for subscription", zap.String("subscription", subscription),
+	)
+	go p.consume(group, topics, health, process)
+
+	return nil
+}
+
+// consume consumes the topics with the consumer group, until the pubsub is
+// stopped or the handler panics.
+func (p *PubSub) consume(
+	group sarama.ConsumerGroup,
+	topics []string,
+	health *transport.SubscriptionHealth,
+	process transport.ProcessFunc,
+) {
+	defer p.lifecycle.Release()
+
+	subID := health.Get().Subscription
+
+	// Creating a context with cancel so, we can cancel the subscription in case
+	// of panic.
+	ctx, cancel := context.WithCancel(p.receiveCtx)
+	defer cancel()
+
+	h := &groupHandler{
+		p:       p,
+		subID:   subID,
+		hName:   health.Get().Handler,
+		health:  health,
+		cancel:  cancel,
+		process: process,
+	}
+
+	p.logger.Debug(
+		"subscription started",
+		zap.String("subscription", subID),
+		zap.String("handlerName", h.hName),
+	)
+
+	// Consume returns at every rebalance of the consumer group, it must be
+	// called again to get the new claims.
+	for ctx.Err() == nil {
+		if err := group.Consume(ctx, topics, h); err != nil {
+			p.logger.Error(
+				"unable to consume messages from subscription",
+				zap.Error(err),
+				zap.String("subscription", subID),
+			)
+			transport.ReportError(p.errCh, err)
+
+			select {
+			case <-time.After(p.minBackoff):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	if err := group.Close(); err != nil {
+		p.logger.Error("unable to close consumer group", zap.Error(err))
+	}
+	health.Stopped(nil)
+}
+
+// groupHandler handles the claims of a consumer group session.
+type groupHandler struct {
+	p       *PubSub
+	subID   string
+	hName   string
+	health  *transport.SubscriptionHealth
+	cancel  context.CancelFunc
+	process transport.ProcessFunc
+}
+
+// Setup is run at the beginning of a new session.
+func (h *groupHandler) Setup(sarama.ConsumerGroupSession) error { return nil }
+
+// Cleanup is run at the end of a session.
+func (h *groupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim processes the messages of a partition one at a time, until the
+// session ends.
+func (h *groupHandler) ConsumeClaim(
+	session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim,
+) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+
+			if !h.handle(session, msg) {
+				return nil
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// handle processes a message until it is acknowledged or dead-lettered and
+// marks its offset.
+//
+// It reports false when the session ended or the handler panicked, leaving
+// the message unmarked.
+func (h *groupHandler) handle(
+	session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage,
+) bool {
+	p := h.p
+
+	h.health.SetLastMessageAt(time.Now())
+
+	raw := &ship.RawMessage{
+		ID:          messageID(msg.Topic, msg.Partition, msg.Offset),
+		Attributes:  make(map[string]string, len(msg.Headers)),
+		Data:        msg.Value,
+		PublishTime: msg.Timestamp,
+		OrderingKey: string(msg.Key),
+	}
+	for _, header := range msg.Headers {
+		raw.Attributes[string(header.Key)] = string(header.Value)
+	}
+
+	backoff := p.minBackoff
+	for attempt := 1; ; attempt++ {
+		ok, err := h.safeProcess(raw)
+		if !ok {
+			return false
+		}
+
+		if err == nil {
+			session.MarkMessage(msg, "")
+			p.metrics.Acked(h.subID, h.hName)
+			return true
+		}
+
+		p.metrics.Nacked(h.subID, h.hName)
+
+		if p.maxAttempts > 0 && attempt >= p.maxAttempts {
+			return h.deadLetter(session, msg, err)
+		}
+
+		p.logger.Debug(
+			"retrying message",
+			zap.String(logIDKey, raw.ID),
+			zap.Duration("backoff", backoff),
+		)
+		if !wait(session, backoff) {
+			return false
+		}
+		backoff = p.nextBackoff(backoff)
+	}
+}
+
+// deadLetter publishes a message which reached the max attempts to the dead
+// letter topic, until it succeeds, and marks its offset. The message is
+// skipped if there is no dead letter topic.
+//
+// It reports false when the session ended, leaving the message unmarked.
+func (h *groupHandler) deadLetter(
+	session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, cause error,
+) bool {
+	p := h.p
+	id := messageID(msg.Topic, msg.Partition, msg.Offset)
+
+	if p.deadLetter == "" {
+		p.logger.Error(
+			"message failed to be processed too many times: skipping it",
+			zap.Error(cause),
+			zap.String(logIDKey, id),
+			zap.String("subscription", h.subID),
+		)
+		session.MarkMessage(msg, "")
+		p.metrics.DeadLettered(h.subID, h.hName)
+		return true
+	}
+
+	backoff := p.minBackoff
+	for {
+		_, _, err := p.producer.SendMessage(h.deadLetterMessage(msg, cause))
+		if err == nil {
+			break
+		}
+
+		p.logger.Error(
+			"unable to publish message to the dead letter topic",
+			zap.Error(err),
+			zap.String(logIDKey, id),
+			zap.String("topic", p.deadLetter),
+		)
+		if !wait(session, backoff) {
+			return false
+		}
+		backoff = p.nextBackoff(backoff)
+	}
+
+	p.logger.Warn(
+		"message failed to be processed too many times: published it to the dead letter topic",
+		zap.Error(cause),
+		zap.String(logIDKey, id),
+		zap.String("topic", p.deadLetter),
+	)
+	session.MarkMessage(msg, "")
+	p.metrics.DeadLettered(h.subID, h.hName)
+	return true
+}
+
+// deadLetterMessage returns the copy of a message published to the dead
+// letter topic.
+func (h *groupHandler) deadLetterMessage(
+	msg *sarama.ConsumerMessage, cause error,
+) *sarama.ProducerMessage {
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, header := range msg.Headers {
+		headers = append(headers, *header)
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(DeadSubscriptionHeader), Value: []byte(h.subID)},
+		sarama.RecordHeader{Key: []byte(DeadTopicHeader), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{
+			Key: []byte(DeadPartitionHeader), Value: []byte(strconv.Itoa(int(msg.Partition))),
+		},
+		sarama.RecordHeader{
+			Key: []byte(DeadOffsetHeader), Value: []byte(strconv.FormatInt(msg.Offset, 10)),
+		},
+		sarama.RecordHeader{Key: []byte(DeadErrorHeader), Value: []byte(cause.Error())},
+	)
+
+	m := &sarama.ProducerMessage{
+		Topic:   h.p.deadLetter,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		m.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	return m
+}
+
+// nextBackoff doubles the backoff, up to the max backoff.
+func (p *PubSub) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > p.maxBackoff {
+		return p.maxBackoff
+	}
+	return backoff
+}
+
+// wait waits for the backoff, it reports false if the session ended first.
+func wait(session sarama.ConsumerGroupSession, backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-session.Context().Done():
+		return false
+	}
+}
+
+// safeProcess processes a message and returns the error of the handler, it
+// reports false when the handler panicked.
+func (h *groupHandler) safeProcess(raw *ship.RawMessage) (ok bool, err error) {
+	p := h.p
+
+	// We don't want an unexpected error in consumer to take down whole application.
+	// We'll try to recover from the panic and remove the subscription from listening.
+	//
+	// This protects an application from going in a continuous crash loop in a
+	// orchestrated environment.
+	defer func() {
+		if r := recover(); r != nil {
+			h.health.Recovered(
+				p.logger, r,
+				"Leaving the message uncommitted and removing the subscription from listening.",
+				zap.String("kafkaMessageId", raw.ID),
+			)
+			p.metrics.Nacked(h.subID, h.hName)
+
+			// Cancel the context will remove stop the subscription from receiving messages.
+			h.cancel()
+			ok = false
+		}
+	}()
+
+	// The handlers are given their own context, so they are not cancelled by
+	// a rebalance.
+	return true, h.process(p.handlerCtx, h.subID, h.hName, raw)
+}
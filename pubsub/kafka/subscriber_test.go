@@ -0,0 +1,373 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+// testRecorder is a metrics.Recorder counting the recorded metrics.
+type testRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{counts: make(map[string]int)}
+}
+
+func (r *testRecorder) inc(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+func (r *testRecorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+func (r *testRecorder) PublishSucceeded(topic string, _ time.Duration) {
+	r.inc("published:" + topic)
+}
+
+func (r *testRecorder) PublishFailed(topic string, _ time.Duration) {
+	r.inc("publish_failed:" + topic)
+}
+
+func (r *testRecorder) Handled(sub, handler string, _ time.Duration) {
+	r.inc("handled:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Acked(sub, handler string) {
+	r.inc("acked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Nacked(sub, handler string) {
+	r.inc("nacked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DeadLettered(sub, handler string) {
+	r.inc("dead_lettered:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DecodeFailed(sub, handler, reason string) {
+	r.inc("decode_failed:" + sub + ":" + handler + ":" + reason)
+}
+
+type rawHandlerFunc func(context.Context, *ship.RawMessage) error
+
+func (f rawHandlerFunc) HandleRawMessage(ctx context.Context, m *ship.RawMessage) error {
+	return f(ctx, m)
+}
+
+// committed returns the last offset committed on the partition of testTopic,
+// -1 when no offset was committed.
+func committed(broker *sarama.MockBroker) int64 {
+	offset := int64(-1)
+	for _, rr := range broker.History() {
+		req, ok := rr.Request.(*sarama.OffsetCommitRequest)
+		if !ok {
+			continue
+		}
+		if o, _, err := req.Offset(testTopic, 0); err == nil && o > offset {
+			offset = o
+		}
+	}
+	return offset
+}
+
+// envelope returns a ship envelope of the event.
+func envelope(t *testing.T, id string, event ship.Event) *ship.RawMessage {
+	t.Helper()
+
+	raw, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{ID: id, Data: event})
+	assert.NoError(t, err)
+
+	return raw
+}
+
+func TestPubSub_Subscribe(t *testing.T) {
+	debezium, err := os.ReadFile("../../debezium/testdata/valid_data.fixture")
+	assert.NoError(t, err)
+
+	fetch := newFetchResponse(
+		envelope(t, "some-id", &userCreated{Email: "someone@flahmingo.com"}),
+		envelope(t, "some-other-id", &testEvent{Name: "unregistered"}),
+		&ship.RawMessage{Data: debezium},
+		envelope(t, "some-last-id", &userCreated{Email: "someone@flahmingo.com"}),
+	)
+
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&userCreated{}))
+
+	recorder := newTestRecorder()
+	suite := newTestSuite(
+		t, fetch,
+		WithRegistry(registry),
+		WithMetrics(recorder),
+		WithRetryBackoff(time.Millisecond, 10*time.Millisecond),
+	)
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		received []string
+		failed   bool
+	)
+	handler := ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first delivery of the last message fails, it must be retried.
+		if m.ID == "some-last-id" && !failed {
+			failed = true
+			return errors.New("some error")
+		}
+
+		received = append(received, m.ID)
+		return nil
+	})
+
+	err = suite.client.Subscribe(testGroup, handler)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return committed(suite.broker) == 4
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []string{
+		"some-id",
+		"e79e906a-5022-473f-9a67-ff0993851be9",
+		"some-last-id",
+	}, received)
+	mu.Unlock()
+
+	hName := "MessageHandlerFunc"
+	assert.Equal(t, 4, recorder.count("acked:"+testGroup+":"+hName))
+	assert.Equal(t, 1, recorder.count("nacked:"+testGroup+":"+hName))
+	assert.Equal(
+		t, 1, recorder.count("decode_failed:"+testGroup+":"+hName+":"+ship.DecodeReasonUnregistered),
+	)
+}
+
+func TestPubSub_SubscribeRaw(t *testing.T) {
+	fetch := newFetchResponse(&ship.RawMessage{
+		Data:        []byte("some-data"),
+		Attributes:  map[string]string{"some-key": "some-value"},
+		OrderingKey: "some-ordering-key",
+	})
+
+	suite := newTestSuite(t, fetch)
+	defer suite.Teardown(t)
+
+	received := make(chan *ship.RawMessage, 1)
+	err := suite.client.SubscribeRaw(
+		testGroup,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	select {
+	case m := <-received:
+		assert.Equal(t, testTopic+"/0/0", m.ID)
+		assert.Equal(t, []byte("some-data"), m.Data)
+		assert.Equal(t, "some-ordering-key", m.OrderingKey)
+		assert.Equal(t, "some-value", m.Attributes["some-key"])
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+
+	assert.Eventually(t, func() bool {
+		return committed(suite.broker) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_SubscribeMaxAttempts(t *testing.T) {
+	fetch := newFetchResponse(
+		&ship.RawMessage{
+			Data:        []byte("some-data"),
+			Attributes:  map[string]string{"some-key": "some-value"},
+			OrderingKey: "some-ordering-key",
+		},
+		&ship.RawMessage{Data: []byte("some-other-data")},
+	)
+
+	testCases := []struct {
+		name       string
+		opts       []Option
+		deadLetter bool
+	}{
+		{
+			name:       "should publish the message to the dead letter topic",
+			opts:       []Option{WithDeadLetterTopic("some-dead-letter-topic")},
+			deadLetter: true,
+		},
+		{
+			name: "should skip the message: no dead letter topic",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder()
+			suite := newTestSuite(
+				t, fetch,
+				append([]Option{
+					WithMetrics(recorder),
+					WithMaxAttempts(3),
+					WithRetryBackoff(time.Millisecond, time.Millisecond),
+				}, tc.opts...)...,
+			)
+			defer suite.Teardown(t)
+
+			if tc.deadLetter {
+				suite.mockProducer(t).ExpectSendMessageWithMessageCheckerFunctionAndSucceed(
+					func(msg *sarama.ProducerMessage) error {
+						assert.Equal(t, "some-dead-letter-topic", msg.Topic)
+						assert.Equal(t, "some-data", encoded(t, msg.Value))
+						assert.Equal(t, "some-ordering-key", encoded(t, msg.Key))
+						assert.Equal(t, map[string]string{
+							"some-key":             "some-value",
+							DeadSubscriptionHeader: testGroup,
+							DeadTopicHeader:        testTopic,
+							DeadPartitionHeader:    "0",
+							DeadOffsetHeader:       "0",
+							DeadErrorHeader:        "some error",
+						}, headers(msg))
+						return nil
+					},
+				)
+			}
+
+			var (
+				mu       sync.Mutex
+				attempts = make(map[string]int)
+			)
+			err := suite.client.SubscribeRaw(
+				testGroup,
+				rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+					mu.Lock()
+					defer mu.Unlock()
+
+					attempts[string(m.Data)]++
+					if string(m.Data) == "some-data" {
+						return errors.New("some error")
+					}
+					return nil
+				}),
+			)
+			assert.NoError(t, err)
+
+			// The next message is processed once the failing one is given up.
+			assert.Eventually(t, func() bool {
+				return committed(suite.broker) == 2
+			}, 10*time.Second, 10*time.Millisecond)
+
+			mu.Lock()
+			assert.Equal(t, map[string]int{"some-data": 3, "some-other-data": 1}, attempts)
+			mu.Unlock()
+
+			hName := "rawHandlerFunc"
+			assert.Equal(t, 3, recorder.count("nacked:"+testGroup+":"+hName))
+			assert.Equal(t, 1, recorder.count("dead_lettered:"+testGroup+":"+hName))
+			assert.Equal(t, 1, recorder.count("acked:"+testGroup+":"+hName))
+		})
+	}
+}
+
+func TestPubSub_SubscribePanic(t *testing.T) {
+	fetch := newFetchResponse(envelope(t, "some-id", &testEvent{Name: "ship"}))
+
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&testEvent{}))
+
+	suite := newTestSuite(t, fetch, WithRegistry(registry))
+	defer suite.Teardown(t)
+
+	err := suite.client.Subscribe(
+		testGroup,
+		ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+			panic("some panic")
+		}),
+	)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		h := suite.client.Health()
+		return len(h.Subscriptions) == 1 &&
+			h.Subscriptions[0].State == ship.SubscriptionPanicked
+	}, 10*time.Second, 10*time.Millisecond)
+
+	h := suite.client.Health().Subscriptions[0]
+	assert.Equal(t, testGroup, h.Subscription)
+	assert.Equal(t, "some panic", h.Error)
+	assert.Equal(t, int64(-1), committed(suite.broker))
+}
+
+func TestPubSub_SubscribeErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *suite)
+		err   string
+	}{
+		{
+			name: "should return error: topic does not exists",
+			opts: []Option{WithSubscription(testGroup, "unknown-topic")},
+			err:  "topic unknown-topic does not exists",
+		},
+		{
+			name: "should return error: pubsub is stopped",
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.Stop())
+			},
+			err: ErrStopped.Error(),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, nil, tc.opts...)
+			defer suite.broker.Close()
+
+			if tc.setup != nil {
+				tc.setup(t, suite)
+			} else {
+				defer func() { assert.NoError(t, suite.client.Stop()) }()
+			}
+
+			err := suite.client.Subscribe(
+				testGroup,
+				ship.MessageHandlerFunc(func(context.Context, *ship.Message) error {
+					return nil
+				}),
+			)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
@@ -0,0 +1,319 @@
+// Package nats contains an implementation of ship.Publisher and
+// ship.Subscriber interface over NATS JetStream.
+//
+// A topic is a subject captured by a stream of its own, see EnsureTopics, and
+// a subscription is a durable consumer of the stream, consuming the subject of
+// the same name unless it is mapped to another topic with WithSubscription.
+package nats
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/debezium"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Default redelivery delay of the messages which failed to be processed.
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+// Compile time check.
+var _ ship.HealthChecker = (*PubSub)(nil)
+
+// Option is an option setter used to configure creation.
+type Option func(*PubSub) error
+
+// shared wraps an option shared by the transports.
+func shared(opt transport.Option) Option {
+	return func(p *PubSub) error {
+		return opt(transport.Settings{
+			Name:     "nats",
+			Pipeline: p.pipeline,
+			Tracing:  &p.tracingOpts,
+			Metrics:  &p.metrics,
+			Logger:   &p.logger,
+		})
+	}
+}
+
+// WithCreateTopic toggle stream creation if it does not exists.
+func WithCreateTopic(create bool) Option {
+	return func(p *PubSub) error {
+		p.createTopic = create
+		return nil
+	}
+}
+
+// WithCreateSubscription toggle durable consumer creation if it does not
+// exists.
+func WithCreateSubscription(create bool) Option {
+	return func(p *PubSub) error {
+		p.createSub = create
+		return nil
+	}
+}
+
+// WithSubscription maps a subscription to the topic consumed by its durable
+// consumer. By default, a subscription consumes the topic of the same name.
+func WithSubscription(subscription, topic string) Option {
+	return func(p *PubSub) error {
+		if topic == "" {
+			return errors.Errorf("subscription %s has no topic", subscription)
+		}
+		if _, err := streamName(topic); err != nil {
+			return err
+		}
+		p.subscriptions[subscription] = topic
+		return nil
+	}
+}
+
+// WithMaxDeliver limits the number of deliveries of a message by the durable
+// consumers created by the client. Default is unlimited.
+func WithMaxDeliver(n int) Option {
+	return func(p *PubSub) error {
+		if n <= 0 {
+			return errors.Errorf("invalid max deliver %d", n)
+		}
+		p.maxDeliver = n
+		return nil
+	}
+}
+
+// WithRetryBackoff changes the delay before a message which failed to be
+// processed is redelivered. The delay doubles after every delivery, from min
+// up to max.
+func WithRetryBackoff(min, max time.Duration) Option {
+	return func(p *PubSub) error {
+		if min <= 0 || max < min {
+			return errors.Errorf("invalid retry backoff [%s, %s]", min, max)
+		}
+		p.minBackoff = min
+		p.maxBackoff = max
+		return nil
+	}
+}
+
+// WithConnOptions passes the options to the NATS connection.
+//
+// A nats.ClosedHandler is overridden, the client uses it to wait for the
+// connection to be drained on Stop.
+func WithConnOptions(opts ...nats.Option) Option {
+	return func(p *PubSub) error {
+		p.connOpts = append(p.connOpts, opts...)
+		return nil
+	}
+}
+
+// WithLogger attaches a zap logger.
+func WithLogger(logger *zap.Logger) Option {
+	return shared(transport.WithLogger(logger))
+}
+
+// WithRegistry uses the provided event registry instead of the
+// ship.DefaultRegistry.
+func WithRegistry(registry *ship.Registry) Option {
+	return shared(transport.WithRegistry(registry))
+}
+
+// WithDebeziumColumns changes the columns of the Debezium outbox table, see
+// pipeline.Pipeline.SetDebeziumColumns.
+func WithDebeziumColumns(columns debezium.Columns) Option {
+	return shared(transport.WithDebeziumColumns(columns))
+}
+
+// WithCodec changes the codec of the published messages, see
+// pipeline.Pipeline.SetCodec.
+func WithCodec(codec ship.Codec) Option {
+	return shared(transport.WithCodec(codec))
+}
+
+// WithCompression compresses the published messages, see
+// pipeline.Pipeline.SetCompression.
+func WithCompression(c compress.Compressor, threshold int) Option {
+	return shared(transport.WithCompression(c, threshold))
+}
+
+// WithClaimCheck checks the oversized published messages in to the store,
+// see pipeline.Pipeline.SetClaimCheck.
+func WithClaimCheck(store claimcheck.Store, threshold int) Option {
+	return shared(transport.WithClaimCheck(store, threshold))
+}
+
+// WithEncryption encrypts the published messages, see
+// pipeline.Pipeline.SetEncryption.
+func WithEncryption(kp encryption.KeyProvider, keyID string) Option {
+	return shared(transport.WithEncryption(kp, keyID))
+}
+
+// WithTracerProvider changes the tracer provider, see
+// tracing.WithTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return shared(transport.WithTracerProvider(tp))
+}
+
+// WithPropagator changes the trace context propagator, see
+// tracing.WithPropagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return shared(transport.WithPropagator(propagator))
+}
+
+// WithMetrics reports the metrics of the client to the recorder, see
+// metrics.Recorder. Default recorder is metrics.Nop.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return shared(transport.WithMetrics(recorder))
+}
+
+// PubSub is a wrapper over a NATS JetStream context.
+type PubSub struct {
+	conn          *nats.Conn
+	js            nats.JetStreamContext
+	connOpts      []nats.Option
+	closed        chan struct{}
+	createTopic   bool
+	createSub     bool
+	subscriptions map[string]string
+	maxDeliver    int
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	logger        *zap.Logger
+	pipeline      *pipeline.Pipeline
+	processor     *transport.Processor
+
+	tracer      *tracing.Tracer
+	tracingOpts []tracing.Option
+
+	metrics metrics.Recorder
+
+	health transport.Health
+
+	// handlerCtx is passed to the handlers.
+	handlerCtx    context.Context
+	handlerCancel context.CancelFunc
+
+	// lifecycle rejects new subscriptions once Stop is called.
+	lifecycle transport.Lifecycle
+}
+
+// tracingSystem is the messaging system reported in spans.
+const tracingSystem = "nats"
+
+// logIDKey is the log field of the message ids.
+const logIDKey = "natsMessageId"
+
+// NewClient creates an instance of NATS JetStream PubSub connected to the
+// server url.
+// All methods are thread-safe until mentioned specifically.
+func NewClient(url string, options ...Option) (*PubSub, error) {
+	p := &PubSub{
+		closed:        make(chan struct{}),
+		subscriptions: make(map[string]string),
+		minBackoff:    DefaultMinBackoff,
+		maxBackoff:    DefaultMaxBackoff,
+		logger:        zap.NewNop(),
+		pipeline:      pipeline.New(),
+		metrics:       metrics.Nop{},
+	}
+
+	// Apply configuration options.
+	for _, opt := range options {
+		if opt == nil {
+			continue
+		}
+		if err := opt(p); err != nil {
+			return nil, errors.Wrap(err, "could not apply option")
+		}
+	}
+
+	p.tracer = tracing.New(tracingSystem, p.tracingOpts...)
+	p.processor = &transport.Processor{
+		Pipeline: p.pipeline,
+		Tracer:   p.tracer,
+		Metrics:  p.metrics,
+		Logger:   p.logger,
+		IDKey:    logIDKey,
+	}
+	p.handlerCtx, p.handlerCancel = context.WithCancel(context.Background())
+
+	connOpts := append(p.connOpts, nats.ClosedHandler(func(*nats.Conn) {
+		close(p.closed)
+	}))
+
+	p.logger.Info("connecting to nats", zap.String("url", url))
+	conn, err := nats.Connect(url, connOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to nats")
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "unable to create jetstream context")
+	}
+
+	p.conn = conn
+	p.js = js
+
+	return p, nil
+}
+
+// Stop stops the pubsub gracefully.
+//
+// The context passed to the in-flight handlers is cancelled right away, then
+// the subscriptions and the pending published messages are drained before the
+// connection is closed. It returns ErrStopped if the pubsub is already
+// stopped.
+func (p *PubSub) Stop() error {
+	if err := p.lifecycle.Stop(); err != nil {
+		return err
+	}
+
+	p.logger.Debug("cancelling handlers context")
+	p.handlerCancel()
+
+	p.logger.Debug("waiting for the pending subscribe calls")
+	p.lifecycle.Wait()
+
+	p.logger.Debug("draining nats connection")
+	if err := p.conn.Drain(); err != nil {
+		return errors.Wrap(err, "unable to drain nats connection")
+	}
+	<-p.closed
+
+	p.health.StopAll()
+
+	return nil
+}
+
+// Health returns the health of the client and of every subscription.
+func (p *PubSub) Health() ship.Health {
+	return p.health.Get(p.lifecycle.Stopped())
+}
+
+// streamName returns the name of the stream capturing a topic, its subject
+// tokens separated by underscores as stream names cannot contain dots.
+//
+// Topics containing an underscore or a wildcard are rejected: their stream
+// would be shared with another topic, e.g. orders.created and orders_created.
+func streamName(topic string) (string, error) {
+	if strings.ContainsAny(topic, "_*>") {
+		return "", errors.Errorf("invalid topic %s: it cannot contain '_', '*' or '>'", topic)
+	}
+	return strings.ReplaceAll(topic, ".", "_"), nil
+}
@@ -0,0 +1,272 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const (
+	testTopic        = "some.topic"
+	testStream       = "some_topic"
+	testSubscription = "some-subscription"
+)
+
+// runServer runs an embedded NATS server with JetStream enabled.
+func runServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+
+	return natstest.RunServer(&opts)
+}
+
+func TestNewClient(t *testing.T) {
+	testCases := []struct {
+		name         string
+		opts         []Option
+		checkReturns func(*testing.T, *PubSub, error)
+	}{
+		{
+			name: "should return error: could not apply option",
+			opts: []Option{
+				func(ps *PubSub) error {
+					return errors.New("some error")
+				},
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "could not apply option")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: subscription has no topic",
+			opts: []Option{WithSubscription(testSubscription, "")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "subscription some-subscription has no topic")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid topic",
+			opts: []Option{WithSubscription(testSubscription, "some_topic")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid topic some_topic")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid max deliver",
+			opts: []Option{WithMaxDeliver(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid max deliver 0")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid retry backoff",
+			opts: []Option{WithRetryBackoff(time.Second, time.Millisecond)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid retry backoff")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return a new client",
+			opts: []Option{
+				nil,
+				WithLogger(zap.NewNop()),
+				WithRegistry(ship.NewRegistry()),
+				WithCreateTopic(true),
+				WithCreateSubscription(true),
+				WithSubscription(testSubscription, testTopic),
+				WithConnOptions(),
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.NoError(t, err)
+				assert.NotNil(t, ps)
+				assert.NoError(t, ps.Stop())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			s := runServer(t)
+			defer s.Shutdown()
+
+			client, err := NewClient(s.ClientURL(), tc.opts...)
+			tc.checkReturns(t, client, err)
+		})
+	}
+}
+
+func TestNewClient_connectionError(t *testing.T) {
+	client, err := NewClient("nats://127.0.0.1:1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to connect to nats")
+	assert.Nil(t, client)
+}
+
+func TestPubSub_EnsureTopics(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		check func(*testing.T, *PubSub, error)
+	}{
+		{
+			name: "should return error: stream does not exists",
+			check: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "stream some_topic of topic some.topic does not exists")
+			},
+		},
+		{
+			name: "should create the stream",
+			opts: []Option{WithCreateTopic(true)},
+			check: func(t *testing.T, ps *PubSub, err error) {
+				assert.NoError(t, err)
+
+				info, err := ps.js.StreamInfo(testStream)
+				assert.NoError(t, err)
+				assert.Equal(t, []string{testTopic}, info.Config.Subjects)
+
+				// The existing stream is kept.
+				assert.NoError(t, ps.EnsureTopics(testTopic))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+			defer suite.Teardown(t)
+
+			err := suite.client.EnsureTopics("", testTopic)
+			tc.check(t, suite.client, err)
+		})
+	}
+}
+
+func TestStreamName(t *testing.T) {
+	testCases := []struct {
+		name   string
+		topic  string
+		stream string
+		errMsg string
+	}{
+		{
+			name:   "should replace the tokens separator",
+			topic:  "orders.created",
+			stream: "orders_created",
+		},
+		{
+			name:   "should return error: topic contains an underscore",
+			topic:  "orders_created",
+			errMsg: "invalid topic orders_created",
+		},
+		{
+			name:   "should return error: topic contains a wildcard",
+			topic:  "orders.*",
+			errMsg: "invalid topic orders.*",
+		},
+		{
+			name:   "should return error: topic contains a full wildcard",
+			topic:  "orders.>",
+			errMsg: "invalid topic orders.>",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			stream, err := streamName(tc.topic)
+			if tc.errMsg != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errMsg)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.stream, stream)
+		})
+	}
+}
+
+func TestPubSub_Stop(t *testing.T) {
+	suite := newTestSuite(t)
+	defer suite.server.Shutdown()
+
+	assert.NoError(t, suite.client.Stop())
+	assert.True(t, suite.client.Health().Stopped)
+	assert.ErrorIs(t, suite.client.Stop(), ErrStopped)
+}
+
+type suite struct {
+	server *server.Server
+	client *PubSub
+}
+
+// Teardown teardowns the test suite.
+func (s *suite) Teardown(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), 10*time.Second)
+	defer timeout()
+
+	done := make(chan struct{})
+
+	go func(t *testing.T) {
+		err := s.client.Stop()
+		assert.NoError(t, err)
+
+		s.server.Shutdown()
+
+		close(done)
+	}(t)
+
+	select {
+	case <-ctx.Done():
+		assert.Fail(t, "unable to stop client properly")
+	case <-done:
+	}
+}
+
+// newTestSuite returns a test suite for easier testing.
+func newTestSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	s := runServer(t)
+
+	opts = append([]Option{WithSubscription(testSubscription, testTopic)}, opts...)
+	client, err := NewClient(s.ClientURL(), opts...)
+	assert.NoError(t, err)
+
+	return &suite{
+		server: s,
+		client: client,
+	}
+}
@@ -0,0 +1,177 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Publisher = (*PubSub)(nil)
+
+// OrderingKeyHeader holds the ship.RawMessage.OrderingKey, JetStream messages
+// have no ordering key.
+const OrderingKeyHeader = "ship_ordering_key"
+
+// EnsureTopics checks whether the stream of a topic exists or not.
+//
+// If createTopic is `true` it will create the stream.
+func (p *PubSub) EnsureTopics(topics ...string) error {
+	for _, topic := range topics {
+		if topic == "" {
+			continue
+		}
+
+		if err := p.topicInit(topic); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// topicInit checks the stream of a topic and create it, if required.
+func (p *PubSub) topicInit(topic string) error {
+	stream, err := streamName(topic)
+	if err != nil {
+		return err
+	}
+
+	p.logger.Info(
+		fmt.Sprintf("checking if stream (%s) exists", stream),
+		zap.String("topic", topic),
+		zap.String("stream", stream),
+	)
+	_, err = p.js.StreamInfo(stream)
+	if err == nil {
+		return nil
+	}
+
+	if err != nats.ErrStreamNotFound {
+		return errors.Wrapf(err, "could not check if stream '%s' exists", stream)
+	}
+
+	if !p.createTopic {
+		return errors.Errorf("stream %s of topic %s does not exists", stream, topic)
+	}
+
+	p.logger.Info(
+		fmt.Sprintf("creating stream %s", stream),
+		zap.String("topic", topic),
+		zap.String("stream", stream),
+	)
+	_, err = p.js.AddStream(&nats.StreamConfig{
+		Name:     stream,
+		Subjects: []string{topic},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s stream", stream)
+	}
+
+	return nil
+}
+
+// Publish publishes the message to a given topic.
+//
+// The message data is encoded with the configured codec and the message fields
+// are sent as headers, see ship.MarshalMessage. The message id is used to
+// deduplicate the message in the stream.
+func (p *PubSub) Publish(topic string, message *ship.Message) error {
+	return p.PublishContext(context.Background(), topic, message)
+}
+
+// PublishContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message headers.
+func (p *PubSub) PublishContext(
+	ctx context.Context, topic string, message *ship.Message,
+) error {
+	raw, err := p.pipeline.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal message to bytes")
+	}
+
+	return p.publish(ctx, topic, raw)
+}
+
+// PublishRaw publishes the message to a given topic.
+func (p *PubSub) PublishRaw(topic string, message *ship.RawMessage) error {
+	return p.PublishRawContext(context.Background(), topic, message)
+}
+
+// PublishRawContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message headers.
+func (p *PubSub) PublishRawContext(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) error {
+	return p.publish(ctx, topic, message)
+}
+
+// publish wraps the raw message and publishes it to a given topic.
+func (p *PubSub) publish(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) (err error) {
+	start := time.Now()
+	ctx, span := p.tracer.StartPublish(ctx, topic)
+	defer func() {
+		tracing.End(span, err)
+		if err != nil {
+			p.metrics.PublishFailed(topic, time.Since(start))
+			return
+		}
+		p.metrics.PublishSucceeded(topic, time.Since(start))
+	}()
+
+	message, err = p.pipeline.Wrap(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "unable to wrap message")
+	}
+
+	msg := nats.NewMsg(topic)
+	msg.Data = message.Data
+	for k, v := range p.tracer.Inject(ctx, message.Attributes) {
+		msg.Header.Set(k, v)
+	}
+	if message.OrderingKey != "" {
+		msg.Header.Set(OrderingKeyHeader, message.OrderingKey)
+	}
+
+	var opts []nats.PubOpt
+	if id := message.Attributes[ship.IDKey]; id != "" {
+		opts = append(opts, nats.MsgId(id))
+	}
+	if _, ok := ctx.Deadline(); ok {
+		opts = append(opts, nats.ContextOpt{Context: ctx})
+	}
+
+	p.logger.Debug(
+		"publishing message to topic", zap.String("topic", topic),
+	)
+	ack, err := p.js.PublishMsg(msg, opts...)
+	if err != nil {
+		p.logger.Error(
+			"unable to publish message",
+			zap.Error(err),
+			zap.String("topic", topic),
+		)
+		return errors.Wrap(err, "could not publish message")
+	}
+
+	tracing.SetMessageID(span, messageID(ack.Stream, ack.Sequence))
+
+	return nil
+}
+
+// messageID returns the id of a JetStream message, which is unique per
+// server.
+func messageID(stream string, sequence uint64) string {
+	return fmt.Sprintf("%s/%d", stream, sequence)
+}
@@ -0,0 +1,98 @@
+package nats
+
+import (
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testEvent) EventName() string { return "TestEvent" }
+
+func TestPubSub_Publish(t *testing.T) {
+	testCases := []struct {
+		name    string
+		publish func(p *PubSub) error
+		check   func(t *testing.T, msg *nats.RawStreamMsg)
+	}{
+		{
+			name: "should publish a message",
+			publish: func(p *PubSub) error {
+				return p.Publish(testTopic, &ship.Message{
+					ID:   "some-id",
+					Data: &testEvent{Name: "ship"},
+				})
+			},
+			check: func(t *testing.T, msg *nats.RawStreamMsg) {
+				assert.Equal(t, testTopic, msg.Subject)
+				assert.JSONEq(t, `{"name": "ship"}`, string(msg.Data))
+				assert.Equal(t, "some-id", msg.Header.Get(ship.IDKey))
+				assert.Equal(t, "TestEvent", msg.Header.Get(ship.TypeKey))
+				assert.Equal(t, "application/json", msg.Header.Get(ship.ContentTypeKey))
+			},
+		},
+		{
+			name: "should publish a raw message with its ordering key",
+			publish: func(p *PubSub) error {
+				return p.PublishRaw(testTopic, &ship.RawMessage{
+					Data:        []byte("some-data"),
+					Attributes:  map[string]string{"some-key": "some-value"},
+					OrderingKey: "some-ordering-key",
+				})
+			},
+			check: func(t *testing.T, msg *nats.RawStreamMsg) {
+				assert.Equal(t, "some-data", string(msg.Data))
+				assert.Equal(t, "some-value", msg.Header.Get("some-key"))
+				assert.Equal(t, "some-ordering-key", msg.Header.Get(OrderingKeyHeader))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, WithCreateTopic(true))
+			defer suite.Teardown(t)
+
+			assert.NoError(t, suite.client.EnsureTopics(testTopic))
+			assert.NoError(t, tc.publish(suite.client))
+
+			msg, err := suite.client.js.GetMsg(testStream, 1)
+			assert.NoError(t, err)
+			tc.check(t, msg)
+		})
+	}
+}
+
+func TestPubSub_PublishDeduplication(t *testing.T) {
+	suite := newTestSuite(t, WithCreateTopic(true))
+	defer suite.Teardown(t)
+
+	assert.NoError(t, suite.client.EnsureTopics(testTopic))
+
+	message := &ship.Message{ID: "some-id", Data: &testEvent{Name: "ship"}}
+	assert.NoError(t, suite.client.Publish(testTopic, message))
+	assert.NoError(t, suite.client.Publish(testTopic, message))
+
+	info, err := suite.client.js.StreamInfo(testStream)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), info.State.Msgs)
+}
+
+func TestPubSub_PublishError(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newTestSuite(t, WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	// No stream captures the topic.
+	err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not publish message")
+	assert.Equal(t, 1, recorder.count("publish_failed:"+testTopic))
+}
@@ -0,0 +1,253 @@
+package nats
+
+import (
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Subscriber = (*PubSub)(nil)
+
+// ErrStopped is returned when subscribing to or stopping a stopped pubsub.
+var ErrStopped = transport.ErrStopped
+
+// topic returns the topic consumed by a subscription.
+func (p *PubSub) topic(subscription string) string {
+	if topic, ok := p.subscriptions[subscription]; ok {
+		return topic
+	}
+
+	return subscription
+}
+
+// subInit checks for existence of the durable consumer of a subscription and
+// creates it, if required.
+//
+// The consumer delivers the messages to a queue group of the subscription
+// name, so the instances of a service share the messages.
+func (p *PubSub) subInit(subscription, stream, topic string) error {
+	p.logger.Info("checking if subscription exists", zap.String("name", subscription))
+	_, err := p.js.ConsumerInfo(stream, subscription)
+	if err == nil {
+		return nil
+	}
+
+	if err != nats.ErrConsumerNotFound {
+		return errors.Wrapf(
+			err, "could not check if subscription '%s' exists", subscription,
+		)
+	}
+
+	if !p.createSub {
+		return errors.Errorf("subscription %s does not exists", subscription)
+	}
+
+	p.logger.Info("creating subscription", zap.String("name", subscription))
+	_, err = p.js.AddConsumer(stream, &nats.ConsumerConfig{
+		Durable:        subscription,
+		DeliverSubject: nats.NewInbox(),
+		DeliverGroup:   subscription,
+		DeliverPolicy:  nats.DeliverAllPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+		MaxDeliver:     p.maxDeliver,
+		FilterSubject:  topic,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s subscription", subscription)
+	}
+
+	return nil
+}
+
+// Subscribe subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// A message which the handler fails to process is negatively acknowledged and
+// redelivered after a delay, see WithRetryBackoff.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+//
+// Example:
+//
+//	pubsub, err := nats.NewClient("nats://localhost:4222")
+//	if err != nil {
+//		// do something with error
+//		return
+//	}
+//
+//	pubsub.Subscribe("some-subscription-name", handler)
+//	pubsub.Subscribe("some-subscription-name2", handler2)
+func (p *PubSub) Subscribe(
+	subscription string, handler ship.MessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.Handler(handler),
+	)
+}
+
+// SubscribeRaw subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The messages are redelivered as in Subscribe.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+func (p *PubSub) SubscribeRaw(
+	subscription string, handler ship.RawMessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.RawHandler(handler),
+	)
+}
+
+// subscribe binds to the durable consumer of a subscription and processes its
+// messages with process.
+//
+// The lifecycle is held until the subscription is bound, so Stop drains it.
+func (p *PubSub) subscribe(subscription, hName string, process transport.ProcessFunc) error {
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+	defer p.lifecycle.Release()
+
+	topic := p.topic(subscription)
+	stream, err := streamName(topic)
+	if err != nil {
+		return err
+	}
+
+	if err := p.subInit(subscription, stream, topic); err != nil {
+		return errors.WithStack(err)
+	}
+
+	health := p.health.Track(subscription, hName)
+	s := &subscriber{
+		p:       p,
+		subID:   subscription,
+		hName:   hName,
+		health:  health,
+		process: process,
+	}
+
+	p.logger.Info(
+		"starting listener for subscription", zap.String("subscription", subscription),
+	)
+
+	// Binding to the consumer keeps it when the subscription is drained.
+	_, err = p.js.QueueSubscribe(
+		topic, subscription, s.receive, nats.Bind(stream, subscription), nats.ManualAck(),
+	)
+	if err != nil {
+		health.Stopped(err)
+		if err == nats.ErrConnectionDraining || err == nats.ErrConnectionClosed {
+			return ErrStopped
+		}
+		return errors.Wrapf(err, "unable to subscribe to %s", subscription)
+	}
+
+	return nil
+}
+
+// subscriber processes the messages of a subscription.
+type subscriber struct {
+	p       *PubSub
+	subID   string
+	hName   string
+	health  *transport.SubscriptionHealth
+	process transport.ProcessFunc
+}
+
+// receive processes a delivered message and acknowledges it.
+func (s *subscriber) receive(msg *nats.Msg) {
+	p := s.p
+
+	s.health.SetLastMessageAt(time.Now())
+
+	meta, err := msg.Metadata()
+	if err != nil {
+		p.logger.Error("unable to get message metadata", zap.Error(err))
+		return
+	}
+
+	raw := &ship.RawMessage{
+		ID:          messageID(meta.Stream, meta.Sequence.Stream),
+		Attributes:  make(map[string]string, len(msg.Header)),
+		Data:        msg.Data,
+		PublishTime: meta.Timestamp,
+		OrderingKey: msg.Header.Get(OrderingKeyHeader),
+	}
+	for k := range msg.Header {
+		if k != OrderingKeyHeader {
+			raw.Attributes[k] = msg.Header.Get(k)
+		}
+	}
+
+	// We don't want an unexpected error in consumer to take down whole application.
+	// We'll try to recover from the panic and remove the subscription from listening.
+	//
+	// This protects an application from going in a continuous crash loop in a
+	// orchestrated environment.
+	defer func() {
+		if r := recover(); r != nil {
+			s.health.Recovered(
+				p.logger, r,
+				"Nacking the received message and removing the subscription from listening.",
+				zap.String("natsMessageId", raw.ID),
+			)
+			s.nack(msg, meta)
+
+			// The durable consumer is kept, as the subscription is bound to it.
+			if err := msg.Sub.Unsubscribe(); err != nil {
+				p.logger.Error("unable to unsubscribe", zap.Error(err))
+			}
+		}
+	}()
+
+	// The handlers are given their own context, so they can be cancelled on
+	// Stop.
+	if err := s.process(p.handlerCtx, s.subID, s.hName, raw); err != nil {
+		s.nack(msg, meta)
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		p.logger.Error("unable to ack message", zap.Error(err))
+	}
+	p.metrics.Acked(s.subID, s.hName)
+}
+
+// nack negatively acknowledges a message, so it is redelivered after a delay
+// growing with its deliveries, and records it.
+//
+// The message is also recorded as dead-lettered, if it was the last delivery
+// allowed by the consumer.
+func (s *subscriber) nack(msg *nats.Msg, meta *nats.MsgMetadata) {
+	p := s.p
+
+	delay := p.minBackoff
+	for i := uint64(1); i < meta.NumDelivered && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+
+	if err := msg.NakWithDelay(delay); err != nil {
+		p.logger.Error("unable to nack message", zap.Error(err))
+	}
+	p.metrics.Nacked(s.subID, s.hName)
+
+	if p.maxDeliver > 0 && meta.NumDelivered >= uint64(p.maxDeliver) {
+		p.metrics.DeadLettered(s.subID, s.hName)
+	}
+}
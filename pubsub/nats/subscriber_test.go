@@ -0,0 +1,308 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+// testRecorder is a metrics.Recorder counting the recorded metrics.
+type testRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{counts: make(map[string]int)}
+}
+
+func (r *testRecorder) inc(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+func (r *testRecorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+func (r *testRecorder) PublishSucceeded(topic string, _ time.Duration) {
+	r.inc("published:" + topic)
+}
+
+func (r *testRecorder) PublishFailed(topic string, _ time.Duration) {
+	r.inc("publish_failed:" + topic)
+}
+
+func (r *testRecorder) Handled(sub, handler string, _ time.Duration) {
+	r.inc("handled:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Acked(sub, handler string) {
+	r.inc("acked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Nacked(sub, handler string) {
+	r.inc("nacked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DeadLettered(sub, handler string) {
+	r.inc("dead_lettered:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DecodeFailed(sub, handler, reason string) {
+	r.inc("decode_failed:" + sub + ":" + handler + ":" + reason)
+}
+
+type rawHandlerFunc func(context.Context, *ship.RawMessage) error
+
+func (f rawHandlerFunc) HandleRawMessage(ctx context.Context, m *ship.RawMessage) error {
+	return f(ctx, m)
+}
+
+// newSubscriptionSuite returns a test suite with the stream of the test topic
+// and the durable consumer of the test subscription.
+func newSubscriptionSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	opts = append([]Option{
+		WithCreateTopic(true),
+		WithCreateSubscription(true),
+		WithRetryBackoff(time.Millisecond, 10*time.Millisecond),
+	}, opts...)
+	suite := newTestSuite(t, opts...)
+
+	assert.NoError(t, suite.client.EnsureTopics(testTopic))
+
+	return suite
+}
+
+// envelope returns a ship envelope of the event.
+func envelope(t *testing.T, id string, event ship.Event) *ship.RawMessage {
+	t.Helper()
+
+	raw, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{ID: id, Data: event})
+	assert.NoError(t, err)
+
+	return raw
+}
+
+func TestPubSub_Subscribe(t *testing.T) {
+	debezium, err := os.ReadFile("../../debezium/testdata/valid_data.fixture")
+	assert.NoError(t, err)
+
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&userCreated{}))
+
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithRegistry(registry), WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		received []string
+		failed   bool
+	)
+	handler := ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first delivery of the last message fails, it must be redelivered.
+		if m.ID == "some-last-id" && !failed {
+			failed = true
+			return errors.New("some error")
+		}
+
+		received = append(received, m.ID)
+		return nil
+	})
+
+	err = suite.client.Subscribe(testSubscription, handler)
+	assert.NoError(t, err)
+
+	for _, m := range []*ship.RawMessage{
+		envelope(t, "some-id", &userCreated{Email: "someone@flahmingo.com"}),
+		envelope(t, "some-other-id", &testEvent{Name: "unregistered"}),
+		{Data: debezium},
+		envelope(t, "some-last-id", &userCreated{Email: "someone@flahmingo.com"}),
+	} {
+		assert.NoError(t, suite.client.PublishRaw(testTopic, m))
+	}
+
+	hName := "MessageHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("acked:"+testSubscription+":"+hName) == 4
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []string{
+		"some-id",
+		"e79e906a-5022-473f-9a67-ff0993851be9",
+		"some-last-id",
+	}, received)
+	mu.Unlock()
+
+	assert.Equal(t, 1, recorder.count("nacked:"+testSubscription+":"+hName))
+	assert.Equal(
+		t, 1,
+		recorder.count("decode_failed:"+testSubscription+":"+hName+":"+ship.DecodeReasonUnregistered),
+	)
+}
+
+func TestPubSub_SubscribeRaw(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	received := make(chan *ship.RawMessage, 1)
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{
+		Data:        []byte("some-data"),
+		Attributes:  map[string]string{"some-key": "some-value"},
+		OrderingKey: "some-ordering-key",
+	})
+	assert.NoError(t, err)
+
+	select {
+	case m := <-received:
+		assert.Equal(t, "some_topic/1", m.ID)
+		assert.Equal(t, []byte("some-data"), m.Data)
+		assert.Equal(t, "some-ordering-key", m.OrderingKey)
+		assert.Equal(t, "some-value", m.Attributes["some-key"])
+		assert.NotContains(t, m.Attributes, OrderingKeyHeader)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+}
+
+func TestPubSub_SubscribeDeadLettered(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithMaxDeliver(2), WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			return errors.New("some error")
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+
+	hName := "rawHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("dead_lettered:"+testSubscription+":"+hName) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The message is not delivered again.
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, recorder.count("nacked:"+testSubscription+":"+hName))
+}
+
+func TestPubSub_SubscribePanic(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			panic("some panic")
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		h := suite.client.Health()
+		return len(h.Subscriptions) == 1 &&
+			h.Subscriptions[0].State == ship.SubscriptionPanicked
+	}, 10*time.Second, 10*time.Millisecond)
+
+	h := suite.client.Health().Subscriptions[0]
+	assert.Equal(t, testSubscription, h.Subscription)
+	assert.Equal(t, "some panic", h.Error)
+
+	// The durable consumer is kept, so the message is not lost.
+	info, err := suite.client.js.ConsumerInfo(testStream, testSubscription)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), info.AckFloor.Stream)
+}
+
+func TestPubSub_SubscribeErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *suite)
+		err   string
+	}{
+		{
+			name: "should return error: subscription does not exists",
+			opts: []Option{WithCreateTopic(true)},
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.EnsureTopics(testTopic))
+			},
+			err: "subscription some-subscription does not exists",
+		},
+		{
+			name: "should return error: stream does not exists",
+			opts: []Option{WithCreateSubscription(true)},
+			err:  "unable to create some-subscription subscription",
+		},
+		{
+			name: "should return error: pubsub is stopped",
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.Stop())
+			},
+			err: ErrStopped.Error(),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+			defer suite.server.Shutdown()
+
+			if tc.setup != nil {
+				tc.setup(t, suite)
+			}
+			defer func() { _ = suite.client.Stop() }()
+
+			err := suite.client.Subscribe(
+				testSubscription,
+				ship.MessageHandlerFunc(func(context.Context, *ship.Message) error {
+					return nil
+				}),
+			)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
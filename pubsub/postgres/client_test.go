@@ -0,0 +1,401 @@
+package postgres
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const (
+	testPublication  = "some-publication"
+	testSubscription = "some-subscription"
+)
+
+// fakeServer is an in-memory server handling the queries and replication
+// commands of the client.
+type fakeServer struct {
+	mu           sync.Mutex
+	publications map[string]string
+	slots        map[string]*fakeSlot
+	dialErr      error
+	queryErr     error
+}
+
+// fakeSlot is a replication slot, the test sends its changes.
+type fakeSlot struct {
+	changes   chan []byte
+	confirmed LSN
+	statuses  int
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		publications: make(map[string]string),
+		slots:        make(map[string]*fakeSlot),
+	}
+}
+
+var (
+	literalRe    = regexp.MustCompile(`'([^']*)'`)
+	identifierRe = regexp.MustCompile(`"([^"]*)"`)
+)
+
+func (s *fakeServer) query(sql string) ([][][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queryErr != nil {
+		return nil, s.queryErr
+	}
+
+	row := [][][]byte{{[]byte("1")}}
+	switch {
+	case strings.HasPrefix(sql, "SELECT 1 FROM pg_publication "):
+		if _, ok := s.publications[literalRe.FindStringSubmatch(sql)[1]]; ok {
+			return row, nil
+		}
+		return nil, nil
+	case strings.HasPrefix(sql, "CREATE PUBLICATION "):
+		name := identifierRe.FindStringSubmatch(sql)[1]
+		if _, ok := s.publications[name]; ok {
+			return nil, &pgconn.PgError{Code: duplicateObject}
+		}
+		s.publications[name] = sql
+		return nil, nil
+	case strings.HasPrefix(sql, "SELECT 1 FROM pg_replication_slots "):
+		if _, ok := s.slots[literalRe.FindStringSubmatch(sql)[1]]; ok {
+			return row, nil
+		}
+		return nil, nil
+	case strings.HasPrefix(sql, "CREATE_REPLICATION_SLOT "):
+		name := identifierRe.FindStringSubmatch(sql)[1]
+		if _, ok := s.slots[name]; ok {
+			return nil, &pgconn.PgError{Code: duplicateObject}
+		}
+		s.slots[name] = &fakeSlot{changes: make(chan []byte, 100)}
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unexpected query %s", sql)
+}
+
+// slot returns a replication slot.
+func (s *fakeServer) slot(name string) *fakeSlot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.slots[name]
+}
+
+// confirmed returns the confirmed position of a slot and the number of
+// status updates received.
+func (s *fakeServer) confirmed(name string) (LSN, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.slots[name].confirmed, s.slots[name].statuses
+}
+
+// fakeConn is a connection to a fakeServer.
+type fakeConn struct {
+	server *fakeServer
+	slot   *fakeSlot
+}
+
+func (c *fakeConn) query(_ context.Context, sql string) ([][][]byte, error) {
+	return c.server.query(sql)
+}
+
+func (c *fakeConn) startReplication(_ context.Context, sql string) error {
+	if !strings.Contains(sql, fmt.Sprintf("publication_names '\"%s\"'", testPublication)) {
+		return fmt.Errorf("unexpected publication in %s", sql)
+	}
+
+	name := identifierRe.FindStringSubmatch(sql)[1]
+	c.slot = c.server.slot(name)
+	if c.slot == nil {
+		return &pgconn.PgError{Message: fmt.Sprintf("replication slot %q does not exist", name)}
+	}
+	return nil
+}
+
+func (c *fakeConn) receive(ctx context.Context) (pgproto3.BackendMessage, error) {
+	select {
+	case data, ok := <-c.slot.changes:
+		if !ok {
+			return nil, errors.New("connection closed")
+		}
+		return &pgproto3.CopyData{Data: data}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *fakeConn) sendCopyData(_ context.Context, data []byte) error {
+	if data[0] != standbyStatusUpdateByteID {
+		return fmt.Errorf("unexpected message %q", data[0])
+	}
+
+	c.server.mu.Lock()
+	c.slot.confirmed = LSN(binary.BigEndian.Uint64(data[9:]))
+	c.slot.statuses++
+	c.server.mu.Unlock()
+	return nil
+}
+
+func (c *fakeConn) close(context.Context) error {
+	return nil
+}
+
+// useFakeServer replaces the connections of the clients with connections to a
+// new fake server, until the end of the test.
+func useFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+
+	s := newFakeServer()
+	original := dial
+	dial = func(ctx context.Context, connString string) (conn, error) {
+		if s.dialErr != nil {
+			return nil, s.dialErr
+		}
+		return &fakeConn{server: s}, nil
+	}
+	t.Cleanup(func() { dial = original })
+
+	return s
+}
+
+func TestNewClient(t *testing.T) {
+	testCases := []struct {
+		name         string
+		opts         []Option
+		checkReturns func(*testing.T, *PubSub, error)
+	}{
+		{
+			name: "should return error: could not apply option",
+			opts: []Option{
+				func(ps *PubSub) error {
+					return errors.New("some error")
+				},
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "could not apply option")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: subscription has no publication",
+			opts: []Option{WithSubscription(testSubscription, "")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "subscription some-subscription has no publication")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: tables cannot be empty",
+			opts: []Option{WithTables()},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "tables cannot be empty")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid standby timeout",
+			opts: []Option{WithStandbyTimeout(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid standby timeout 0s")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid retry backoff",
+			opts: []Option{WithRetryBackoff(0, time.Second)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid retry backoff [0s, 1s]")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid max attempts",
+			opts: []Option{WithMaxAttempts(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid max attempts 0")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return a new client",
+			opts: []Option{
+				nil,
+				WithLogger(zap.NewNop()),
+				WithRegistry(ship.NewRegistry()),
+				WithCreateTopic(true),
+				WithCreateSubscription(true),
+				WithSubscription(testSubscription, testPublication),
+				WithTables("public.events"),
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.NoError(t, err)
+				assert.NotNil(t, ps)
+				assert.Equal(t, []string{"public.events"}, ps.tables)
+				assert.NoError(t, ps.Stop())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			useFakeServer(t)
+
+			client, err := NewClient("postgres://localhost:5432/db", tc.opts...)
+			tc.checkReturns(t, client, err)
+		})
+	}
+}
+
+func TestNewClient_connectionErrors(t *testing.T) {
+	client, err := NewClient("postgres://localhost:invalid-port/db")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid connection string")
+	assert.Nil(t, client)
+
+	s := useFakeServer(t)
+	s.dialErr = errors.New("some error")
+
+	client, err = NewClient("postgres://localhost:5432/db")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to connect to postgres")
+	assert.Nil(t, client)
+}
+
+func TestPubSub_EnsureTopics(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *fakeServer)
+		check func(*testing.T, *fakeServer, error)
+	}{
+		{
+			name: "should return error: publication does not exists",
+			check: func(t *testing.T, s *fakeServer, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "publication some-publication does not exists")
+			},
+		},
+		{
+			name: "should return error: could not check if publication exists",
+			setup: func(t *testing.T, s *fakeServer) {
+				s.queryErr = errors.New("some error")
+			},
+			check: func(t *testing.T, s *fakeServer, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "could not check if publication 'some-publication' exists")
+			},
+		},
+		{
+			name: "should create a publication of the tables",
+			opts: []Option{WithCreateTopic(true), WithTables("public.events", "other_events")},
+			check: func(t *testing.T, s *fakeServer, err error) {
+				assert.NoError(t, err)
+				assert.Equal(
+					t,
+					`CREATE PUBLICATION "some-publication" FOR TABLE "public"."events", "other_events" WITH (publish = 'insert')`,
+					s.publications[testPublication],
+				)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+			defer suite.Teardown(t)
+
+			if tc.setup != nil {
+				tc.setup(t, suite.server)
+			}
+
+			err := suite.client.EnsureTopics("", testPublication)
+			tc.check(t, suite.server, err)
+		})
+	}
+}
+
+func TestPubSub_Stop(t *testing.T) {
+	suite := newTestSuite(t)
+
+	assert.NoError(t, suite.client.Stop())
+	assert.True(t, suite.client.Health().Stopped)
+	assert.ErrorIs(t, suite.client.Stop(), ErrStopped)
+}
+
+type suite struct {
+	server *fakeServer
+	client *PubSub
+}
+
+// Teardown teardowns the test suite.
+func (s *suite) Teardown(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), 10*time.Second)
+	defer timeout()
+
+	done := make(chan struct{})
+
+	go func(t *testing.T) {
+		err := s.client.Stop()
+		assert.NoError(t, err)
+
+		close(done)
+	}(t)
+
+	select {
+	case <-ctx.Done():
+		assert.Fail(t, "unable to stop client properly")
+	case <-done:
+	}
+}
+
+// newTestSuite returns a test suite for easier testing.
+func newTestSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	s := useFakeServer(t)
+
+	opts = append([]Option{
+		WithSubscription(testSubscription, testPublication),
+		WithStandbyTimeout(20 * time.Millisecond),
+	}, opts...)
+	client, err := NewClient("postgres://localhost:5432/db", opts...)
+	assert.NoError(t, err)
+
+	return &suite{
+		server: s,
+		client: client,
+	}
+}
@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/pkg/errors"
+)
+
+// conn is a replication connection to the server.
+type conn interface {
+	// query runs a simple query and returns the rows of its result.
+	query(ctx context.Context, sql string) ([][][]byte, error)
+	// startReplication runs a START_REPLICATION command and waits for the
+	// server to start streaming.
+	startReplication(ctx context.Context, sql string) error
+	// receive receives the next message of the replication stream.
+	receive(ctx context.Context) (pgproto3.BackendMessage, error)
+	// sendCopyData sends a message of the replication protocol.
+	sendCopyData(ctx context.Context, data []byte) error
+	close(ctx context.Context) error
+}
+
+// dial opens a replication connection, it is replaced in tests.
+var dial = func(ctx context.Context, connString string) (conn, error) {
+	config, err := pgconn.ParseConfig(connString)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid connection string")
+	}
+	config.RuntimeParams["replication"] = "database"
+
+	c, err := pgconn.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pgConn{conn: c}, nil
+}
+
+// pgConn is a conn over a *pgconn.PgConn.
+type pgConn struct {
+	conn *pgconn.PgConn
+}
+
+func (c *pgConn) query(ctx context.Context, sql string) ([][][]byte, error) {
+	results, err := c.conn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	return results[len(results)-1].Rows, nil
+}
+
+func (c *pgConn) startReplication(ctx context.Context, sql string) error {
+	buf := (&pgproto3.Query{String: sql}).Encode(nil)
+	if err := c.conn.SendBytes(ctx, buf); err != nil {
+		return err
+	}
+
+	msg, err := c.conn.ReceiveMessage(ctx)
+	if err != nil {
+		return err
+	}
+	switch msg := msg.(type) {
+	case *pgproto3.CopyBothResponse:
+		return nil
+	case *pgproto3.ErrorResponse:
+		return pgconn.ErrorResponseToPgError(msg)
+	default:
+		return errors.Errorf("unexpected response %T", msg)
+	}
+}
+
+func (c *pgConn) receive(ctx context.Context) (pgproto3.BackendMessage, error) {
+	return c.conn.ReceiveMessage(ctx)
+}
+
+func (c *pgConn) sendCopyData(ctx context.Context, data []byte) error {
+	return c.conn.SendBytes(ctx, (&pgproto3.CopyData{Data: data}).Encode(nil))
+}
+
+func (c *pgConn) close(ctx context.Context) error {
+	return c.conn.Close(ctx)
+}
+
+// quoteIdentifier quotes a possibly schema qualified identifier.
+func quoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteLiteral quotes a string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,265 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LSN is a position in the write-ahead log of the server.
+type LSN uint64
+
+// String returns the LSN in the format of the server, e.g. 16/B374D848.
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+// Replication protocol messages, sent within CopyData messages.
+const (
+	xLogDataByteID                = 'w'
+	primaryKeepaliveMessageByteID = 'k'
+	standbyStatusUpdateByteID     = 'r'
+)
+
+// pgoutput message types handled by the client, the others are ignored.
+const (
+	beginByteID    = 'B'
+	commitByteID   = 'C'
+	relationByteID = 'R'
+	insertByteID   = 'I'
+)
+
+// Kinds of the columns of a tuple.
+const (
+	tupleNull      = 'n'
+	tupleUnchanged = 'u'
+	tupleText      = 't'
+)
+
+// postgresEpoch is the origin of the times of the replication protocol.
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// pgTime converts a replication protocol time to a time.Time.
+func pgTime(micros int64) time.Time {
+	return postgresEpoch.Add(time.Duration(micros) * time.Microsecond)
+}
+
+// reader reads the fields of a message, the first error is kept and the next
+// reads return zero values.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = errors.New("message is too short")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	i := strings.IndexByte(string(r.buf), 0)
+	if i < 0 {
+		r.err = errors.New("unterminated string")
+		return ""
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
+
+// xLogData is a chunk of the write-ahead log streamed by the server.
+type xLogData struct {
+	walStart LSN
+	walData  []byte
+}
+
+// parseXLogData parses the XLogData message in data, without its type.
+func parseXLogData(data []byte) (*xLogData, error) {
+	r := &reader{buf: data}
+	x := &xLogData{walStart: LSN(r.uint64())}
+	r.uint64() // ServerWALEnd.
+	r.uint64() // ServerTime.
+	if r.err != nil {
+		return nil, errors.Wrap(r.err, "invalid xlog data")
+	}
+	x.walData = r.buf
+
+	return x, nil
+}
+
+// parseKeepalive parses the primary keepalive message in data, without its
+// type, and reports whether the server requested a reply.
+func parseKeepalive(data []byte) (bool, error) {
+	r := &reader{buf: data}
+	r.uint64() // ServerWALEnd.
+	r.uint64() // ServerTime.
+	reply := r.byte()
+	if r.err != nil {
+		return false, errors.Wrap(r.err, "invalid keepalive message")
+	}
+
+	return reply == 1, nil
+}
+
+// standbyStatusUpdate encodes a standby status update, confirming the changes
+// up to lsn were processed.
+func standbyStatusUpdate(lsn LSN, now time.Time) []byte {
+	buf := make([]byte, 34)
+	buf[0] = standbyStatusUpdateByteID
+	// Written, flushed and applied positions.
+	binary.BigEndian.PutUint64(buf[1:], uint64(lsn))
+	binary.BigEndian.PutUint64(buf[9:], uint64(lsn))
+	binary.BigEndian.PutUint64(buf[17:], uint64(lsn))
+	binary.BigEndian.PutUint64(buf[25:], uint64(now.Sub(postgresEpoch).Microseconds()))
+	// The last byte requests no reply.
+	return buf
+}
+
+// column is a column of a relation.
+type column struct {
+	name    string
+	typeOID uint32
+}
+
+// relation describes a table, before its first change in a session.
+type relation struct {
+	id        uint32
+	namespace string
+	name      string
+	columns   []column
+}
+
+// begin marks the start of a transaction.
+type begin struct {
+	commitTime time.Time
+	xid        uint32
+}
+
+// commit marks the end of a transaction.
+type commit struct {
+	endLSN LSN
+}
+
+// tupleColumn is the value of a column of a tuple.
+type tupleColumn struct {
+	kind byte
+	data []byte
+}
+
+// insert is a row inserted in a relation.
+type insert struct {
+	relationID uint32
+	tuple      []tupleColumn
+}
+
+// parseMessage parses a pgoutput message. It returns nil for the message
+// types which are not handled.
+func parseMessage(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty pgoutput message")
+	}
+
+	r := &reader{buf: data[1:]}
+	var msg interface{}
+	switch data[0] {
+	case beginByteID:
+		r.uint64() // FinalLSN.
+		msg = &begin{commitTime: pgTime(int64(r.uint64())), xid: r.uint32()}
+	case commitByteID:
+		r.byte()   // Flags.
+		r.uint64() // CommitLSN.
+		msg = &commit{endLSN: LSN(r.uint64())}
+		r.uint64() // CommitTime.
+	case relationByteID:
+		rel := &relation{
+			id:        r.uint32(),
+			namespace: r.string(),
+			name:      r.string(),
+		}
+		r.byte() // ReplicaIdentity.
+		n := int(r.uint16())
+		for i := 0; i < n && r.err == nil; i++ {
+			r.byte() // Flags.
+			col := column{name: r.string(), typeOID: r.uint32()}
+			r.uint32() // TypeModifier.
+			rel.columns = append(rel.columns, col)
+		}
+		msg = rel
+	case insertByteID:
+		ins := &insert{relationID: r.uint32()}
+		if kind := r.byte(); r.err == nil && kind != 'N' {
+			return nil, errors.Errorf("invalid insert tuple type %q", kind)
+		}
+		ins.tuple = parseTuple(r)
+		msg = ins
+	default:
+		return nil, nil
+	}
+
+	if r.err != nil {
+		return nil, errors.Wrapf(r.err, "invalid pgoutput message %q", data[0])
+	}
+
+	return msg, nil
+}
+
+// parseTuple parses the tuple data of a message.
+func parseTuple(r *reader) []tupleColumn {
+	n := int(r.uint16())
+	tuple := make([]tupleColumn, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		col := tupleColumn{kind: r.byte()}
+		switch col.kind {
+		case tupleNull, tupleUnchanged:
+		case tupleText:
+			col.data = r.next(int(r.uint32()))
+		default:
+			r.err = errors.Errorf("invalid tuple column kind %q", col.kind)
+		}
+		tuple = append(tuple, col)
+	}
+
+	return tuple
+}
@@ -0,0 +1,257 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Encoders of the replication protocol and pgoutput messages sent by the
+// server.
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+func encodeXLogData(walStart LSN, data []byte) []byte {
+	b := []byte{xLogDataByteID}
+	b = appendUint64(b, uint64(walStart))
+	b = appendUint64(b, uint64(walStart))
+	b = appendUint64(b, 0)
+	return append(b, data...)
+}
+
+func encodeKeepalive(reply bool) []byte {
+	b := []byte{primaryKeepaliveMessageByteID}
+	b = appendUint64(b, 0)
+	b = appendUint64(b, 0)
+	if reply {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func encodeBegin(commitTime time.Time) []byte {
+	b := []byte{beginByteID}
+	b = appendUint64(b, 0)
+	b = appendUint64(b, uint64(commitTime.Sub(postgresEpoch).Microseconds()))
+	return appendUint32(b, 1)
+}
+
+func encodeCommit(endLSN LSN) []byte {
+	b := []byte{commitByteID, 0}
+	b = appendUint64(b, uint64(endLSN))
+	b = appendUint64(b, uint64(endLSN))
+	return appendUint64(b, 0)
+}
+
+func encodeRelation(rel *relation) []byte {
+	b := []byte{relationByteID}
+	b = appendUint32(b, rel.id)
+	b = appendString(b, rel.namespace)
+	b = appendString(b, rel.name)
+	b = append(b, 'd')
+	b = appendUint16(b, uint16(len(rel.columns)))
+	for _, col := range rel.columns {
+		b = append(b, 0)
+		b = appendString(b, col.name)
+		b = appendUint32(b, col.typeOID)
+		b = appendUint32(b, 0xFFFFFFFF)
+	}
+	return b
+}
+
+// encodeInsert encodes an insert of the values, a nil value is NULL.
+func encodeInsert(relationID uint32, values ...*string) []byte {
+	b := []byte{insertByteID}
+	b = appendUint32(b, relationID)
+	b = append(b, 'N')
+	b = appendUint16(b, uint16(len(values)))
+	for _, v := range values {
+		if v == nil {
+			b = append(b, tupleNull)
+			continue
+		}
+		b = append(b, tupleText)
+		b = appendUint32(b, uint32(len(*v)))
+		b = append(b, *v...)
+	}
+	return b
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestLSN_String(t *testing.T) {
+	assert.Equal(t, "0/0", LSN(0).String())
+	assert.Equal(t, "16/B374D848", LSN(0x16B374D848).String())
+}
+
+func TestParseMessage(t *testing.T) {
+	commitTime := time.Date(2022, time.January, 31, 14, 15, 17, 181841000, time.UTC)
+	rel := &relation{
+		id:        42,
+		namespace: "public",
+		name:      "events",
+		columns:   []column{{name: "id", typeOID: 2950}, {name: "version", typeOID: int8OID}},
+	}
+
+	testCases := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, msg interface{}, err error)
+	}{
+		{
+			name: "should parse a begin message",
+			data: encodeBegin(commitTime),
+			check: func(t *testing.T, msg interface{}, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, &begin{commitTime: commitTime, xid: 1}, msg)
+			},
+		},
+		{
+			name: "should parse a commit message",
+			data: encodeCommit(LSN(1234)),
+			check: func(t *testing.T, msg interface{}, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, &commit{endLSN: 1234}, msg)
+			},
+		},
+		{
+			name: "should parse a relation message",
+			data: encodeRelation(rel),
+			check: func(t *testing.T, msg interface{}, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, rel, msg)
+			},
+		},
+		{
+			name: "should parse an insert message",
+			data: encodeInsert(42, strPtr("some-id"), nil),
+			check: func(t *testing.T, msg interface{}, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, &insert{
+					relationID: 42,
+					tuple: []tupleColumn{
+						{kind: tupleText, data: []byte("some-id")},
+						{kind: tupleNull},
+					},
+				}, msg)
+			},
+		},
+		{
+			name: "should ignore the other messages",
+			data: []byte{'D', 0, 0, 0, 42},
+			check: func(t *testing.T, msg interface{}, err error) {
+				assert.NoError(t, err)
+				assert.Nil(t, msg)
+			},
+		},
+		{
+			name: "should return error: message is too short",
+			data: encodeInsert(42, strPtr("some-id"))[:12],
+			check: func(t *testing.T, msg interface{}, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "message is too short")
+			},
+		},
+		{
+			name: "should return error: empty pgoutput message",
+			check: func(t *testing.T, msg interface{}, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "empty pgoutput message")
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := parseMessage(tc.data)
+			tc.check(t, msg, err)
+		})
+	}
+}
+
+func TestStandbyStatusUpdate(t *testing.T) {
+	now := postgresEpoch.Add(time.Second)
+	data := standbyStatusUpdate(LSN(1234), now)
+
+	assert.Len(t, data, 34)
+	assert.Equal(t, byte(standbyStatusUpdateByteID), data[0])
+	for _, offset := range []int{1, 9, 17} {
+		assert.Equal(t, uint64(1234), binary.BigEndian.Uint64(data[offset:]))
+	}
+	assert.Equal(t, uint64(time.Second.Microseconds()), binary.BigEndian.Uint64(data[25:]))
+	assert.Equal(t, byte(0), data[33])
+}
+
+func TestEnvelope(t *testing.T) {
+	rel := &relation{
+		name: "events",
+		columns: []column{
+			{name: "id", typeOID: 2950},
+			{name: "published", typeOID: boolOID},
+			{name: "version", typeOID: int8OID},
+			{name: "score", typeOID: float8OID},
+			{name: "ratio", typeOID: float8OID},
+			{name: "at", typeOID: timestamptzOID},
+			{name: "local_at", typeOID: timestampOID},
+			{name: "data", typeOID: 3802},
+			{name: "metadata", typeOID: 3802},
+			{name: "payload", typeOID: byteaOID},
+		},
+	}
+
+	data, err := envelope(rel, []tupleColumn{
+		{kind: tupleText, data: []byte("some-id")},
+		{kind: tupleText, data: []byte("t")},
+		{kind: tupleText, data: []byte("92697")},
+		{kind: tupleText, data: []byte("1.5")},
+		{kind: tupleText, data: []byte("NaN")},
+		{kind: tupleText, data: []byte("2022-01-31 09:15:17.181841-05")},
+		{kind: tupleText, data: []byte("2022-01-31 14:15:17")},
+		{kind: tupleText, data: []byte(`{"email": "someone@flahmingo.com"}`)},
+		{kind: tupleNull},
+		{kind: tupleText, data: []byte(`\x7b7d`)},
+	}, LSN(218487408), 767)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"payload": {
+			"id": "some-id",
+			"published": true,
+			"version": 92697,
+			"score": 1.5,
+			"ratio": "NaN",
+			"at": "2022-01-31T14:15:17.181841Z",
+			"local_at": "2022-01-31T14:15:17Z",
+			"data": "{\"email\": \"someone@flahmingo.com\"}",
+			"metadata": null,
+			"payload": "e30=",
+			"__table": "events",
+			"__lsn": 218487408,
+			"__txId": 767,
+			"__deleted": "false"
+		}
+	}`, string(data))
+
+	_, err = envelope(rel, nil, 0, 0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "row has 0 columns, relation events has 10")
+}
@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// duplicateObject is the SQLSTATE of an object which already exists.
+const duplicateObject = "42710"
+
+// isDuplicateObject reports whether err is returned for an object which
+// already exists, e.g. created by another instance in the meantime.
+func isDuplicateObject(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == duplicateObject
+}
+
+// EnsureTopics checks whether the publication of a topic exists or not.
+//
+// If createTopic is `true` it will create a publication of the inserts in the
+// tables, see WithTables.
+func (p *PubSub) EnsureTopics(topics ...string) error {
+	for _, topic := range topics {
+		if topic == "" {
+			continue
+		}
+
+		if err := p.topicInit(topic); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// topicInit checks the publication of a topic and create it, if required.
+func (p *PubSub) topicInit(topic string) error {
+	ctx := context.Background()
+
+	p.logger.Info(
+		fmt.Sprintf("checking if publication (%s) exists", topic), zap.String("topic", topic),
+	)
+	exists, err := p.publicationExists(ctx, topic)
+	if err != nil {
+		return errors.Wrapf(err, "could not check if publication '%s' exists", topic)
+	}
+	if exists {
+		return nil
+	}
+
+	if !p.createTopic {
+		return errors.Errorf("publication %s does not exists", topic)
+	}
+
+	p.logger.Info(fmt.Sprintf("creating publication %s", topic), zap.String("topic", topic))
+	tables := make([]string, len(p.tables))
+	for i, table := range p.tables {
+		tables[i] = quoteIdentifier(table)
+	}
+	_, err = p.query(ctx, fmt.Sprintf(
+		"CREATE PUBLICATION %s FOR TABLE %s WITH (publish = 'insert')",
+		quoteIdentifier(topic), strings.Join(tables, ", "),
+	))
+	if err != nil && !isDuplicateObject(err) {
+		return errors.Wrapf(err, "unable to create %s publication", topic)
+	}
+
+	return nil
+}
+
+// publicationExists reports whether a publication exists.
+func (p *PubSub) publicationExists(ctx context.Context, name string) (bool, error) {
+	rows, err := p.query(
+		ctx, "SELECT 1 FROM pg_publication WHERE pubname = "+quoteLiteral(name),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return len(rows) > 0, nil
+}
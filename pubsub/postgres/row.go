@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Type OIDs of the columns converted to JSON values, the values of the other
+// types are kept as strings.
+const (
+	boolOID        = 16
+	byteaOID       = 17
+	int8OID        = 20
+	int2OID        = 21
+	int4OID        = 23
+	oidOID         = 26
+	float4OID      = 700
+	float8OID      = 701
+	timestampOID   = 1114
+	timestamptzOID = 1184
+)
+
+// timestampLayouts are the text formats of the timestamps with a time zone,
+// the offset is printed with minutes only when they are not zero.
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07:00:00",
+}
+
+// envelope converts a row inserted in a relation to a flattened Debezium
+// event, as produced by the ExtractNewRecordState transformation with the
+// table, lsn and txId fields added.
+func envelope(rel *relation, tuple []tupleColumn, lsn LSN, xid uint32) ([]byte, error) {
+	if len(tuple) != len(rel.columns) {
+		return nil, errors.Errorf(
+			"row has %d columns, relation %s has %d", len(tuple), rel.name, len(rel.columns),
+		)
+	}
+
+	payload := make(map[string]interface{}, len(tuple)+4)
+	for i, col := range tuple {
+		switch col.kind {
+		case tupleNull:
+			payload[rel.columns[i].name] = nil
+		case tupleText:
+			payload[rel.columns[i].name] = value(rel.columns[i].typeOID, string(col.data))
+		}
+	}
+	payload["__table"] = rel.name
+	payload["__lsn"] = uint64(lsn)
+	payload["__txId"] = xid
+	payload["__deleted"] = "false"
+
+	data, err := json.Marshal(map[string]interface{}{"payload": payload})
+	return data, errors.Wrap(err, "unable to encode row")
+}
+
+// value converts the text representation of a column to the JSON value
+// Debezium produces for it.
+func value(typeOID uint32, text string) interface{} {
+	switch typeOID {
+	case boolOID:
+		return text == "t"
+	case int2OID, int4OID, int8OID, oidOID:
+		return json.Number(text)
+	case float4OID, float8OID:
+		// NaN and infinities are not JSON numbers.
+		f, err := strconv.ParseFloat(text, 64)
+		if err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return json.Number(text)
+		}
+	case timestamptzOID:
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t.UTC().Format(time.RFC3339Nano)
+			}
+		}
+	case timestampOID:
+		if t, err := time.Parse("2006-01-02 15:04:05.999999999", text); err == nil {
+			return t.Format(time.RFC3339Nano)
+		}
+	case byteaOID:
+		// Bytes are base64 encoded, their text format is hexadecimal.
+		if strings.HasPrefix(text, `\x`) {
+			if b, err := hex.DecodeString(text[2:]); err == nil {
+				return base64.StdEncoding.EncodeToString(b)
+			}
+		}
+	}
+
+	return text
+}
@@ -0,0 +1,466 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Subscriber = (*PubSub)(nil)
+
+// ErrStopped is returned when subscribing to or stopping a stopped pubsub.
+var ErrStopped = transport.ErrStopped
+
+// publication returns the publication decoded by a subscription.
+func (p *PubSub) publication(subscription string) string {
+	if publication, ok := p.subscriptions[subscription]; ok {
+		return publication
+	}
+
+	return subscription
+}
+
+// subInit checks for existence of the replication slot of a subscription and
+// creates it, if required.
+func (p *PubSub) subInit(subscription, publication string) error {
+	ctx := context.Background()
+
+	exists, err := p.publicationExists(ctx, publication)
+	if err != nil {
+		return errors.Wrapf(err, "could not check if publication '%s' exists", publication)
+	}
+	if !exists {
+		return errors.Errorf("publication %s does not exists", publication)
+	}
+
+	p.logger.Info("checking if subscription exists", zap.String("name", subscription))
+	rows, err := p.query(
+		ctx,
+		"SELECT 1 FROM pg_replication_slots WHERE slot_name = "+quoteLiteral(subscription),
+	)
+	if err != nil {
+		return errors.Wrapf(
+			err, "could not check if subscription '%s' exists", subscription,
+		)
+	}
+	if len(rows) > 0 {
+		return nil
+	}
+
+	if !p.createSub {
+		return errors.Errorf("subscription %s does not exists", subscription)
+	}
+
+	p.logger.Info("creating subscription", zap.String("name", subscription))
+	_, err = p.query(ctx, fmt.Sprintf(
+		"CREATE_REPLICATION_SLOT %s LOGICAL pgoutput NOEXPORT_SNAPSHOT",
+		quoteIdentifier(subscription),
+	))
+	// The slot may have been created by another instance in the meantime.
+	if err != nil && !isDuplicateObject(err) {
+		return errors.Wrapf(err, "unable to create %s subscription", subscription)
+	}
+
+	return nil
+}
+
+// Subscribe subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The inserted rows are processed one at a time, in the order of their
+// transactions. A message which the handler fails to process is processed
+// again after a delay, see WithRetryBackoff, blocking the next ones, until it
+// reaches the max attempts, see WithMaxAttempts: it is then skipped. The
+// position of a transaction is confirmed to the server once all its messages
+// are acknowledged or skipped, the transactions which were not confirmed are
+// streamed again after a restart. The status updates keep being sent while
+// the handler runs.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+//
+// Example:
+//
+//	pubsub, err := postgres.NewClient("postgres://localhost:5432/db")
+//	if err != nil {
+//		// do something with error
+//		return
+//	}
+//
+//	pubsub.Subscribe("some-subscription-name", handler)
+//	pubsub.Subscribe("some-subscription-name2", handler2)
+func (p *PubSub) Subscribe(
+	subscription string, handler ship.MessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.Handler(handler),
+	)
+}
+
+// SubscribeRaw subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The data of the messages is the flattened Debezium event of the inserted
+// rows. They are processed and confirmed as in Subscribe.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+func (p *PubSub) SubscribeRaw(
+	subscription string, handler ship.RawMessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.RawHandler(handler),
+	)
+}
+
+// subscribe starts streaming the replication slot of a subscription on its
+// own connection and processes the inserted rows with process.
+func (p *PubSub) subscribe(subscription, hName string, process transport.ProcessFunc) error {
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+
+	publication := p.publication(subscription)
+	if err := p.subInit(subscription, publication); err != nil {
+		p.lifecycle.Release()
+		return errors.WithStack(err)
+	}
+
+	c, err := p.startReplication(subscription, publication)
+	if err != nil {
+		p.lifecycle.Release()
+		return errors.WithStack(err)
+	}
+
+	health := p.health.Track(subscription, hName)
+	cons := &consumer{
+		p:          p,
+		conn:       c,
+		subID:      subscription,
+		hName:      hName,
+		health:     health,
+		process:    process,
+		relations:  make(map[uint32]*relation),
+		lastStatus: time.Now(),
+	}
+
+	p.logger.Info(
+		"starting listener for subscription", zap.String("subscription", subscription),
+	)
+	go cons.consume()
+
+	return nil
+}
+
+// startReplication opens a replication connection streaming the changes of
+// a slot, from its last confirmed position.
+func (p *PubSub) startReplication(slot, publication string) (conn, error) {
+	ctx := p.receiveCtx
+
+	c, err := dial(ctx, p.connString)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to postgres")
+	}
+
+	err = c.startReplication(ctx, fmt.Sprintf(
+		"START_REPLICATION SLOT %s LOGICAL 0/0 (proto_version '1', publication_names %s)",
+		quoteIdentifier(slot), quoteLiteral(quoteIdentifier(publication)),
+	))
+	if err != nil {
+		_ = c.close(context.Background())
+		return nil, errors.Wrapf(err, "unable to start replication of %s subscription", slot)
+	}
+
+	return c, nil
+}
+
+// consumer consumes the changes streamed by the replication slot of a
+// subscription.
+type consumer struct {
+	p       *PubSub
+	conn    conn
+	subID   string
+	hName   string
+	health  *transport.SubscriptionHealth
+	process transport.ProcessFunc
+
+	// relations are the tables described by the server, by id.
+	relations map[uint32]*relation
+	// commitTime is the commit time of the current transaction.
+	commitTime time.Time
+	// xid is the id of the current transaction.
+	xid uint32
+	// confirmed is the end position of the last processed transaction.
+	confirmed LSN
+	// lastStatus is the time of the last status update sent to the server.
+	lastStatus time.Time
+}
+
+// consume receives and processes the changes, until the pubsub is stopped,
+// the handler panics or the connection fails.
+//
+// The processed position is confirmed every standbyTimeout and once
+// stopped.
+func (c *consumer) consume() {
+	p := c.p
+	defer p.lifecycle.Release()
+	defer func() { _ = c.conn.close(context.Background()) }()
+
+	p.logger.Debug(
+		"subscription started",
+		zap.String("subscription", c.subID),
+		zap.String("handlerName", c.hName),
+	)
+
+	for p.receiveCtx.Err() == nil {
+		ctx, cancel := context.WithDeadline(p.receiveCtx, c.lastStatus.Add(p.standbyTimeout))
+		msg, err := c.conn.receive(ctx)
+		timedOut := err != nil && ctx.Err() == context.DeadlineExceeded
+		cancel()
+
+		if p.receiveCtx.Err() != nil {
+			break
+		}
+		if timedOut {
+			c.sendStatus()
+			continue
+		}
+
+		if err == nil {
+			var ok bool
+			ok, err = c.handleMessage(msg)
+			if !ok {
+				return
+			}
+		}
+		if err != nil {
+			p.logger.Error(
+				"unable to receive changes from subscription",
+				zap.Error(err),
+				zap.String("subscription", c.subID),
+			)
+			transport.ReportError(p.errCh, err)
+			c.health.Stopped(err)
+			return
+		}
+	}
+
+	c.sendStatus()
+	c.health.Stopped(nil)
+}
+
+// handleMessage handles a message of the replication stream.
+//
+// It reports false when the handler panicked.
+func (c *consumer) handleMessage(msg pgproto3.BackendMessage) (bool, error) {
+	switch msg := msg.(type) {
+	case *pgproto3.CopyData:
+		if len(msg.Data) == 0 {
+			return true, errors.New("empty replication message")
+		}
+
+		switch msg.Data[0] {
+		case primaryKeepaliveMessageByteID:
+			reply, err := parseKeepalive(msg.Data[1:])
+			if err != nil {
+				return true, err
+			}
+			if reply {
+				c.sendStatus()
+			}
+		case xLogDataByteID:
+			x, err := parseXLogData(msg.Data[1:])
+			if err != nil {
+				return true, err
+			}
+			return c.handleChange(x)
+		}
+	case *pgproto3.ErrorResponse:
+		return true, pgconn.ErrorResponseToPgError(msg)
+	}
+
+	return true, nil
+}
+
+// handleChange handles a pgoutput message, the inserted rows are processed
+// and the position of the transactions is recorded once they are committed.
+//
+// It reports false when the handler panicked.
+func (c *consumer) handleChange(x *xLogData) (bool, error) {
+	m, err := parseMessage(x.walData)
+	if err != nil {
+		return true, err
+	}
+
+	switch m := m.(type) {
+	case *relation:
+		c.relations[m.id] = m
+	case *begin:
+		c.commitTime = m.commitTime
+		c.xid = m.xid
+	case *commit:
+		c.confirmed = m.endLSN
+	case *insert:
+		rel, ok := c.relations[m.relationID]
+		if !ok {
+			return true, errors.Errorf("unknown relation %d", m.relationID)
+		}
+
+		data, err := envelope(rel, m.tuple, x.walStart, c.xid)
+		if err != nil {
+			return true, err
+		}
+
+		return c.handle(&ship.RawMessage{
+			ID:          x.walStart.String(),
+			Data:        data,
+			Attributes:  make(map[string]string),
+			PublishTime: c.commitTime,
+		}), nil
+	}
+
+	return true, nil
+}
+
+// handle processes a message until it is acknowledged or it reached the max
+// attempts, waiting for a growing delay between the attempts. It gives up
+// once the pubsub is stopped, the transaction of the message is not confirmed
+// then.
+//
+// It reports false when the handler panicked.
+func (c *consumer) handle(raw *ship.RawMessage) (ok bool) {
+	p := c.p
+
+	// We don't want an unexpected error in consumer to take down whole application.
+	// We'll try to recover from the panic and remove the subscription from listening.
+	//
+	// This protects an application from going in a continuous crash loop in a
+	// orchestrated environment.
+	defer func() {
+		if r := recover(); r != nil {
+			c.health.Recovered(
+				p.logger, r,
+				"Leaving the transaction unconfirmed and removing the subscription from listening.",
+				zap.String(logIDKey, raw.ID),
+			)
+			p.metrics.Nacked(c.subID, c.hName)
+			ok = false
+		}
+	}()
+
+	// The stream is not read while the message is processed, the status
+	// updates are sent meanwhile so the server does not drop the connection.
+	defer c.keepalive()()
+
+	delay := p.minBackoff
+	for attempt := 1; ; attempt++ {
+		c.health.SetLastMessageAt(time.Now())
+
+		// The handlers are given their own context, so they can be cancelled
+		// on Stop.
+		err := c.process(p.handlerCtx, c.subID, c.hName, raw)
+		if err == nil {
+			p.metrics.Acked(c.subID, c.hName)
+			return true
+		}
+		p.metrics.Nacked(c.subID, c.hName)
+
+		if p.maxAttempts > 0 && attempt >= p.maxAttempts {
+			p.logger.Error(
+				"message failed to be processed too many times: skipping it",
+				zap.Error(err),
+				zap.String("subscription", c.subID),
+				zap.String("handlerName", c.hName),
+				zap.String(logIDKey, raw.ID),
+			)
+			p.metrics.DeadLettered(c.subID, c.hName)
+			return true
+		}
+
+		if !c.wait(delay) {
+			return true
+		}
+		if delay *= 2; delay > p.maxBackoff {
+			delay = p.maxBackoff
+		}
+	}
+}
+
+// wait waits for d. It reports false when the pubsub is stopped.
+func (c *consumer) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.p.receiveCtx.Done():
+		return false
+	}
+}
+
+// keepalive sends the status updates every standbyTimeout from its own
+// goroutine, until the returned function is called. The stream must not be
+// read meanwhile.
+func (c *consumer) keepalive() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(c.p.standbyTimeout)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.sendStatus()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// sendStatus confirms the position of the last processed transaction to the
+// server, which keeps the connection alive as well.
+func (c *consumer) sendStatus() {
+	p := c.p
+
+	c.lastStatus = time.Now()
+	err := c.conn.sendCopyData(
+		context.Background(), standbyStatusUpdate(c.confirmed, c.lastStatus),
+	)
+	if err != nil {
+		p.logger.Error(
+			"unable to send status update",
+			zap.Error(err),
+			zap.String("subscription", c.subID),
+		)
+		return
+	}
+
+	p.logger.Debug(
+		"sent status update",
+		zap.String("subscription", c.subID),
+		zap.Stringer("lsn", c.confirmed),
+	)
+}
@@ -0,0 +1,512 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+// testRecorder is a metrics.Recorder counting the recorded metrics.
+type testRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{counts: make(map[string]int)}
+}
+
+func (r *testRecorder) inc(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+func (r *testRecorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+func (r *testRecorder) PublishSucceeded(topic string, _ time.Duration) {
+	r.inc("published:" + topic)
+}
+
+func (r *testRecorder) PublishFailed(topic string, _ time.Duration) {
+	r.inc("publish_failed:" + topic)
+}
+
+func (r *testRecorder) Handled(sub, handler string, _ time.Duration) {
+	r.inc("handled:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Acked(sub, handler string) {
+	r.inc("acked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Nacked(sub, handler string) {
+	r.inc("nacked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DeadLettered(sub, handler string) {
+	r.inc("dead_lettered:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DecodeFailed(sub, handler, reason string) {
+	r.inc("decode_failed:" + sub + ":" + handler + ":" + reason)
+}
+
+type rawHandlerFunc func(context.Context, *ship.RawMessage) error
+
+func (f rawHandlerFunc) HandleRawMessage(ctx context.Context, m *ship.RawMessage) error {
+	return f(ctx, m)
+}
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testEvent) EventName() string { return "TestEvent" }
+
+// eventsRelation is the outbox table of the tests.
+var eventsRelation = &relation{
+	id:        16385,
+	namespace: "public",
+	name:      "events",
+	columns: []column{
+		{name: "id", typeOID: 2950},
+		{name: "type", typeOID: 25},
+		{name: "aggregate_id", typeOID: 25},
+		{name: "aggregate_type", typeOID: 25},
+		{name: "data", typeOID: 3802},
+		{name: "at", typeOID: timestamptzOID},
+		{name: "version", typeOID: int8OID},
+	},
+}
+
+// testCommitTime is the commit time of the transactions sent by sendTx.
+var testCommitTime = time.Date(2022, time.January, 31, 14, 15, 17, 0, time.UTC)
+
+// outboxRow returns the values of a row of the outbox table.
+func outboxRow(t *testing.T, id string, event ship.Event) []*string {
+	t.Helper()
+
+	data, err := json.Marshal(event)
+	assert.NoError(t, err)
+
+	return []*string{
+		strPtr(id),
+		strPtr(event.EventName()),
+		strPtr("some-aggregate-id"),
+		strPtr("user"),
+		strPtr(string(data)),
+		strPtr("2022-01-31 14:15:17.181841+00"),
+		strPtr("3"),
+	}
+}
+
+// sendTx sends a transaction inserting the rows in the outbox table, from
+// position start. It returns the end position of the transaction.
+func sendTx(slot *fakeSlot, start LSN, rows ...[]*string) LSN {
+	lsn := start
+	send := func(data []byte) {
+		slot.changes <- encodeXLogData(lsn, data)
+		lsn++
+	}
+
+	send(encodeBegin(testCommitTime))
+	send(encodeRelation(eventsRelation))
+	for _, row := range rows {
+		send(encodeInsert(eventsRelation.id, row...))
+	}
+	end := lsn + 1
+	send(encodeCommit(end))
+
+	return end
+}
+
+// newSubscriptionSuite returns a test suite with the test publication,
+// replication slots are created on subscribe.
+func newSubscriptionSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	opts = append([]Option{
+		WithCreateTopic(true),
+		WithCreateSubscription(true),
+		WithRetryBackoff(10*time.Millisecond, 10*time.Millisecond),
+	}, opts...)
+
+	s := newTestSuite(t, opts...)
+	assert.NoError(t, s.client.EnsureTopics(testPublication))
+
+	return s
+}
+
+func TestPubSub_Subscribe(t *testing.T) {
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&userCreated{}))
+
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithRegistry(registry), WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		received []*ship.Message
+		failed   bool
+	)
+	handler := ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first attempt of the last message fails, it must be retried.
+		if m.ID == "some-last-id" && !failed {
+			failed = true
+			return errors.New("some error")
+		}
+
+		received = append(received, m)
+		return nil
+	})
+
+	err := suite.client.Subscribe(testSubscription, handler)
+	assert.NoError(t, err)
+
+	slot := suite.server.slot(testSubscription)
+	end := sendTx(
+		slot, 100,
+		outboxRow(t, "some-id", &userCreated{ID: "some-user-id", Email: "someone@flahmingo.com"}),
+		outboxRow(t, "some-other-id", &testEvent{Name: "unregistered"}),
+	)
+	end = sendTx(
+		slot, end,
+		outboxRow(t, "some-last-id", &userCreated{Email: "someone@flahmingo.com"}),
+	)
+
+	hName := "MessageHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("acked:"+testSubscription+":"+hName) == 3
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	if assert.Len(t, received, 2) {
+		m := received[0]
+		assert.Equal(t, "some-id", m.ID)
+		assert.Equal(t, "UserCreated", m.Type)
+		assert.Equal(t, "some-aggregate-id", m.AggregateID)
+		assert.Equal(t, "user", m.AggregateType)
+		assert.Equal(t, uint64(3), m.Version)
+		assert.True(t, m.At.Equal(time.Date(2022, time.January, 31, 14, 15, 17, 181841000, time.UTC)))
+		assert.Equal(t, &userCreated{ID: "some-user-id", Email: "someone@flahmingo.com"}, m.Data)
+
+		assert.Equal(t, "some-last-id", received[1].ID)
+	}
+	mu.Unlock()
+
+	assert.Equal(t, 1, recorder.count("nacked:"+testSubscription+":"+hName))
+	assert.Equal(
+		t, 1,
+		recorder.count("decode_failed:"+testSubscription+":"+hName+":"+ship.DecodeReasonUnregistered),
+	)
+
+	// The end of the last transaction is confirmed.
+	assert.Eventually(t, func() bool {
+		confirmed, _ := suite.server.confirmed(testSubscription)
+		return confirmed == end
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_SubscribeRaw(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	received := make(chan *ship.RawMessage, 1)
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	sendTx(
+		suite.server.slot(testSubscription), 0x100000000,
+		outboxRow(t, "some-id", &testEvent{Name: "ship"}),
+	)
+
+	select {
+	case m := <-received:
+		// The insert is the third message of the transaction.
+		assert.Equal(t, "1/2", m.ID)
+		assert.Equal(t, testCommitTime, m.PublishTime)
+		assert.Empty(t, m.Attributes)
+
+		var event struct {
+			Payload map[string]interface{} `json:"payload"`
+		}
+		assert.NoError(t, json.Unmarshal(m.Data, &event))
+		assert.Equal(t, "some-id", event.Payload["id"])
+		assert.Equal(t, "TestEvent", event.Payload["type"])
+		assert.Equal(t, `{"name":"ship"}`, event.Payload["data"])
+		assert.Equal(t, "events", event.Payload["__table"])
+		assert.Equal(t, float64(0x100000002), event.Payload["__lsn"])
+		assert.Equal(t, float64(1), event.Payload["__txId"])
+		assert.Equal(t, "false", event.Payload["__deleted"])
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+}
+
+func TestPubSub_SubscribeKeepalive(t *testing.T) {
+	suite := newSubscriptionSuite(t, WithStandbyTimeout(time.Hour))
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	slot := suite.server.slot(testSubscription)
+	slot.changes <- encodeKeepalive(false)
+	slot.changes <- encodeKeepalive(true)
+
+	// Only the keepalive requesting a reply is answered.
+	assert.Eventually(t, func() bool {
+		_, statuses := suite.server.confirmed(testSubscription)
+		return statuses == 1
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_SubscribeKeepaliveWhileProcessing(t *testing.T) {
+	suite := newSubscriptionSuite(t, WithStandbyTimeout(10*time.Millisecond))
+	defer suite.Teardown(t)
+
+	release := make(chan struct{})
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			<-release
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	slot := suite.server.slot(testSubscription)
+	end := sendTx(slot, 100, outboxRow(t, "some-id", &testEvent{Name: "ship"}))
+
+	// The status updates are sent while the handler is blocked.
+	_, before := suite.server.confirmed(testSubscription)
+	assert.Eventually(t, func() bool {
+		_, statuses := suite.server.confirmed(testSubscription)
+		return statuses >= before+3
+	}, 10*time.Second, 10*time.Millisecond)
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		confirmed, _ := suite.server.confirmed(testSubscription)
+		return confirmed == end
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_SubscribeMaxAttempts(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithMetrics(recorder), WithMaxAttempts(3))
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		attempts = make(map[string]int)
+	)
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts[m.ID]++
+			return errors.New("some error")
+		}),
+	)
+	assert.NoError(t, err)
+
+	slot := suite.server.slot(testSubscription)
+	end := sendTx(slot, 100, outboxRow(t, "some-id", &testEvent{Name: "ship"}))
+
+	// The message is skipped, so its transaction is confirmed.
+	assert.Eventually(t, func() bool {
+		confirmed, _ := suite.server.confirmed(testSubscription)
+		return confirmed == end
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, map[string]int{"0/66": 3}, attempts)
+	mu.Unlock()
+
+	hName := "rawHandlerFunc"
+	assert.Equal(t, 3, recorder.count("nacked:"+testSubscription+":"+hName))
+	assert.Equal(t, 1, recorder.count("dead_lettered:"+testSubscription+":"+hName))
+	assert.Equal(t, 0, recorder.count("acked:"+testSubscription+":"+hName))
+}
+
+func TestPubSub_SubscribeStopWhileRetrying(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithMetrics(recorder))
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			return errors.New("some error")
+		}),
+	)
+	assert.NoError(t, err)
+
+	slot := suite.server.slot(testSubscription)
+	first := sendTx(slot, 100, outboxRow(t, "some-id", &testEvent{Name: "ship"}))
+	sendTx(slot, first, outboxRow(t, "some-other-id", &testEvent{Name: "ship"}))
+
+	assert.Eventually(t, func() bool {
+		return recorder.count("nacked:"+testSubscription+":rawHandlerFunc") >= 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	suite.Teardown(t)
+
+	// The failed transaction is not confirmed, so it is streamed again.
+	confirmed, _ := suite.server.confirmed(testSubscription)
+	assert.Equal(t, LSN(0), confirmed)
+	assert.Equal(t, ship.SubscriptionStopped, suite.client.Health().Subscriptions[0].State)
+}
+
+func TestPubSub_SubscribePanic(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			panic("some panic")
+		}),
+	)
+	assert.NoError(t, err)
+
+	sendTx(
+		suite.server.slot(testSubscription), 100,
+		outboxRow(t, "some-id", &testEvent{Name: "ship"}),
+	)
+
+	assert.Eventually(t, func() bool {
+		h := suite.client.Health()
+		return len(h.Subscriptions) == 1 &&
+			h.Subscriptions[0].State == ship.SubscriptionPanicked
+	}, 10*time.Second, 10*time.Millisecond)
+
+	h := suite.client.Health().Subscriptions[0]
+	assert.Equal(t, testSubscription, h.Subscription)
+	assert.Equal(t, "some panic", h.Error)
+
+	// The transaction is not confirmed, so it is not lost.
+	confirmed, _ := suite.server.confirmed(testSubscription)
+	assert.Equal(t, LSN(0), confirmed)
+}
+
+func TestPubSub_SubscribeStreamError(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	// An insert in a relation which was not described.
+	slot := suite.server.slot(testSubscription)
+	slot.changes <- encodeXLogData(100, encodeInsert(42, strPtr("some-id")))
+
+	assert.Eventually(t, func() bool {
+		h := suite.client.Health()
+		return len(h.Subscriptions) == 1 &&
+			h.Subscriptions[0].State == ship.SubscriptionErrored
+	}, 10*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "unknown relation 42", suite.client.Health().Subscriptions[0].Error)
+}
+
+func TestPubSub_SubscribeErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *suite)
+		err   string
+	}{
+		{
+			name: "should return error: publication does not exists",
+			opts: []Option{WithCreateSubscription(true)},
+			err:  "publication some-publication does not exists",
+		},
+		{
+			name: "should return error: subscription does not exists",
+			opts: []Option{WithCreateTopic(true)},
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.EnsureTopics(testPublication))
+			},
+			err: "subscription some-subscription does not exists",
+		},
+		{
+			name: "should return error: unable to connect to postgres",
+			opts: []Option{WithCreateTopic(true), WithCreateSubscription(true)},
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.EnsureTopics(testPublication))
+				s.server.dialErr = errors.New("some error")
+			},
+			err: "unable to connect to postgres",
+		},
+		{
+			name: "should return error: pubsub is stopped",
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.Stop())
+			},
+			err: ErrStopped.Error(),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+
+			if tc.setup != nil {
+				tc.setup(t, suite)
+			}
+			defer func() { _ = suite.client.Stop() }()
+
+			err := suite.client.Subscribe(
+				testSubscription,
+				ship.MessageHandlerFunc(func(context.Context, *ship.Message) error {
+					return nil
+				}),
+			)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
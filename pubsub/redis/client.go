@@ -0,0 +1,366 @@
+// Package redis contains an implementation of ship.Publisher and
+// ship.Subscriber interface over Redis Streams.
+//
+// A topic is a stream and a subscription is a consumer group of the stream,
+// consuming the stream of the same name unless it is mapped to another topic
+// with WithSubscription.
+package redis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/debezium"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Default consumption settings.
+const (
+	// DefaultClaimMinIdle is the time after which a pending message is
+	// reclaimed.
+	DefaultClaimMinIdle = 30 * time.Second
+	// DefaultBlock is the time a read waits for new messages.
+	DefaultBlock = time.Second
+)
+
+// Compile time check.
+var _ ship.HealthChecker = (*PubSub)(nil)
+
+// Option is an option setter used to configure creation.
+type Option func(*PubSub) error
+
+// shared wraps an option shared by the transports.
+func shared(opt transport.Option) Option {
+	return func(p *PubSub) error {
+		return opt(transport.Settings{
+			Name:     "redis",
+			Pipeline: p.pipeline,
+			Tracing:  &p.tracingOpts,
+			Metrics:  &p.metrics,
+			Logger:   &p.logger,
+		})
+	}
+}
+
+// WithCreateTopic toggle stream creation if it does not exists.
+func WithCreateTopic(create bool) Option {
+	return func(p *PubSub) error {
+		p.createTopic = create
+		return nil
+	}
+}
+
+// WithCreateSubscription toggle consumer group creation if it does not
+// exists. A created consumer group starts from the first message of the
+// stream.
+func WithCreateSubscription(create bool) Option {
+	return func(p *PubSub) error {
+		p.createSub = create
+		return nil
+	}
+}
+
+// WithSubscription maps a subscription to the topic consumed by its consumer
+// group. By default, a subscription consumes the topic of the same name.
+func WithSubscription(subscription, topic string) Option {
+	return func(p *PubSub) error {
+		if topic == "" {
+			return errors.Errorf("subscription %s has no topic", subscription)
+		}
+		p.subscriptions[subscription] = topic
+		return nil
+	}
+}
+
+// WithConsumer changes the name of the client in the consumer groups.
+// Default name is the hostname followed by the process id.
+//
+// Every instance of a service must have its own name.
+func WithConsumer(name string) Option {
+	return func(p *PubSub) error {
+		if name == "" {
+			return errors.New("consumer name cannot be empty")
+		}
+		p.consumer = name
+		return nil
+	}
+}
+
+// WithMaxLen trims the streams to approximately n messages when publishing.
+// Default is no trimming.
+func WithMaxLen(n int64) Option {
+	return func(p *PubSub) error {
+		if n <= 0 {
+			return errors.Errorf("invalid max length %d", n)
+		}
+		p.maxLen = n
+		return nil
+	}
+}
+
+// WithClaimMinIdle changes the time after which a pending message is
+// reclaimed by a consumer of its group.
+//
+// It is the time before the messages of a crashed consumer are taken over, as
+// well as the minimum delay before a message which failed to be processed is
+// redelivered.
+func WithClaimMinIdle(d time.Duration) Option {
+	return func(p *PubSub) error {
+		if d <= 0 {
+			return errors.Errorf("invalid claim min idle %s", d)
+		}
+		p.claimMinIdle = d
+		return nil
+	}
+}
+
+// WithMaxAttempts limits the number of deliveries of a message, as counted by
+// its consumer group. A message which failed to be processed on its n-th
+// delivery is added to the dead letter stream, see WithDeadLetterTopic, and
+// acknowledged. Default is unlimited: the message is reclaimed until it is
+// processed.
+func WithMaxAttempts(n int) Option {
+	return func(p *PubSub) error {
+		if n <= 0 {
+			return errors.Errorf("invalid max attempts %d", n)
+		}
+		p.maxAttempts = n
+		return nil
+	}
+}
+
+// WithDeadLetterTopic sets the stream receiving the messages which reached
+// the max attempts, with the Dead* fields describing their origin. Without
+// dead letter stream, such messages are skipped.
+func WithDeadLetterTopic(topic string) Option {
+	return func(p *PubSub) error {
+		if topic == "" {
+			return errors.New("dead letter topic cannot be empty")
+		}
+		p.deadLetter = topic
+		return nil
+	}
+}
+
+// WithBlock changes the time a read waits for new messages. Stop waits for
+// the pending reads, so it bounds the time Stop takes.
+func WithBlock(d time.Duration) Option {
+	return func(p *PubSub) error {
+		if d <= 0 {
+			return errors.Errorf("invalid block %s", d)
+		}
+		p.block = d
+		return nil
+	}
+}
+
+// WithLogger attaches a zap logger.
+func WithLogger(logger *zap.Logger) Option {
+	return shared(transport.WithLogger(logger))
+}
+
+// WithRegistry uses the provided event registry instead of the
+// ship.DefaultRegistry.
+func WithRegistry(registry *ship.Registry) Option {
+	return shared(transport.WithRegistry(registry))
+}
+
+// WithDebeziumColumns changes the columns of the Debezium outbox table, see
+// pipeline.Pipeline.SetDebeziumColumns.
+func WithDebeziumColumns(columns debezium.Columns) Option {
+	return shared(transport.WithDebeziumColumns(columns))
+}
+
+// WithCodec changes the codec of the published messages, see
+// pipeline.Pipeline.SetCodec.
+func WithCodec(codec ship.Codec) Option {
+	return shared(transport.WithCodec(codec))
+}
+
+// WithCompression compresses the published messages, see
+// pipeline.Pipeline.SetCompression.
+func WithCompression(c compress.Compressor, threshold int) Option {
+	return shared(transport.WithCompression(c, threshold))
+}
+
+// WithClaimCheck checks the oversized published messages in to the store,
+// see pipeline.Pipeline.SetClaimCheck.
+func WithClaimCheck(store claimcheck.Store, threshold int) Option {
+	return shared(transport.WithClaimCheck(store, threshold))
+}
+
+// WithEncryption encrypts the published messages, see
+// pipeline.Pipeline.SetEncryption.
+func WithEncryption(kp encryption.KeyProvider, keyID string) Option {
+	return shared(transport.WithEncryption(kp, keyID))
+}
+
+// WithTracerProvider changes the tracer provider, see
+// tracing.WithTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return shared(transport.WithTracerProvider(tp))
+}
+
+// WithPropagator changes the trace context propagator, see
+// tracing.WithPropagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return shared(transport.WithPropagator(propagator))
+}
+
+// WithMetrics reports the metrics of the client to the recorder, see
+// metrics.Recorder. Default recorder is metrics.Nop.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return shared(transport.WithMetrics(recorder))
+}
+
+// PubSub is a wrapper over a Redis client.
+type PubSub struct {
+	client        *redis.Client
+	createTopic   bool
+	createSub     bool
+	subscriptions map[string]string
+	consumer      string
+	maxLen        int64
+	claimMinIdle  time.Duration
+	block         time.Duration
+	maxAttempts   int
+	deadLetter    string
+	logger        *zap.Logger
+	errCh         chan error
+	pipeline      *pipeline.Pipeline
+	processor     *transport.Processor
+
+	tracer      *tracing.Tracer
+	tracingOpts []tracing.Option
+
+	metrics metrics.Recorder
+
+	health transport.Health
+
+	// receiveCtx is cancelled to stop reading messages.
+	receiveCtx    context.Context
+	receiveCancel context.CancelFunc
+	// handlerCtx is passed to the handlers.
+	handlerCtx    context.Context
+	handlerCancel context.CancelFunc
+
+	// lifecycle rejects new subscriptions once Stop is called.
+	lifecycle transport.Lifecycle
+}
+
+const errorBufferLimit = 10
+
+// tracingSystem is the messaging system reported in spans.
+const tracingSystem = "redis"
+
+// logIDKey is the log field of the message ids.
+const logIDKey = "redisMessageId"
+
+// defaultConsumer returns the default name of the client in the consumer
+// groups.
+func defaultConsumer() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "ship"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// NewClient creates an instance of Redis PubSub connected to the server url,
+// e.g. redis://localhost:6379/0.
+// All methods are thread-safe until mentioned specifically.
+func NewClient(url string, options ...Option) (*PubSub, error) {
+	p := &PubSub{
+		subscriptions: make(map[string]string),
+		consumer:      defaultConsumer(),
+		claimMinIdle:  DefaultClaimMinIdle,
+		block:         DefaultBlock,
+		logger:        zap.NewNop(),
+		errCh:         make(chan error, errorBufferLimit),
+		pipeline:      pipeline.New(),
+		metrics:       metrics.Nop{},
+	}
+
+	// Apply configuration options.
+	for _, opt := range options {
+		if opt == nil {
+			continue
+		}
+		if err := opt(p); err != nil {
+			return nil, errors.Wrap(err, "could not apply option")
+		}
+	}
+
+	p.tracer = tracing.New(tracingSystem, p.tracingOpts...)
+	p.processor = &transport.Processor{
+		Pipeline: p.pipeline,
+		Tracer:   p.tracer,
+		Metrics:  p.metrics,
+		Logger:   p.logger,
+		IDKey:    logIDKey,
+	}
+
+	redisOpts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid redis url")
+	}
+
+	p.receiveCtx, p.receiveCancel = context.WithCancel(context.Background())
+	p.handlerCtx, p.handlerCancel = context.WithCancel(context.Background())
+
+	p.logger.Info("connecting to redis", zap.String("addr", redisOpts.Addr))
+	client := redis.NewClient(redisOpts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, errors.Wrap(err, "unable to connect to redis")
+	}
+
+	p.client = client
+
+	return p, nil
+}
+
+// Stop stops the pubsub gracefully.
+//
+// The context passed to the in-flight handlers is cancelled right away, then
+// it waits for them and the pending reads to return and closes the client.
+// The messages which were not acknowledged are reclaimed by the other
+// consumers of their group. It returns ErrStopped if the pubsub is already
+// stopped.
+func (p *PubSub) Stop() error {
+	if err := p.lifecycle.Stop(); err != nil {
+		return err
+	}
+
+	p.logger.Debug("cancelling handlers and receive contexts")
+	p.handlerCancel()
+	p.receiveCancel()
+
+	p.logger.Debug("waiting for the subscriptions to stop")
+	p.lifecycle.Wait()
+
+	if err := p.client.Close(); err != nil {
+		return errors.Wrap(err, "unable to close redis client")
+	}
+
+	return nil
+}
+
+// Health returns the health of the client and of every subscription.
+func (p *PubSub) Health() ship.Health {
+	return p.health.Get(p.lifecycle.Stopped())
+}
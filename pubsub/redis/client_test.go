@@ -0,0 +1,266 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const (
+	testTopic        = "some-topic"
+	testSubscription = "some-subscription"
+)
+
+func TestNewClient(t *testing.T) {
+	testCases := []struct {
+		name         string
+		opts         []Option
+		checkReturns func(*testing.T, *PubSub, error)
+	}{
+		{
+			name: "should return error: could not apply option",
+			opts: []Option{
+				func(ps *PubSub) error {
+					return errors.New("some error")
+				},
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "could not apply option")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: subscription has no topic",
+			opts: []Option{WithSubscription(testSubscription, "")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "subscription some-subscription has no topic")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: consumer name cannot be empty",
+			opts: []Option{WithConsumer("")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "consumer name cannot be empty")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid max length",
+			opts: []Option{WithMaxLen(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid max length 0")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid claim min idle",
+			opts: []Option{WithClaimMinIdle(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid claim min idle 0s")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid block",
+			opts: []Option{WithBlock(-time.Second)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid block -1s")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid max attempts",
+			opts: []Option{WithMaxAttempts(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid max attempts 0")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: dead letter topic cannot be empty",
+			opts: []Option{WithDeadLetterTopic("")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "dead letter topic cannot be empty")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return a new client",
+			opts: []Option{
+				nil,
+				WithLogger(zap.NewNop()),
+				WithRegistry(ship.NewRegistry()),
+				WithCreateTopic(true),
+				WithCreateSubscription(true),
+				WithSubscription(testSubscription, testTopic),
+				WithConsumer("some-consumer"),
+				WithMaxLen(1000),
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.NoError(t, err)
+				assert.NotNil(t, ps)
+				assert.Equal(t, "some-consumer", ps.consumer)
+				assert.NoError(t, ps.Stop())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			s := miniredis.RunT(t)
+
+			client, err := NewClient("redis://"+s.Addr(), tc.opts...)
+			tc.checkReturns(t, client, err)
+		})
+	}
+}
+
+func TestNewClient_connectionErrors(t *testing.T) {
+	client, err := NewClient("some-url")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid redis url")
+	assert.Nil(t, client)
+
+	client, err = NewClient("redis://127.0.0.1:1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to connect to redis")
+	assert.Nil(t, client)
+}
+
+func TestPubSub_EnsureTopics(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *miniredis.Miniredis)
+		check func(*testing.T, *miniredis.Miniredis, error)
+	}{
+		{
+			name: "should return error: stream does not exists",
+			check: func(t *testing.T, s *miniredis.Miniredis, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "stream some-topic does not exists")
+			},
+		},
+		{
+			name: "should return error: key is not a stream",
+			opts: []Option{WithCreateTopic(true)},
+			setup: func(t *testing.T, s *miniredis.Miniredis) {
+				assert.NoError(t, s.Set(testTopic, "some-value"))
+			},
+			check: func(t *testing.T, s *miniredis.Miniredis, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "key some-topic is a string, not a stream")
+			},
+		},
+		{
+			name: "should create an empty stream",
+			opts: []Option{WithCreateTopic(true)},
+			check: func(t *testing.T, s *miniredis.Miniredis, err error) {
+				assert.NoError(t, err)
+
+				keyType := s.Type(testTopic)
+				assert.Equal(t, "stream", keyType)
+
+				entries, err := s.Stream(testTopic)
+				assert.NoError(t, err)
+				assert.Empty(t, entries)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+			defer suite.Teardown(t)
+
+			if tc.setup != nil {
+				tc.setup(t, suite.server)
+			}
+
+			err := suite.client.EnsureTopics("", testTopic)
+			tc.check(t, suite.server, err)
+		})
+	}
+}
+
+func TestPubSub_Stop(t *testing.T) {
+	suite := newTestSuite(t)
+
+	assert.NoError(t, suite.client.Stop())
+	assert.True(t, suite.client.Health().Stopped)
+	assert.ErrorIs(t, suite.client.Stop(), ErrStopped)
+}
+
+type suite struct {
+	server *miniredis.Miniredis
+	client *PubSub
+}
+
+// Teardown teardowns the test suite.
+func (s *suite) Teardown(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), 10*time.Second)
+	defer timeout()
+
+	done := make(chan struct{})
+
+	go func(t *testing.T) {
+		err := s.client.Stop()
+		assert.NoError(t, err)
+
+		close(done)
+	}(t)
+
+	select {
+	case <-ctx.Done():
+		assert.Fail(t, "unable to stop client properly")
+	case <-done:
+	}
+}
+
+// newTestSuite returns a test suite for easier testing.
+//
+// The server is closed at the end of the test.
+func newTestSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	s := miniredis.RunT(t)
+
+	opts = append([]Option{
+		WithSubscription(testSubscription, testTopic),
+		WithBlock(10 * time.Millisecond),
+	}, opts...)
+	client, err := NewClient("redis://"+s.Addr(), opts...)
+	assert.NoError(t, err)
+
+	return &suite{
+		server: s,
+		client: client,
+	}
+}
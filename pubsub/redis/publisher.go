@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Publisher = (*PubSub)(nil)
+
+// Fields of a stream message holding the ship.RawMessage data and ordering
+// key, the other fields are the message attributes.
+const (
+	DataField        = "ship_data"
+	OrderingKeyField = "ship_ordering_key"
+)
+
+// EnsureTopics checks whether the stream of a topic exists or not.
+//
+// If createTopic is `true` it will create an empty stream.
+func (p *PubSub) EnsureTopics(topics ...string) error {
+	for _, topic := range topics {
+		if topic == "" {
+			continue
+		}
+
+		if err := p.topicInit(topic); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// topicInit checks the stream of a topic and create it, if required.
+func (p *PubSub) topicInit(topic string) error {
+	ctx := context.Background()
+
+	p.logger.Info(
+		fmt.Sprintf("checking if stream (%s) exists", topic), zap.String("topic", topic),
+	)
+	keyType, err := p.client.Type(ctx, topic).Result()
+	if err != nil {
+		return errors.Wrapf(err, "could not check if stream '%s' exists", topic)
+	}
+
+	switch keyType {
+	case "stream":
+		return nil
+	case "none":
+	default:
+		return errors.Errorf("key %s is a %s, not a stream", topic, keyType)
+	}
+
+	if !p.createTopic {
+		return errors.Errorf("stream %s does not exists", topic)
+	}
+
+	// A stream cannot be created empty, the first message is deleted right
+	// away.
+	p.logger.Info(fmt.Sprintf("creating stream %s", topic), zap.String("topic", topic))
+	id, err := p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: topic,
+		Values: map[string]interface{}{DataField: ""},
+	}).Result()
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s stream", topic)
+	}
+
+	if err := p.client.XDel(ctx, topic, id).Err(); err != nil {
+		return errors.Wrapf(err, "unable to create %s stream", topic)
+	}
+
+	return nil
+}
+
+// Publish publishes the message to a given topic.
+//
+// The message data is encoded with the configured codec and the message fields
+// are sent as stream message fields, see ship.MarshalMessage.
+func (p *PubSub) Publish(topic string, message *ship.Message) error {
+	return p.PublishContext(context.Background(), topic, message)
+}
+
+// PublishContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message fields.
+func (p *PubSub) PublishContext(
+	ctx context.Context, topic string, message *ship.Message,
+) error {
+	raw, err := p.pipeline.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal message to bytes")
+	}
+
+	return p.publish(ctx, topic, raw)
+}
+
+// PublishRaw publishes the message to a given topic.
+func (p *PubSub) PublishRaw(topic string, message *ship.RawMessage) error {
+	return p.PublishRawContext(context.Background(), topic, message)
+}
+
+// PublishRawContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message fields.
+func (p *PubSub) PublishRawContext(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) error {
+	return p.publish(ctx, topic, message)
+}
+
+// publish wraps the raw message and appends it to the stream of a topic.
+func (p *PubSub) publish(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) (err error) {
+	start := time.Now()
+	ctx, span := p.tracer.StartPublish(ctx, topic)
+	defer func() {
+		tracing.End(span, err)
+		if err != nil {
+			p.metrics.PublishFailed(topic, time.Since(start))
+			return
+		}
+		p.metrics.PublishSucceeded(topic, time.Since(start))
+	}()
+
+	message, err = p.pipeline.Wrap(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "unable to wrap message")
+	}
+
+	attributes := p.tracer.Inject(ctx, message.Attributes)
+	values := make(map[string]interface{}, len(attributes)+2)
+	for k, v := range attributes {
+		values[k] = v
+	}
+	values[DataField] = message.Data
+	if message.OrderingKey != "" {
+		values[OrderingKeyField] = message.OrderingKey
+	}
+
+	p.logger.Debug(
+		"publishing message to topic", zap.String("topic", topic),
+	)
+	id, err := p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: topic,
+		MaxLen: p.maxLen,
+		Approx: p.maxLen > 0,
+		Values: values,
+	}).Result()
+	if err != nil {
+		p.logger.Error(
+			"unable to publish message",
+			zap.Error(err),
+			zap.String("topic", topic),
+		)
+		return errors.Wrap(err, "could not publish message")
+	}
+
+	tracing.SetMessageID(span, messageID(topic, id))
+
+	return nil
+}
+
+// messageID returns the id of a stream message, which is unique per server.
+func messageID(topic, id string) string {
+	return fmt.Sprintf("%s/%s", topic, id)
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testEvent) EventName() string { return "TestEvent" }
+
+// fields returns the fields of a stream entry as a map.
+func fields(entry miniredis.StreamEntry) map[string]string {
+	f := make(map[string]string, len(entry.Values)/2)
+	for i := 0; i+1 < len(entry.Values); i += 2 {
+		f[entry.Values[i]] = entry.Values[i+1]
+	}
+	return f
+}
+
+func TestPubSub_Publish(t *testing.T) {
+	testCases := []struct {
+		name    string
+		publish func(p *PubSub) error
+		check   func(t *testing.T, f map[string]string)
+	}{
+		{
+			name: "should publish a message",
+			publish: func(p *PubSub) error {
+				return p.Publish(testTopic, &ship.Message{
+					ID:   "some-id",
+					Data: &testEvent{Name: "ship"},
+				})
+			},
+			check: func(t *testing.T, f map[string]string) {
+				assert.JSONEq(t, `{"name": "ship"}`, f[DataField])
+				assert.Equal(t, "some-id", f[ship.IDKey])
+				assert.Equal(t, "TestEvent", f[ship.TypeKey])
+				assert.Equal(t, "application/json", f[ship.ContentTypeKey])
+				assert.NotContains(t, f, OrderingKeyField)
+			},
+		},
+		{
+			name: "should publish a raw message with its ordering key",
+			publish: func(p *PubSub) error {
+				return p.PublishRaw(testTopic, &ship.RawMessage{
+					Data:        []byte("some-data"),
+					Attributes:  map[string]string{"some-key": "some-value"},
+					OrderingKey: "some-ordering-key",
+				})
+			},
+			check: func(t *testing.T, f map[string]string) {
+				assert.Equal(t, map[string]string{
+					DataField:        "some-data",
+					OrderingKeyField: "some-ordering-key",
+					"some-key":       "some-value",
+				}, f)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t)
+			defer suite.Teardown(t)
+
+			assert.NoError(t, tc.publish(suite.client))
+
+			entries, err := suite.server.Stream(testTopic)
+			assert.NoError(t, err)
+			if assert.Len(t, entries, 1) {
+				tc.check(t, fields(entries[0]))
+			}
+		})
+	}
+}
+
+func TestPubSub_PublishMaxLen(t *testing.T) {
+	suite := newTestSuite(t, WithMaxLen(2))
+	defer suite.Teardown(t)
+
+	for i := 0; i < 5; i++ {
+		err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+		assert.NoError(t, err)
+	}
+
+	entries, err := suite.server.Stream(testTopic)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestPubSub_PublishError(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newTestSuite(t, WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	assert.NoError(t, suite.server.Set(testTopic, "some-value"))
+
+	err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not publish message")
+	assert.Equal(t, 1, recorder.count("publish_failed:"+testTopic))
+}
@@ -0,0 +1,478 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Subscriber = (*PubSub)(nil)
+
+// ErrStopped is returned when subscribing to or stopping a stopped pubsub.
+var ErrStopped = transport.ErrStopped
+
+const (
+	// readCount is the maximum number of messages returned by a read.
+	readCount = 10
+	// claimCount is the maximum number of pending messages checked by a claim.
+	claimCount = 100
+	// readErrorDelay is the delay before reading again after an error.
+	readErrorDelay = time.Second
+)
+
+// Fields of the messages added to the dead letter stream, in addition to
+// their own fields.
+const (
+	// DeadSubscriptionField holds the subscription which failed to process
+	// the message.
+	DeadSubscriptionField = "dead_letter_subscription"
+
+	// DeadTopicField and DeadIDField hold the stream and the id of the
+	// message.
+	DeadTopicField = "dead_letter_topic"
+	DeadIDField    = "dead_letter_id"
+
+	// DeadErrorField holds the error of the last attempt.
+	DeadErrorField = "dead_letter_error"
+)
+
+// topic returns the topic consumed by a subscription.
+func (p *PubSub) topic(subscription string) string {
+	if topic, ok := p.subscriptions[subscription]; ok {
+		return topic
+	}
+
+	return subscription
+}
+
+// subInit checks for existence of the consumer group of a subscription and
+// creates it, if required.
+func (p *PubSub) subInit(subscription, topic string) error {
+	ctx := context.Background()
+
+	p.logger.Info("checking if subscription exists", zap.String("name", subscription))
+	groups, err := p.client.XInfoGroups(ctx, topic).Result()
+	if err != nil && !isNoSuchKey(err) {
+		return errors.Wrapf(
+			err, "could not check if subscription '%s' exists", subscription,
+		)
+	}
+
+	for _, group := range groups {
+		if group.Name == subscription {
+			return nil
+		}
+	}
+
+	if !p.createSub {
+		return errors.Errorf("subscription %s does not exists", subscription)
+	}
+
+	p.logger.Info("creating subscription", zap.String("name", subscription))
+	err = p.client.XGroupCreateMkStream(ctx, topic, subscription, "0").Err()
+	// The group may have been created by another instance in the meantime.
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrapf(err, "unable to create %s subscription", subscription)
+	}
+
+	return nil
+}
+
+// isNoSuchKey reports whether err is returned for a missing stream.
+func isNoSuchKey(err error) bool {
+	return strings.HasPrefix(err.Error(), "ERR no such key")
+}
+
+// Subscribe subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The messages of the stream are processed one at a time. A message which the
+// handler fails to process stays pending and is redelivered once it is
+// reclaimed, see WithClaimMinIdle, meanwhile the following messages of the
+// stream are processed: a redelivered message is out of order. The pending
+// messages of crashed consumers are reclaimed the same way. A message which
+// reached its max attempts is dead-lettered, see WithMaxAttempts.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+//
+// Example:
+//
+//	pubsub, err := redis.NewClient("redis://localhost:6379/0")
+//	if err != nil {
+//		// do something with error
+//		return
+//	}
+//
+//	pubsub.Subscribe("some-subscription-name", handler)
+//	pubsub.Subscribe("some-subscription-name2", handler2)
+func (p *PubSub) Subscribe(
+	subscription string, handler ship.MessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.Handler(handler),
+	)
+}
+
+// SubscribeRaw subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The messages are processed and redelivered as in Subscribe.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+func (p *PubSub) SubscribeRaw(
+	subscription string, handler ship.RawMessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.RawHandler(handler),
+	)
+}
+
+// subscribe starts consuming the stream of a subscription with process.
+func (p *PubSub) subscribe(subscription, hName string, process transport.ProcessFunc) error {
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+
+	topic := p.topic(subscription)
+	if err := p.subInit(subscription, topic); err != nil {
+		p.lifecycle.Release()
+		return errors.WithStack(err)
+	}
+
+	health := p.health.Track(subscription, hName)
+	c := &consumer{
+		p:       p,
+		topic:   topic,
+		subID:   subscription,
+		hName:   hName,
+		health:  health,
+		process: process,
+	}
+
+	p.logger.Info(
+		"starting listener for subscription", zap.String("subscription", subscription),
+	)
+	go c.consume()
+
+	return nil
+}
+
+// consumer consumes the stream of a subscription.
+type consumer struct {
+	p       *PubSub
+	topic   string
+	subID   string
+	hName   string
+	health  *transport.SubscriptionHealth
+	process transport.ProcessFunc
+}
+
+// consume reads and processes the messages of the stream, until the pubsub is
+// stopped or the handler panics.
+//
+// The pending messages are reclaimed every claimMinIdle before reading new
+// messages.
+func (c *consumer) consume() {
+	p := c.p
+	defer p.lifecycle.Release()
+
+	p.logger.Debug(
+		"subscription started",
+		zap.String("subscription", c.subID),
+		zap.String("handlerName", c.hName),
+	)
+
+	var lastClaim time.Time
+	for p.receiveCtx.Err() == nil {
+		var (
+			msgs []delivery
+			err  error
+		)
+		if time.Since(lastClaim) >= p.claimMinIdle {
+			lastClaim = time.Now()
+			msgs, err = c.claim()
+		} else {
+			msgs, err = c.read()
+		}
+
+		if err != nil {
+			if p.receiveCtx.Err() != nil {
+				break
+			}
+
+			p.logger.Error(
+				"unable to read messages from subscription",
+				zap.Error(err),
+				zap.String("subscription", c.subID),
+			)
+			transport.ReportError(p.errCh, err)
+
+			select {
+			case <-time.After(readErrorDelay):
+			case <-p.receiveCtx.Done():
+			}
+			continue
+		}
+
+		for i := range msgs {
+			if !c.handle(&msgs[i]) {
+				return
+			}
+		}
+	}
+
+	c.health.Stopped(nil)
+}
+
+// delivery is a message delivered to the consumer.
+type delivery struct {
+	redis.XMessage
+
+	// count is the number of times the message was delivered to the group.
+	count int64
+}
+
+// read reads the new messages of the stream, waiting for them up to block.
+func (c *consumer) read() ([]delivery, error) {
+	p := c.p
+
+	streams, err := p.client.XReadGroup(p.receiveCtx, &redis.XReadGroupArgs{
+		Group:    c.subID,
+		Consumer: p.consumer,
+		Streams:  []string{c.topic, ">"},
+		Count:    readCount,
+		Block:    p.block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read group")
+	}
+
+	var msgs []delivery
+	for _, s := range streams {
+		for _, msg := range s.Messages {
+			msgs = append(msgs, delivery{XMessage: msg, count: 1})
+		}
+	}
+
+	return msgs, nil
+}
+
+// claim claims the messages of the group which were pending for claimMinIdle,
+// including the messages of the client itself.
+func (c *consumer) claim() ([]delivery, error) {
+	p := c.p
+
+	pending, err := p.client.XPendingExt(p.receiveCtx, &redis.XPendingExtArgs{
+		Stream: c.topic,
+		Group:  c.subID,
+		Start:  "-",
+		End:    "+",
+		Count:  claimCount,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list pending messages")
+	}
+
+	var ids []string
+	counts := make(map[string]int64, len(pending))
+	for _, m := range pending {
+		if m.Idle >= p.claimMinIdle {
+			ids = append(ids, m.ID)
+			counts[m.ID] = m.RetryCount
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// The idle time is checked again by the server, a message claimed by
+	// another consumer in the meantime is skipped.
+	claimed, err := p.client.XClaim(p.receiveCtx, &redis.XClaimArgs{
+		Stream:   c.topic,
+		Group:    c.subID,
+		Consumer: p.consumer,
+		MinIdle:  p.claimMinIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to claim pending messages")
+	}
+
+	p.logger.Debug(
+		"claimed pending messages",
+		zap.String("subscription", c.subID),
+		zap.Int("count", len(claimed)),
+	)
+
+	// Claiming a message counts as a delivery.
+	msgs := make([]delivery, 0, len(claimed))
+	for _, msg := range claimed {
+		msgs = append(msgs, delivery{XMessage: msg, count: counts[msg.ID] + 1})
+	}
+
+	return msgs, nil
+}
+
+// handle processes a message and acknowledges it. A message which is not
+// acknowledged stays pending, until it is reclaimed, or is dead-lettered once
+// it reached the max attempts.
+//
+// It reports false when the handler panicked.
+func (c *consumer) handle(msg *delivery) (ok bool) {
+	p := c.p
+
+	c.health.SetLastMessageAt(time.Now())
+
+	raw := rawMessage(c.topic, &msg.XMessage)
+
+	// We don't want an unexpected error in consumer to take down whole application.
+	// We'll try to recover from the panic and remove the subscription from listening.
+	//
+	// This protects an application from going in a continuous crash loop in a
+	// orchestrated environment.
+	defer func() {
+		if r := recover(); r != nil {
+			c.health.Recovered(
+				p.logger, r,
+				"Leaving the message pending and removing the subscription from listening.",
+				zap.String("redisMessageId", raw.ID),
+			)
+			p.metrics.Nacked(c.subID, c.hName)
+			ok = false
+		}
+	}()
+
+	// The handlers are given their own context, so they can be cancelled on
+	// Stop.
+	if err := c.process(p.handlerCtx, c.subID, c.hName, raw); err != nil {
+		p.metrics.Nacked(c.subID, c.hName)
+
+		if p.maxAttempts > 0 && msg.count >= int64(p.maxAttempts) {
+			c.deadLetter(msg, err)
+		}
+		return true
+	}
+
+	// The message is acked even if the pubsub is stopping.
+	if err := p.client.XAck(context.Background(), c.topic, c.subID, msg.ID).Err(); err != nil {
+		p.logger.Error("unable to ack message", zap.Error(err))
+		return true
+	}
+	p.metrics.Acked(c.subID, c.hName)
+
+	return true
+}
+
+// deadLetter adds a message which reached the max attempts to the dead letter
+// stream and acknowledges it, atomically. The message is skipped if there is
+// no dead letter stream.
+//
+// A message which could not be dead-lettered stays pending, it is
+// dead-lettered again when it is reclaimed.
+func (c *consumer) deadLetter(msg *delivery, cause error) {
+	p := c.p
+	id := messageID(c.topic, msg.ID)
+
+	// The message is dead-lettered even if the pubsub is stopping.
+	ctx := context.Background()
+
+	if p.deadLetter == "" {
+		p.logger.Error(
+			"message failed to be processed too many times: skipping it",
+			zap.Error(cause),
+			zap.String(logIDKey, id),
+			zap.String("subscription", c.subID),
+		)
+		if err := p.client.XAck(ctx, c.topic, c.subID, msg.ID).Err(); err != nil {
+			p.logger.Error("unable to ack message", zap.Error(err))
+			return
+		}
+		p.metrics.DeadLettered(c.subID, c.hName)
+		return
+	}
+
+	values := make(map[string]interface{}, len(msg.Values)+4)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values[DeadSubscriptionField] = c.subID
+	values[DeadTopicField] = c.topic
+	values[DeadIDField] = msg.ID
+	values[DeadErrorField] = cause.Error()
+
+	_, err := p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: p.deadLetter, Values: values})
+		pipe.XAck(ctx, c.topic, c.subID, msg.ID)
+		return nil
+	})
+	if err != nil {
+		p.logger.Error(
+			"unable to add message to the dead letter stream",
+			zap.Error(err),
+			zap.String(logIDKey, id),
+			zap.String("topic", p.deadLetter),
+		)
+		return
+	}
+
+	p.logger.Warn(
+		"message failed to be processed too many times: added it to the dead letter stream",
+		zap.Error(cause),
+		zap.String(logIDKey, id),
+		zap.String("topic", p.deadLetter),
+	)
+	p.metrics.DeadLettered(c.subID, c.hName)
+}
+
+// rawMessage converts a stream message to a ship.RawMessage.
+func rawMessage(topic string, msg *redis.XMessage) *ship.RawMessage {
+	raw := &ship.RawMessage{
+		ID:          messageID(topic, msg.ID),
+		Attributes:  make(map[string]string, len(msg.Values)),
+		PublishTime: publishTime(msg.ID),
+	}
+
+	for k, v := range msg.Values {
+		s, _ := v.(string)
+		switch k {
+		case DataField:
+			raw.Data = []byte(s)
+		case OrderingKeyField:
+			raw.OrderingKey = s
+		default:
+			raw.Attributes[k] = s
+		}
+	}
+
+	return raw
+}
+
+// publishTime returns the time at which a stream message was added, from the
+// milliseconds part of its id.
+func publishTime(id string) time.Time {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
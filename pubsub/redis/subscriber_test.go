@@ -0,0 +1,423 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+// testRecorder is a metrics.Recorder counting the recorded metrics.
+type testRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{counts: make(map[string]int)}
+}
+
+func (r *testRecorder) inc(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+func (r *testRecorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+func (r *testRecorder) PublishSucceeded(topic string, _ time.Duration) {
+	r.inc("published:" + topic)
+}
+
+func (r *testRecorder) PublishFailed(topic string, _ time.Duration) {
+	r.inc("publish_failed:" + topic)
+}
+
+func (r *testRecorder) Handled(sub, handler string, _ time.Duration) {
+	r.inc("handled:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Acked(sub, handler string) {
+	r.inc("acked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Nacked(sub, handler string) {
+	r.inc("nacked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DeadLettered(sub, handler string) {
+	r.inc("dead_lettered:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DecodeFailed(sub, handler, reason string) {
+	r.inc("decode_failed:" + sub + ":" + handler + ":" + reason)
+}
+
+type rawHandlerFunc func(context.Context, *ship.RawMessage) error
+
+func (f rawHandlerFunc) HandleRawMessage(ctx context.Context, m *ship.RawMessage) error {
+	return f(ctx, m)
+}
+
+// newSubscriptionSuite returns a test suite with the consumer group of the
+// test subscription.
+func newSubscriptionSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	opts = append([]Option{
+		WithCreateSubscription(true),
+		WithClaimMinIdle(50 * time.Millisecond),
+	}, opts...)
+
+	return newTestSuite(t, opts...)
+}
+
+// pending returns the number of pending messages of the test subscription.
+func pending(t *testing.T, s *suite) int64 {
+	t.Helper()
+
+	p, err := s.client.client.XPending(context.Background(), testTopic, testSubscription).Result()
+	assert.NoError(t, err)
+
+	return p.Count
+}
+
+// envelope returns a ship envelope of the event.
+func envelope(t *testing.T, id string, event ship.Event) *ship.RawMessage {
+	t.Helper()
+
+	raw, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{ID: id, Data: event})
+	assert.NoError(t, err)
+
+	return raw
+}
+
+func TestPubSub_Subscribe(t *testing.T) {
+	debezium, err := os.ReadFile("../../debezium/testdata/valid_data.fixture")
+	assert.NoError(t, err)
+
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&userCreated{}))
+
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithRegistry(registry), WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		received []string
+		failed   bool
+	)
+	handler := ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first delivery of the last message fails, it must be reclaimed.
+		if m.ID == "some-last-id" && !failed {
+			failed = true
+			return errors.New("some error")
+		}
+
+		received = append(received, m.ID)
+		return nil
+	})
+
+	err = suite.client.Subscribe(testSubscription, handler)
+	assert.NoError(t, err)
+
+	for _, m := range []*ship.RawMessage{
+		envelope(t, "some-id", &userCreated{Email: "someone@flahmingo.com"}),
+		envelope(t, "some-other-id", &testEvent{Name: "unregistered"}),
+		{Data: debezium},
+		envelope(t, "some-last-id", &userCreated{Email: "someone@flahmingo.com"}),
+	} {
+		assert.NoError(t, suite.client.PublishRaw(testTopic, m))
+	}
+
+	hName := "MessageHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("acked:"+testSubscription+":"+hName) == 4
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []string{
+		"some-id",
+		"e79e906a-5022-473f-9a67-ff0993851be9",
+		"some-last-id",
+	}, received)
+	mu.Unlock()
+
+	assert.Equal(t, 1, recorder.count("nacked:"+testSubscription+":"+hName))
+	assert.Equal(
+		t, 1,
+		recorder.count("decode_failed:"+testSubscription+":"+hName+":"+ship.DecodeReasonUnregistered),
+	)
+	assert.Equal(t, int64(0), pending(t, suite))
+}
+
+func TestPubSub_SubscribeRaw(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	received := make(chan *ship.RawMessage, 1)
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{
+		Data:        []byte("some-data"),
+		Attributes:  map[string]string{"some-key": "some-value"},
+		OrderingKey: "some-ordering-key",
+	})
+	assert.NoError(t, err)
+
+	select {
+	case m := <-received:
+		assert.True(t, strings.HasPrefix(m.ID, testTopic+"/"))
+		assert.Equal(t, []byte("some-data"), m.Data)
+		assert.Equal(t, "some-ordering-key", m.OrderingKey)
+		assert.Equal(t, map[string]string{"some-key": "some-value"}, m.Attributes)
+		assert.WithinDuration(t, time.Now(), m.PublishTime, time.Minute)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+}
+
+func TestPubSub_SubscribeReclaim(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	ctx := context.Background()
+	rdb := suite.client.client
+
+	// A crashed consumer read the message without acknowledging it.
+	err := rdb.XGroupCreateMkStream(ctx, testTopic, testSubscription, "0").Err()
+	assert.NoError(t, err)
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+	_, err = rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    testSubscription,
+		Consumer: "some-crashed-consumer",
+		Streams:  []string{testTopic, ">"},
+	}).Result()
+	assert.NoError(t, err)
+
+	received := make(chan *ship.RawMessage, 1)
+	err = suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	select {
+	case m := <-received:
+		assert.Equal(t, []byte("some-data"), m.Data)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not reclaimed")
+	}
+
+	assert.Eventually(t, func() bool {
+		return pending(t, suite) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_SubscribeMaxAttempts(t *testing.T) {
+	const deadLetter = "some-dead-letter-topic"
+
+	testCases := []struct {
+		name       string
+		opts       []Option
+		deadLetter bool
+	}{
+		{
+			name:       "should add the message to the dead letter stream",
+			opts:       []Option{WithDeadLetterTopic(deadLetter)},
+			deadLetter: true,
+		},
+		{
+			name: "should skip the message: no dead letter stream",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := newTestRecorder()
+			suite := newSubscriptionSuite(
+				t,
+				append([]Option{WithMetrics(recorder), WithMaxAttempts(3)}, tc.opts...)...,
+			)
+			defer suite.Teardown(t)
+
+			var (
+				mu       sync.Mutex
+				attempts = make(map[string]int)
+			)
+			err := suite.client.SubscribeRaw(
+				testSubscription,
+				rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+					mu.Lock()
+					defer mu.Unlock()
+
+					attempts[string(m.Data)]++
+					if string(m.Data) == "some-data" {
+						return errors.New("some error")
+					}
+					return nil
+				}),
+			)
+			assert.NoError(t, err)
+
+			err = suite.client.PublishRaw(testTopic, &ship.RawMessage{
+				Data:       []byte("some-data"),
+				Attributes: map[string]string{"some-key": "some-value"},
+			})
+			assert.NoError(t, err)
+			err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-other-data")})
+			assert.NoError(t, err)
+
+			hName := "rawHandlerFunc"
+			assert.Eventually(t, func() bool {
+				return recorder.count("dead_lettered:"+testSubscription+":"+hName) == 1
+			}, 10*time.Second, 10*time.Millisecond)
+
+			mu.Lock()
+			assert.Equal(t, map[string]int{"some-data": 3, "some-other-data": 1}, attempts)
+			mu.Unlock()
+
+			assert.Equal(t, 3, recorder.count("nacked:"+testSubscription+":"+hName))
+			assert.Equal(t, int64(0), pending(t, suite))
+
+			msgs, err := suite.client.client.XRange(context.Background(), deadLetter, "-", "+").Result()
+			assert.NoError(t, err)
+			if !tc.deadLetter {
+				assert.Empty(t, msgs)
+				return
+			}
+
+			if assert.Len(t, msgs, 1) {
+				id, _ := msgs[0].Values[DeadIDField].(string)
+				assert.NotEmpty(t, id)
+				assert.Equal(t, map[string]interface{}{
+					DataField:             "some-data",
+					"some-key":            "some-value",
+					DeadSubscriptionField: testSubscription,
+					DeadTopicField:        testTopic,
+					DeadIDField:           id,
+					DeadErrorField:        "some error",
+				}, msgs[0].Values)
+			}
+		})
+	}
+}
+
+func TestPubSub_SubscribePanic(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			panic("some panic")
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		h := suite.client.Health()
+		return len(h.Subscriptions) == 1 &&
+			h.Subscriptions[0].State == ship.SubscriptionPanicked
+	}, 10*time.Second, 10*time.Millisecond)
+
+	h := suite.client.Health().Subscriptions[0]
+	assert.Equal(t, testSubscription, h.Subscription)
+	assert.Equal(t, "some panic", h.Error)
+
+	// The message stays pending, so it is not lost.
+	assert.Equal(t, int64(1), pending(t, suite))
+}
+
+func TestPubSub_SubscribeErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *suite)
+		err   string
+	}{
+		{
+			name: "should return error: subscription does not exists",
+			opts: []Option{WithCreateTopic(true)},
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.EnsureTopics(testTopic))
+			},
+			err: "subscription some-subscription does not exists",
+		},
+		{
+			name: "should return error: key is not a stream",
+			opts: []Option{WithCreateSubscription(true)},
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.server.Set(testTopic, "some-value"))
+			},
+			err: "could not check if subscription 'some-subscription' exists",
+		},
+		{
+			name: "should return error: pubsub is stopped",
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.Stop())
+			},
+			err: ErrStopped.Error(),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+
+			if tc.setup != nil {
+				tc.setup(t, suite)
+			}
+			defer func() { _ = suite.client.Stop() }()
+
+			err := suite.client.Subscribe(
+				testSubscription,
+				ship.MessageHandlerFunc(func(context.Context, *ship.Message) error {
+					return nil
+				}),
+			)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
@@ -0,0 +1,396 @@
+// Package sql contains an implementation of ship.Publisher and
+// ship.Subscriber interface over a database table, for small deployments
+// without a broker.
+//
+// A published message is inserted once per subscription of its topic. The
+// subscriptions claim their messages for a visibility timeout, delete them
+// once they are acknowledged and make them visible again after a delay when
+// they fail to be processed. The messages of an ordering key are claimed one
+// at a time, in the order they were published.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/claimcheck"
+	"github.com/Flahmingo-Investments/ship/compress"
+	"github.com/Flahmingo-Investments/ship/debezium"
+	"github.com/Flahmingo-Investments/ship/encryption"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/Flahmingo-Investments/ship/metrics"
+	"github.com/Flahmingo-Investments/ship/pipeline"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Default consumption settings.
+const (
+	// DefaultTablePrefix is the prefix of the tables of the pubsub.
+	DefaultTablePrefix = "ship_"
+	// DefaultBatchSize is the maximum number of messages claimed at once.
+	DefaultBatchSize = 10
+	// DefaultPollInterval is the time between two claims, when there are no
+	// messages to process.
+	DefaultPollInterval = time.Second
+	// DefaultVisibilityTimeout is the time for which claimed messages are
+	// hidden from the other clients.
+	DefaultVisibilityTimeout = 30 * time.Second
+	// DefaultMinBackoff and DefaultMaxBackoff bound the delay before a
+	// message which failed to be processed is visible again.
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+// Compile time check.
+var _ ship.HealthChecker = (*PubSub)(nil)
+
+// Option is an option setter used to configure creation.
+type Option func(*PubSub) error
+
+// shared wraps an option shared by the transports.
+func shared(opt transport.Option) Option {
+	return func(p *PubSub) error {
+		return opt(transport.Settings{
+			Name:     "sql",
+			Pipeline: p.pipeline,
+			Tracing:  &p.tracingOpts,
+			Metrics:  &p.metrics,
+			Logger:   &p.logger,
+		})
+	}
+}
+
+// WithDialect changes the SQL dialect of the database. Default is Postgres.
+func WithDialect(d Dialect) Option {
+	return func(p *PubSub) error {
+		if d.name == "" {
+			return errors.New("invalid dialect")
+		}
+		p.dialect = d
+		return nil
+	}
+}
+
+// WithTablePrefix changes the prefix of the tables of the pubsub.
+// Default prefix is DefaultTablePrefix.
+func WithTablePrefix(prefix string) Option {
+	return func(p *PubSub) error {
+		p.prefix = prefix
+		return nil
+	}
+}
+
+// WithCreateTopic toggle topic creation if it does not exists. The tables of
+// the pubsub are created as well.
+func WithCreateTopic(create bool) Option {
+	return func(p *PubSub) error {
+		p.createTopic = create
+		return nil
+	}
+}
+
+// WithCreateSubscription toggle subscription creation if it does not exists.
+// The tables of the pubsub are created as well. A created subscription
+// receives the messages published from then on.
+func WithCreateSubscription(create bool) Option {
+	return func(p *PubSub) error {
+		p.createSub = create
+		return nil
+	}
+}
+
+// WithSubscription maps a subscription to the topic it receives the messages
+// of, when it is created. By default, a subscription receives the messages of
+// the topic of the same name.
+func WithSubscription(subscription, topic string) Option {
+	return func(p *PubSub) error {
+		if topic == "" {
+			return errors.Errorf("subscription %s has no topic", subscription)
+		}
+		p.subscriptions[subscription] = topic
+		return nil
+	}
+}
+
+// WithBatchSize changes the maximum number of messages claimed at once by a
+// subscription. Default is DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return func(p *PubSub) error {
+		if n <= 0 {
+			return errors.Errorf("invalid batch size %d", n)
+		}
+		p.batchSize = n
+		return nil
+	}
+}
+
+// WithPollInterval changes the time between two claims of a subscription,
+// when there are no messages to process. Stop waits for the pending claims,
+// so it bounds the time Stop takes.
+func WithPollInterval(d time.Duration) Option {
+	return func(p *PubSub) error {
+		if d <= 0 {
+			return errors.Errorf("invalid poll interval %s", d)
+		}
+		p.pollInterval = d
+		return nil
+	}
+}
+
+// WithVisibilityTimeout changes the time for which claimed messages are
+// hidden from the other clients.
+//
+// It is the time before the messages of a crashed client are processed by
+// the others. A message whose processing takes longer may be processed
+// twice, so it must exceed the processing time of a whole batch.
+func WithVisibilityTimeout(d time.Duration) Option {
+	return func(p *PubSub) error {
+		if d <= 0 {
+			return errors.Errorf("invalid visibility timeout %s", d)
+		}
+		p.visibilityTimeout = d
+		return nil
+	}
+}
+
+// WithRetryBackoff changes the delay before a message which failed to be
+// processed is visible again. The delay doubles after every attempt, from min
+// up to max.
+func WithRetryBackoff(min, max time.Duration) Option {
+	return func(p *PubSub) error {
+		if min <= 0 || max < min {
+			return errors.Errorf("invalid retry backoff [%s, %s]", min, max)
+		}
+		p.minBackoff = min
+		p.maxBackoff = max
+		return nil
+	}
+}
+
+// WithMaxAttempts limits the number of attempts to process a message. A
+// message which failed to be processed n times is dead-lettered: it is kept
+// in the table, with its dead_lettered_at column set, and is not delivered
+// anymore. Default is unlimited.
+func WithMaxAttempts(n int) Option {
+	return func(p *PubSub) error {
+		if n <= 0 {
+			return errors.Errorf("invalid max attempts %d", n)
+		}
+		p.maxAttempts = n
+		return nil
+	}
+}
+
+// WithLogger attaches a zap logger.
+func WithLogger(logger *zap.Logger) Option {
+	return shared(transport.WithLogger(logger))
+}
+
+// WithRegistry uses the provided event registry instead of the
+// ship.DefaultRegistry.
+func WithRegistry(registry *ship.Registry) Option {
+	return shared(transport.WithRegistry(registry))
+}
+
+// WithDebeziumColumns changes the columns of the Debezium outbox table, see
+// pipeline.Pipeline.SetDebeziumColumns.
+func WithDebeziumColumns(columns debezium.Columns) Option {
+	return shared(transport.WithDebeziumColumns(columns))
+}
+
+// WithCodec changes the codec of the published messages, see
+// pipeline.Pipeline.SetCodec.
+func WithCodec(codec ship.Codec) Option {
+	return shared(transport.WithCodec(codec))
+}
+
+// WithCompression compresses the published messages, see
+// pipeline.Pipeline.SetCompression.
+func WithCompression(c compress.Compressor, threshold int) Option {
+	return shared(transport.WithCompression(c, threshold))
+}
+
+// WithClaimCheck checks the oversized published messages in to the store,
+// see pipeline.Pipeline.SetClaimCheck.
+func WithClaimCheck(store claimcheck.Store, threshold int) Option {
+	return shared(transport.WithClaimCheck(store, threshold))
+}
+
+// WithEncryption encrypts the published messages, see
+// pipeline.Pipeline.SetEncryption.
+func WithEncryption(kp encryption.KeyProvider, keyID string) Option {
+	return shared(transport.WithEncryption(kp, keyID))
+}
+
+// WithTracerProvider changes the tracer provider, see
+// tracing.WithTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return shared(transport.WithTracerProvider(tp))
+}
+
+// WithPropagator changes the trace context propagator, see
+// tracing.WithPropagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return shared(transport.WithPropagator(propagator))
+}
+
+// WithMetrics reports the metrics of the client to the recorder, see
+// metrics.Recorder. Default recorder is metrics.Nop.
+func WithMetrics(recorder metrics.Recorder) Option {
+	return shared(transport.WithMetrics(recorder))
+}
+
+// PubSub is a wrapper over a database.
+type PubSub struct {
+	db                *sql.DB
+	dialect           Dialect
+	prefix            string
+	createTopic       bool
+	createSub         bool
+	subscriptions     map[string]string
+	batchSize         int
+	pollInterval      time.Duration
+	visibilityTimeout time.Duration
+	minBackoff        time.Duration
+	maxBackoff        time.Duration
+	maxAttempts       int
+	logger            *zap.Logger
+	errCh             chan error
+	pipeline          *pipeline.Pipeline
+	processor         *transport.Processor
+
+	tracer      *tracing.Tracer
+	tracingOpts []tracing.Option
+
+	metrics metrics.Recorder
+
+	health transport.Health
+
+	// receiveCtx is cancelled to stop claiming messages.
+	receiveCtx    context.Context
+	receiveCancel context.CancelFunc
+	// handlerCtx is passed to the handlers.
+	handlerCtx    context.Context
+	handlerCancel context.CancelFunc
+
+	// lifecycle rejects new subscriptions once Stop is called.
+	lifecycle transport.Lifecycle
+}
+
+const errorBufferLimit = 10
+
+// logIDKey is the log field of the message ids.
+const logIDKey = "sqlMessageId"
+
+// NewClient creates an instance of SQL PubSub over the database db.
+//
+// The database is owned by the caller, it is not closed on Stop.
+// All methods are thread-safe until mentioned specifically.
+func NewClient(db *sql.DB, options ...Option) (*PubSub, error) {
+	p := &PubSub{
+		dialect:           Postgres,
+		prefix:            DefaultTablePrefix,
+		subscriptions:     make(map[string]string),
+		batchSize:         DefaultBatchSize,
+		pollInterval:      DefaultPollInterval,
+		visibilityTimeout: DefaultVisibilityTimeout,
+		minBackoff:        DefaultMinBackoff,
+		maxBackoff:        DefaultMaxBackoff,
+		logger:            zap.NewNop(),
+		errCh:             make(chan error, errorBufferLimit),
+		pipeline:          pipeline.New(),
+		metrics:           metrics.Nop{},
+	}
+
+	// Apply configuration options.
+	for _, opt := range options {
+		if opt == nil {
+			continue
+		}
+		if err := opt(p); err != nil {
+			return nil, errors.Wrap(err, "could not apply option")
+		}
+	}
+
+	if db == nil {
+		return nil, errors.New("database cannot be nil")
+	}
+
+	p.tracer = tracing.New(p.dialect.name, p.tracingOpts...)
+	p.processor = &transport.Processor{
+		Pipeline: p.pipeline,
+		Tracer:   p.tracer,
+		Metrics:  p.metrics,
+		Logger:   p.logger,
+		IDKey:    logIDKey,
+	}
+
+	p.logger.Info("connecting to database", zap.Stringer("dialect", p.dialect))
+	if err := db.PingContext(context.Background()); err != nil {
+		return nil, errors.Wrap(err, "unable to connect to database")
+	}
+
+	p.receiveCtx, p.receiveCancel = context.WithCancel(context.Background())
+	p.handlerCtx, p.handlerCancel = context.WithCancel(context.Background())
+
+	p.db = db
+
+	return p, nil
+}
+
+// Stop stops the pubsub gracefully.
+//
+// The context passed to the in-flight handlers is cancelled right away, then
+// it waits for them and the pending claims to return. The messages which were
+// not acknowledged are visible again once their visibility timeout expired.
+// It returns ErrStopped if the pubsub is already stopped.
+func (p *PubSub) Stop() error {
+	if err := p.lifecycle.Stop(); err != nil {
+		return err
+	}
+
+	p.logger.Debug("cancelling handlers and receive contexts")
+	p.handlerCancel()
+	p.receiveCancel()
+
+	p.logger.Debug("waiting for the subscriptions to stop")
+	p.lifecycle.Wait()
+
+	return nil
+}
+
+// Health returns the health of the client and of every subscription.
+func (p *PubSub) Health() ship.Health {
+	return p.health.Get(p.lifecycle.Stopped())
+}
+
+// table returns the name of a table of the pubsub.
+func (p *PubSub) table(name string) string {
+	return p.prefix + name
+}
+
+// query returns a query with the table names and placeholders of the pubsub,
+// the tables are referenced as {topics}, {subscriptions} and {messages}.
+func (p *PubSub) query(query string) string {
+	for _, name := range []string{"topics", "subscriptions", "messages"} {
+		query = strings.ReplaceAll(query, "{"+name+"}", p.table(name))
+	}
+	return p.dialect.rebind(query)
+}
+
+// createTables creates the tables of the pubsub, if they do not exist.
+func (p *PubSub) createTables(ctx context.Context) error {
+	for _, stmt := range p.dialect.schema(p.prefix) {
+		if _, err := p.db.ExecContext(ctx, stmt); err != nil {
+			return errors.Wrap(err, "unable to create tables")
+		}
+	}
+	return nil
+}
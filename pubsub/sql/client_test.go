@@ -0,0 +1,299 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const (
+	testTopic        = "some-topic"
+	testSubscription = "some-subscription"
+)
+
+func TestNewClient(t *testing.T) {
+	testCases := []struct {
+		name         string
+		opts         []Option
+		checkReturns func(*testing.T, *PubSub, error)
+	}{
+		{
+			name: "should return error: could not apply option",
+			opts: []Option{
+				func(ps *PubSub) error {
+					return errors.New("some error")
+				},
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "could not apply option")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid dialect",
+			opts: []Option{WithDialect(Dialect{})},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid dialect")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: subscription has no topic",
+			opts: []Option{WithSubscription(testSubscription, "")},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "subscription some-subscription has no topic")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid batch size",
+			opts: []Option{WithBatchSize(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid batch size 0")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid poll interval",
+			opts: []Option{WithPollInterval(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid poll interval 0s")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid visibility timeout",
+			opts: []Option{WithVisibilityTimeout(-time.Second)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid visibility timeout -1s")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid retry backoff",
+			opts: []Option{WithRetryBackoff(time.Minute, time.Second)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid retry backoff [1m0s, 1s]")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return error: invalid max attempts",
+			opts: []Option{WithMaxAttempts(0)},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid max attempts 0")
+
+				assert.Nil(t, ps)
+			},
+		},
+		{
+			name: "should return a new client",
+			opts: []Option{
+				nil,
+				WithLogger(zap.NewNop()),
+				WithRegistry(ship.NewRegistry()),
+				WithCreateTopic(true),
+				WithCreateSubscription(true),
+				WithSubscription(testSubscription, testTopic),
+				WithTablePrefix("some_"),
+				WithMaxAttempts(5),
+			},
+			checkReturns: func(t *testing.T, ps *PubSub, err error) {
+				assert.NoError(t, err)
+				assert.NotNil(t, ps)
+				assert.Equal(t, "some_messages", ps.table("messages"))
+				assert.NoError(t, ps.Stop())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]Option{WithDialect(SQLite)}, tc.opts...)
+			client, err := NewClient(openDB(t), opts...)
+			tc.checkReturns(t, client, err)
+		})
+	}
+}
+
+func TestNewClient_connectionErrors(t *testing.T) {
+	client, err := NewClient(nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "database cannot be nil")
+	assert.Nil(t, client)
+
+	db := openDB(t)
+	assert.NoError(t, db.Close())
+
+	client, err = NewClient(db, WithDialect(SQLite))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to connect to database")
+	assert.Nil(t, client)
+}
+
+func TestDialect_rebind(t *testing.T) {
+	query := "SELECT name FROM topics WHERE name = ? AND id < ?"
+
+	assert.Equal(t, query, SQLite.rebind(query))
+	assert.Equal(
+		t,
+		"SELECT name FROM topics WHERE name = $1 AND id < $2",
+		Postgres.rebind(query),
+	)
+}
+
+func TestPubSub_EnsureTopics(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *suite)
+		check func(*testing.T, *suite, error)
+	}{
+		{
+			name: "should return error: could not check if topic exists",
+			check: func(t *testing.T, s *suite, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "could not check if topic 'some-topic' exists")
+			},
+		},
+		{
+			name: "should return error: topic does not exists",
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.createTables(context.Background()))
+			},
+			check: func(t *testing.T, s *suite, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "topic some-topic does not exists")
+			},
+		},
+		{
+			name: "should create the tables and the topic",
+			opts: []Option{WithCreateTopic(true)},
+			check: func(t *testing.T, s *suite, err error) {
+				assert.NoError(t, err)
+
+				exists, err := s.client.topicExists(context.Background(), s.db, testTopic)
+				assert.NoError(t, err)
+				assert.True(t, exists)
+
+				// Creating an existing topic is a no-op.
+				assert.NoError(t, s.client.EnsureTopics(testTopic))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+			defer suite.Teardown(t)
+
+			if tc.setup != nil {
+				tc.setup(t, suite)
+			}
+
+			err := suite.client.EnsureTopics("", testTopic)
+			tc.check(t, suite, err)
+		})
+	}
+}
+
+func TestPubSub_Stop(t *testing.T) {
+	suite := newTestSuite(t)
+
+	assert.NoError(t, suite.client.Stop())
+	assert.True(t, suite.client.Health().Stopped)
+	assert.ErrorIs(t, suite.client.Stop(), ErrStopped)
+
+	// The database is not closed.
+	assert.NoError(t, suite.db.Ping())
+}
+
+type suite struct {
+	db     *sql.DB
+	client *PubSub
+}
+
+// Teardown teardowns the test suite.
+func (s *suite) Teardown(t *testing.T) {
+	ctx, timeout := context.WithTimeout(context.Background(), 10*time.Second)
+	defer timeout()
+
+	done := make(chan struct{})
+
+	go func(t *testing.T) {
+		err := s.client.Stop()
+		assert.NoError(t, err)
+
+		close(done)
+	}(t)
+
+	select {
+	case <-ctx.Done():
+		assert.Fail(t, "unable to stop client properly")
+	case <-done:
+	}
+}
+
+// openDB opens a SQLite database in a temporary directory.
+//
+// The database is closed at the end of the test.
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open(
+		"sqlite3", filepath.Join(t.TempDir(), "ship.db")+"?_busy_timeout=5000",
+	)
+	assert.NoError(t, err)
+	// SQLite serializes the writes, a single connection avoids busy errors.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+// newTestSuite returns a test suite for easier testing.
+func newTestSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	db := openDB(t)
+
+	opts = append([]Option{
+		WithDialect(SQLite),
+		WithSubscription(testSubscription, testTopic),
+		WithPollInterval(10 * time.Millisecond),
+	}, opts...)
+	client, err := NewClient(db, opts...)
+	assert.NoError(t, err)
+
+	return &suite{
+		db:     db,
+		client: client,
+	}
+}
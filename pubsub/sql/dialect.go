@@ -0,0 +1,94 @@
+package sql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect holds the SQL differences between the supported databases.
+type Dialect struct {
+	name string
+	// numbered reports whether the placeholders are numbered, e.g. $1.
+	numbered bool
+	// idColumn is the definition of the auto incremented message id.
+	idColumn string
+	// blobType is the type of the message data.
+	blobType string
+	// lockClause locks the claimed rows, skipping the ones locked by other
+	// clients.
+	lockClause string
+}
+
+// Supported dialects.
+var (
+	// Postgres claims messages with SELECT ... FOR UPDATE SKIP LOCKED, so
+	// several clients claim different messages concurrently.
+	Postgres = Dialect{
+		name:       "postgresql",
+		numbered:   true,
+		idColumn:   "BIGSERIAL PRIMARY KEY",
+		blobType:   "BYTEA",
+		lockClause: "FOR UPDATE SKIP LOCKED",
+	}
+	// SQLite claims messages in a single UPDATE statement, which is atomic
+	// since SQLite serializes the writes. It requires SQLite 3.35 or later.
+	SQLite = Dialect{
+		name:     "sqlite",
+		idColumn: "INTEGER PRIMARY KEY AUTOINCREMENT",
+		blobType: "BLOB",
+	}
+)
+
+// String returns the name of the dialect.
+func (d Dialect) String() string {
+	return d.name
+}
+
+// rebind replaces the ? placeholders of a query with the ones of the dialect.
+func (d Dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+
+	return b.String()
+}
+
+// schema returns the statements creating the tables of the pubsub, prefixed
+// with prefix.
+func (d Dialect) schema(prefix string) []string {
+	return []string{
+		"CREATE TABLE IF NOT EXISTS " + prefix + "topics (" +
+			"name TEXT PRIMARY KEY)",
+		"CREATE TABLE IF NOT EXISTS " + prefix + "subscriptions (" +
+			"name TEXT PRIMARY KEY, " +
+			"topic TEXT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS " + prefix + "messages (" +
+			"id " + d.idColumn + ", " +
+			"subscription TEXT NOT NULL, " +
+			"ordering_key TEXT NOT NULL, " +
+			"attributes TEXT NOT NULL, " +
+			"data " + d.blobType + " NOT NULL, " +
+			"published_at BIGINT NOT NULL, " +
+			"visible_at BIGINT NOT NULL, " +
+			"attempts INTEGER NOT NULL DEFAULT 0, " +
+			"dead_lettered_at BIGINT)",
+		"CREATE INDEX IF NOT EXISTS " + prefix + "messages_visible_idx ON " +
+			prefix + "messages (subscription, visible_at)",
+		"CREATE INDEX IF NOT EXISTS " + prefix + "messages_ordering_idx ON " +
+			prefix + "messages (subscription, ordering_key, id)",
+	}
+}
@@ -0,0 +1,243 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/tracing"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Publisher = (*PubSub)(nil)
+
+// EnsureTopics checks whether a topic exists or not.
+//
+// If createTopic is `true` it will create the topic, along with the tables of
+// the pubsub.
+func (p *PubSub) EnsureTopics(topics ...string) error {
+	for _, topic := range topics {
+		if topic == "" {
+			continue
+		}
+
+		if err := p.topicInit(topic); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// topicInit checks a topic and create it, if required.
+func (p *PubSub) topicInit(topic string) error {
+	ctx := context.Background()
+
+	if p.createTopic {
+		p.logger.Info(fmt.Sprintf("creating topic %s", topic), zap.String("topic", topic))
+		if err := p.createTables(ctx); err != nil {
+			return errors.Wrapf(err, "unable to create %s topic", topic)
+		}
+
+		_, err := p.db.ExecContext(
+			ctx,
+			p.query("INSERT INTO {topics} (name) VALUES (?) ON CONFLICT DO NOTHING"),
+			topic,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "unable to create %s topic", topic)
+		}
+
+		return nil
+	}
+
+	p.logger.Info(
+		fmt.Sprintf("checking if topic (%s) exists", topic), zap.String("topic", topic),
+	)
+	exists, err := p.topicExists(ctx, p.db, topic)
+	if err != nil {
+		return errors.Wrapf(err, "could not check if topic '%s' exists", topic)
+	}
+	if !exists {
+		return errors.Errorf("topic %s does not exists", topic)
+	}
+
+	return nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// topicExists reports whether a topic exists.
+func (p *PubSub) topicExists(ctx context.Context, q querier, topic string) (bool, error) {
+	var name string
+	err := q.QueryRowContext(
+		ctx, p.query("SELECT name FROM {topics} WHERE name = ?"), topic,
+	).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Publish publishes the message to a given topic.
+//
+// The message data is encoded with the configured codec and the message fields
+// are stored as attributes, see ship.MarshalMessage.
+func (p *PubSub) Publish(topic string, message *ship.Message) error {
+	return p.PublishContext(context.Background(), topic, message)
+}
+
+// PublishContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message attributes.
+func (p *PubSub) PublishContext(
+	ctx context.Context, topic string, message *ship.Message,
+) error {
+	raw, err := p.pipeline.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal message to bytes")
+	}
+
+	return p.publish(ctx, topic, raw)
+}
+
+// PublishRaw publishes the message to a given topic.
+func (p *PubSub) PublishRaw(topic string, message *ship.RawMessage) error {
+	return p.PublishRawContext(context.Background(), topic, message)
+}
+
+// PublishRawContext publishes the message to a given topic.
+//
+// The trace context of ctx is propagated to the subscribers through the
+// message attributes.
+func (p *PubSub) PublishRawContext(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) error {
+	return p.publish(ctx, topic, message)
+}
+
+// publish wraps the raw message and inserts it for every subscription of a
+// topic, in a single transaction.
+func (p *PubSub) publish(
+	ctx context.Context, topic string, message *ship.RawMessage,
+) (err error) {
+	start := time.Now()
+	ctx, span := p.tracer.StartPublish(ctx, topic)
+	defer func() {
+		tracing.End(span, err)
+		if err != nil {
+			p.metrics.PublishFailed(topic, time.Since(start))
+			return
+		}
+		p.metrics.PublishSucceeded(topic, time.Since(start))
+	}()
+
+	message, err = p.pipeline.Wrap(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, "unable to wrap message")
+	}
+
+	attributes := p.tracer.Inject(ctx, message.Attributes)
+	encoded, err := json.Marshal(attributes)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode message attributes")
+	}
+
+	p.logger.Debug(
+		"publishing message to topic", zap.String("topic", topic),
+	)
+	if err := p.insert(ctx, topic, message, encoded); err != nil {
+		p.logger.Error(
+			"unable to publish message",
+			zap.Error(err),
+			zap.String("topic", topic),
+		)
+		return errors.Wrap(err, "could not publish message")
+	}
+
+	tracing.SetMessageID(span, attributes[ship.IDKey])
+
+	return nil
+}
+
+// insert inserts a message for every subscription of a topic.
+func (p *PubSub) insert(
+	ctx context.Context, topic string, message *ship.RawMessage, attributes []byte,
+) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to begin transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	exists, err := p.topicExists(ctx, tx, topic)
+	if err != nil {
+		return errors.Wrapf(err, "could not check if topic '%s' exists", topic)
+	}
+	if !exists {
+		return errors.Errorf("topic %s does not exists", topic)
+	}
+
+	subs, err := p.topicSubscriptions(ctx, tx, topic)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	data := message.Data
+	if data == nil {
+		data = []byte{}
+	}
+	for _, sub := range subs {
+		_, err := tx.ExecContext(
+			ctx,
+			p.query(
+				"INSERT INTO {messages} "+
+					"(subscription, ordering_key, attributes, data, published_at, visible_at) "+
+					"VALUES (?, ?, ?, ?, ?, ?)",
+			),
+			sub, message.OrderingKey, string(attributes), data, now, now,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "unable to insert message for %s subscription", sub)
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "unable to commit transaction")
+}
+
+// topicSubscriptions returns the subscriptions of a topic.
+func (p *PubSub) topicSubscriptions(
+	ctx context.Context, tx *sql.Tx, topic string,
+) ([]string, error) {
+	rows, err := tx.QueryContext(
+		ctx, p.query("SELECT name FROM {subscriptions} WHERE topic = ?"), topic,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list subscriptions")
+	}
+	defer rows.Close()
+
+	var subs []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "unable to list subscriptions")
+		}
+		subs = append(subs, name)
+	}
+
+	return subs, errors.Wrap(rows.Err(), "unable to list subscriptions")
+}
@@ -0,0 +1,148 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+}
+
+func (*testEvent) EventName() string { return "TestEvent" }
+
+// storedMessage is a row of the messages table.
+type storedMessage struct {
+	subscription string
+	orderingKey  string
+	attributes   map[string]string
+	data         []byte
+	attempts     int
+	deadLettered bool
+}
+
+// storedMessages returns the rows of the messages table, in insertion order.
+func storedMessages(t *testing.T, s *suite) []storedMessage {
+	t.Helper()
+
+	rows, err := s.db.Query(
+		s.client.query(
+			"SELECT subscription, ordering_key, attributes, data, attempts, " +
+				"dead_lettered_at IS NOT NULL FROM {messages} ORDER BY id",
+		),
+	)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer rows.Close()
+
+	var msgs []storedMessage
+	for rows.Next() {
+		var (
+			m          storedMessage
+			attributes string
+		)
+		err := rows.Scan(
+			&m.subscription, &m.orderingKey, &attributes, &m.data, &m.attempts, &m.deadLettered,
+		)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal([]byte(attributes), &m.attributes))
+		msgs = append(msgs, m)
+	}
+	assert.NoError(t, rows.Err())
+
+	return msgs
+}
+
+func TestPubSub_Publish(t *testing.T) {
+	testCases := []struct {
+		name    string
+		publish func(p *PubSub) error
+		check   func(t *testing.T, m storedMessage)
+	}{
+		{
+			name: "should publish a message",
+			publish: func(p *PubSub) error {
+				return p.Publish(testTopic, &ship.Message{
+					ID:   "some-id",
+					Data: &testEvent{Name: "ship"},
+				})
+			},
+			check: func(t *testing.T, m storedMessage) {
+				assert.JSONEq(t, `{"name": "ship"}`, string(m.data))
+				assert.Equal(t, "some-id", m.attributes[ship.IDKey])
+				assert.Equal(t, "TestEvent", m.attributes[ship.TypeKey])
+				assert.Equal(t, "application/json", m.attributes[ship.ContentTypeKey])
+				assert.Empty(t, m.orderingKey)
+			},
+		},
+		{
+			name: "should publish a raw message with its ordering key",
+			publish: func(p *PubSub) error {
+				return p.PublishRaw(testTopic, &ship.RawMessage{
+					Data:        []byte("some-data"),
+					Attributes:  map[string]string{"some-key": "some-value"},
+					OrderingKey: "some-ordering-key",
+				})
+			},
+			check: func(t *testing.T, m storedMessage) {
+				assert.Equal(t, []byte("some-data"), m.data)
+				assert.Equal(t, "some-ordering-key", m.orderingKey)
+				assert.Equal(t, map[string]string{"some-key": "some-value"}, m.attributes)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newSubscriptionSuite(t)
+			defer suite.Teardown(t)
+
+			// Every subscription of the topic gets its own copy.
+			assert.NoError(t, suite.client.subInit(testSubscription, testTopic))
+			assert.NoError(t, suite.client.subInit("some-other-subscription", testTopic))
+
+			assert.NoError(t, tc.publish(suite.client))
+
+			msgs := storedMessages(t, suite)
+			if assert.Len(t, msgs, 2) {
+				assert.ElementsMatch(
+					t,
+					[]string{testSubscription, "some-other-subscription"},
+					[]string{msgs[0].subscription, msgs[1].subscription},
+				)
+				tc.check(t, msgs[0])
+				tc.check(t, msgs[1])
+			}
+		})
+	}
+}
+
+func TestPubSub_PublishWithoutSubscriptions(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+	assert.Empty(t, storedMessages(t, suite))
+}
+
+func TestPubSub_PublishError(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newTestSuite(t, WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	assert.NoError(t, suite.client.createTables(context.Background()))
+
+	err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not publish message")
+	assert.Contains(t, err.Error(), "topic some-topic does not exists")
+	assert.Equal(t, 1, recorder.count("publish_failed:"+testTopic))
+}
@@ -0,0 +1,421 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/Flahmingo-Investments/ship/internal/transport"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Compile time check.
+var _ ship.Subscriber = (*PubSub)(nil)
+
+// ErrStopped is returned when subscribing to or stopping a stopped pubsub.
+var ErrStopped = transport.ErrStopped
+
+// topic returns the topic of a subscription, when it is created.
+func (p *PubSub) topic(subscription string) string {
+	if topic, ok := p.subscriptions[subscription]; ok {
+		return topic
+	}
+
+	return subscription
+}
+
+// subInit checks for existence of a subscription and creates it, if required.
+func (p *PubSub) subInit(subscription, topic string) error {
+	ctx := context.Background()
+
+	if p.createSub {
+		if err := p.createTables(ctx); err != nil {
+			return errors.Wrapf(err, "unable to create %s subscription", subscription)
+		}
+	}
+
+	p.logger.Info("checking if subscription exists", zap.String("name", subscription))
+	var name string
+	err := p.db.QueryRowContext(
+		ctx, p.query("SELECT name FROM {subscriptions} WHERE name = ?"), subscription,
+	).Scan(&name)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return errors.Wrapf(
+			err, "could not check if subscription '%s' exists", subscription,
+		)
+	}
+
+	if !p.createSub {
+		return errors.Errorf("subscription %s does not exists", subscription)
+	}
+
+	exists, err := p.topicExists(ctx, p.db, topic)
+	if err != nil {
+		return errors.Wrapf(err, "could not check if topic '%s' exists", topic)
+	}
+	if !exists {
+		return errors.Errorf("topic %s does not exists", topic)
+	}
+
+	p.logger.Info("creating subscription", zap.String("name", subscription))
+	// The subscription may have been created by another instance in the
+	// meantime.
+	_, err = p.db.ExecContext(
+		ctx,
+		p.query("INSERT INTO {subscriptions} (name, topic) VALUES (?, ?) ON CONFLICT DO NOTHING"),
+		subscription, topic,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s subscription", subscription)
+	}
+
+	return nil
+}
+
+// Subscribe subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The messages are claimed in batches and processed one at a time, in the
+// order they were published. A message which the handler fails to process is
+// visible again after a delay, see WithRetryBackoff, and blocks the next
+// messages of its ordering key until then.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+//
+// Example:
+//
+//	pubsub, err := sql.NewClient(db)
+//	if err != nil {
+//		// do something with error
+//		return
+//	}
+//
+//	pubsub.Subscribe("some-subscription-name", handler)
+//	pubsub.Subscribe("some-subscription-name2", handler2)
+func (p *PubSub) Subscribe(
+	subscription string, handler ship.MessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.Handler(handler),
+	)
+}
+
+// SubscribeRaw subscribes a handler to a given subscription.
+// It stops receiving message in case of, panics.
+//
+// The messages are processed and redelivered as in Subscribe.
+//
+// It is a non-blocking call, it returns ErrStopped once the pubsub is stopped.
+// NOTE: to stop the subscriptions. Call Stop method.
+func (p *PubSub) SubscribeRaw(
+	subscription string, handler ship.RawMessageHandler,
+) error {
+	return p.subscribe(
+		subscription,
+		transport.HandlerName(handler),
+		p.processor.RawHandler(handler),
+	)
+}
+
+// subscribe starts claiming the messages of a subscription with process.
+func (p *PubSub) subscribe(subscription, hName string, process transport.ProcessFunc) error {
+	if err := p.lifecycle.Acquire(); err != nil {
+		return err
+	}
+
+	if err := p.subInit(subscription, p.topic(subscription)); err != nil {
+		p.lifecycle.Release()
+		return errors.WithStack(err)
+	}
+
+	health := p.health.Track(subscription, hName)
+	c := &consumer{
+		p:       p,
+		subID:   subscription,
+		hName:   hName,
+		health:  health,
+		process: process,
+	}
+
+	p.logger.Info(
+		"starting listener for subscription", zap.String("subscription", subscription),
+	)
+	go c.consume()
+
+	return nil
+}
+
+// message is a message claimed by a consumer.
+type message struct {
+	id int64
+	// attempts is the number of times the message was claimed, it identifies
+	// the claim: a message claimed again by another client after its
+	// visibility timeout is not acknowledged by the previous one.
+	attempts int
+	raw      *ship.RawMessage
+}
+
+// consumer consumes the messages of a subscription.
+type consumer struct {
+	p       *PubSub
+	subID   string
+	hName   string
+	health  *transport.SubscriptionHealth
+	process transport.ProcessFunc
+}
+
+// consume claims and processes the messages of the subscription, until the
+// pubsub is stopped or the handler panics.
+func (c *consumer) consume() {
+	p := c.p
+	defer p.lifecycle.Release()
+
+	p.logger.Debug(
+		"subscription started",
+		zap.String("subscription", c.subID),
+		zap.String("handlerName", c.hName),
+	)
+
+	for p.receiveCtx.Err() == nil {
+		msgs, err := c.claim()
+		if err != nil {
+			if p.receiveCtx.Err() != nil {
+				break
+			}
+
+			p.logger.Error(
+				"unable to claim messages from subscription",
+				zap.Error(err),
+				zap.String("subscription", c.subID),
+			)
+			transport.ReportError(p.errCh, err)
+			c.wait()
+			continue
+		}
+
+		for i, msg := range msgs {
+			// The messages which were not processed yet are released for the
+			// other clients.
+			if p.receiveCtx.Err() != nil {
+				c.release(msgs[i:])
+				break
+			}
+			if !c.handle(msg) {
+				c.release(msgs[i+1:])
+				return
+			}
+		}
+
+		// A full batch means more messages may be visible.
+		if len(msgs) < p.batchSize {
+			c.wait()
+		}
+	}
+
+	c.health.Stopped(nil)
+}
+
+// wait waits for the poll interval, or until the pubsub is stopped.
+func (c *consumer) wait() {
+	select {
+	case <-time.After(c.p.pollInterval):
+	case <-c.p.receiveCtx.Done():
+	}
+}
+
+// claim claims the next visible messages of the subscription, hiding them for
+// the visibility timeout.
+//
+// A message with an ordering key is only claimed once the previous messages of
+// its key are acknowledged or dead-lettered.
+func (c *consumer) claim() ([]*message, error) {
+	p := c.p
+
+	now := time.Now()
+	rows, err := p.db.QueryContext(
+		p.receiveCtx,
+		p.query(
+			"UPDATE {messages} SET visible_at = ?, attempts = attempts + 1 "+
+				"WHERE id IN ("+
+				"SELECT m.id FROM {messages} m "+
+				"WHERE m.subscription = ? AND m.visible_at <= ? AND m.dead_lettered_at IS NULL "+
+				"AND (m.ordering_key = '' OR NOT EXISTS ("+
+				"SELECT 1 FROM {messages} o "+
+				"WHERE o.subscription = m.subscription AND o.ordering_key = m.ordering_key "+
+				"AND o.id < m.id AND o.dead_lettered_at IS NULL)) "+
+				"ORDER BY m.id LIMIT ? "+p.dialect.lockClause+
+				") RETURNING id, ordering_key, attributes, data, published_at, attempts",
+		),
+		now.Add(p.visibilityTimeout).UnixMilli(), c.subID, now.UnixMilli(), p.batchSize,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to claim messages")
+	}
+	defer rows.Close()
+
+	var msgs []*message
+	for rows.Next() {
+		var (
+			msg         = &message{raw: &ship.RawMessage{}}
+			attributes  string
+			publishedAt int64
+		)
+		err := rows.Scan(
+			&msg.id, &msg.raw.OrderingKey, &attributes, &msg.raw.Data, &publishedAt, &msg.attempts,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read claimed message")
+		}
+
+		if err := json.Unmarshal([]byte(attributes), &msg.raw.Attributes); err != nil {
+			return nil, errors.Wrap(err, "unable to decode message attributes")
+		}
+		msg.raw.ID = strconv.FormatInt(msg.id, 10)
+		msg.raw.PublishTime = time.UnixMilli(publishedAt)
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to claim messages")
+	}
+
+	// The returned rows are not ordered.
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].id < msgs[j].id })
+
+	return msgs, nil
+}
+
+// handle processes a message and acknowledges it. A message which is not
+// acknowledged is visible again after a delay.
+//
+// It reports false when the handler panicked.
+func (c *consumer) handle(msg *message) (ok bool) {
+	p := c.p
+
+	c.health.SetLastMessageAt(time.Now())
+
+	// We don't want an unexpected error in consumer to take down whole application.
+	// We'll try to recover from the panic and remove the subscription from listening.
+	//
+	// This protects an application from going in a continuous crash loop in a
+	// orchestrated environment.
+	defer func() {
+		if r := recover(); r != nil {
+			c.health.Recovered(
+				p.logger, r,
+				"Nacking the message and removing the subscription from listening.",
+				zap.String("sqlMessageId", msg.raw.ID),
+			)
+			c.nack(msg)
+			ok = false
+		}
+	}()
+
+	// The handlers are given their own context, so they can be cancelled on
+	// Stop.
+	if err := c.process(p.handlerCtx, c.subID, c.hName, msg.raw); err != nil {
+		c.nack(msg)
+		return true
+	}
+
+	c.ack(msg)
+
+	return true
+}
+
+// ack deletes a processed message and records it.
+//
+// The message is acked even if the pubsub is stopping.
+func (c *consumer) ack(msg *message) {
+	p := c.p
+
+	_, err := p.db.ExecContext(
+		context.Background(),
+		p.query("DELETE FROM {messages} WHERE id = ? AND attempts = ?"),
+		msg.id, msg.attempts,
+	)
+	if err != nil {
+		p.logger.Error("unable to ack message", zap.Error(err))
+		return
+	}
+	p.metrics.Acked(c.subID, c.hName)
+}
+
+// nack makes a message visible again after a delay growing with its attempts
+// and records it.
+//
+// The message is dead-lettered instead, if it was the last attempt allowed.
+func (c *consumer) nack(msg *message) {
+	p := c.p
+
+	p.metrics.Nacked(c.subID, c.hName)
+
+	if p.maxAttempts > 0 && msg.attempts >= p.maxAttempts {
+		_, err := p.db.ExecContext(
+			context.Background(),
+			p.query("UPDATE {messages} SET dead_lettered_at = ? WHERE id = ? AND attempts = ?"),
+			time.Now().UnixMilli(), msg.id, msg.attempts,
+		)
+		if err != nil {
+			p.logger.Error("unable to dead-letter message", zap.Error(err))
+			return
+		}
+		p.metrics.DeadLettered(c.subID, c.hName)
+		return
+	}
+
+	delay := p.minBackoff
+	for i := 1; i < msg.attempts && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+
+	c.setVisibleAt(msg, time.Now().Add(delay), "unable to nack message")
+}
+
+// release makes claimed messages which were not processed visible right away.
+// Their claim is not counted as an attempt.
+func (c *consumer) release(msgs []*message) {
+	p := c.p
+
+	now := time.Now().UnixMilli()
+	for _, msg := range msgs {
+		_, err := p.db.ExecContext(
+			context.Background(),
+			p.query(
+				"UPDATE {messages} SET visible_at = ?, attempts = attempts - 1 "+
+					"WHERE id = ? AND attempts = ?",
+			),
+			now, msg.id, msg.attempts,
+		)
+		if err != nil {
+			p.logger.Error("unable to release message", zap.Error(err))
+		}
+	}
+}
+
+// setVisibleAt changes the time at which a claimed message is visible again.
+func (c *consumer) setVisibleAt(msg *message, t time.Time, errMsg string) {
+	p := c.p
+
+	_, err := p.db.ExecContext(
+		context.Background(),
+		p.query("UPDATE {messages} SET visible_at = ? WHERE id = ? AND attempts = ?"),
+		t.UnixMilli(), msg.id, msg.attempts,
+	)
+	if err != nil {
+		p.logger.Error(errMsg, zap.Error(err))
+	}
+}
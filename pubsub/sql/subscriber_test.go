@@ -0,0 +1,412 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship"
+	"github.com/stretchr/testify/assert"
+)
+
+type userCreated struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+}
+
+func (*userCreated) EventName() string { return "UserCreated" }
+
+// testRecorder is a metrics.Recorder counting the recorded metrics.
+type testRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newTestRecorder() *testRecorder {
+	return &testRecorder{counts: make(map[string]int)}
+}
+
+func (r *testRecorder) inc(key string) {
+	r.mu.Lock()
+	r.counts[key]++
+	r.mu.Unlock()
+}
+
+func (r *testRecorder) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[key]
+}
+
+func (r *testRecorder) PublishSucceeded(topic string, _ time.Duration) {
+	r.inc("published:" + topic)
+}
+
+func (r *testRecorder) PublishFailed(topic string, _ time.Duration) {
+	r.inc("publish_failed:" + topic)
+}
+
+func (r *testRecorder) Handled(sub, handler string, _ time.Duration) {
+	r.inc("handled:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Acked(sub, handler string) {
+	r.inc("acked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) Nacked(sub, handler string) {
+	r.inc("nacked:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DeadLettered(sub, handler string) {
+	r.inc("dead_lettered:" + sub + ":" + handler)
+}
+
+func (r *testRecorder) DecodeFailed(sub, handler, reason string) {
+	r.inc("decode_failed:" + sub + ":" + handler + ":" + reason)
+}
+
+type rawHandlerFunc func(context.Context, *ship.RawMessage) error
+
+func (f rawHandlerFunc) HandleRawMessage(ctx context.Context, m *ship.RawMessage) error {
+	return f(ctx, m)
+}
+
+// newSubscriptionSuite returns a test suite with the tables and the test
+// topic, subscriptions are created on subscribe.
+func newSubscriptionSuite(t *testing.T, opts ...Option) *suite {
+	t.Helper()
+
+	opts = append([]Option{
+		WithCreateTopic(true),
+		WithCreateSubscription(true),
+		WithRetryBackoff(10*time.Millisecond, 10*time.Millisecond),
+	}, opts...)
+
+	s := newTestSuite(t, opts...)
+	assert.NoError(t, s.client.EnsureTopics(testTopic))
+
+	return s
+}
+
+// envelope returns a ship envelope of the event.
+func envelope(t *testing.T, id string, event ship.Event) *ship.RawMessage {
+	t.Helper()
+
+	raw, err := ship.MarshalMessage(ship.JSONCodec{}, &ship.Message{ID: id, Data: event})
+	assert.NoError(t, err)
+
+	return raw
+}
+
+func TestPubSub_Subscribe(t *testing.T) {
+	debezium, err := os.ReadFile("../../debezium/testdata/valid_data.fixture")
+	assert.NoError(t, err)
+
+	registry := ship.NewRegistry()
+	assert.NoError(t, registry.Register(&userCreated{}))
+
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithRegistry(registry), WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		received []string
+		failed   bool
+	)
+	handler := ship.MessageHandlerFunc(func(ctx context.Context, m *ship.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first attempt of the last message fails, it must be retried.
+		if m.ID == "some-last-id" && !failed {
+			failed = true
+			return errors.New("some error")
+		}
+
+		received = append(received, m.ID)
+		return nil
+	})
+
+	err = suite.client.Subscribe(testSubscription, handler)
+	assert.NoError(t, err)
+
+	for _, m := range []*ship.RawMessage{
+		envelope(t, "some-id", &userCreated{Email: "someone@flahmingo.com"}),
+		envelope(t, "some-other-id", &testEvent{Name: "unregistered"}),
+		{Data: debezium},
+		envelope(t, "some-last-id", &userCreated{Email: "someone@flahmingo.com"}),
+	} {
+		assert.NoError(t, suite.client.PublishRaw(testTopic, m))
+	}
+
+	hName := "MessageHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("acked:"+testSubscription+":"+hName) == 4
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []string{
+		"some-id",
+		"e79e906a-5022-473f-9a67-ff0993851be9",
+		"some-last-id",
+	}, received)
+	mu.Unlock()
+
+	assert.Equal(t, 1, recorder.count("nacked:"+testSubscription+":"+hName))
+	assert.Equal(
+		t, 1,
+		recorder.count("decode_failed:"+testSubscription+":"+hName+":"+ship.DecodeReasonUnregistered),
+	)
+	assert.Empty(t, storedMessages(t, suite))
+}
+
+func TestPubSub_SubscribeRaw(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	received := make(chan *ship.RawMessage, 1)
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{
+		Data:        []byte("some-data"),
+		Attributes:  map[string]string{"some-key": "some-value"},
+		OrderingKey: "some-ordering-key",
+	})
+	assert.NoError(t, err)
+
+	select {
+	case m := <-received:
+		assert.NotEmpty(t, m.ID)
+		assert.Equal(t, []byte("some-data"), m.Data)
+		assert.Equal(t, "some-ordering-key", m.OrderingKey)
+		assert.Equal(t, map[string]string{"some-key": "some-value"}, m.Attributes)
+		assert.WithinDuration(t, time.Now(), m.PublishTime, time.Minute)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+}
+
+func TestPubSub_SubscribeOrderingKeys(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithMetrics(recorder))
+	defer suite.Teardown(t)
+
+	var (
+		mu       sync.Mutex
+		received []string
+		failed   bool
+	)
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			// The first message of key a fails once, the next message of the
+			// key must wait for it.
+			if string(m.Data) == "a1" && !failed {
+				failed = true
+				return errors.New("some error")
+			}
+
+			received = append(received, string(m.Data))
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	for _, m := range []*ship.RawMessage{
+		{Data: []byte("a1"), OrderingKey: "a"},
+		{Data: []byte("a2"), OrderingKey: "a"},
+		{Data: []byte("b1"), OrderingKey: "b"},
+	} {
+		assert.NoError(t, suite.client.PublishRaw(testTopic, m))
+	}
+
+	assert.Eventually(t, func() bool {
+		return recorder.count("acked:"+testSubscription+":rawHandlerFunc") == 3
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{"a1", "a2", "b1"}, received)
+
+	index := make(map[string]int, len(received))
+	for i, data := range received {
+		index[data] = i
+	}
+	assert.Less(t, index["a1"], index["a2"])
+}
+
+func TestPubSub_SubscribeDeadLetter(t *testing.T) {
+	recorder := newTestRecorder()
+	suite := newSubscriptionSuite(t, WithMetrics(recorder), WithMaxAttempts(2))
+	defer suite.Teardown(t)
+
+	err := suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			return errors.New("some error")
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+
+	hName := "rawHandlerFunc"
+	assert.Eventually(t, func() bool {
+		return recorder.count("dead_lettered:"+testSubscription+":"+hName) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The message is kept, but not delivered anymore.
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, recorder.count("nacked:"+testSubscription+":"+hName))
+
+	msgs := storedMessages(t, suite)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, 2, msgs[0].attempts)
+		assert.True(t, msgs[0].deadLettered)
+	}
+}
+
+func TestPubSub_SubscribeVisibilityTimeout(t *testing.T) {
+	suite := newSubscriptionSuite(t, WithVisibilityTimeout(50*time.Millisecond))
+	defer suite.Teardown(t)
+
+	// A crashed client claimed the message without acknowledging it.
+	assert.NoError(t, suite.client.subInit(testSubscription, testTopic))
+	err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+
+	crashed := &consumer{p: suite.client, subID: testSubscription}
+	msgs, err := crashed.claim()
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	received := make(chan *ship.RawMessage, 1)
+	err = suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			received <- m
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	select {
+	case m := <-received:
+		assert.Equal(t, []byte("some-data"), m.Data)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "message not redelivered")
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(storedMessages(t, suite)) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_SubscribePanic(t *testing.T) {
+	suite := newSubscriptionSuite(t)
+	defer suite.Teardown(t)
+
+	// Both messages are claimed together, the second one is released.
+	assert.NoError(t, suite.client.subInit(testSubscription, testTopic))
+	err := suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-data")})
+	assert.NoError(t, err)
+	err = suite.client.PublishRaw(testTopic, &ship.RawMessage{Data: []byte("some-other-data")})
+	assert.NoError(t, err)
+
+	err = suite.client.SubscribeRaw(
+		testSubscription,
+		rawHandlerFunc(func(ctx context.Context, m *ship.RawMessage) error {
+			panic("some panic")
+		}),
+	)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		h := suite.client.Health()
+		return len(h.Subscriptions) == 1 &&
+			h.Subscriptions[0].State == ship.SubscriptionPanicked
+	}, 10*time.Second, 10*time.Millisecond)
+
+	h := suite.client.Health().Subscriptions[0]
+	assert.Equal(t, testSubscription, h.Subscription)
+	assert.Equal(t, "some panic", h.Error)
+
+	// The messages are kept, so they are not lost. The released message did
+	// not use an attempt.
+	assert.Eventually(t, func() bool {
+		msgs := storedMessages(t, suite)
+		return len(msgs) == 2 && msgs[0].attempts == 1 && msgs[1].attempts == 0
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestPubSub_SubscribeErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		opts  []Option
+		setup func(t *testing.T, s *suite)
+		err   string
+	}{
+		{
+			name: "should return error: subscription does not exists",
+			opts: []Option{WithCreateTopic(true)},
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.EnsureTopics(testTopic))
+			},
+			err: "subscription some-subscription does not exists",
+		},
+		{
+			name: "should return error: could not check if subscription exists",
+			err:  "could not check if subscription 'some-subscription' exists",
+		},
+		{
+			name: "should return error: topic does not exists",
+			opts: []Option{WithCreateSubscription(true)},
+			err:  "topic some-topic does not exists",
+		},
+		{
+			name: "should return error: pubsub is stopped",
+			setup: func(t *testing.T, s *suite) {
+				assert.NoError(t, s.client.Stop())
+			},
+			err: ErrStopped.Error(),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			suite := newTestSuite(t, tc.opts...)
+
+			if tc.setup != nil {
+				tc.setup(t, suite)
+			}
+			defer func() { _ = suite.client.Stop() }()
+
+			err := suite.client.Subscribe(
+				testSubscription,
+				ship.MessageHandlerFunc(func(context.Context, *ship.Message) error {
+					return nil
+				}),
+			)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
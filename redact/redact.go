@@ -0,0 +1,230 @@
+// Package redact renders protobuf events for logs with the fields marked by
+// the (ship.pii) and (ship.sensitive) options masked.
+//
+// It backs the MarshalLogObject and Redacted methods generated by
+// protoc-gen-ship, so events can be logged safely with zap.Any or zap.Object.
+package redact
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship/schema"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Mask replaces the value of a masked field.
+const Mask = "[REDACTED]"
+
+// Full names of the well-known types rendered as time values.
+const (
+	timestampName protoreflect.FullName = "google.protobuf.Timestamp"
+	durationName  protoreflect.FullName = "google.protobuf.Duration"
+)
+
+// IsSensitive reports whether the field is marked with the (ship.pii) or the
+// (ship.sensitive) option.
+func IsSensitive(fd protoreflect.FieldDescriptor) bool {
+	opts := fd.Options()
+	if opts == nil {
+		return false
+	}
+
+	pii, _ := proto.GetExtension(opts, schema.E_Pii).(bool)
+	sensitive, _ := proto.GetExtension(opts, schema.E_Sensitive).(bool)
+
+	return pii || sensitive
+}
+
+// MarshalLogObject encodes the message fields into the zap object encoder,
+// masking the sensitive fields of the message and its nested messages.
+func MarshalLogObject(enc zapcore.ObjectEncoder, m proto.Message) error {
+	if m == nil {
+		return nil
+	}
+	return object{m.ProtoReflect()}.MarshalLogObject(enc)
+}
+
+// String returns the JSON representation of the message with the sensitive
+// fields masked.
+func String(m proto.Message) string {
+	enc := zapcore.NewMapObjectEncoder()
+	if err := MarshalLogObject(enc, m); err != nil {
+		return Mask
+	}
+
+	b, err := json.Marshal(enc.Fields)
+	if err != nil {
+		return Mask
+	}
+
+	return string(b)
+}
+
+// object is a zapcore.ObjectMarshaler of a protobuf message.
+type object struct {
+	m protoreflect.Message
+}
+
+// MarshalLogObject encodes the fields of the message.
+func (o object) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	fields := o.m.Descriptor().Fields()
+
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+
+		// Skip the unset oneof members and message fields.
+		if (fd.ContainingOneof() != nil || fd.Message() != nil) &&
+			!fd.IsList() && !fd.IsMap() && !o.m.Has(fd) {
+			continue
+		}
+
+		name := string(fd.Name())
+		if IsSensitive(fd) {
+			enc.AddString(name, Mask)
+			continue
+		}
+
+		if err := addField(enc, name, fd, o.m.Get(fd)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addField encodes a field value into the object encoder.
+func addField(
+	enc zapcore.ObjectEncoder, name string, fd protoreflect.FieldDescriptor, v protoreflect.Value,
+) error {
+	switch {
+	case fd.IsList():
+		return enc.AddArray(name, list{fd, v.List()})
+	case fd.IsMap():
+		return enc.AddObject(name, mapObject{fd, v.Map()})
+	}
+
+	//nolint:exhaustive
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		enc.AddBool(name, v.Bool())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		enc.AddInt64(name, v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		enc.AddUint64(name, v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		enc.AddFloat64(name, v.Float())
+	case protoreflect.StringKind:
+		enc.AddString(name, v.String())
+	case protoreflect.BytesKind:
+		enc.AddBinary(name, v.Bytes())
+	case protoreflect.EnumKind:
+		enc.AddString(name, enumName(fd, v.Enum()))
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return addMessage(enc, name, v.Message())
+	}
+
+	return nil
+}
+
+// addMessage encodes a message value into the object encoder. Timestamps and
+// durations are encoded as time values.
+func addMessage(enc zapcore.ObjectEncoder, name string, m protoreflect.Message) error {
+	switch m.Descriptor().FullName() {
+	case timestampName:
+		enc.AddTime(name, toTime(m))
+		return nil
+	case durationName:
+		enc.AddDuration(name, toDuration(m))
+		return nil
+	}
+
+	return enc.AddObject(name, object{m})
+}
+
+// list is a zapcore.ArrayMarshaler of a repeated field.
+type list struct {
+	fd protoreflect.FieldDescriptor
+	l  protoreflect.List
+}
+
+// MarshalLogArray encodes the elements of the list.
+func (l list) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for i := 0; i < l.l.Len(); i++ {
+		v := l.l.Get(i)
+
+		//nolint:exhaustive
+		switch l.fd.Kind() {
+		case protoreflect.BoolKind:
+			enc.AppendBool(v.Bool())
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+			protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+			enc.AppendInt64(v.Int())
+		case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+			protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			enc.AppendUint64(v.Uint())
+		case protoreflect.FloatKind, protoreflect.DoubleKind:
+			enc.AppendFloat64(v.Float())
+		case protoreflect.StringKind:
+			enc.AppendString(v.String())
+		case protoreflect.BytesKind:
+			enc.AppendByteString(v.Bytes())
+		case protoreflect.EnumKind:
+			enc.AppendString(enumName(l.fd, v.Enum()))
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			if err := enc.AppendObject(object{v.Message()}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// mapObject is a zapcore.ObjectMarshaler of a map field.
+type mapObject struct {
+	fd protoreflect.FieldDescriptor
+	m  protoreflect.Map
+}
+
+// MarshalLogObject encodes the entries of the map.
+func (m mapObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	var err error
+	m.m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		err = addField(enc, k.String(), m.fd.MapValue(), v)
+		return err == nil
+	})
+	return err
+}
+
+// enumName returns the name of the enum value or its number, if it is not
+// defined.
+func enumName(fd protoreflect.FieldDescriptor, n protoreflect.EnumNumber) string {
+	if ev := fd.Enum().Values().ByNumber(n); ev != nil {
+		return string(ev.Name())
+	}
+	return strconv.FormatInt(int64(n), 10)
+}
+
+// toTime converts a google.protobuf.Timestamp message into time.Time.
+func toTime(m protoreflect.Message) time.Time {
+	fields := m.Descriptor().Fields()
+	seconds := m.Get(fields.ByName("seconds")).Int()
+	nanos := m.Get(fields.ByName("nanos")).Int()
+
+	return time.Unix(seconds, nanos).UTC()
+}
+
+// toDuration converts a google.protobuf.Duration message into time.Duration.
+func toDuration(m protoreflect.Message) time.Duration {
+	fields := m.Descriptor().Fields()
+	seconds := m.Get(fields.ByName("seconds")).Int()
+	nanos := m.Get(fields.ByName("nanos")).Int()
+
+	return time.Duration(seconds)*time.Second + time.Duration(nanos)
+}
@@ -0,0 +1,127 @@
+package redact
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Flahmingo-Investments/ship/schema"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newTestMessage builds a message type with sensitive, pii and nested fields.
+func newTestMessage(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	option := func(ext protoreflect.ExtensionType) *descriptorpb.FieldOptions {
+		opts := &descriptorpb.FieldOptions{}
+		proto.SetExtension(opts, ext, true)
+		return opts
+	}
+
+	field := func(
+		name string, number int32, typ descriptorpb.FieldDescriptorProto_Type,
+	) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+
+	email := field("email", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	email.Options = option(schema.E_Pii)
+
+	password := field("password", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	password.Options = option(schema.E_Sensitive)
+
+	tags := field("tags", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	tags.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+
+	createdAt := field("created_at", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	createdAt.TypeName = proto.String(".google.protobuf.Timestamp")
+
+	friend := field("friend", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	friend.TypeName = proto.String(".test.User")
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test.proto"),
+		Package:    proto.String("test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					email, password, tags, createdAt, friend,
+				},
+			},
+		},
+	}
+
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	assert.NoError(t, err)
+
+	return fd.Messages().ByName("User")
+}
+
+func TestString(t *testing.T) {
+	md := newTestMessage(t)
+
+	newUser := func(id string) *dynamicpb.Message {
+		m := dynamicpb.NewMessage(md)
+		m.Set(md.Fields().ByName("id"), protoreflect.ValueOfString(id))
+		m.Set(md.Fields().ByName("email"), protoreflect.ValueOfString(id+"@example.com"))
+		m.Set(md.Fields().ByName("password"), protoreflect.ValueOfString("hunter2"))
+		return m
+	}
+
+	user := newUser("some-id")
+	tags := user.Mutable(md.Fields().ByName("tags")).List()
+	tags.Append(protoreflect.ValueOfString("admin"))
+
+	createdAt := timestamppb.New(time.Date(2022, 1, 31, 14, 15, 17, 0, time.UTC))
+	user.Set(
+		md.Fields().ByName("created_at"),
+		protoreflect.ValueOfMessage(createdAt.ProtoReflect()),
+	)
+	user.Set(md.Fields().ByName("friend"), protoreflect.ValueOfMessage(newUser("friend-id")))
+
+	s := String(user)
+	assert.NotContains(t, s, "example.com")
+	assert.NotContains(t, s, "hunter2")
+	assert.JSONEq(t, `{
+		"id": "some-id",
+		"email": "[REDACTED]",
+		"password": "[REDACTED]",
+		"tags": ["admin"],
+		"created_at": "2022-01-31T14:15:17Z",
+		"friend": {
+			"id": "friend-id",
+			"email": "[REDACTED]",
+			"password": "[REDACTED]",
+			"tags": []
+		}
+	}`, s)
+}
+
+func TestMarshalLogObject(t *testing.T) {
+	enc := zapcore.NewMapObjectEncoder()
+	assert.NoError(t, MarshalLogObject(enc, nil))
+	assert.Empty(t, enc.Fields)
+
+	md := newTestMessage(t)
+	assert.True(t, IsSensitive(md.Fields().ByName("email")))
+	assert.True(t, IsSensitive(md.Fields().ByName("password")))
+	assert.False(t, IsSensitive(md.Fields().ByName("id")))
+}
@@ -29,6 +29,30 @@ var file_ship_proto_extTypes = []protoimpl.ExtensionInfo{
 		Tag:           "varint,9001,opt,name=event",
 		Filename:      "ship.proto",
 	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         9002,
+		Name:          "ship.pii",
+		Tag:           "varint,9002,opt,name=pii",
+		Filename:      "ship.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         9003,
+		Name:          "ship.subject",
+		Tag:           "varint,9003,opt,name=subject",
+		Filename:      "ship.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
+		ExtensionType: (*bool)(nil),
+		Field:         9004,
+		Name:          "ship.sensitive",
+		Tag:           "varint,9004,opt,name=sensitive",
+		Filename:      "ship.proto",
+	},
 }
 
 // Extension fields to descriptorpb.MessageOptions.
@@ -43,6 +67,30 @@ var (
 	E_Event = &file_ship_proto_extTypes[0]
 )
 
+// Extension fields to descriptorpb.FieldOptions.
+var (
+	// Adding pii as true marks a field as personally identifiable information.
+	// The generated EncryptPII and DecryptPII methods encrypt and decrypt these
+	// fields with the key of the data subject, so deleting the key makes them
+	// unreadable while rest of the event stays usable.
+	//
+	// Only string and bytes fields can be marked as pii.
+	//
+	// optional bool pii = 9002;
+	E_Pii = &file_ship_proto_extTypes[1]
+	// Adding subject as true marks the field which identifies the data subject
+	// of the event, e.g. a user id. It generates the PIISubject method.
+	//
+	// optional bool subject = 9003;
+	E_Subject = &file_ship_proto_extTypes[2]
+	// Adding sensitive as true masks the field when the event is logged with
+	// the generated MarshalLogObject and Redacted methods, e.g. for secrets and
+	// hashed passwords. Fields marked as pii are always masked.
+	//
+	// optional bool sensitive = 9004;
+	E_Sensitive = &file_ship_proto_extTypes[3]
+)
+
 var File_ship_proto protoreflect.FileDescriptor
 
 var file_ship_proto_rawDesc = []byte{
@@ -52,22 +100,37 @@ var file_ship_proto_rawDesc = []byte{
 	0x72, 0x6f, 0x74, 0x6f, 0x3a, 0x39, 0x0a, 0x05, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x1f, 0x2e,
 	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
 	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xa9,
-	0x46, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x88, 0x01, 0x01, 0x42,
-	0x2e, 0x5a, 0x2c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x46, 0x6c,
-	0x61, 0x68, 0x6d, 0x69, 0x6e, 0x67, 0x6f, 0x2d, 0x49, 0x6e, 0x76, 0x65, 0x73, 0x74, 0x6d, 0x65,
-	0x6e, 0x74, 0x73, 0x2f, 0x73, 0x68, 0x69, 0x70, 0x2f, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x46, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x88, 0x01, 0x01, 0x3a,
+	0x33, 0x0a, 0x03, 0x70, 0x69, 0x69, 0x12, 0x1d, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x4f, 0x70,
+	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xaa, 0x46, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x70, 0x69,
+	0x69, 0x88, 0x01, 0x01, 0x3a, 0x3b, 0x0a, 0x07, 0x73, 0x75, 0x62, 0x6a, 0x65, 0x63, 0x74, 0x12,
+	0x1d, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xab,
+	0x46, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x62, 0x6a, 0x65, 0x63, 0x74, 0x88, 0x01,
+	0x01, 0x3a, 0x3f, 0x0a, 0x09, 0x73, 0x65, 0x6e, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x12, 0x1d,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xac, 0x46,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x73, 0x65, 0x6e, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x88,
+	0x01, 0x01, 0x42, 0x2e, 0x5a, 0x2c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
+	0x2f, 0x46, 0x6c, 0x61, 0x68, 0x6d, 0x69, 0x6e, 0x67, 0x6f, 0x2d, 0x49, 0x6e, 0x76, 0x65, 0x73,
+	0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x2f, 0x73, 0x68, 0x69, 0x70, 0x2f, 0x73, 0x63, 0x68, 0x65,
+	0x6d, 0x61, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var file_ship_proto_goTypes = []interface{}{
 	(*descriptorpb.MessageOptions)(nil), // 0: google.protobuf.MessageOptions
+	(*descriptorpb.FieldOptions)(nil),   // 1: google.protobuf.FieldOptions
 }
 var file_ship_proto_depIdxs = []int32{
 	0, // 0: ship.event:extendee -> google.protobuf.MessageOptions
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	0, // [0:1] is the sub-list for extension extendee
+	1, // 1: ship.pii:extendee -> google.protobuf.FieldOptions
+	1, // 2: ship.subject:extendee -> google.protobuf.FieldOptions
+	1, // 3: ship.sensitive:extendee -> google.protobuf.FieldOptions
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	0, // [0:4] is the sub-list for extension extendee
 	0, // [0:0] is the sub-list for field type_name
 }
 
@@ -83,7 +146,7 @@ func file_ship_proto_init() {
 			RawDescriptor: file_ship_proto_rawDesc,
 			NumEnums:      0,
 			NumMessages:   0,
-			NumExtensions: 1,
+			NumExtensions: 4,
 			NumServices:   0,
 		},
 		GoTypes:           file_ship_proto_goTypes,
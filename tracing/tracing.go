@@ -0,0 +1,183 @@
+// Package tracing propagates OpenTelemetry trace context through message
+// attributes and starts the spans shared by the ship transports.
+//
+// A publisher injects the W3C trace context of the publish span into the
+// message attributes and a subscriber extracts it, so the receive, decode and
+// handle spans continue the trace of the publisher.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer used by ship.
+const InstrumentationName = "github.com/Flahmingo-Investments/ship"
+
+// Attribute keys of the ship spans.
+const (
+	// HandlerKey is the name of the message handler.
+	HandlerKey = attribute.Key("ship.handler")
+
+	// EventTypeKey is the type of the handled event.
+	EventTypeKey = attribute.Key("ship.event_type")
+)
+
+// Tracer wraps an OpenTelemetry tracer and a propagator.
+//
+// The zero value is not usable, use New.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	system     string
+}
+
+// Option is an option setter used to configure a Tracer.
+type Option func(*Tracer)
+
+// WithTracerProvider uses the provided tracer provider instead of the global
+// one.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(t *Tracer) {
+		if tp != nil {
+			t.tracer = tp.Tracer(InstrumentationName)
+		}
+	}
+}
+
+// WithPropagator uses the provided propagator instead of the W3C trace
+// context propagator.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(t *Tracer) {
+		if p != nil {
+			t.propagator = p
+		}
+	}
+}
+
+// New creates a Tracer for the messaging system, e.g. gcp_pubsub or kafka.
+func New(system string, opts ...Option) *Tracer {
+	t := &Tracer{
+		tracer:     otel.GetTracerProvider().Tracer(InstrumentationName),
+		propagator: propagation.TraceContext{},
+		system:     system,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(t)
+		}
+	}
+
+	return t
+}
+
+// Inject returns a copy of the attributes carrying the trace context of ctx.
+func (t *Tracer) Inject(ctx context.Context, attrs map[string]string) map[string]string {
+	carrier := make(propagation.MapCarrier, len(attrs)+len(t.propagator.Fields()))
+	for k, v := range attrs {
+		carrier[k] = v
+	}
+
+	t.propagator.Inject(ctx, carrier)
+
+	return carrier
+}
+
+// Extract returns a context carrying the trace context of the attributes.
+func (t *Tracer) Extract(ctx context.Context, attrs map[string]string) context.Context {
+	return t.propagator.Extract(ctx, propagation.MapCarrier(attrs))
+}
+
+// Strip returns a copy of the attributes without the trace context fields.
+func (t *Tracer) Strip(attrs map[string]string) map[string]string {
+	out := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		out[k] = v
+	}
+
+	for _, k := range t.propagator.Fields() {
+		delete(out, k)
+	}
+
+	return out
+}
+
+// StartPublish starts a producer span for publishing to the topic.
+func (t *Tracer) StartPublish(ctx context.Context, topic string) (context.Context, trace.Span) {
+	return t.tracer.Start(
+		ctx,
+		topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(t.system),
+			semconv.MessagingDestinationKey.String(topic),
+			semconv.MessagingDestinationKindTopic,
+		),
+	)
+}
+
+// StartReceive extracts the trace context from the attributes and starts a
+// consumer span for a message received from the subscription.
+func (t *Tracer) StartReceive(
+	ctx context.Context, subscription, messageID string, attrs map[string]string,
+) (context.Context, trace.Span) {
+	ctx = t.Extract(ctx, attrs)
+
+	return t.tracer.Start(
+		ctx,
+		subscription+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(t.system),
+			semconv.MessagingDestinationKey.String(subscription),
+			semconv.MessagingOperationReceive,
+			semconv.MessagingMessageIDKey.String(messageID),
+		),
+	)
+}
+
+// StartDecode starts a span for decoding a received message.
+func (t *Tracer) StartDecode(ctx context.Context) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "decode")
+}
+
+// StartHandle starts a span for handling a received message with the
+// handler. A span handling a batch of messages is linked to their receive
+// spans.
+func (t *Tracer) StartHandle(
+	ctx context.Context, handlerName, eventType string, links ...trace.Link,
+) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String(t.system),
+		semconv.MessagingOperationProcess,
+		HandlerKey.String(handlerName),
+	}
+	if eventType != "" {
+		attrs = append(attrs, EventTypeKey.String(eventType))
+	}
+
+	return t.tracer.Start(
+		ctx, "handle", trace.WithAttributes(attrs...), trace.WithLinks(links...),
+	)
+}
+
+// SetMessageID sets the id assigned to a published message on the span.
+func SetMessageID(span trace.Span, id string) {
+	span.SetAttributes(semconv.MessagingMessageIDKey.String(id))
+}
+
+// End records the error, if any, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	tracer := New("test", WithTracerProvider(tp), nil)
+
+	ctx, span := tracer.StartPublish(context.Background(), "some-topic")
+	attrs := map[string]string{"key": "value"}
+	injected := tracer.Inject(ctx, attrs)
+	End(span, nil)
+
+	assert.NotContains(t, attrs, "traceparent")
+	assert.Contains(t, injected, "traceparent")
+	assert.Equal(t, attrs, tracer.Strip(injected))
+
+	ctx, span = tracer.StartReceive(context.Background(), "some-sub", "some-id", injected)
+	_, handleSpan := tracer.StartHandle(ctx, "handler", "SomeEvent")
+	End(handleSpan, errors.New("some error"))
+	End(span, nil)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+
+	publish, handle, receive := spans[0], spans[1], spans[2]
+	assert.Equal(t, "some-topic send", publish.Name())
+	assert.Equal(t, trace.SpanKindProducer, publish.SpanKind())
+
+	assert.Equal(t, "some-sub receive", receive.Name())
+	assert.Equal(t, trace.SpanKindConsumer, receive.SpanKind())
+	assert.Equal(t, publish.SpanContext().TraceID(), receive.SpanContext().TraceID())
+	assert.Equal(t, publish.SpanContext().SpanID(), receive.Parent().SpanID())
+
+	assert.Equal(t, "handle", handle.Name())
+	assert.Equal(t, receive.SpanContext().SpanID(), handle.Parent().SpanID())
+	assert.Equal(t, codes.Error, handle.Status().Code)
+}